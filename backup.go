@@ -0,0 +1,157 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+//Backup writes every statement of the bucket files of the db to w. Equivalent to BackupSince with an lsn of zero.
+func (db *StitchDB) Backup(w io.Writer) (uint64, error) {
+	return db.BackupSince(0, w)
+}
+
+//BackupSince writes the statements of the bucket files of the db committed with a log sequence number greater than lsn to
+//w and returns the highest log sequence number issued when the backup started. Passing the returned number to the next
+//call writes only the statements committed since; a statement that was written after the backup started may be written
+//again by the next backup. Statements are read from the bucket files so deletes and overwrites are written in the order
+//they were committed. Each bucket with statements to back up is written as a length prefixed record holding the bucket
+//name followed by its statements; the system buckets are not backed up. An lsn of zero writes every statement including
+//statements written before log sequence numbers were recorded. Compaction removes the statements of overwritten and
+//deleted entries so a backup since a number before the last compaction of a bucket may not contain the deletes made
+//before it. Returns an error if the db is closed or not persistent, or a bucket file could not be read or written to w.
+func (db *StitchDB) BackupSince(lsn uint64, w io.Writer) (uint64, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return 0, errors.New("error: db: db is closed")
+	}
+	if !db.config.persist {
+		return 0, errors.New("error: db: db is not persistent")
+	}
+	high := db.currentLSN()
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stmts, err := db.buckets[name].backupSince(lsn)
+		if err != nil {
+			return 0, err
+		}
+		if len(stmts) == 0 {
+			continue
+		}
+		body := append([]byte("BACKUP~"+name+"\n"), stmts...)
+		if _, err := w.Write(append([]byte(strconv.Itoa(len(body))+"\n"), body...)); err != nil {
+			return 0, errors.Annotate(err, "error: db: failed to write backup")
+		}
+	}
+	return high, nil
+}
+
+//Restore applies the records written by Backup or BackupSince read from r to the buckets of the same name, one read/write
+//transaction per record. Backups are restored in the order they were taken. Returns an error if the db is closed, a
+//record is malformed, the bucket of a record does not exist, or a record could not be applied; records before the
+//failing record remain applied.
+func (db *StitchDB) Restore(r io.Reader) error {
+	rd := bufio.NewReader(r)
+	for {
+		record, err := readStmt(rd)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Annotate(err, "error: db: failed to read backup record")
+		}
+		header := strings.SplitN(strings.SplitN(record, "\n", 2)[0], "~", 2)
+		if len(header) != 2 || header[0] != "BACKUP" {
+			return errors.New("error: db: invalid backup record")
+		}
+		stmts := bufio.NewReader(strings.NewReader(record[strings.Index(record, "\n")+1:]))
+		err = db.Update(header[1], func(t *Tx) error {
+			for {
+				stmt, err := readStmt(stmts)
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := t.applyStmt(stmt); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return errors.Annotate(err, "error: db: failed to restore bucket "+header[1])
+		}
+	}
+}
+
+//backupSince returns the statements of the bucket file, including writes that have not been flushed, committed with a log
+//sequence number greater than lsn in the length prefixed format of the bucket file.
+func (b *Bucket) backupSince(lsn uint64) ([]byte, error) {
+	b.lock(MODE_READ)
+	defer b.unlock(MODE_READ)
+	if !b.open || b.file == nil {
+		return nil, nil
+	}
+	f, err := os.Open(b.file.Name())
+	if err != nil {
+		return nil, errors.Annotate(err, "error: db: failed to open bucket file")
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: db: failed to read bucket file")
+	}
+	rd := bufio.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(b.aofbuf)))
+	var buf []byte
+	for {
+		stmt, err := readStmt(rd)
+		if err != nil {
+			//The bucket file may end with a partial statement that is ignored when the bucket is loaded.
+			return buf, nil
+		}
+		stype, sparts, err := parseEntryStmtTypeName(stmt)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: db: failed to parse bucket file statement")
+		}
+		var slsn string
+		switch stype {
+		case "INSERT", "DELETE":
+			if len(sparts) > 9 {
+				slsn = sparts[9]
+			}
+		case "EXPIRE", "COUNTER":
+			if len(sparts) > 3 {
+				slsn = sparts[3]
+			}
+		case "TOMBSTONE":
+			if len(sparts) > 10 {
+				slsn = sparts[10]
+			}
+		default:
+			continue
+		}
+		var n uint64
+		if slsn != "" {
+			if n, err = strconv.ParseUint(strings.TrimSpace(slsn), 10, 64); err != nil {
+				return nil, errors.Annotate(err, "error: db: failed to parse bucket file statement")
+			}
+		}
+		if lsn == 0 || n > lsn {
+			buf = append(buf, strconv.Itoa(len(stmt))+"\n"+stmt...)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_BackupSince(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/backup/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	fc, _ := NewConfig(Persist, DirPath("stitch/test/follower/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	restored, err := NewStitchDB(fc)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(fc) returned error \"%v\"", err)
+	}
+	restored.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Hour))
+	db.CreateBucket("backedup", opts)
+	restored.CreateBucket("backedup", opts)
+	db.Update("backedup", func(tx *Tx) error {
+		for i := 0; i < 5; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	var full bytes.Buffer
+	high, err := db.Backup(&full)
+	if err != nil {
+		t.Errorf("Failure: db.Backup(...) returned error \"%v\"", err)
+	}
+	if n := bytes.Count(full.Bytes(), []byte("INSERT~")); n != 5 {
+		t.Errorf("Failure: expected 5 insert statements in full backup got %d in %q", n, full.Bytes())
+	}
+	db.Update("backedup", func(tx *Tx) error {
+		tx.Delete(&Entry{k: "key-1"})
+		e, _ := NewEntry("key-2", "{ \"value\":20}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	var inc bytes.Buffer
+	if _, err := db.BackupSince(high, &inc); err != nil {
+		t.Errorf("Failure: db.BackupSince(...) returned error \"%v\"", err)
+	}
+	if n := bytes.Count(inc.Bytes(), []byte("INSERT~")); n != 1 {
+		t.Errorf("Failure: expected 1 insert statement in incremental backup got %d in %q", n, inc.Bytes())
+	}
+	if n := bytes.Count(inc.Bytes(), []byte("DELETE~")); n != 1 {
+		t.Errorf("Failure: expected 1 delete statement in incremental backup got %d in %q", n, inc.Bytes())
+	}
+	if err := restored.Restore(bytes.NewReader(full.Bytes())); err != nil {
+		t.Errorf("Failure: restored.Restore(...) returned error \"%v\" for full backup", err)
+	}
+	if err := restored.Restore(bytes.NewReader(inc.Bytes())); err != nil {
+		t.Errorf("Failure: restored.Restore(...) returned error \"%v\" for incremental backup", err)
+	}
+	db.View("backedup", func(ptx *Tx) error {
+		return restored.View("backedup", func(rtx *Tx) error {
+			for i := 0; i < 5; i++ {
+				k := "key-" + strconv.Itoa(i)
+				p, _ := ptx.Get(&Entry{k: k})
+				r, _ := rtx.Get(&Entry{k: k})
+				if (p == nil) != (r == nil) || (p != nil && !verifyEntry(p, r)) {
+					t.Errorf("Failure: expected restored entry %v got %v", p, r)
+				}
+			}
+			if _, err := rtx.GetWithTombstone("key-1"); err != nil {
+				t.Errorf("Failure: rtx.GetWithTombstone(\"key-1\") returned error \"%v\"", err)
+			}
+			return nil
+		})
+	})
+	if err := restored.Restore(bytes.NewReader([]byte("5\nDROP\n"))); err == nil {
+		t.Error("Failure: restored.Restore(...) expected error for invalid record got nil")
+	}
+	db.DropBucket("backedup")
+	restored.DropBucket("backedup")
+	db.Close()
+	restored.Close()
+	if _, err := db.Backup(&full); err == nil {
+		t.Error("Failure: db.Backup(...) expected error for closed db got nil")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+//Batch queues read/write updates to the buckets of the db that are applied together by StitchDB.Batch.
+type Batch struct {
+	ops []batchOp //Queued updates in the order they were queued.
+}
+
+//batchOp is an update queued on a batch.
+type batchOp struct {
+	bucket string
+	f      func(t *Tx) error
+}
+
+//Update queues the provided function to be applied to the bucket specified by the bucket name provided when the batch is
+//applied. The function is not called by Update.
+func (b *Batch) Update(bucket string, f func(t *Tx) error) {
+	b.ops = append(b.ops, batchOp{bucket: bucket, f: f})
+}
+
+//Batch passes a new batch to the provided function and then applies the updates queued on the batch. The write locks of
+//the buckets of the batch are obtained in name order and the queued updates are called in the order they were queued;
+//updates to the same bucket share a single transaction so that each bucket is written and synced once for the batch
+//rather than once per update. If an update returns an error every update of the batch is rolled back and the error is
+//returned. If f returns an error nothing is applied and the error is returned. The changes of every bucket are written
+//to the bucket files in name order before any bucket completes its commit; if the changes of a bucket cannot be written
+//the changes already written for the other buckets are removed from their files and every update of the batch is rolled
+//back. Returns an error if the db is closed or a bucket of the batch is invalid.
+func (db *StitchDB) Batch(f func(b *Batch) error) error {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	batch := &Batch{}
+	if err := f(batch); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(batch.ops))
+	bkts := make(map[string]*Bucket)
+	for _, op := range batch.ops {
+		//Names are trimmed as getBucket trims them so that each bucket has a single transaction.
+		name := strings.TrimSpace(op.bucket)
+		if _, ok := bkts[name]; ok {
+			continue
+		}
+		b, err := db.getBucket(name)
+		if err != nil || b == nil {
+			return errors.New("error: db: invalid bucket " + op.bucket)
+		}
+		bkts[name] = b
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	txs := make(map[string]*Tx, len(names))
+	rollback := func() {
+		for k := len(names) - 1; k >= 0; k-- {
+			if tx, ok := txs[names[k]]; ok {
+				if err := tx.rollbackTx(); err != nil {
+					db.config.logger.Errorf("%v", errors.ErrorStack(err))
+				}
+			}
+		}
+	}
+	startTime := time.Now()
+	for _, name := range names {
+		tx, err := bkts[name].startTx(MODE_READ_WRITE, 0)
+		if err != nil {
+			rollback()
+			return errors.Annotate(err, "error: db: failed to start batch transaction on bucket "+name)
+		}
+		db.registerTx(tx)
+		tx.sysperf = &SystemPerformanceEntry{Transaction: true, Bucket: tx.bkt.name, Mode: tx.mode}
+		txs[name] = tx
+	}
+	for _, op := range batch.ops {
+		if err := op.f(txs[strings.TrimSpace(op.bucket)]); err != nil {
+			rollback()
+			return err
+		}
+	}
+	for _, name := range names {
+		txs[name].sysperf.TxTime = time.Since(startTime)
+	}
+	keys := make(map[string][]string, len(names))
+	marks := make(map[string]aofMark, len(names))
+	for k, name := range names {
+		tx := txs[name]
+		tx.sysperf.Commit = true
+		var err error
+		keys[name], marks[name], err = tx.writeChanges()
+		if err != nil {
+			//The changes of the failed bucket were reverted by writeChanges; those written before it are removed.
+			tx.completeCommit(keys[name], startTime, err)
+			for _, written := range names[:k] {
+				txs[written].bkt.replbuf = nil
+				if derr := txs[written].bkt.discardAOF(marks[written]); derr != nil {
+					db.config.logger.Errorf("%v", errors.ErrorStack(derr))
+				}
+			}
+			delete(txs, name)
+			rollback()
+			return errors.Annotate(err, "error: db: failed to commit batch to bucket "+name)
+		}
+	}
+	for _, name := range names {
+		txs[name].completeCommit(keys[name], startTime, nil)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_Batch(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(EACH), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("batch-a", opts)
+	db.CreateBucket("batch-b", opts)
+	eopt, _ := NewEntryOptions()
+	set := func(key string) func(tx *Tx) error {
+		return func(tx *Tx) error {
+			e, _ := NewEntry(key, "{ \"value\":\""+key+"\"}", false, eopt)
+			_, err := tx.Set(e)
+			return err
+		}
+	}
+	err = db.Batch(func(b *Batch) error {
+		b.Update("batch-b", set("key-1"))
+		b.Update("batch-a", set("key-1"))
+		b.Update("batch-a", set("key-2"))
+		//Updates to the same bucket share a transaction so later updates observe earlier updates.
+		b.Update("batch-a", func(tx *Tx) error {
+			if e, _ := tx.Get(&Entry{k: "key-2"}); e == nil {
+				t.Error("Failure: expected key-2 queued earlier in batch to be visible got nil")
+			}
+			return nil
+		})
+		//Names are trimmed as other operations trim them.
+		b.Update(" batch-a ", func(tx *Tx) error { return nil })
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failure: db.Batch() returned error \"%v\"", err)
+	}
+	//A failing update rolls back every update of the batch.
+	err = db.Batch(func(b *Batch) error {
+		b.Update("batch-a", set("key-3"))
+		b.Update("batch-b", set("key-3"))
+		b.Update("batch-b", func(tx *Tx) error {
+			return fmt.Errorf("rollback")
+		})
+		return nil
+	})
+	if err == nil || err.Error() != "rollback" {
+		t.Errorf("Failure: db.Batch() with failing update expected error \"rollback\" got \"%v\"", err)
+	}
+	if err := db.Batch(func(b *Batch) error {
+		b.Update("batch-a", set("key-4"))
+		b.Update("missing", set("key-4"))
+		return nil
+	}); err == nil {
+		t.Error("Failure: db.Batch() with invalid bucket expected error got nil")
+	}
+	if err := db.Batch(func(b *Batch) error {
+		b.Update("batch-a", set("key-5"))
+		return fmt.Errorf("abandon")
+	}); err == nil {
+		t.Error("Failure: db.Batch() with failing batch function expected error got nil")
+	}
+	expect := func(when string) {
+		for bucket, want := range map[string]int{"batch-a": 2, "batch-b": 1} {
+			db.View(bucket, func(tx *Tx) error {
+				if size, _ := tx.Size(""); size != want {
+					t.Errorf("Failure: expected %d entries in %s %s got %d", want, bucket, when, size)
+				}
+				for _, key := range []string{"key-3", "key-4", "key-5"} {
+					if e, _ := tx.Get(&Entry{k: key}); e != nil {
+						t.Errorf("Failure: expected %s not to be applied to %s %s got %v", key, bucket, when, e)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	//A bucket whose changes cannot be written rolls back the buckets written before it.
+	err = db.Batch(func(b *Batch) error {
+		b.Update("batch-a", set("key-3"))
+		b.Update(" batch-a", set("key-4"))
+		b.Update("batch-b", func(tx *Tx) error {
+			e, _ := NewEntry("key-3", "{}", false, eopt)
+			tx.Set(e)
+			//Fail the writes of batch-b by replacing its file with a read only handle.
+			tx.bkt.file.Close()
+			var err error
+			tx.bkt.file, err = os.Open(db.getDBFilePath("batch-b" + BUCKET_FILE_EXTENSION))
+			return err
+		})
+		return nil
+	})
+	if err == nil {
+		t.Error("Failure: db.Batch() with failing write expected error got nil")
+	}
+	bkt := db.buckets["batch-b"]
+	bkt.file.Close()
+	if bkt.file, err = os.OpenFile(db.getDBFilePath("batch-b"+BUCKET_FILE_EXTENSION), os.O_RDWR, 0666); err != nil {
+		t.Errorf("Failure: failed to reopen bucket file \"%v\"", err)
+	}
+	bkt.file.Seek(0, io.SeekEnd)
+	expect("after batches")
+	if n := len(db.ActiveTransactions()); n != 0 {
+		t.Errorf("Failure: expected no active transactions after batches got %d", n)
+	}
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen")
+	db.DropBucket("batch-a")
+	db.DropBucket("batch-b")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
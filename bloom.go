@@ -0,0 +1,79 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+//BLOOM_BITS_PER_ENTRY is the number of counters allocated per expected entry in a bloom filter.
+const BLOOM_BITS_PER_ENTRY int = 10
+
+//BLOOM_HASHES is the number of counters set for each key in a bloom filter.
+const BLOOM_HASHES int = 7
+
+//bloomFilter is a counting bloom filter over entry keys. Counters allow keys to be removed when entries are deleted; a
+//counter that saturates is never decremented so the filter never reports a present key as absent.
+type bloomFilter struct {
+	lock     sync.RWMutex //Lock for the filter; allows lookups without the bucket lock.
+	counters []uint8      //Counters for each position of the filter.
+}
+
+//newBloomFilter creates a bloom filter sized for the expected number of entries.
+func newBloomFilter(entries int) *bloomFilter {
+	if entries < 1 {
+		entries = 1
+	}
+	return &bloomFilter{
+		counters: make([]uint8, entries*BLOOM_BITS_PER_ENTRY),
+	}
+}
+
+//positions returns the counter positions for the key using double hashing.
+func (f *bloomFilter) positions(key string) []int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	pos := make([]int, BLOOM_HASHES)
+	for i := range pos {
+		pos[i] = int((h1 + uint32(i)*h2) % uint32(len(f.counters)))
+	}
+	return pos
+}
+
+//add records the key in the filter.
+func (f *bloomFilter) add(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, p := range f.positions(key) {
+		if f.counters[p] < 255 {
+			f.counters[p]++
+		}
+	}
+}
+
+//remove removes a key previously added to the filter.
+func (f *bloomFilter) remove(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, p := range f.positions(key) {
+		if f.counters[p] > 0 && f.counters[p] < 255 {
+			f.counters[p]--
+		}
+	}
+}
+
+//mightContain returns false if the key is definitely not in the filter and true if it may be.
+func (f *bloomFilter) mightContain(key string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, p := range f.positions(key) {
+		if f.counters[p] == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter_mightContain(t *testing.T) {
+	f := newBloomFilter(256)
+	for i := 0; i < 256; i++ {
+		f.add("key-" + strconv.Itoa(i))
+	}
+	for i := 0; i < 256; i++ {
+		if !f.mightContain("key-" + strconv.Itoa(i)) {
+			t.Errorf("Failure: expected f.mightContain(key-%v) == true got false", i)
+		}
+	}
+	falsePositives := 0
+	for i := 256; i < 1256; i++ {
+		if f.mightContain("key-" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Failure: expected fewer than 50 false positives in 1000 lookups got %v", falsePositives)
+	}
+	for i := 0; i < 256; i++ {
+		f.remove("key-" + strconv.Itoa(i))
+	}
+	for _, c := range f.counters {
+		if c != 0 {
+			t.Errorf("Failure: expected all counters to be zero after removing all keys")
+			break
+		}
+	}
+}
@@ -5,18 +5,19 @@ package stitchdb
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cbergoon/btree"
 	"github.com/dhconnelly/rtreego"
 	"github.com/juju/errors"
-	"github.com/tidwall/gjson"
 )
 
 //COMPACT_FACTOR is the Multiplier factor for to determine when to compact log.
@@ -27,18 +28,52 @@ type Bucket struct {
 	name         string                  //Name of the bucket.
 	db           *StitchDB               //Reference to containing DB.
 	bktlock      sync.RWMutex            //Lock for bucket.
+	rdrsem       chan struct{}           //Semaphore bounding concurrent read transactions if max readers is set.
+	ownlock      sync.Mutex              //Lock for the owners of the bucket lock.
+	writer       int64                   //Id of the goroutine that started the read/write transaction holding the lock.
+	readers      map[int64]int           //Number of read only transactions holding the lock by the goroutine that started them.
 	data         *btree.BTree            //Primary tree for bucket.
 	eviction     *btree.BTree            //Data for bucket ordered by eviction time.
 	invalidation *btree.BTree            //Data for bucket ordered by invalidation time.
+	insertion    *btree.BTree            //Data for bucket ordered by log sequence number if insertion order is enabled.
 	rtree        *rtreego.Rtree          //Rtree of data for geolocation.
+	tombstones   *btree.BTree            //Tombstones of deleted entries when tombstone retention is enabled.
+	bloom        *bloomFilter            //Bloom filter over entry keys if enabled for the bucket.
 	indexes      map[string]*Index       //Map of indexes built over data.
+	counters     map[string]counter      //Counters of the bucket by name; see Tx.IncrCounter.
+	idxsusp      bool                    //Indicates that the index trees are not maintained; set by Tx.SuspendIndexes.
 	file         *os.File                //Bucket Append Only File.
 	rct          uint64                  //AOF row count.
+	loaded       int                     //Number of records replayed from the AOF when the bucket was opened.
+	aofct        int                     //Statements in the AOF since it was opened or last compacted.
+	lsn          uint64                  //Highest log sequence number replayed from the AOF.
+	lastWrite    time.Time               //Time statements were last written to the AOF.
+	managed      time.Time               //Time the bucket manager last ran.
+	sweepcur     *Entry                  //Last entry removed by a limited sweep; the next sweep resumes after it.
 	open         bool                    //Indicated the status of the bucket.
 	options      *BucketOptions          //Options for the bucket.
 	aofbuf       []byte                  //AOF write buffer.
+	replbuf      []byte                  //Statements of the commit in progress for replication; nil if not replicating.
 	sysntry      *SystemEntry            //System entry to be written on management cycle.
 	sysperfentry *SystemPerformanceEntry //System performance metrics written on management cycle.
+	expirylock   sync.Mutex              //Lock for expiry channels.
+	expiry       []chan *Entry           //Channels receiving entries removed after expiring.
+	entries      int64                   //Number of entries in data counted toward the totals of the db.
+	bytes        int64                   //Size of the keys and values of the entries in data.
+	qgen         uint64                  //Generation of data incremented by each insert and delete; see queryCache.
+	qcache       *queryCache             //Keys returned by recent queries if the query cache is enabled; nil otherwise.
+	stale        *staleCache             //Entries last read or committed if stale reads are enabled; nil otherwise.
+}
+
+//BucketStats describes the contents and structure of a bucket.
+type BucketStats struct {
+	Entries int //Number of entries in the bucket that have not expired.
+	Expired int //Number of expired entries waiting to be removed by the manager.
+	Indexes int //Number of indexes on the bucket.
+	Degree  int //Degree of the bucket's btrees.
+	//Upper bound on the number of levels in the bucket's main btree derived from its size and degree. The btree does not
+	//expose its actual height which may be lower.
+	MaxHeight int
 }
 
 //eItype provides a basic context via type for tree iType.
@@ -51,8 +86,15 @@ type iItype struct {
 	db *StitchDB
 }
 
+//sItype provides a basic context via type for tree iType.
+type sItype struct{}
+
 //newBucket creates a new bucket for the specified db with the provided options.
 func newBucket(db *StitchDB, bucketOptions *BucketOptions, name string) (*Bucket, error) {
+	var insertion *btree.BTree
+	if bucketOptions.insord {
+		insertion = btree.New(bucketOptions.btdeg, &sItype{})
+	}
 	return &Bucket{
 		name:         name,
 		db:           db,
@@ -60,47 +102,155 @@ func newBucket(db *StitchDB, bucketOptions *BucketOptions, name string) (*Bucket
 		data:         btree.New(bucketOptions.btdeg, nil),
 		eviction:     btree.New(bucketOptions.btdeg, &eItype{db: db}),
 		invalidation: btree.New(bucketOptions.btdeg, &iItype{db: db}),
+		insertion:    insertion,
 		rtree:        rtreego.NewTree(bucketOptions.dims, bucketOptions.btdeg, bucketOptions.btdeg*2),
+		tombstones:   btree.New(bucketOptions.btdeg, nil),
 		indexes:      make(map[string]*Index),
+		counters:     make(map[string]counter),
+		rdrsem:       newReaderSemaphore(bucketOptions.maxrdrs),
+		bloom:        newBucketBloomFilter(bucketOptions.bloom),
+		qcache:       newQueryCache(db.config.queryCacheSize),
+		stale:        newStaleCache(bucketOptions.stale),
 	}, nil
 }
 
+//newBucketBloomFilter returns a bloom filter sized for the expected number of entries or nil if entries is zero.
+func newBucketBloomFilter(entries int) *bloomFilter {
+	if entries <= 0 {
+		return nil
+	}
+	return newBloomFilter(entries)
+}
+
+//newReaderSemaphore returns a semaphore bounding readers to n or nil if n is zero.
+func newReaderSemaphore(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
 //loadBucketFile reads the entire bucket file and inserts the entries into the bucket. Populates the main, invalidation,
-//expiration, and index trees.
+//expiration, and index trees. The configured replay progress function is called after each batch of statements with the
+//number of records replayed so far.
 func (b *Bucket) loadBucketFile() error {
-	entries := make([]string, 0)
-	r := bufio.NewReader(b.file)
-	var err error
-	var iline []byte
-	for {
-		for i := 0; i < 1024; i++ {
-			iline, err = r.ReadBytes('\n')
+	if b.db.config.mmapReplay && mmapSupported {
+		if err := b.replayMapped(); err != nil {
+			return err
+		}
+	} else if err := b.replay(b.file, b.db.config.replayProgress); err != nil {
+		return err
+	}
+	//Rebuild Indexes
+	for _, ind := range b.indexes {
+		ind.rebuild()
+	}
+	return nil
+}
+
+//replay reads the statements of a bucket file from rd and applies them to the bucket. Statements are replayed
+//sequentially in the order they were written so a reloaded bucket iterates in the same order as the bucket that wrote
+//the file. Statements are replayed in batches; progress, if not nil, is called after each batch with the number of
+//records replayed so far.
+func (b *Bucket) replay(rd io.Reader, progress func(bucket string, records int)) error {
+	return b.replayStmts(stmtReader(bufio.NewReader(rd)), progress)
+}
+
+//stmtReader returns a function that reads the next length prefixed statement of a bucket file from r. The function
+//returns io.EOF once r is exhausted, including when r ends within a statement.
+func stmtReader(r *bufio.Reader) func() (string, error) {
+	return func() (string, error) {
+		for {
+			iline, err := r.ReadBytes('\n')
 			if err == io.EOF && len(iline) <= 0 {
-				break //Read is complete
+				return "", io.EOF //Read is complete
 			} else if err != nil {
-				return errors.Annotate(err, "error: bucket: failed to read bucket file")
+				return "", errors.Annotate(err, "error: bucket: failed to read bucket file")
 			}
-			var size int
-			size, err = strconv.Atoi(strings.TrimSpace(string(iline)))
+			size, err := strconv.Atoi(strings.TrimSpace(string(iline)))
 			if err != nil {
-				return errors.Annotate(err, "error: bucket: bucket file data is corrupt; missing or unusable entry length")
+				return "", errors.Annotate(err, "error: bucket: bucket file data is corrupt; missing or unusable entry length")
 			}
-			if size > 0 {
-				entry := make([]byte, size)
-				var readlen int
-				readlen, err = io.ReadFull(r, entry)
-				if err == io.ErrUnexpectedEOF || err == io.EOF {
-					break
-				} else if err != nil {
-					return errors.Annotate(err, "error: bucket: failed to read bucket file")
-				}
-				if readlen != size {
-					return errors.Annotate(err, "error: bucket: bucket file data is corrupt; entry length is invalid")
-				}
-				entries = append(entries, string(entry))
+			if size <= 0 {
+				continue
+			}
+			entry := make([]byte, size)
+			if _, err := io.ReadFull(r, entry); err == io.EOF {
+				return "", io.EOF
+			} else if err != nil {
+				return "", errors.Annotate(err, "error: bucket: failed to read bucket file")
+			}
+			return string(entry), nil
+		}
+	}
+}
+
+//replayMapped replays the bucket file from a memory mapped region of the file rather than reading it through the file
+//handle. Statements are sliced from the region and copied once into the strings kept by the bucket instead of being
+//copied through a read buffer; they are parsed and validated as replay parses and validates them. The file offset is
+//moved to the end of the file so that subsequent writes are appended.
+func (b *Bucket) replayMapped() error {
+	data, unmap, err := mmapFile(b.file)
+	if err != nil {
+		return err
+	}
+	var off int
+	err = b.replayStmts(func() (string, error) {
+		for {
+			if off >= len(data) {
+				return "", io.EOF //Read is complete
 			}
+			n := bytes.IndexByte(data[off:], '\n')
+			if n < 0 {
+				return "", errors.Annotate(io.EOF, "error: bucket: failed to read bucket file")
+			}
+			size, err := strconv.Atoi(string(bytes.TrimSpace(data[off : off+n])))
+			if err != nil {
+				return "", errors.Annotate(err, "error: bucket: bucket file data is corrupt; missing or unusable entry length")
+			}
+			off += n + 1
+			if size <= 0 {
+				continue
+			}
+			if off == len(data) {
+				return "", io.EOF
+			} else if size > len(data)-off {
+				return "", errors.Annotate(io.ErrUnexpectedEOF, "error: bucket: failed to read bucket file")
+			}
+			off += size
+			return string(data[off-size : off]), nil
 		}
+	}, b.db.config.replayProgress)
+	if uerr := unmap(); uerr != nil && err == nil {
+		err = errors.Annotate(uerr, "error: bucket: failed to unmap bucket file")
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(0, io.SeekEnd); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to seek bucket file")
+	}
+	return nil
+}
 
+//replayStmts applies the statements returned by next to the bucket in batches until next returns io.EOF, calling
+//progress, if not nil, after each batch with the number of records replayed so far. Returns the first error returned by
+//next other than io.EOF.
+func (b *Bucket) replayStmts(next func() (string, error), progress func(bucket string, records int)) error {
+	entries := make([]string, 0)
+	var err error
+	for {
+		for i := 0; i < 1024; i++ {
+			var stmt string
+			stmt, err = next()
+			if err != nil {
+				break
+			}
+			entries = append(entries, stmt)
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
 		for _, e := range entries {
 			stype, sparts, err := parseEntryStmtTypeName(e)
 			if err != nil {
@@ -111,40 +261,114 @@ func (b *Bucket) loadBucketFile() error {
 				if err != nil {
 					return errors.Annotate(err, "error: bucket: failed to parse statement")
 				}
+				if b.options.validator != nil {
+					if verr := b.options.validator(nentry); verr != nil {
+						if b.options.valpolicy != VALIDATE_QUARANTINE {
+							return errors.Annotate(verr, "error: bucket: entry "+nentry.k+" failed validation")
+						}
+						if err := b.quarantine(e); err != nil {
+							return err
+						}
+						b.delete(&Entry{k: nentry.k})
+						b.replayedLSN(nentry.lsn)
+						continue
+					}
+				}
 				b.insert(nentry)
+				b.deleteTombstone(nentry.k)
+				b.replayedLSN(nentry.lsn)
 			} else if stype == "DELETE" {
 				nentry, err := NewEntryFromStmt(sparts)
 				if err != nil {
 					return errors.Annotate(err, "error: bucket: failed to parse statement")
 				}
 				b.delete(nentry)
+				b.replayedLSN(nentry.lsn)
+			} else if stype == "TOMBSTONE" {
+				ts, err := newTombstoneFromStmt(sparts)
+				if err != nil {
+					return errors.Annotate(err, "error: bucket: failed to parse statement")
+				}
+				b.setTombstone(ts)
+			} else if stype == "EXPIRE" {
+				if len(sparts) < 3 {
+					return errors.New("error: bucket: failed to parse statement; invalid expire statement")
+				}
+				exp, err := parseStmtTime(sparts[2])
+				if err != nil {
+					return errors.Annotate(err, "error: bucket: failed to parse statement")
+				}
+				var lsn uint64
+				if len(sparts) > 3 {
+					lsn, err = strconv.ParseUint(strings.TrimSpace(sparts[3]), 10, 64)
+					if err != nil {
+						return errors.Annotate(err, "error: bucket: failed to parse statement")
+					}
+				}
+				if curr := b.get(&Entry{k: sparts[1]}); curr != nil {
+					opts := *curr.opts
+					opts.doesExp = true
+					opts.expTime = exp
+					b.insert(&Entry{k: curr.k, v: curr.v, opts: &opts, location: curr.location, binary: curr.binary, lsn: lsn, version: curr.version})
+				}
+				b.replayedLSN(lsn)
+			} else if stype == "COUNTER" {
+				name, c, err := newCounterFromStmt(sparts)
+				if err != nil {
+					return errors.Annotate(err, "error: bucket: failed to parse statement")
+				}
+				b.counters[name] = c
+				b.rct++
+				b.replayedLSN(c.lsn)
+			} else if stype == "SEQUENCE" {
+				if len(sparts) < 2 {
+					return errors.New("error: bucket: failed to parse statement; invalid sequence statement")
+				}
+				lsn, err := strconv.ParseUint(strings.TrimSpace(sparts[1]), 10, 64)
+				if err != nil {
+					return errors.Annotate(err, "error: bucket: failed to parse statement")
+				}
+				b.replayedLSN(lsn)
 			}
 		}
 
+		b.loaded += len(entries)
+		if progress != nil && len(entries) > 0 {
+			progress(b.name, b.loaded)
+		}
 		entries = nil
 
 		if err == io.EOF {
-			break //Read is complete
-		} else if err != nil {
-			return errors.Annotate(err, "error: bucket: failed to read file")
+			return nil //Read is complete
 		}
 	}
+}
 
-	//Rebuild Indexes
-	for _, ind := range b.indexes {
-		ind.rebuild()
+//quarantine appends the statement of an entry that failed validation during replay to the quarantine file of the bucket
+//in the length prefixed format of the bucket file. The statement remains in the bucket file until it is compacted.
+func (b *Bucket) quarantine(stmt string) error {
+	f, err := os.OpenFile(b.db.getDBFilePath(b.name+BUCKET_QUARANTINE_FILE_EXTENSION), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return errors.Annotate(err, "error: bucket: failed to open quarantine file")
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(strconv.Itoa(len(stmt)) + "\n" + stmt)); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to write quarantine file")
 	}
+	return nil
+}
 
-	if err == io.EOF {
-		return nil
+//replayedLSN records a log sequence number replayed from the AOF.
+func (b *Bucket) replayedLSN(lsn uint64) {
+	if lsn > b.lsn {
+		b.lsn = lsn
 	}
-	return err
 }
 
 //parseEntryStmtTypeName returns the entry name and slice of the remaining parts of the tree.
 func parseEntryStmtTypeName(stmt string) (string, []string, error) {
 	parts := strings.Split(stmt, "~")
-	if parts[0] == "INSERT" || parts[0] == "DELETE" {
+	if parts[0] == "INSERT" || parts[0] == "DELETE" || parts[0] == "TOMBSTONE" || parts[0] == "EXPIRE" || parts[0] == "SEQUENCE" || parts[0] == "COUNTER" {
 		return strings.TrimSpace(parts[0]), parts, nil
 	}
 	return "", nil, errors.New("error: bucket: invalid or unrecognized statement")
@@ -155,44 +379,176 @@ func parseEntryStmtTypeName(stmt string) (string, []string, error) {
 func (b *Bucket) writeAOFBuf() error {
 	if b.db.config.persist {
 		if len(b.aofbuf) > 0 {
-			written, err := b.file.Write(b.aofbuf)
-			if err != nil {
-				return errors.Annotate(err, "error: bucket: failed to write bucket file")
-			}
-			if written != len(b.aofbuf) {
-				return errors.New("error: bucket: failed to write bucket file")
+			if err := b.flushAOFBuf(); err != nil {
+				return err
 			}
 			if b.db.config.syncFreq == EACH {
-				err := b.file.Sync()
+				err := b.retryWrite(b.file.Sync)
 				if err != nil {
-					errors.Annotate(err, "error: bucket: failed to sync file")
+					return errors.Annotate(err, "error: bucket: failed to sync file")
 				}
 			}
-			b.aofbuf = nil
 		}
 	}
 	return nil
 }
 
-//writeDeleteEntry generates and appends an insert entry to the write buffer.
-func (b *Bucket) writeDeleteEntry(e *Entry) {
-	stmt := e.EntryDeleteStmt()
+//flushAOFBuf writes the db file buffer to disk without a sync. The written portion of the buffer is removed even if the
+//write fails so that a later flush or retry does not duplicate it.
+func (b *Bucket) flushAOFBuf() error {
+	err := b.retryWrite(func() error {
+		written, err := b.file.Write(b.aofbuf)
+		b.aofbuf = b.aofbuf[written:]
+		if err != nil {
+			return errors.Annotate(err, "error: bucket: failed to write bucket file")
+		}
+		if len(b.aofbuf) > 0 {
+			return errors.New("error: bucket: failed to write bucket file")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.aofbuf = nil
+	b.lastWrite = time.Now()
+	return nil
+}
+
+//retryWrite calls write until it succeeds or the configured retries are exhausted, waiting the configured backoff before
+//each retry. If the write still fails the configured write failure policy is applied and the last error is returned.
+func (b *Bucket) retryWrite(write func() error) error {
+	err := write()
+	backoff := b.db.config.writeBackoff
+	for i := 0; err != nil && i < b.db.config.writeRetries; i++ {
+		b.db.config.logger.Errorf("%v", errors.Annotate(err, "error: bucket: retrying failed write to bucket file of "+b.name))
+		time.Sleep(backoff)
+		backoff *= 2
+		err = write()
+	}
+	if err != nil && b.db.config.writeFailure == WRITE_FAIL_READ_ONLY {
+		b.db.setReadOnly()
+	}
+	return err
+}
+
+//idle checks if idle compaction is enabled, the bucket has not been written to for the idle period, and the AOF contains
+//statements that compaction would remove.
+func (b *Bucket) idle() bool {
+	idle := b.db.config.compactIdle
+	return idle > 0 && time.Since(b.lastWrite) >= idle && b.aofct > b.data.Len()
+}
+
+//aofMark is a position in the AOF of a bucket recorded with markAOF.
+type aofMark struct {
+	offset int64 //Length of the bucket file.
+	aofct  int   //Statements in the AOF.
+}
+
+//markAOF flushes statements pending in the write buffer and returns the current position of the AOF. Returns an error if
+//the pending statements could not be written.
+func (b *Bucket) markAOF() (aofMark, error) {
+	if !b.db.config.persist {
+		return aofMark{aofct: b.aofct}, nil
+	}
+	if len(b.aofbuf) > 0 {
+		if err := b.flushAOFBuf(); err != nil {
+			return aofMark{}, err
+		}
+	}
+	offset, err := b.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return aofMark{}, errors.Annotate(err, "error: bucket: failed to seek bucket file")
+	}
+	return aofMark{offset: offset, aofct: b.aofct}, nil
+}
+
+//discardAOF removes the statements written to the AOF or buffered since the mark was recorded. Returns an error if the
+//bucket file could not be truncated.
+func (b *Bucket) discardAOF(mark aofMark) error {
+	b.aofbuf = nil
+	b.aofct = mark.aofct
+	if !b.db.config.persist {
+		return nil
+	}
+	if err := b.file.Truncate(mark.offset); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to truncate bucket file")
+	}
+	if _, err := b.file.Seek(mark.offset, io.SeekStart); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to seek bucket file")
+	}
+	return nil
+}
+
+//appendAOFBuf appends the statement to the write buffer flushing the buffer if it has reached the configured capacity.
+func (b *Bucket) appendAOFBuf(stmt []byte) error {
 	b.aofbuf = append(b.aofbuf, stmt...)
+	if b.replbuf != nil {
+		b.replbuf = append(b.replbuf, stmt...)
+	}
+	b.aofct++
+	if b.db.config.persist && b.db.config.aofBufSize > 0 && len(b.aofbuf) >= b.db.config.aofBufSize {
+		return b.flushAOFBuf()
+	}
+	return nil
+}
+
+//writeDeleteEntry generates and appends an insert entry to the write buffer.
+func (b *Bucket) writeDeleteEntry(e *Entry) error {
+	return b.appendAOFBuf(e.EntryDeleteStmt())
+}
+
+//writeExpireEntry generates and appends an expire entry to the write buffer.
+func (b *Bucket) writeExpireEntry(e *Entry) error {
+	return b.appendAOFBuf(e.EntryExpireStmt())
+}
+
+//writeTombstoneEntry generates and appends a tombstone entry to the write buffer.
+func (b *Bucket) writeTombstoneEntry(e *Entry) error {
+	return b.appendAOFBuf(e.EntryTombstoneStmt())
 }
 
 //writeInsertEntry generates and appends a delete entry to the write buffer.
-func (b *Bucket) writeInsertEntry(e *Entry) {
-	stmt := e.EntryInsertStmt()
-	b.aofbuf = append(b.aofbuf, stmt...)
+func (b *Bucket) writeInsertEntry(e *Entry) error {
+	return b.appendAOFBuf(e.insertStmt(b.options.compress))
+}
+
+//stats computes the statistics of the bucket. Expired entries are counted by walking the eviction tree in expiry order
+//stopping at the first entry that has not expired.
+func (b *Bucket) stats() BucketStats {
+	st := BucketStats{
+		Indexes: len(b.indexes),
+		Degree:  b.options.btdeg,
+	}
+	b.eviction.Ascend(func(i btree.Item) bool {
+		if !i.(*Entry).IsExpired() {
+			return false
+		}
+		st.Expired++
+		return true
+	})
+	n := b.data.Len()
+	st.Entries = n - st.Expired
+	if n > 0 {
+		//Every node other than the root holds at least degree-1 items.
+		st.MaxHeight = 1
+		for m := (n + 1) / 2; m >= b.options.btdeg && b.options.btdeg > 1; m /= b.options.btdeg {
+			st.MaxHeight++
+		}
+	}
+	return st
 }
 
 //openBucket opens and loads a bucket. It is expected that the manager is started by the caller of this function after
-//bucket is open. Returns an error if the file could not be opened or created or if the bucket file could not be loaded.
+//bucket is open. Returns an error if the file could not be opened or created or if the bucket file could not be loaded in
+//which case the bucket is left closed.
 func (b *Bucket) openBucket(file string) error {
 	b.lock(MODE_READ_WRITE)
 	defer b.unlock(MODE_READ_WRITE)
-	b.open = true
 	if b.db.config.persist {
+		if err := b.recoverCompaction(file); err != nil {
+			return err
+		}
 		var err error
 		b.file, err = os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0666)
 		if err != nil {
@@ -200,31 +556,85 @@ func (b *Bucket) openBucket(file string) error {
 		}
 		err = b.loadBucketFile()
 		if err != nil {
+			b.release()
 			return errors.Annotate(err, "error bucket: failed to load from file")
 		}
 	}
+	b.aofct = b.loaded
+	b.lastWrite = time.Now()
+	b.managed = time.Now()
+	b.open = true
 	return nil
 }
 
-//close closes the bucket flushing the write buffer to disk. Performs a sync regardless of frequency setting as it is not
-//guaranteed that the manager will execute again before exiting. Returns an error if the write to the bucket file failed,
-//the file sync failed, or if the file fails to close.
+//account adds the provided number of entries and bytes to the counts of the bucket and, unless the bucket is a system
+//bucket, to the totals of the db.
+func (b *Bucket) account(entries, bytes int64) {
+	b.entries += entries
+	b.bytes += bytes
+	if !b.options.system {
+		atomic.AddInt64(&b.db.totalEntries, entries)
+		atomic.AddInt64(&b.db.totalBytes, bytes)
+	}
+}
+
+//release removes the counts of the bucket from the totals of the db once its entries are discarded.
+func (b *Bucket) release() {
+	b.account(-b.entries, -b.bytes)
+}
+
+//recoverCompaction resolves the temporary file of a compaction of the bucket that was interrupted. If the bucket file
+//exists the compaction did not replace it and the temporary file, which may be partial, is removed. If only the temporary
+//file exists the previous bucket file was removed after the temporary file was synced, as compactions did before the
+//bucket file was replaced by rename, and the temporary file becomes the bucket file. Returns an error if the temporary
+//file could not be removed or renamed.
+func (b *Bucket) recoverCompaction(file string) error {
+	tmp := b.db.getDBFilePath(b.name + BUCKET_TMP_FILE_EXTENSION)
+	if _, err := os.Stat(tmp); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(file); err == nil {
+		if err := os.Remove(tmp); err != nil {
+			return errors.Annotate(err, "error: bucket: failed to remove temporary bucket file of interrupted compaction")
+		}
+		return nil
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to recover temporary bucket file of interrupted compaction")
+	}
+	syncDir(b.db.config.dirPath)
+	return nil
+}
+
+//syncDir syncs the directory so that renames within it are durable. Errors are ignored as directories cannot be synced
+//on every platform.
+func syncDir(path string) {
+	if d, err := os.Open(path); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+//close closes the bucket flushing the write buffer to disk and closes its expiry channels. Performs a sync regardless of
+//frequency setting, including NONE, as it is not guaranteed that the manager will execute again before exiting. Returns
+//an error if the write to the bucket file failed, the file sync failed, or if the file fails to close.
 func (b *Bucket) close() error {
 	b.lock(MODE_READ_WRITE)
 	defer b.unlock(MODE_READ_WRITE)
+	b.closeExpiry()
+	b.release()
 	if b.db.config.persist {
 		if len(b.aofbuf) > 0 {
-			_, err := b.file.Write(b.aofbuf)
-			if err != nil {
+			if err := b.flushAOFBuf(); err != nil {
 				return errors.Annotate(err, "error: bucket: failed to write to bucket")
 			}
-			b.aofbuf = nil
 		}
-		if err := b.file.Sync(); err != nil {
+		if err := b.retryWrite(b.file.Sync); err != nil {
 			return errors.Annotate(err, "error: bucket: failed to sync bucket file")
 		}
 		b.open = false
-		b.aofbuf, b.data, b.eviction, b.invalidation, b.indexes = nil, nil, nil, nil, nil
+		b.aofbuf, b.data, b.eviction, b.invalidation, b.indexes, b.tombstones = nil, nil, nil, nil, nil, nil
+		b.insertion, b.counters = nil, nil
 		err := b.file.Close()
 		if err != nil {
 			return errors.Annotate(err, "error: bucket: failed to close bucket file")
@@ -233,15 +643,34 @@ func (b *Bucket) close() error {
 	return nil
 }
 
-//indexExists returns true if the index exists for the provided index name.
+//indexExists returns true if the index exists for the provided index name. Indexes do not exist while they are
+//suspended.
 func (b *Bucket) indexExists(index string) bool {
 	idx, ok := b.indexes[index]
-	if ok && idx != nil {
+	if ok && idx != nil && !b.idxsusp {
 		return true
 	}
 	return false
 }
 
+//resumeIndexes resumes maintaining the index trees if they are suspended rebuilding each index from the entries of the
+//bucket. It is assumed the the caller obtains a lock on the db.
+func (b *Bucket) resumeIndexes() {
+	if !b.idxsusp {
+		return
+	}
+	b.idxsusp = false
+	for _, ind := range b.indexes {
+		ind.rebuild()
+	}
+}
+
+//isSpatial returns true if the entry belongs in the rtree of the bucket. An entry is only tracked by the rtree if the
+//bucket is geo enabled and the entry has a location.
+func (b *Bucket) isSpatial(e *Entry) bool {
+	return b.options.geo && len(e.location) > 0
+}
+
 //get retrieves an entry from the data tree using the default (key) comparator for the entry. It is assumed the the caller
 //obtains a lock on the db.
 func (b *Bucket) get(key *Entry) *Entry {
@@ -251,7 +680,7 @@ func (b *Bucket) get(key *Entry) *Entry {
 	return nil
 }
 
-//insert adds an entry to the bucket populating the expires, invalidation, and index trees. It is assumed the the caller
+//insert adds an entry to the bucket populating the expires, invalidation, insertion, and index trees. It is assumed the the caller
 //obtains a lock on the db.
 func (b *Bucket) insert(entry *Entry) *Entry {
 	var pentry *Entry
@@ -259,24 +688,33 @@ func (b *Bucket) insert(entry *Entry) *Entry {
 		pentry = p.(*Entry)
 	}
 	b.rct++
+	b.qgen++
 	if pentry != nil {
+		b.account(0, entry.size()-pentry.size())
 		if pentry.opts.doesExp {
 			b.eviction.Delete(pentry)
 		}
 		if pentry.opts.doesInv {
 			b.invalidation.Delete(pentry)
 		}
-		//Iterate through indexes delete pentry
-		for _, ind := range b.indexes {
-			ind.delete(pentry)
+		if b.insertion != nil {
+			b.insertion.Delete(pentry)
 		}
-		//Delete from Rtree
-		if b.options.geo {
-			ljson := gjson.Get(pentry.v, "coords")
-			if ljson.Exists() {
-				b.rtree.DeleteWithComparator(pentry, GetEntryComparator())
+		//Iterate through indexes delete pentry; suspended indexes are rebuilt when resumed
+		if !b.idxsusp {
+			for _, ind := range b.indexes {
+				ind.delete(pentry)
 			}
 		}
+		//Delete from Rtree
+		if b.isSpatial(pentry) {
+			b.rtree.DeleteWithComparator(pentry, GetEntryComparator())
+		}
+	} else {
+		b.account(1, entry.size())
+		if b.bloom != nil {
+			b.bloom.add(entry.k)
+		}
 	}
 	if entry.opts.doesExp {
 		b.eviction.ReplaceOrInsert(entry)
@@ -284,17 +722,22 @@ func (b *Bucket) insert(entry *Entry) *Entry {
 	if entry.opts.doesInv {
 		b.invalidation.ReplaceOrInsert(entry)
 	}
-	//Iterate through indexes insert entry
-	for _, ind := range b.indexes {
-		ind.insert(entry)
+	if b.insertion != nil {
+		b.insertion.ReplaceOrInsert(entry)
 	}
-	//Insert into Rtree
-	if b.options.geo {
-		ljson := gjson.Get(entry.v, "coords")
-		if ljson.Exists() {
-			b.rtree.Insert(entry)
+	//Iterate through indexes insert entry; suspended indexes are rebuilt when resumed
+	if !b.idxsusp {
+		for _, ind := range b.indexes {
+			ind.insert(entry)
 		}
 	}
+	//Insert into Rtree
+	if b.options.geo && entry.location == nil && !entry.binary {
+		entry.location = parseLocation(entry.v)
+	}
+	if b.isSpatial(entry) {
+		b.rtree.Insert(entry)
+	}
 	return pentry
 }
 
@@ -306,53 +749,167 @@ func (b *Bucket) delete(key *Entry) *Entry {
 		pentry = p.(*Entry)
 	}
 	b.rct++
+	b.qgen++
 	if pentry != nil {
+		b.account(-1, -pentry.size())
 		if pentry.opts.doesExp {
 			b.eviction.Delete(pentry)
 		}
 		if pentry.opts.doesInv {
 			b.invalidation.Delete(pentry)
 		}
-		//Iterate through indexes delete pentry
-		for _, ind := range b.indexes {
-			ind.delete(pentry)
+		if b.insertion != nil {
+			b.insertion.Delete(pentry)
 		}
-		//Delete from Rtree
-		if b.options.geo {
-			if b.options.geo {
-				ljson := gjson.Get(pentry.v, "coords")
-				if ljson.Exists() {
-					b.rtree.DeleteWithComparator(pentry, GetEntryComparator())
-				}
+		//Iterate through indexes delete pentry; suspended indexes are rebuilt when resumed
+		if !b.idxsusp {
+			for _, ind := range b.indexes {
+				ind.delete(pentry)
 			}
 		}
+		//Delete from Rtree
+		if b.isSpatial(pentry) {
+			b.rtree.DeleteWithComparator(pentry, GetEntryComparator())
+		}
+		if b.bloom != nil {
+			b.bloom.remove(pentry.k)
+		}
 		return pentry
 	}
 	return nil
 }
 
-//startTx returns a new transaction with the specified RW mode and obtains the lock on the bucket. Returns an error if
-//the db or bucket is closed or if the transactions fails to be created.
-func (b *Bucket) startTx(mode RWMode) (*Tx, error) {
+//getTombstone returns the tombstone for the provided key or nil if the key has no tombstone. It is assumed the the caller
+//obtains a lock on the db.
+func (b *Bucket) getTombstone(key string) *Entry {
+	if e := b.tombstones.Get(&Entry{k: key}); e != nil {
+		return e.(*Entry)
+	}
+	return nil
+}
+
+//setTombstone inserts the tombstone replacing and returning the previous tombstone for the key if one exists. It is
+//assumed the the caller obtains a lock on the db.
+func (b *Bucket) setTombstone(ts *Entry) *Entry {
+	if p := b.tombstones.ReplaceOrInsert(ts); p != nil {
+		return p.(*Entry)
+	}
+	return nil
+}
+
+//deleteTombstone removes and returns the tombstone for the provided key if one exists. It is assumed the the caller
+//obtains a lock on the db.
+func (b *Bucket) deleteTombstone(key string) *Entry {
+	if p := b.tombstones.Delete(&Entry{k: key}); p != nil {
+		return p.(*Entry)
+	}
+	return nil
+}
+
+//reapTombstones removes tombstones that have been retained for longer than the tombstone retention of the bucket and
+//returns the number removed. It is assumed the the caller obtains a lock on the db.
+func (b *Bucket) reapTombstones() int {
+	var reap []*Entry
+	cutoff := time.Now().Add(-b.options.tombret)
+	b.tombstones.Ascend(func(item btree.Item) bool {
+		ts := item.(*Entry)
+		if ts.deleted.Before(cutoff) {
+			reap = append(reap, ts)
+		}
+		return true
+	})
+	for _, ts := range reap {
+		b.tombstones.Delete(ts)
+	}
+	return len(reap)
+}
+
+//startTx returns a new transaction with the specified RW mode and obtains the lock on the bucket waiting at most timeout
+//for the lock; a timeout that is not positive waits indefinitely. Returns an error if the db or bucket is closed, if the
+//transactions fails to be created, if the lock was not obtained before the timeout, or if the calling goroutine started
+//a transaction holding the lock that would never be released while waiting for it.
+func (b *Bucket) startTx(mode RWMode, timeout time.Duration) (*Tx, error) {
 	if b.db == nil || !b.db.open || b == nil || !b.open {
 		return nil, errors.New("error: bucket: resource is not open")
 	}
+	if mode == MODE_READ_WRITE && b.db.ReadOnly() {
+		return nil, errors.New("error: bucket: db is read only after a failed bucket file write")
+	}
 	tx, err := newTx(b.db, b, mode)
 	if err != nil {
 		return nil, errors.Annotate(err, "error: bucket: failed to create transaction")
 	}
-	tx.lock()
+	var goid int64
+	if b.db.config.reentrancyCheck {
+		goid = goroutineID()
+	}
+	if b.lockedBy(goid) {
+		return nil, errors.New("error: bucket: bucket already locked by this goroutine")
+	}
+	if !tx.lockTimeout(timeout) {
+		return nil, errLockTimeout
+	}
+	b.own(tx, goid)
 	return tx, nil
 }
 
+//lockedBy returns true if a transaction started by the goroutine with the provided id holds the lock on the bucket. A
+//further transaction from the goroutine could deadlock in either mode: a read/write transaction waits for the readers
+//to finish and the read lock is writer preferring, so a nested read only transaction waits behind a waiting writer, or
+//behind the reader limit of MaxReaders. Returns false if the id is zero.
+func (b *Bucket) lockedBy(goid int64) bool {
+	if goid == 0 {
+		return false
+	}
+	b.ownlock.Lock()
+	defer b.ownlock.Unlock()
+	return b.writer == goid || b.readers[goid] > 0
+}
+
+//own records the goroutine with the provided id as the owner of the lock obtained by the transaction. Nothing is
+//recorded if the id is zero.
+func (b *Bucket) own(t *Tx, goid int64) {
+	if goid == 0 {
+		return
+	}
+	b.ownlock.Lock()
+	defer b.ownlock.Unlock()
+	t.goid = goid
+	if t.mode == MODE_READ_WRITE {
+		b.writer = goid
+	} else {
+		if b.readers == nil {
+			b.readers = make(map[int64]int)
+		}
+		b.readers[goid]++
+	}
+}
+
+//disown removes the owner of the lock obtained by the transaction when the lock is released.
+func (b *Bucket) disown(t *Tx) {
+	if t.goid == 0 {
+		return
+	}
+	b.ownlock.Lock()
+	defer b.ownlock.Unlock()
+	if t.mode == MODE_READ_WRITE {
+		b.writer = 0
+	} else if b.readers[t.goid]--; b.readers[t.goid] <= 0 {
+		delete(b.readers, t.goid)
+	}
+	t.goid = 0
+}
+
 //handleTx executes the provided function against the transaction. The transaction will be committed if and only if the
 //transaction is a Read/Write transaction and the provided function returns a nil error otherwise the transaction will be
-//rolled back.
-func (b *Bucket) handleTx(mode RWMode, f func(t *Tx) error) error {
-	tx, err := b.startTx(mode)
+//rolled back. The lock on the bucket is obtained as startTx does with the provided timeout. Returns the error returned by
+//the provided function after a rollback.
+func (b *Bucket) handleTx(mode RWMode, timeout time.Duration, f func(t *Tx) error) error {
+	tx, err := b.startTx(mode, timeout)
 	if err != nil {
 		return err
 	}
+	b.db.registerTx(tx)
 
 	startTime := time.Now()
 	err = f(tx)
@@ -365,7 +922,9 @@ func (b *Bucket) handleTx(mode RWMode, f func(t *Tx) error) error {
 	}
 
 	if err != nil {
-		err := tx.rollbackTx()
+		if rerr := tx.rollbackTx(); rerr != nil {
+			return rerr
+		}
 		return err
 	}
 	if tx.mode == MODE_READ_WRITE {
@@ -380,126 +939,283 @@ func (b *Bucket) handleTx(mode RWMode, f func(t *Tx) error) error {
 	}
 }
 
-//manager is the main execution loop for the bucket. The manager loop is executed at the specified frequency of the database.
+//manager is the main execution loop for the bucket. The bucket file is checkpointed at the checkpoint frequency and
+//expired entries, tombstones, and invalidations are swept at the sweep frequency of the database.
 func (b *Bucket) manager() error {
 	if b == nil || b.db == nil || b.db.config == nil {
 		return nil
 	}
-	mngct := time.NewTicker(b.db.config.manageFrequency)
-	defer mngct.Stop()
-	for range mngct.C {
+	chkt := time.NewTicker(b.db.config.checkpointInterval())
+	defer chkt.Stop()
+	swpt := time.NewTicker(b.db.config.sweepInterval())
+	defer swpt.Stop()
+	for {
+		var checkpoint bool
+		select {
+		case <-chkt.C:
+			checkpoint = true
+		case <-swpt.C:
+		}
 		b.lock(MODE_READ_WRITE)
 		if !b.db.open {
 			break
 		}
-		if b.db.config.persist {
-			if len(b.aofbuf) > 0 {
-				_, err := b.file.Write(b.aofbuf)
+		if !b.open {
+			//Bucket failed to load; leave the file untouched.
+			b.unlock(MODE_READ_WRITE)
+			continue
+		}
+		b.managed = time.Now()
+		if checkpoint {
+			b.checkpoint()
+		} else {
+			b.sweep()
+		}
+		b.unlock(MODE_READ_WRITE)
+
+		//Todo (cbergoon): Add SysPerf Logic/Write
+	}
+	return nil
+}
+
+//checkpoint writes the AOF buffer to the bucket file, syncs the file as configured, and compacts the file if required.
+//It is assumed the the caller obtains a lock on the bucket.
+func (b *Bucket) checkpoint() {
+	if b.db.config.persist {
+		if len(b.aofbuf) > 0 {
+			err := b.flushAOFBuf()
+			if err != nil {
+				b.db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to write to bucket file")))
+			}
+			if b.db.config.syncFreq == EACH {
+				err := b.file.Sync()
 				if err != nil {
-					fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to write to bucket file")))
+					b.db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to sync1 bucket file")))
 				}
-				b.aofbuf = nil
-				if b.db.config.syncFreq == EACH {
-					err := b.file.Sync()
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to sync1 bucket file")))
-					}
-				} else if b.db.config.syncFreq == MNGFREQ {
-					err := b.file.Sync()
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to sync2 bucket file")))
-					}
+			} else if b.db.config.syncFreq == MNGFREQ {
+				err := b.file.Sync()
+				if err != nil {
+					b.db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to sync2 bucket file")))
 				}
 			}
-			if b != nil && b.data != nil {
-				if b.rct > uint64(b.data.Len()*COMPACT_FACTOR) {
-					err := b.compactLog()
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to compact bucket file")))
-					}
+		}
+		if b != nil && b.data != nil {
+			if b.rct > uint64(b.data.Len()*COMPACT_FACTOR) || b.idle() {
+				err := b.compactLog()
+				if err != nil {
+					b.db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: bucket: failed to compact bucket file")))
 				}
 			}
 		}
+	}
+}
 
-		if b != nil && b.data != nil {
-			for i := 0; i < b.eviction.Len(); i++ {
-				var eitem *Entry
-				mitem := b.eviction.Min()
-				if mitem != nil {
-					eitem = mitem.(*Entry)
-				}
-				if eitem.IsExpired() {
-					b.delete(eitem)
-					//callback
+//sweep removes expired entries, reaps tombstones past retention, and marks entries past their invalidation time as
+//invalid. The duration of the sweep and the number and size of the entries it removes are recorded by the metrics of the
+//db. It is assumed the the caller obtains a lock on the bucket.
+func (b *Bucket) sweep() {
+	start := time.Now()
+	var expired, reaped int
+	var reclaimed int64
+	if b != nil && b.data != nil {
+		if limit := b.db.config.sweepLimit; limit > 0 {
+			expired, reclaimed = b.sweepLimited(limit)
+		} else {
+			//The eviction tree is ordered by expiry time; entries are removed until the earliest has not expired.
+			for b.eviction.Len() > 0 {
+				eitem := b.eviction.Min().(*Entry)
+				if !eitem.IsExpired() {
+					break
 				}
+				reclaimed += b.expire(eitem)
+				expired++
 			}
 		}
+	}
 
-		if b != nil && b.data != nil {
-			for i := 0; i < b.invalidation.Len(); i++ {
-				var eitem *Entry
-				mitem := b.invalidation.Min()
-				if mitem != nil {
-					eitem = mitem.(*Entry)
-				}
-				if eitem.IsInvalid() {
-					eitem.invalid = true
-					//callback
-				}
+	if b != nil && b.tombstones != nil && b.tombstones.Len() > 0 {
+		reaped = b.reapTombstones()
+	}
+
+	if b != nil && b.data != nil {
+		for i := 0; i < b.invalidation.Len(); i++ {
+			var eitem *Entry
+			mitem := b.invalidation.Min()
+			if mitem != nil {
+				eitem = mitem.(*Entry)
+			}
+			if eitem.IsInvalid() {
+				eitem.invalid = true
+				//callback
 			}
 		}
+	}
 
-		b.unlock(MODE_READ_WRITE)
+	if b != nil && b.stale != nil {
+		b.stale.prune(time.Now())
+	}
 
-		//Todo (cbergoon): Add SysPerf Logic/Write
+	metrics := b.db.config.metrics
+	metrics.RecordDuration(b.name, METRIC_SWEEP, time.Since(start))
+	if expired > 0 {
+		metrics.IncrCount(b.name, METRIC_EXPIRED, expired)
+		metrics.IncrCount(b.name, METRIC_EXPIRED_BYTES, int(reclaimed))
 	}
-	return nil
+	if reaped > 0 {
+		metrics.IncrCount(b.name, METRIC_TOMBSTONES_REAPED, reaped)
+	}
+}
+
+//expire removes the expired entry from the bucket and sends it to the expiry channels of the bucket. Returns the size in
+//bytes of the key and value of the entry. It is assumed the the caller obtains a lock on the bucket.
+func (b *Bucket) expire(e *Entry) int64 {
+	b.delete(e)
+	b.notifyExpired(e)
+	return e.size()
 }
 
-//compactLog rewrites the log resulting in a condensed form containing only insert/update statements.
+//sweepLimited removes at most limit expired entries resuming in expiry order after the last entry removed by the
+//previous limited sweep and wrapping around to the earliest expired entry. It is assumed the the caller obtains a lock on
+//the bucket. Returns the number of entries removed and the size in bytes of their keys and values.
+func (b *Bucket) sweepLimited(limit int) (int, int64) {
+	var expired []*Entry
+	collect := func(item btree.Item) bool {
+		e := item.(*Entry)
+		if !e.IsExpired() {
+			return false
+		}
+		expired = append(expired, e)
+		return len(expired) < limit
+	}
+	if b.sweepcur != nil {
+		b.eviction.AscendGreaterOrEqual(b.sweepcur, collect)
+		//Wrap around to the expired entries before the cursor.
+		if len(expired) < limit {
+			b.eviction.AscendLessThan(b.sweepcur, collect)
+		}
+	} else {
+		b.eviction.Ascend(collect)
+	}
+	var reclaimed int64
+	for _, e := range expired {
+		reclaimed += b.expire(e)
+	}
+	if len(expired) > 0 {
+		//The cursor holds a copy of the expiry of the last entry removed; the entry itself may be reused.
+		last := expired[len(expired)-1]
+		opts := *last.opts
+		b.sweepcur = &Entry{k: last.k, opts: &opts}
+	}
+	return len(expired), reclaimed
+}
+
+//compactLog rewrites the log resulting in a condensed form containing only insert/update statements. The compacted log
+//is written and synced to a temporary file that atomically replaces the bucket file so that the bucket file is complete
+//at every point of the compaction; a temporary file left by an interrupted compaction is removed when the bucket is
+//opened.
 func (b *Bucket) compactLog() error {
-	//open new tmp file
+	//open new tmp file; a partial file left by an interrupted compaction is truncated
 	var err error
-	tmpFile, err := os.OpenFile(b.db.getDBFilePath(b.name+BUCKET_TMP_FILE_EXTENSION), os.O_CREATE|os.O_RDWR, 0666)
+	tmpFile, err := os.OpenFile(b.db.getDBFilePath(b.name+BUCKET_TMP_FILE_EXTENSION), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
 	if err != nil {
 		return errors.Annotate(err, "error: bucket: failed to open temporary bucket file")
 	}
 	var buf []byte
+	var werr error
 	b.data.Ascend(func(item btree.Item) bool {
-		eItem := item.(*Entry)
-		return func(e *Entry) bool {
-			buf = append(buf, e.EntryInsertStmt()...)
+		if !item.(*Entry).persists() {
+			return true
+		}
+		buf = append(buf, item.(*Entry).insertStmt(b.options.compress)...)
+		if len(buf) > 1024*1024 {
+			_, werr = tmpFile.Write(buf)
+			buf = nil
+		}
+		return werr == nil
+	})
+	//Tombstones follow the entries so that replaying the compacted file restores them.
+	if werr == nil {
+		b.tombstones.Ascend(func(item btree.Item) bool {
+			if !item.(*Entry).persists() {
+				return true
+			}
+			buf = append(buf, item.(*Entry).EntryTombstoneStmt()...)
 			if len(buf) > 1024*1024 {
-				tmpFile.Write(buf)
+				_, werr = tmpFile.Write(buf)
 				buf = nil
 			}
-			return true
-		}(eItem)
-	})
-	err = tmpFile.Sync()
-	if err != nil {
-		return errors.Annotate(err, "error: bucket: failed to sync temporary bucket file")
+			return werr == nil
+		})
 	}
-	err = b.file.Close()
-	if err != nil {
-		return errors.Annotate(err, "error: bucket: failed to close bucket file")
+	//Counters follow the tombstones in name order.
+	if werr == nil {
+		names := make([]string, 0, len(b.counters))
+		for name := range b.counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf = append(buf, counterStmt(name, b.counters[name])...)
+		}
+	}
+	//The log sequence numbers of deleted entries are not in the compacted file; record the highest number issued.
+	if lsn := b.db.currentLSN(); lsn > 0 {
+		buf = append(buf, sequenceStmt(lsn)...)
+	}
+	if werr == nil && len(buf) > 0 {
+		_, werr = tmpFile.Write(buf)
+	}
+	if werr != nil {
+		tmpFile.Close()
+		return errors.Annotate(werr, "error: bucket: failed to write temporary bucket file")
 	}
-	err = os.Remove(b.db.getDBFilePath(b.name + BUCKET_FILE_EXTENSION))
+	err = tmpFile.Sync()
 	if err != nil {
-		return errors.Annotate(err, "error: bucket: failed to delete bucket file")
+		return errors.Annotate(err, "error: bucket: failed to sync temporary bucket file")
 	}
 	err = tmpFile.Close()
 	if err != nil {
 		return errors.Annotate(err, "error: bucket: failed to close temporary bucket file")
 	}
+	err = b.file.Close()
+	if err != nil {
+		return errors.Annotate(err, "error: bucket: failed to close bucket file")
+	}
+	//The rename replaces the bucket file in a single step; the previous file remains until the rename succeeds.
 	err = os.Rename(b.db.getDBFilePath(b.name+BUCKET_TMP_FILE_EXTENSION), b.db.getDBFilePath(b.name+BUCKET_FILE_EXTENSION))
 	if err != nil {
 		return errors.Annotate(err, "error: bucket: failed to rename bucket file")
 	}
+	syncDir(b.db.config.dirPath)
 	b.file, err = os.OpenFile(b.db.getDBFilePath(b.name+BUCKET_FILE_EXTENSION), os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return errors.Annotate(err, "error: bucket: failed to open bucket file")
 	}
+	//Subsequent statements are appended to the compacted file.
+	if _, err = b.file.Seek(0, io.SeekEnd); err != nil {
+		return errors.Annotate(err, "error: bucket: failed to seek bucket file")
+	}
+	b.rct = uint64(b.data.Len())
+	b.aofct = b.data.Len()
+	return nil
+}
+
+//healthy returns an error if the bucket is not open, its manager has not run since stale, or in persistent mode its
+//bucket file is not writable.
+func (b *Bucket) healthy(stale time.Time) error {
+	b.lock(MODE_READ)
+	defer b.unlock(MODE_READ)
+	if !b.open {
+		return errors.New("error: bucket: bucket " + b.name + " is not open")
+	}
+	if b.managed.Before(stale) {
+		return errors.New("error: bucket: manager of bucket " + b.name + " has not run since " + b.managed.Format(time.RFC3339))
+	}
+	if b.db.config.persist {
+		if err := healthyFile(b.file); err != nil {
+			return errors.Annotate(err, "error: bucket: bucket file of bucket "+b.name+" is not writable")
+		}
+	}
 	return nil
 }
 
@@ -5,18 +5,47 @@ package stitchdb
 
 import (
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/juju/errors"
 )
 
+//ValidationPolicy represents the action taken when an entry replayed from a bucket file fails the validator of the
+//bucket.
+type ValidationPolicy int
+
+const (
+	//VALIDATE_REJECT fails the load of the bucket so the bucket is not opened.
+	VALIDATE_REJECT ValidationPolicy = iota
+	//VALIDATE_QUARANTINE skips the entry, removing any earlier entry of its key, and appends its statement to the
+	//quarantine file of the bucket.
+	VALIDATE_QUARANTINE
+)
+
 //BucketOptions holds bucket metadata.
 type BucketOptions struct {
-	system   bool //Indicates that this bucket is the system bucket.
-	btdeg    int  //Dergee of the B-Tree; used to optimize performance based on use case.
-	geo      bool //Indicates if the bucket is geo enabled or not.
-	georincl bool //Indicates if the range of radius searches are inclusive or exclusive.
-	time     bool //Indicates if the bucket is time series enabled. Todo: Implement
-	dims     int  //Number of dimensions the geo functionality will utilize.
+	system   bool          //Indicates that this bucket is the system bucket.
+	btdeg    int           //Dergee of the B-Tree; used to optimize performance based on use case.
+	geo      bool          //Indicates if the bucket is geo enabled or not.
+	georincl bool          //Indicates if the range of radius searches are inclusive or exclusive.
+	latlon   bool          //Indicates if the locations of the bucket are latitude and longitude in degrees.
+	time     bool          //Indicates if the bucket is time series enabled. Todo: Implement
+	dims     int           //Number of dimensions the geo functionality will utilize.
+	tombret  time.Duration //Duration that tombstones of deleted entries are retained; zero disables tombstones.
+	clones   bool          //Indicates if iterators pass clones of entries to callbacks.
+	maxrdrs  int           //Maximum number of concurrent read transactions; zero is unbounded.
+	bloom    int           //Expected number of entries the bloom filter is sized for; zero disables the filter.
+	maxkeyln int           //Maximum length in bytes of entry keys; zero is unbounded.
+	insord   bool          //Indicates if the bucket keeps its entries ordered by the commit that last wrote them.
+	compress bool          //Indicates if entry values are gzip compressed in the bucket file.
+	stale    time.Duration //Longest time an entry is served by GetTimeout after it was last known current; zero disables.
+	defaults *EntryOptions //Options applied to entries set in the bucket that do not set them; nil if none.
+	//Merges an incoming entry with the existing entry for the same key on set. Not persisted.
+	merge func(existing, incoming *Entry) (*Entry, error)
+	//Validates entries before they are set or replayed. Not persisted.
+	validator func(e *Entry) error
+	valpolicy ValidationPolicy //Action taken when a replayed entry fails the validator. Not persisted.
 }
 
 //System sets the system option.
@@ -31,6 +60,18 @@ func Geo(b *BucketOptions) error {
 	return nil
 }
 
+//GeoLatLon enables geo-location functionality with the "coords" field of entry values holding a latitude and longitude
+//in degrees, in that order, and sets the number of dimensions to two. Setting an entry with a location that does not
+//have two coordinates, a latitude outside [-90, 90], or a longitude outside [-180, 180] returns an error. Radius searches
+//and nearest neighbor searches of the bucket measure great-circle distance in meters, wrapping across the 180th meridian
+//and over the poles.
+func GeoLatLon(b *BucketOptions) error {
+	b.geo = true
+	b.latlon = true
+	b.dims = 2
+	return nil
+}
+
 //GeoRangeIsInclusive enables inclusive range checks.
 func GeoRangeIsInclusive(b *BucketOptions) error {
 	b.georincl = true
@@ -59,6 +100,120 @@ func BTreeDegree(degree int) func(*BucketOptions) error {
 	}
 }
 
+//TombstoneRetention enables tombstones for the bucket. Deleting an entry leaves a tombstone recording the time of the
+//deletion which is retained for the provided duration before being reaped by the bucket manager. Tombstones are written
+//to the bucket file and survive compaction and restarts; the retention is measured from the original deletion time.
+func TombstoneRetention(retention time.Duration) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		b.tombret = retention
+		return nil
+	}
+}
+
+//IterateClones enables passing clones of entries to iterator callbacks. This prevents a retained entry from aliasing live
+//data in the bucket at the cost of an allocation for each entry visited.
+func IterateClones(b *BucketOptions) error {
+	b.clones = true
+	return nil
+}
+
+//MaxReaders bounds the number of read transactions that may hold the bucket concurrently. Additional readers wait for
+//a running reader to finish. Bucket locks are writer-preferring; once a write transaction is waiting new readers wait
+//until the writer completes, so bounding readers limits how long a writer waits on readers already running.
+func MaxReaders(n int) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if n < 0 {
+			return errors.New("error: bucket_optiona: max readers must not be negative")
+		}
+		b.maxrdrs = n
+		return nil
+	}
+}
+
+//BloomFilter enables a bloom filter over the keys of the bucket sized for the expected number of entries. The filter is
+//queried by StitchDB.MightContain to rule out absent keys without a transaction.
+func BloomFilter(entries int) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if entries < 0 {
+			return errors.New("error: bucket_optiona: bloom filter entries must not be negative")
+		}
+		b.bloom = entries
+		return nil
+	}
+}
+
+//MaxKeyLength bounds the length in bytes of the keys of entries set in the bucket. Setting an entry with a longer key
+//returns an error.
+func MaxKeyLength(n int) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if n < 0 {
+			return errors.New("error: bucket_optiona: max key length must not be negative")
+		}
+		b.maxkeyln = n
+		return nil
+	}
+}
+
+//InsertionOrder enables Tx.AscendInsertionOrder for the bucket by keeping the entries in a tree ordered by the log
+//sequence number of the commit that last wrote them. Overwriting an entry moves it to the end of the order.
+func InsertionOrder(b *BucketOptions) error {
+	b.insord = true
+	return nil
+}
+
+//DefaultEntryOptions sets options applied to entries set in the bucket that do not set them themselves: the expiry and
+//invalidation of entries that do not expire or invalidate, the tolerance of entries with a tolerance of zero, and
+//NoPersist. Expiry and invalidation must be set with ExpireAfter and InvalidAfter and are measured from the time each
+//entry is set. Entries replicated, restored, or replayed from the bucket file keep the options they were written with.
+func DefaultEntryOptions(opts *EntryOptions) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if opts == nil {
+			return errors.New("error: bucket_optiona: default entry options must not be nil")
+		}
+		if (opts.doesExp && opts.expTTL == 0) || (opts.doesInv && opts.invTTL == 0) {
+			return errors.New("error: bucket_optiona: default entry options must use ExpireAfter and InvalidAfter")
+		}
+		defaults := *opts
+		b.defaults = &defaults
+		return nil
+	}
+}
+
+//MergeFunc sets the function used to merge an entry being set with the live entry already stored under the same key.
+//The entry returned by f is stored in place of the incoming entry and must have the same key. The merge function is not
+//persisted with the bucket; use StitchDB.SetMergeFunc to set it again after the db is opened.
+func MergeFunc(f func(existing, incoming *Entry) (*Entry, error)) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		b.merge = f
+		return nil
+	}
+}
+
+//Validator sets the function used to validate entries set in the bucket. Set calls f with the entry to be stored, after
+//the default entry options and merge function are applied, and fails without changing the bucket if f returns an error;
+//entries applied by Restore and ApplyReplication are validated as well. Entries replayed from the bucket file when the
+//bucket is loaded with the validator set, such as by CreateBucket over an existing bucket file, are validated and
+//handled as set by OnValidationFailure. The validator is not persisted with the bucket; use the BucketValidator config
+//option to set it again when the db is opened.
+func Validator(f func(e *Entry) error) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		b.validator = f
+		return nil
+	}
+}
+
+//OnValidationFailure sets the action taken when an entry replayed from the bucket file fails the validator of the
+//bucket. The default is VALIDATE_REJECT. The policy is not persisted with the bucket.
+func OnValidationFailure(policy ValidationPolicy) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if policy != VALIDATE_REJECT && policy != VALIDATE_QUARANTINE {
+			return errors.New("error: bucket_options: invalid validation failure policy")
+		}
+		b.valpolicy = policy
+		return nil
+	}
+}
+
 //NewBucketOptions creates a new bucket options using the provided option modifiers.
 func NewBucketOptions(options ...func(*BucketOptions) error) (*BucketOptions, error) {
 	c := &BucketOptions{}
@@ -68,9 +223,34 @@ func NewBucketOptions(options ...func(*BucketOptions) error) (*BucketOptions, er
 			return nil, errors.New("error: bucket_options: could not create bucket options")
 		}
 	}
+	if c.latlon && c.dims != 2 {
+		return nil, errors.New("error: bucket_options: latitude and longitude buckets must have two dimensions")
+	}
 	return c, nil
 }
 
+//StaleReads enables a stale read cache for the bucket used by StitchDB.GetTimeout. Entries returned by Get in read only
+//transactions and entries committed over keys in the cache are recorded with the time they were known current. If
+//GetTimeout cannot obtain the lock on the bucket before its timeout it returns the recorded entry marked as stale,
+//provided it was known current within bound. Entries not known current within bound are removed by the bucket manager.
+func StaleReads(bound time.Duration) func(*BucketOptions) error {
+	return func(b *BucketOptions) error {
+		if bound < 0 {
+			return errors.New("error: bucket_optiona: stale read bound must not be negative")
+		}
+		b.stale = bound
+		return nil
+	}
+}
+
+//Compress gzip compresses the values of the entries written to the bucket file. Compressed values are flagged in their
+//insert statements so that a bucket file holding both compressed and uncompressed values is loaded as written; entries are
+//held uncompressed in memory.
+func Compress(b *BucketOptions) error {
+	b.compress = true
+	return nil
+}
+
 //bucketOptionsCreateStmt returns the statement that represents the bucket options.
 func (b *BucketOptions) bucketOptionsCreateStmt() []byte {
 	var cbuf []byte
@@ -85,11 +265,42 @@ func (b *BucketOptions) bucketOptionsCreateStmt() []byte {
 	cbuf = append(cbuf, strconv.Itoa(boolToInt(b.time))...)
 	cbuf = append(cbuf, ':')
 	cbuf = append(cbuf, strconv.Itoa(b.dims)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.FormatInt(int64(b.tombret), 10)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(b.clones))...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(b.maxrdrs)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(b.bloom)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(b.maxkeyln)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(b.insord))...)
+	var defaults EntryOptions
+	if b.defaults != nil {
+		defaults = *b.defaults
+	}
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.FormatInt(int64(defaults.expTTL), 10)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.FormatInt(int64(defaults.invTTL), 10)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.FormatFloat(defaults.tol, 'f', -1, 64)...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(defaults.noPersist))...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(b.compress))...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(b.latlon))...)
+	cbuf = append(cbuf, ':')
+	cbuf = append(cbuf, strconv.FormatInt(int64(b.stale), 10)...)
 	return cbuf
 }
 
-//NewBucketOptionsFromStmt returns bucket options representing the options portion of the statement. Returns an error if the
-//bucket statement could not be parsed.
+//NewBucketOptionsFromStmt returns bucket options representing the options portion of the statement. Options that follow
+//dims are optional so that statements written by earlier versions can be read. Returns an error if the bucket statement
+//could not be parsed.
 func NewBucketOptionsFromStmt(stmt []string) (*BucketOptions, error) {
 	btdeg, err := strconv.ParseInt(stmt[1], 10, 64)
 	if err != nil {
@@ -107,7 +318,7 @@ func NewBucketOptionsFromStmt(stmt []string) (*BucketOptions, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
 	}
-	time, err := strconv.ParseBool(stmt[5])
+	tseries, err := strconv.ParseBool(stmt[5])
 	if err != nil {
 		return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
 	}
@@ -115,13 +326,139 @@ func NewBucketOptionsFromStmt(stmt []string) (*BucketOptions, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
 	}
+	var tombret int64
+	if len(stmt) > 7 {
+		tombret, err = strconv.ParseInt(stmt[7], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var clones bool
+	if len(stmt) > 8 {
+		clones, err = strconv.ParseBool(stmt[8])
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var maxrdrs int64
+	if len(stmt) > 9 {
+		maxrdrs, err = strconv.ParseInt(stmt[9], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var bloom int64
+	if len(stmt) > 10 {
+		bloom, err = strconv.ParseInt(stmt[10], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var maxkeyln int64
+	if len(stmt) > 11 {
+		maxkeyln, err = strconv.ParseInt(stmt[11], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var insord bool
+	if len(stmt) > 12 {
+		insord, err = strconv.ParseBool(stmt[12])
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var defaults *EntryOptions
+	if len(stmt) > 16 {
+		expTTL, err := strconv.ParseInt(stmt[13], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+		invTTL, err := strconv.ParseInt(stmt[14], 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+		tol, err := strconv.ParseFloat(stmt[15], 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+		noPersist, err := strconv.ParseBool(strings.TrimSpace(stmt[16]))
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+		if expTTL > 0 || invTTL > 0 || tol != 0 || noPersist {
+			defaults = &EntryOptions{
+				doesExp:   expTTL > 0,
+				doesInv:   invTTL > 0,
+				expTTL:    time.Duration(expTTL),
+				invTTL:    time.Duration(invTTL),
+				tol:       tol,
+				noPersist: noPersist,
+			}
+		}
+	}
+	var compress bool
+	if len(stmt) > 17 {
+		compress, err = strconv.ParseBool(strings.TrimSpace(stmt[17]))
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var latlon bool
+	if len(stmt) > 18 {
+		latlon, err = strconv.ParseBool(strings.TrimSpace(stmt[18]))
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
+	var stale int64
+	if len(stmt) > 19 {
+		stale, err = strconv.ParseInt(strings.TrimSpace(stmt[19]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: bucket_optiona: failed to parse bucket options")
+		}
+	}
 	opts := &BucketOptions{
 		btdeg:    int(btdeg),
 		system:   system,
 		geo:      geo,
 		georincl: georincl,
-		time:     time,
+		latlon:   latlon,
+		time:     tseries,
 		dims:     int(dims),
+		tombret:  time.Duration(tombret),
+		clones:   clones,
+		maxrdrs:  int(maxrdrs),
+		bloom:    int(bloom),
+		maxkeyln: int(maxkeyln),
+		insord:   insord,
+		compress: compress,
+		stale:    time.Duration(stale),
+		defaults: defaults,
 	}
 	return opts, nil
 }
+
+//withDefaults applies the default entry options of the bucket to the options the entry does not set. The options of the
+//entry are copied before the defaults are applied so that options shared by several entries are not modified.
+func (b *BucketOptions) withDefaults(e *Entry) {
+	d := b.defaults
+	if d == nil {
+		return
+	}
+	var opts EntryOptions
+	if e.opts != nil {
+		opts = *e.opts
+	}
+	if d.doesExp && !opts.doesExp {
+		opts.doesExp, opts.expTime = true, time.Now().Add(d.expTTL)
+	}
+	if d.doesInv && !opts.doesInv {
+		opts.doesInv, opts.invTime = true, time.Now().Add(d.invTTL)
+	}
+	if opts.tol == 0 {
+		opts.tol = d.tol
+	}
+	opts.noPersist = opts.noPersist || d.noPersist
+	e.opts = &opts
+}
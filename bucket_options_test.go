@@ -6,6 +6,7 @@ package stitchdb
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSystem(t *testing.T) {
@@ -90,6 +91,116 @@ func TestBTreeDegree(t *testing.T) {
 	}
 }
 
+func TestTombstoneRetention(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(TombstoneRetention(time.Minute))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(TombstoneRetention(time.Minute)) returned error \"%v\"", err)
+	}
+	if bucketOptions == nil {
+		t.Errorf("Failure: NewBucketOptions(TombstoneRetention(time.Minute)) returned nil bucket options")
+	}
+	if bucketOptions.tombret != time.Minute {
+		t.Errorf("Failure: NewBucketOptions(TombstoneRetention(time.Minute)) expected bucketOptions.tombret == %v got bucketOptions.tombret == %v", time.Minute, bucketOptions.tombret)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.tombret != time.Minute {
+		t.Errorf("Failure: Expected parsedBucketOptions.tombret == %v got parsedBucketOptions.tombret == %v", time.Minute, parsedBucketOptions.tombret)
+	}
+	legacyBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts[:6]...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts[:6]) returned error \"%v\"", err)
+	}
+	if legacyBucketOptions.tombret != 0 {
+		t.Errorf("Failure: Expected legacyBucketOptions.tombret == 0 got legacyBucketOptions.tombret == %v", legacyBucketOptions.tombret)
+	}
+}
+
+func TestIterateClones(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(IterateClones)
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(IterateClones) returned error \"%v\"", err)
+	}
+	if bucketOptions == nil {
+		t.Errorf("Failure: NewBucketOptions(IterateClones) returned nil bucket options")
+	}
+	if bucketOptions.clones != true {
+		t.Errorf("Failure: NewBucketOptions(IterateClones) expected bucketOptions.clones == true got bucketOptions.clones == %v", bucketOptions.clones)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.clones != true {
+		t.Errorf("Failure: Expected parsedBucketOptions.clones == true got parsedBucketOptions.clones == %v", parsedBucketOptions.clones)
+	}
+}
+
+func TestMaxReaders(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(MaxReaders(4))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(MaxReaders(4)) returned error \"%v\"", err)
+	}
+	if bucketOptions == nil {
+		t.Errorf("Failure: NewBucketOptions(MaxReaders(4)) returned nil bucket options")
+	}
+	if bucketOptions.maxrdrs != 4 {
+		t.Errorf("Failure: NewBucketOptions(MaxReaders(4)) expected bucketOptions.maxrdrs == 4 got bucketOptions.maxrdrs == %v", bucketOptions.maxrdrs)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.maxrdrs != 4 {
+		t.Errorf("Failure: Expected parsedBucketOptions.maxrdrs == 4 got parsedBucketOptions.maxrdrs == %v", parsedBucketOptions.maxrdrs)
+	}
+	_, err = NewBucketOptions(MaxReaders(-1))
+	if err == nil {
+		t.Errorf("Failure: NewBucketOptions(MaxReaders(-1)) expected error got nil")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(BloomFilter(1000))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(BloomFilter(1000)) returned error \"%v\"", err)
+	}
+	if bucketOptions == nil {
+		t.Errorf("Failure: NewBucketOptions(BloomFilter(1000)) returned nil bucket options")
+	}
+	if bucketOptions.bloom != 1000 {
+		t.Errorf("Failure: NewBucketOptions(BloomFilter(1000)) expected bucketOptions.bloom == 1000 got bucketOptions.bloom == %v", bucketOptions.bloom)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.bloom != 1000 {
+		t.Errorf("Failure: Expected parsedBucketOptions.bloom == 1000 got parsedBucketOptions.bloom == %v", parsedBucketOptions.bloom)
+	}
+}
+
+func TestMergeFunc(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(MergeFunc(func(existing, incoming *Entry) (*Entry, error) {
+		return incoming, nil
+	}))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(MergeFunc(...)) returned error \"%v\"", err)
+	}
+	if bucketOptions == nil {
+		t.Errorf("Failure: NewBucketOptions(MergeFunc(...)) returned nil bucket options")
+	}
+	if bucketOptions.merge == nil {
+		t.Errorf("Failure: NewBucketOptions(MergeFunc(...)) expected bucketOptions.merge != nil got nil")
+	}
+}
+
 func TestNewBucketOptions(t *testing.T) {
 	bucketOptions, err := NewBucketOptions(System, Geo, GeoRangeIsInclusive, Time, Dims(3), BTreeDegree(256))
 	if err != nil {
@@ -124,8 +235,8 @@ func TestBucketOptions_bucketOptionsCreateStmt(t *testing.T) {
 		t.Errorf("Failure: NewBucketOptions(System, Geo, GeoRangeIsInclusive, Time, Dims(3), BTreeDegree(256)) returned error \"%v\"", err)
 	}
 	opts := bucketOptions.bucketOptionsCreateStmt()
-	if len(opts) != 13 {
-		t.Errorf("Failure: bucketOptionsCreateStmt() statement expected length 13 got length %v", len(opts))
+	if len(opts) != 39 {
+		t.Errorf("Failure: bucketOptionsCreateStmt() statement expected length 39 got length %v", len(opts))
 	}
 }
 
@@ -135,8 +246,8 @@ func TestNewBucketOptionsFromStmt(t *testing.T) {
 		t.Errorf("Failure: NewBucketOptions(System, Geo, GeoRangeIsInclusive, Time, Dims(3), BTreeDegree(256)) returned error \"%v\"", err)
 	}
 	opts := bucketOptions.bucketOptionsCreateStmt()
-	if len(opts) != 13 {
-		t.Errorf("Failure: bucketOptionsCreateStmt() statement expected length 13 got length %v", len(opts))
+	if len(opts) != 39 {
+		t.Errorf("Failure: bucketOptionsCreateStmt() statement expected length 39 got length %v", len(opts))
 	}
 	parts := strings.Split(string(opts), ":")
 	var partsFull []string
@@ -168,3 +279,129 @@ func TestNewBucketOptionsFromStmt(t *testing.T) {
 		t.Errorf("Failure: Expected bucketOptions.system == true got bucketOptions.system == %v", parsedBucketOptions.system)
 	}
 }
+
+func TestMaxKeyLength(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(MaxKeyLength(16))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(MaxKeyLength(16)) returned error \"%v\"", err)
+	}
+	if bucketOptions.maxkeyln != 16 {
+		t.Errorf("Failure: NewBucketOptions(MaxKeyLength(16)) expected bucketOptions.maxkeyln == 16 got bucketOptions.maxkeyln == %v", bucketOptions.maxkeyln)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.maxkeyln != 16 {
+		t.Errorf("Failure: Expected parsedBucketOptions.maxkeyln == 16 got parsedBucketOptions.maxkeyln == %v", parsedBucketOptions.maxkeyln)
+	}
+	if _, err := NewBucketOptions(MaxKeyLength(-1)); err == nil {
+		t.Error("Failure: NewBucketOptions(MaxKeyLength(-1)) expected error got nil")
+	}
+}
+
+func TestInsertionOrder(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(InsertionOrder)
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(InsertionOrder) returned error \"%v\"", err)
+	}
+	if !bucketOptions.insord {
+		t.Error("Failure: NewBucketOptions(InsertionOrder) expected bucketOptions.insord == true got false")
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if !parsedBucketOptions.insord {
+		t.Error("Failure: Expected parsedBucketOptions.insord == true got false")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(Compress)
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(Compress) returned error \"%v\"", err)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if !parsedBucketOptions.compress {
+		t.Error("Failure: Expected parsedBucketOptions.compress == true got false")
+	}
+	//Statements written before the option existed do not compress.
+	parsedBucketOptions, err = NewBucketOptionsFromStmt(append([]string{""}, parts[:16]...))
+	if err != nil || parsedBucketOptions.compress {
+		t.Errorf("Failure: expected statement without compress option to parse as uncompressed got error \"%v\"", err)
+	}
+}
+
+func TestGeoLatLon(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(GeoLatLon)
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(GeoLatLon) returned error \"%v\"", err)
+	}
+	if !bucketOptions.geo || !bucketOptions.latlon || bucketOptions.dims != 2 {
+		t.Error("Failure: NewBucketOptions(GeoLatLon) resulted in invalid bucket options")
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if !parsedBucketOptions.latlon {
+		t.Error("Failure: Expected parsedBucketOptions.latlon == true got false")
+	}
+	if _, err := NewBucketOptions(GeoLatLon, Dims(3)); err == nil {
+		t.Error("Failure: NewBucketOptions(GeoLatLon, Dims(3)) expected error got nil")
+	}
+}
+
+func TestDefaultEntryOptions(t *testing.T) {
+	defaults, _ := NewEntryOptions(ExpireAfter(time.Minute), NoPersist)
+	bucketOptions, err := NewBucketOptions(DefaultEntryOptions(defaults))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(DefaultEntryOptions(defaults)) returned error \"%v\"", err)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if d := parsedBucketOptions.defaults; d == nil || !d.doesExp || d.expTTL != time.Minute || !d.noPersist || d.doesInv {
+		t.Errorf("Failure: Expected parsed default entry options to expire after 1m and not persist got %v", d)
+	}
+	if parsedBucketOptions, _ := NewBucketOptionsFromStmt(append([]string{""}, strings.Split(string((&BucketOptions{}).bucketOptionsCreateStmt()), ":")...)); parsedBucketOptions.defaults != nil {
+		t.Errorf("Failure: Expected no parsed default entry options got %v", parsedBucketOptions.defaults)
+	}
+	absolute, _ := NewEntryOptions(ExpireTime(time.Now()))
+	if _, err := NewBucketOptions(DefaultEntryOptions(absolute)); err == nil {
+		t.Error("Failure: NewBucketOptions(DefaultEntryOptions(absolute)) expected error got nil")
+	}
+}
+
+func TestStaleReads(t *testing.T) {
+	bucketOptions, err := NewBucketOptions(StaleReads(5 * time.Second))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptions(StaleReads(5 * time.Second)) returned error \"%v\"", err)
+	}
+	parts := strings.Split(string(bucketOptions.bucketOptionsCreateStmt()), ":")
+	parsedBucketOptions, err := NewBucketOptionsFromStmt(append([]string{""}, parts...))
+	if err != nil {
+		t.Errorf("Failure: NewBucketOptionsFromStmt(parts) returned error \"%v\"", err)
+	}
+	if parsedBucketOptions.stale != 5*time.Second {
+		t.Errorf("Failure: Expected parsedBucketOptions.stale == 5s got %v", parsedBucketOptions.stale)
+	}
+	//Statements written before the option existed do not enable stale reads.
+	parsedBucketOptions, err = NewBucketOptionsFromStmt(append([]string{""}, parts[:18]...))
+	if err != nil || parsedBucketOptions.stale != 0 {
+		t.Errorf("Failure: expected statement without stale read option to parse as disabled got error \"%v\"", err)
+	}
+	if _, err := NewBucketOptions(StaleReads(-time.Second)); err == nil {
+		t.Error("Failure: NewBucketOptions(StaleReads(-time.Second)) expected error got nil")
+	}
+}
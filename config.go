@@ -4,6 +4,7 @@
 package stitchdb
 
 import (
+	"strings"
 	"time"
 
 	"github.com/juju/errors"
@@ -21,15 +22,58 @@ const (
 	NONE
 )
 
+//WriteFailurePolicy represents the action taken when a write to a bucket file fails after all retries.
+type WriteFailurePolicy int
+
+const (
+	//WRITE_FAIL_COMMIT fails the commit and rolls back the transaction; later transactions may write again.
+	WRITE_FAIL_COMMIT WriteFailurePolicy = iota
+	//WRITE_FAIL_READ_ONLY fails the commit, rolls back the transaction, and rejects read/write transactions until the db
+	//is reopened.
+	WRITE_FAIL_READ_ONLY
+)
+
 //Config holds StitchDB metadata.
 type Config struct {
-	persist             bool          //Indicates if the db should be persisted to disk.
-	dirPath             string        //Path where db files should be stored.
-	syncFreq            IOFrequency   //Interval at which the db files should be sync'd.
-	manageFrequency     time.Duration //Interval at which db's manager should execute.
-	developer           bool          //Enable developer mode.
-	performanceMonitor  bool          //Enable performance monitor.
-	bucketFileMultLimit int           //Compaction factor of the the bucket file.
+	persist             bool               //Indicates if the db should be persisted to disk.
+	dirPath             string             //Path where db files should be stored.
+	syncFreq            IOFrequency        //Interval at which the db files should be sync'd.
+	manageFrequency     time.Duration      //Interval at which db's manager should execute.
+	sweepFrequency      time.Duration      //Interval at which bucket managers sweep expirations; 0 uses manageFrequency.
+	checkpointFrequency time.Duration      //Interval at which bucket managers flush bucket files; 0 uses manageFrequency.
+	sweepLimit          int                //Maximum number of expired entries removed by a sweep of a bucket; 0 is unbounded.
+	spillThreshold      int                //Number of previous entries a tx holds in memory before spilling; 0 is unbounded.
+	developer           bool               //Enable developer mode.
+	performanceMonitor  bool               //Enable performance monitor.
+	bucketFileMultLimit int                //Compaction factor of the the bucket file.
+	aofBufSize          int                //Capacity in bytes of a bucket's AOF write buffer; 0 is unbounded.
+	logger              Logger             //Destination of diagnostic output.
+	metrics             Metrics            //Destination of operation measurements.
+	compactIdle         time.Duration      //Period without writes after which a bucket file is compacted; 0 is disabled.
+	rejectExisting      bool               //Indicates if CreateBucket fails when a file for the bucket already exists.
+	noManager           bool               //Indicates if the db and bucket managers are not started.
+	mmapReplay          bool               //Indicates if bucket files are memory mapped when replayed on open.
+	reentrancyCheck     bool               //Indicates if transactions record their goroutine to reject reentrant transactions.
+	maxTotalEntries     int64              //Maximum number of entries across the buckets of the db; 0 is unbounded.
+	maxTotalBytes       int64              //Maximum size in bytes of the keys and values of the db; 0 is unbounded.
+	writeRetries        int                //Number of times a failed bucket file write or sync is retried.
+	writeBackoff        time.Duration      //Delay before the first retry of a failed bucket file write; doubles each retry.
+	writeFailure        WriteFailurePolicy //Action taken when a bucket file write fails after all retries.
+	scrubFrequency      time.Duration      //Interval at which the scrubber verifies buckets; 0 disables the scrubber.
+	scrubBudget         int                //Maximum number of records replayed by a pass of the scrubber; 0 is unbounded.
+	queryCacheSize      int                //Number of query results cached per bucket; 0 disables the query cache.
+	//Called periodically while bucket files are replayed.
+	replayProgress func(bucket string, recordsLoaded int)
+	//Called with the result of each bucket verified by the scrubber; nil writes problems to the logger.
+	scrubReport func(bucket string, report VerifyReport, err error)
+	//Validators applied by name to buckets before their bucket files are replayed.
+	validators map[string]bucketValidator
+}
+
+//bucketValidator holds a validator and validation failure policy registered for a bucket with BucketValidator.
+type bucketValidator struct {
+	validator func(e *Entry) error
+	policy    ValidationPolicy
 }
 
 //Persist enables the db to persist to disk.
@@ -54,7 +98,8 @@ func Sync(frequency IOFrequency) func(*Config) error {
 	}
 }
 
-//ManageFrequency sets the frequency at which the the db manager should run.
+//ManageFrequency sets the frequency at which the the db manager should run. Bucket managers also run at this frequency
+//unless SweepFrequency or CheckpointFrequency is set.
 func ManageFrequency(frequency time.Duration) func(*Config) error {
 	return func(c *Config) error {
 		c.manageFrequency = frequency
@@ -62,7 +107,113 @@ func ManageFrequency(frequency time.Duration) func(*Config) error {
 	}
 }
 
-//Developer enables developer mode.
+//SweepFrequency sets the frequency at which bucket managers remove expired entries, reap tombstones, and mark entries
+//past their invalidation time as invalid. By default the manage frequency is used.
+func SweepFrequency(frequency time.Duration) func(*Config) error {
+	return func(c *Config) error {
+		if frequency < 0 {
+			return errors.New("error: config: sweep frequency must not be negative")
+		}
+		c.sweepFrequency = frequency
+		return nil
+	}
+}
+
+//SweepLimit sets the maximum number of expired entries a bucket manager removes in a sweep. A limited sweep resumes in
+//expiry order after the last entry removed by the previous sweep and wraps around to the earliest expired entry so that
+//every expired entry is eventually removed even if entries keep expiring before it. By default a sweep removes every
+//expired entry.
+func SweepLimit(n int) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: sweep limit must not be negative")
+		}
+		c.sweepLimit = n
+		return nil
+	}
+}
+
+//RollbackSpillThreshold sets the number of previous entries a read/write transaction holds in memory to roll back its
+//changes. Previous entries recorded after the threshold is reached are written to a temporary file in the db directory,
+//or the system temporary directory if the db is not persistent, that is read if the transaction is rolled back and
+//removed when the transaction completes. Entries that are not persisted, are invalidated, or have a time to live are
+//always held in memory. By default every previous entry is held in memory.
+func RollbackSpillThreshold(n int) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: rollback spill threshold must not be negative")
+		}
+		c.spillThreshold = n
+		return nil
+	}
+}
+
+//CheckpointFrequency sets the frequency at which bucket managers write buffered statements to the bucket files, sync the
+//files when Sync is MNGFREQ, and compact the files. By default the manage frequency is used.
+func CheckpointFrequency(frequency time.Duration) func(*Config) error {
+	return func(c *Config) error {
+		if frequency < 0 {
+			return errors.New("error: config: checkpoint frequency must not be negative")
+		}
+		c.checkpointFrequency = frequency
+		return nil
+	}
+}
+
+//ScrubFrequency enables the scrubber, a background task of the db manager that verifies buckets as Verify does at the
+//provided frequency to find drift between buckets, their bucket files, and their indexes before it is read. Buckets are
+//verified in name order resuming after the bucket verified last, replaying up to the budget of records set by
+//ScrubBudget per pass. Bucket files are replayed without holding the lock on the bucket, which is only held to compare
+//the bucket with its replayed file. A pass stops early at a bucket that a read/write transaction holds or waits for so
+//that the scrubber gives way to foreground work; the bucket is verified by a later pass. Problems are reported to the
+//function set by ScrubReport or written to the logger. By default the scrubber is disabled.
+func ScrubFrequency(frequency time.Duration) func(*Config) error {
+	return func(c *Config) error {
+		if frequency < 0 {
+			return errors.New("error: config: scrub frequency must not be negative")
+		}
+		c.scrubFrequency = frequency
+		return nil
+	}
+}
+
+//ScrubBudget sets the maximum number of bucket file records the scrubber replays in a pass. A bucket file with more
+//records is replayed over several passes. By default a pass verifies every bucket.
+func ScrubBudget(n int) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: scrub budget must not be negative")
+		}
+		c.scrubBudget = n
+		return nil
+	}
+}
+
+//ScrubReport sets a function called with the result of each bucket verified by the scrubber, including buckets without
+//problems. The function is called from the scrubber without locks held. By default reports with problems and errors are
+//written to the logger.
+func ScrubReport(f func(bucket string, report VerifyReport, err error)) func(*Config) error {
+	return func(c *Config) error {
+		c.scrubReport = f
+		return nil
+	}
+}
+
+//QueryCacheSize enables a cache of the keys returned by Tx.Query holding the results of up to n distinct filters per
+//bucket with the least recently used result evicted first. Any insert or delete in a bucket, including a write of an
+//uncommitted transaction and its rollback, discards the cached results of the bucket so a cached result is only reused
+//while the entries of the bucket are unchanged. By default query results are not cached.
+func QueryCacheSize(n int) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: query cache size must not be negative")
+		}
+		c.queryCacheSize = n
+		return nil
+	}
+}
+
+//Developer enables developer mode. In developer mode the default logger also writes debug and info messages.
 func Developer(c *Config) error {
 	c.developer = true
 	return nil
@@ -82,6 +233,176 @@ func BucketFileMultLimit(limit int) func(*Config) error {
 	}
 }
 
+//IdleCompaction enables compaction of a bucket file once the bucket has not been written to for the provided period. The
+//file is compacted at most once per idle period and only if it contains statements that compaction would remove. A
+//period of 0 (the default) disables idle compaction; size based compaction is unaffected.
+func IdleCompaction(idle time.Duration) func(*Config) error {
+	return func(c *Config) error {
+		if idle < 0 {
+			return errors.New("error: config: idle compaction period must not be negative")
+		}
+		c.compactIdle = idle
+		return nil
+	}
+}
+
+//DisableManager prevents the db and bucket managers from being started so that no background goroutine flushes, syncs,
+//compacts, or sweeps the db. Maintenance runs only when StitchDB.RunMaintenance is called; statements are still written
+//to the bucket files on commit and synced as configured by Sync at commit and on Close.
+func DisableManager(c *Config) error {
+	c.noManager = true
+	return nil
+}
+
+//RejectExistingBucketFiles makes CreateBucket return an error when a bucket file for the bucket already exists, such as
+//the file of a dropped bucket. By default the existing file is loaded into the created bucket.
+func RejectExistingBucketFiles(c *Config) error {
+	c.rejectExisting = true
+	return nil
+}
+
+//WriteRetry retries a bucket file write or sync that fails up to retries times, waiting backoff before the first retry
+//and doubling the wait before each following retry. The bucket is locked while the write is retried. By default failed
+//writes are not retried.
+func WriteRetry(retries int, backoff time.Duration) func(*Config) error {
+	return func(c *Config) error {
+		if retries < 0 || backoff < 0 {
+			return errors.New("error: config: write retries and backoff must not be negative")
+		}
+		c.writeRetries = retries
+		c.writeBackoff = backoff
+		return nil
+	}
+}
+
+//OnWriteFailure sets the action taken when a bucket file write or sync fails after all retries. The commit that failed
+//to write always returns an error and is rolled back. The default is WRITE_FAIL_COMMIT.
+func OnWriteFailure(policy WriteFailurePolicy) func(*Config) error {
+	return func(c *Config) error {
+		if policy != WRITE_FAIL_COMMIT && policy != WRITE_FAIL_READ_ONLY {
+			return errors.New("error: config: invalid write failure policy")
+		}
+		c.writeFailure = policy
+		return nil
+	}
+}
+
+//AOFBufferSize sets the capacity in bytes of each bucket's AOF write buffer. Statements are buffered during commit and
+//written to the bucket file when the buffer reaches capacity and again when the commit completes; a size of 0 (the
+//default) buffers the whole transaction. Sync is applied only at commit boundaries as configured by Sync, so a bounded
+//buffer limits memory for large transactions but a crash mid-commit may leave part of the transaction in the file.
+func AOFBufferSize(size int) func(*Config) error {
+	return func(c *Config) error {
+		if size < 0 {
+			return errors.New("error: config: aof buffer size must not be negative")
+		}
+		c.aofBufSize = size
+		return nil
+	}
+}
+
+//MaxTotalEntries limits the number of entries stored across all buckets of the db, excluding the system buckets, to n.
+//Entries that have expired but have not been removed by the bucket manager are counted. A set that would add an entry
+//beyond the limit fails; sets that replace an entry are not limited by the entry count. Transactions on different
+//buckets are not serialized by the check so concurrent transactions may together exceed the limit by the entries they
+//set at the same time. A limit of 0 (the default) is unbounded. Returns an error if n is negative.
+func MaxTotalEntries(n int64) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: max total entries must not be negative")
+		}
+		c.maxTotalEntries = n
+		return nil
+	}
+}
+
+//MaxTotalBytes limits the total size in bytes of the keys and values stored across all buckets of the db, excluding the
+//system buckets, to n. A set that would grow the total beyond the limit fails; sets that do not grow the total succeed
+//even if the total exceeds the limit. Concurrent transactions on different buckets may exceed the limit as they may
+//for MaxTotalEntries. A limit of 0 (the default) is unbounded. Returns an error if n is negative.
+func MaxTotalBytes(n int64) func(*Config) error {
+	return func(c *Config) error {
+		if n < 0 {
+			return errors.New("error: config: max total bytes must not be negative")
+		}
+		c.maxTotalBytes = n
+		return nil
+	}
+}
+
+//UseLogger sets the logger that receives the db's diagnostic output. By default errors are written to stdout and debug
+//and info messages are written only in developer mode. A nil logger discards all output.
+func UseLogger(l Logger) func(*Config) error {
+	return func(c *Config) error {
+		if l == nil {
+			l = nopLogger{}
+		}
+		c.logger = l
+		return nil
+	}
+}
+
+//UseMetrics sets the metrics that receive measurements of commits, rollbacks, and scans. By default measurements are
+//kept in memory and readable using StitchDB.Stats when the performance monitor is enabled and discarded otherwise.
+func UseMetrics(m Metrics) func(*Config) error {
+	return func(c *Config) error {
+		if m == nil {
+			m = nopMetrics{}
+		}
+		c.metrics = m
+		return nil
+	}
+}
+
+//MmapReplay memory maps each bucket file while it is replayed on open instead of reading it through the file handle,
+//which reduces copying when loading large bucket files. Statements are validated against their length prefixes as they
+//are when the file is read; the bucket file format holds no checksums. Memory mapping is only available on unix
+//platforms; elsewhere the option is ignored and bucket files are read. A bucket file truncated or modified by another
+//process while it is mapped may fault the process so bucket files must not be changed externally while the db opens.
+func MmapReplay(c *Config) error {
+	c.mmapReplay = true
+	return nil
+}
+
+//BucketValidator registers the validator and validation failure policy of the bucket with the provided name; see
+//Validator and OnValidationFailure. The validator is applied to the bucket when it is loaded by Open, before its bucket
+//file is replayed, so entries written before the db was closed are validated on every restart. It is also applied by
+//CreateBucket when the bucket options provided do not set a validator. Returns an error if f is nil or the policy is
+//invalid.
+func BucketValidator(bucket string, f func(e *Entry) error, policy ValidationPolicy) func(*Config) error {
+	return func(c *Config) error {
+		if f == nil {
+			return errors.New("error: config: bucket validator must not be nil")
+		}
+		if policy != VALIDATE_REJECT && policy != VALIDATE_QUARANTINE {
+			return errors.New("error: config: invalid validation failure policy")
+		}
+		if c.validators == nil {
+			c.validators = make(map[string]bucketValidator)
+		}
+		c.validators[strings.TrimSpace(bucket)] = bucketValidator{validator: f, policy: policy}
+		return nil
+	}
+}
+
+//DetectReentrantTx makes a goroutine that starts a transaction on a bucket while a transaction it started on the same
+//bucket is open receive an error reporting that the bucket is already locked by this goroutine instead of deadlocking.
+//The goroutine starting each transaction is identified from its stack trace, which costs a few microseconds per
+//transaction, so the check is disabled by default.
+func DetectReentrantTx(c *Config) error {
+	c.reentrancyCheck = true
+	return nil
+}
+
+//ReplayProgress sets a function called periodically while Open replays bucket files with the name of the bucket and the
+//number of records replayed from its file so far. The function is called with the db locked and must not use the db.
+func ReplayProgress(f func(bucket string, recordsLoaded int)) func(*Config) error {
+	return func(c *Config) error {
+		c.replayProgress = f
+		return nil
+	}
+}
+
 //NewConfig creates a new config using the provided option modifiers.
 func NewConfig(options ...func(*Config) error) (*Config, error) {
 	// Defaults for required values
@@ -97,5 +418,31 @@ func NewConfig(options ...func(*Config) error) (*Config, error) {
 			return nil, errors.Annotate(err, "error: config: could not create configuration")
 		}
 	}
+	if c.logger == nil {
+		c.logger = &stdLogger{developer: c.developer}
+	}
+	if c.metrics == nil {
+		if c.performanceMonitor {
+			c.metrics = newMemMetrics()
+		} else {
+			c.metrics = nopMetrics{}
+		}
+	}
 	return c, nil
 }
+
+//sweepInterval returns the interval at which bucket managers sweep expirations.
+func (c *Config) sweepInterval() time.Duration {
+	if c.sweepFrequency > 0 {
+		return c.sweepFrequency
+	}
+	return c.manageFrequency
+}
+
+//checkpointInterval returns the interval at which bucket managers flush bucket files.
+func (c *Config) checkpointInterval() time.Duration {
+	if c.checkpointFrequency > 0 {
+		return c.checkpointFrequency
+	}
+	return c.manageFrequency
+}
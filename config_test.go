@@ -60,6 +60,68 @@ func TestBucketFileMultLimit(t *testing.T) {
 	}
 }
 
+func TestAOFBufferSize(t *testing.T) {
+	config, err := NewConfig(AOFBufferSize(4096))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(AOFBufferSize(4096)) returned error \"%v\"", err)
+	}
+	if config == nil {
+		t.Errorf("Failure: NewConfig(AOFBufferSize(4096)) returned nil config")
+	}
+	if config.aofBufSize != 4096 {
+		t.Errorf("Failure: NewConfig(AOFBufferSize(4096)) expected config.aofBufSize == 4096 got config.aofBufSize == %v", config.aofBufSize)
+	}
+	_, err = NewConfig(AOFBufferSize(-1))
+	if err == nil {
+		t.Errorf("Failure: NewConfig(AOFBufferSize(-1)) expected error got nil")
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	config, err := NewConfig(WriteRetry(3, time.Millisecond))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(WriteRetry(3, time.Millisecond)) returned error \"%v\"", err)
+	}
+	if config.writeRetries != 3 || config.writeBackoff != time.Millisecond {
+		t.Errorf("Failure: NewConfig(WriteRetry(3, time.Millisecond)) expected 3 retries and 1ms backoff got %v and %v", config.writeRetries, config.writeBackoff)
+	}
+	_, err = NewConfig(WriteRetry(-1, time.Millisecond))
+	if err == nil {
+		t.Errorf("Failure: NewConfig(WriteRetry(-1, time.Millisecond)) expected error got nil")
+	}
+}
+
+func TestOnWriteFailure(t *testing.T) {
+	config, err := NewConfig(OnWriteFailure(WRITE_FAIL_READ_ONLY))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(OnWriteFailure(WRITE_FAIL_READ_ONLY)) returned error \"%v\"", err)
+	}
+	if config.writeFailure != WRITE_FAIL_READ_ONLY {
+		t.Errorf("Failure: NewConfig(OnWriteFailure(WRITE_FAIL_READ_ONLY)) expected WRITE_FAIL_READ_ONLY got %v", config.writeFailure)
+	}
+	_, err = NewConfig(OnWriteFailure(WriteFailurePolicy(5)))
+	if err == nil {
+		t.Errorf("Failure: NewConfig(OnWriteFailure(WriteFailurePolicy(5))) expected error got nil")
+	}
+}
+
+func TestUseLogger(t *testing.T) {
+	config, err := NewConfig(UseLogger(nil))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(UseLogger(nil)) returned error \"%v\"", err)
+	}
+	if _, ok := config.logger.(nopLogger); !ok {
+		t.Errorf("Failure: NewConfig(UseLogger(nil)) expected nop logger got %T", config.logger)
+	}
+	config, err = NewConfig(Developer)
+	if err != nil {
+		t.Errorf("Failure: NewConfig(Developer) returned error \"%v\"", err)
+	}
+	if l, ok := config.logger.(*stdLogger); !ok || !l.developer {
+		t.Errorf("Failure: NewConfig(Developer) expected developer std logger got %#v", config.logger)
+	}
+}
+
 func TestManageFrequency(t *testing.T) {
 	config, err := NewConfig(ManageFrequency(time.Second))
 	if err != nil {
@@ -73,6 +135,86 @@ func TestManageFrequency(t *testing.T) {
 	}
 }
 
+func TestSweepCheckpointFrequency(t *testing.T) {
+	config, err := NewConfig(ManageFrequency(time.Second), SweepFrequency(time.Millisecond), CheckpointFrequency(time.Minute))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(...) returned error \"%v\"", err)
+	}
+	if config.sweepInterval() != time.Millisecond || config.checkpointInterval() != time.Minute {
+		t.Errorf("Failure: expected sweep interval 1ms and checkpoint interval 1m got %v and %v", config.sweepInterval(), config.checkpointInterval())
+	}
+	config, _ = NewConfig(ManageFrequency(time.Second))
+	if config.sweepInterval() != time.Second || config.checkpointInterval() != time.Second {
+		t.Errorf("Failure: expected sweep and checkpoint intervals to default to 1s got %v and %v", config.sweepInterval(), config.checkpointInterval())
+	}
+	if _, err := NewConfig(SweepFrequency(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(SweepFrequency(-1)) expected error got nil")
+	}
+	if _, err := NewConfig(CheckpointFrequency(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(CheckpointFrequency(-1)) expected error got nil")
+	}
+	if _, err := NewConfig(IdleCompaction(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(IdleCompaction(-1)) expected error got nil")
+	}
+	if config, _ := NewConfig(SweepLimit(10)); config.sweepLimit != 10 {
+		t.Errorf("Failure: NewConfig(SweepLimit(10)) expected config.sweepLimit == 10 got %v", config.sweepLimit)
+	}
+	if _, err := NewConfig(SweepLimit(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(SweepLimit(-1)) expected error got nil")
+	}
+	if config, _ := NewConfig(RollbackSpillThreshold(100)); config.spillThreshold != 100 {
+		t.Errorf("Failure: NewConfig(RollbackSpillThreshold(100)) expected config.spillThreshold == 100 got %v", config.spillThreshold)
+	}
+	if _, err := NewConfig(RollbackSpillThreshold(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(RollbackSpillThreshold(-1)) expected error got nil")
+	}
+	if config, _ := NewConfig(ScrubFrequency(time.Minute), ScrubBudget(2)); config.scrubFrequency != time.Minute || config.scrubBudget != 2 {
+		t.Errorf("Failure: NewConfig(ScrubFrequency(time.Minute), ScrubBudget(2)) expected scrub settings got %v %v", config.scrubFrequency, config.scrubBudget)
+	}
+	if _, err := NewConfig(ScrubFrequency(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(ScrubFrequency(-1)) expected error got nil")
+	}
+	if _, err := NewConfig(ScrubBudget(-1)); err == nil {
+		t.Errorf("Failure: NewConfig(ScrubBudget(-1)) expected error got nil")
+	}
+}
+
+func TestDisableManager(t *testing.T) {
+	config, err := NewConfig(DisableManager)
+	if err != nil {
+		t.Errorf("Failure: NewConfig(DisableManager) returned error \"%v\"", err)
+	}
+	if !config.noManager {
+		t.Errorf("Failure: NewConfig(DisableManager) expected config.noManager == true got false")
+	}
+}
+
+func TestMmapReplay(t *testing.T) {
+	config, err := NewConfig(MmapReplay)
+	if err != nil {
+		t.Errorf("Failure: NewConfig(MmapReplay) returned error \"%v\"", err)
+	}
+	if !config.mmapReplay {
+		t.Errorf("Failure: NewConfig(MmapReplay) expected config.mmapReplay == true got false")
+	}
+}
+
+func TestMaxTotalEntries(t *testing.T) {
+	config, err := NewConfig(MaxTotalEntries(10), MaxTotalBytes(1024))
+	if err != nil {
+		t.Errorf("Failure: NewConfig(MaxTotalEntries(10), MaxTotalBytes(1024)) returned error \"%v\"", err)
+	}
+	if config.maxTotalEntries != 10 || config.maxTotalBytes != 1024 {
+		t.Errorf("Failure: NewConfig(...) expected limits 10 and 1024 got %v and %v", config.maxTotalEntries, config.maxTotalBytes)
+	}
+	if _, err := NewConfig(MaxTotalEntries(-1)); err == nil {
+		t.Error("Failure: NewConfig(MaxTotalEntries(-1)) expected error got nil")
+	}
+	if _, err := NewConfig(MaxTotalBytes(-1)); err == nil {
+		t.Error("Failure: NewConfig(MaxTotalBytes(-1)) expected error got nil")
+	}
+}
+
 func TestDeveloper(t *testing.T) {
 	config, err := NewConfig(Developer)
 	if err != nil {
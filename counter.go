@@ -0,0 +1,135 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+//counter is a named integer of a bucket held apart from the entries of the bucket.
+type counter struct {
+	value int64  //Value of the counter.
+	lsn   uint64 //Log sequence number of the commit that last wrote the counter; zero if not committed.
+}
+
+//IncrCounter adds delta to the counter of the bucket with the provided name and returns the new value. A counter that
+//does not exist starts at zero. Counters are held in a map apart from the entries of the bucket so they are not visited
+//by iterators, indexed, or subject to expiry; each commit writes the value of the changed counters to the bucket file and
+//rolling back the transaction restores their previous values. Returns an error if the name is empty or contains '~' or
+//a newline, the value would overflow, the transaction is read only, or the db or bucket is closed.
+func (t *Tx) IncrCounter(name string, delta int64) (int64, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return 0, errors.New("error: tx: cannot increment counter; db is in invalid state")
+	}
+	if t.mode != MODE_READ_WRITE {
+		return 0, errors.New("error: tx: cannot increment counter in read only transaction")
+	}
+	if name == "" || strings.ContainsAny(name, "~\n") {
+		return 0, errors.New("error: tx: invalid counter name")
+	}
+	curr := t.bkt.counters[name].value
+	if (delta > 0 && curr > math.MaxInt64-delta) || (delta < 0 && curr < math.MinInt64-delta) {
+		return 0, errors.New("error: tx: counter " + name + " would overflow")
+	}
+	t.setCounter(name, curr+delta)
+	return curr + delta, nil
+}
+
+//Counters returns the value of each counter of the bucket by name. Returns an error if the db or bucket is closed.
+func (t *Tx) Counters() (map[string]int64, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot get counters; db is in invalid state")
+	}
+	res := make(map[string]int64, len(t.bkt.counters))
+	for name, c := range t.bkt.counters {
+		res[name] = c.value
+	}
+	return res, nil
+}
+
+//setCounter sets the value of the counter with the provided name recording the previous value for rollback and the
+//name for commit.
+func (t *Tx) setCounter(name string, value int64) {
+	if t.rbctx.backwardCounter == nil {
+		t.rbctx.backwardCounter = make(map[string]*counter)
+	}
+	if _, ok := t.rbctx.backwardCounter[name]; !ok {
+		if c, ok := t.bkt.counters[name]; ok {
+			t.rbctx.backwardCounter[name] = &c
+		} else {
+			t.rbctx.backwardCounter[name] = nil
+		}
+	}
+	t.bkt.counters[name] = counter{value: value, lsn: t.bkt.counters[name].lsn}
+}
+
+//writeCounters appends a counter statement for each counter changed by the transaction to the write buffer in name
+//order. Called from commitTx which holds the lock on the bucket.
+func (t *Tx) writeCounters() error {
+	names := make([]string, 0, len(t.rbctx.backwardCounter))
+	for name := range t.rbctx.backwardCounter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := t.bkt.counters[name]
+		c.lsn = t.db.nextLSN()
+		t.bkt.counters[name] = c
+		//Counter statements are superseded by the next write of the counter and count toward compaction.
+		t.bkt.rct++
+		if err := t.bkt.appendAOFBuf(counterStmt(name, c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//counterStmt builds and returns the counter statement recording the value of the counter with the provided name and the
+//log sequence number of the commit that wrote it.
+func counterStmt(name string, c counter) []byte {
+	var buf, cbuf []byte
+
+	cbuf = append(cbuf, "COUNTER"...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, name...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, strconv.FormatInt(c.value, 10)...)
+	if c.lsn > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.FormatUint(c.lsn, 10)...)
+	}
+	cbuf = append(cbuf, '\n')
+
+	buf = append(buf, strconv.Itoa(len(cbuf))...)
+	buf = append(buf, '\n')
+	buf = append(buf, cbuf...)
+
+	return buf
+}
+
+//newCounterFromStmt parses the counter statement provided and returns the name and counter it represents. Returns an
+//error if the statement could not be parsed.
+func newCounterFromStmt(stmtParts []string) (string, counter, error) {
+	if len(stmtParts) < 3 {
+		return "", counter{}, errors.New("error: bucket: invalid counter statement")
+	}
+	var c counter
+	var err error
+	c.value, err = strconv.ParseInt(strings.TrimSpace(stmtParts[2]), 10, 64)
+	if err != nil {
+		return "", counter{}, errors.Annotate(err, "error: bucket: invalid counter statement")
+	}
+	if len(stmtParts) > 3 {
+		c.lsn, err = strconv.ParseUint(strings.TrimSpace(stmtParts[3]), 10, 64)
+		if err != nil {
+			return "", counter{}, errors.Annotate(err, "error: bucket: invalid counter statement")
+		}
+	}
+	return stmtParts[1], c, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTx_IncrCounter(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("counters", opts)
+	db.Update("counters", func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			tx.IncrCounter("views", 2)
+		}
+		if v, err := tx.IncrCounter("hits", -1); err != nil || v != -1 {
+			t.Errorf("Failure: tx.IncrCounter(\"hits\", -1) expected -1 got %d error \"%v\"", v, err)
+		}
+		if _, err := tx.IncrCounter("bad~name", 1); err == nil {
+			t.Error("Failure: tx.IncrCounter(\"bad~name\", 1) expected error got nil")
+		}
+		if _, err := tx.IncrCounter("views", math.MaxInt64); err == nil {
+			t.Error("Failure: tx.IncrCounter(\"views\", math.MaxInt64) expected overflow error got nil")
+		}
+		if size, _ := tx.Size(""); size != 0 {
+			t.Errorf("Failure: expected counters not to be entries got %d entries", size)
+		}
+		return nil
+	})
+	//Rolling back restores changed counters and removes created counters.
+	db.Update("counters", func(tx *Tx) error {
+		tx.IncrCounter("views", 10)
+		tx.IncrCounter("new", 1)
+		return fmt.Errorf("rollback")
+	})
+	expect := func(when string) {
+		db.View("counters", func(tx *Tx) error {
+			counters, err := tx.Counters()
+			if err != nil {
+				t.Errorf("Failure: tx.Counters() returned error \"%v\"", err)
+			}
+			if len(counters) != 2 || counters["views"] != 6 || counters["hits"] != -1 {
+				t.Errorf("Failure: expected counters map[hits:-1 views:6] %s got %v", when, counters)
+			}
+			if _, err := tx.IncrCounter("views", 1); err == nil {
+				t.Error("Failure: tx.IncrCounter(\"views\", 1) expected error in read only transaction got nil")
+			}
+			return nil
+		})
+	}
+	expect("after rollback")
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen")
+	b := db.buckets["counters"]
+	b.lock(MODE_READ_WRITE)
+	if err := b.compactLog(); err != nil {
+		t.Errorf("Failure: b.compactLog() returned error \"%v\"", err)
+	}
+	b.unlock(MODE_READ_WRITE)
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after compaction")
+	db.DropBucket("counters")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
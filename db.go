@@ -7,12 +7,16 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
 
+	"github.com/cbergoon/btree"
 	"github.com/juju/errors"
 )
 
@@ -23,6 +27,13 @@ const (
 	BUCKET_FILE_EXTENSION string = ".stitch"
 	//BUCKET_TMP_FILE_EXTENSION is the bucket AOF file extension used when replacing file
 	BUCKET_TMP_FILE_EXTENSION string = ".stitch.tmp"
+	//BUCKET_REPLACE_FILE_EXTENSION is the bucket AOF file extension used when building a replacement bucket
+	BUCKET_REPLACE_FILE_EXTENSION string = ".stitch.replace"
+	//BUCKET_QUARANTINE_FILE_EXTENSION is the file extension of the statements that failed validation during replay
+	BUCKET_QUARANTINE_FILE_EXTENSION string = ".stitch.quarantine"
+	//HEALTHY_MANAGE_INTERVALS is the number of manage intervals a manager may go without running before the db is
+	//reported as unhealthy
+	HEALTHY_MANAGE_INTERVALS = 3
 )
 
 //StitchDB represents the database object. All operations on the database originate from this object.
@@ -37,14 +48,33 @@ type StitchDB struct {
 	bktcfgfrc    int
 	sysntry      *SystemEntry
 	sysperfentry *SystemPerformanceEntry
+	loaded       map[string]int
+	unloaded     map[string][]byte //Create statements of buckets that could not be created from the bucket config file.
+	managed      time.Time         //Time the db manager last ran.
+	repllock     sync.Mutex        //Lock for the replication state.
+	streams      []chan []byte     //Replication streams receiving committed records.
+	replepoch    string            //Identifies the run of the db numbering the records sent to the streams; set by Open.
+	replseq      uint64            //Sequence number of the last record sent to the replication streams.
+	replfrom     string            //Epoch of the primary run that sent the last replication record applied to the db.
+	replapplied  uint64            //Sequence number of the last replication record applied to the db.
+	txlock       sync.Mutex        //Lock for the active transactions.
+	active       map[uint64]TxInfo //Transactions holding the lock on their bucket by transaction id.
+	txseq        uint64            //Id of the last registered transaction.
+	scrubbed     string            //Name of the bucket verified last by the scrubber.
+	scrubbing    *scrubState       //Verification of the scrubber in progress; nil between buckets.
+	lsn          uint64            //Highest log sequence number issued; accessed atomically.
+	readonly     int32             //Set to 1 when a failed write makes the db read only; accessed atomically.
+	totalEntries int64             //Number of entries in the buckets other than the system buckets; accessed atomically.
+	totalBytes   int64             //Size of the keys and values of totalEntries; accessed atomically.
 }
 
 //NewStitchDB returns a new StitchDB with the specified configuration. Note: this function only creates the representation
 //of the DB and does not open or start the db.
 func NewStitchDB(config *Config) (*StitchDB, error) {
 	stitch := &StitchDB{
-		config:  config,
-		buckets: make(map[string]*Bucket),
+		config:   config,
+		buckets:  make(map[string]*Bucket),
+		unloaded: make(map[string][]byte),
 	}
 	sysbktopts, err := NewBucketOptions(BTreeDegree(32), System, Time)
 	if err != nil {
@@ -97,7 +127,7 @@ func (db *StitchDB) readConfigFileBuckets() (map[string][]string, error) {
 //of the statement.
 func parseStmtTypeName(stmt string) (string, []string, error) {
 	parts := strings.Split(stmt, ":")
-	if len(parts) == 8 && parts[0] == "CREATE" {
+	if len(parts) >= 8 && parts[0] == "CREATE" {
 		return strings.TrimSpace(parts[1]), parts[1:], nil
 	} else if len(parts) == 2 && parts[0] == "DROP" {
 		return strings.TrimSpace(parts[1]), nil, nil
@@ -113,21 +143,27 @@ func (db *StitchDB) getDBFilePath(fileName string) string {
 
 //Open initializes the db for use and starts the manager routine. Open opens/creates the main db append only file, parses
 //the statements within, creates the buckets stored in the file, and opens each bucket. Returns an error if the process was
-//not able to create the directory, failed to read the stitch db
+//not able to create the directory, failed to read the stitch db. If one or more buckets fail to load the db is still opened
+//with the buckets that loaded successfully and an *OpenError describing the failed buckets is returned.
 func (db *StitchDB) Open() error {
+	openErr := &OpenError{}
 	se := &SystemEntry{
 		Version:         STITCH_VERSION,
 		InitialLoadTime: time.Now(),
+		RecordsLoaded:   make(map[string]int),
 	}
 	startUpTimeStart := time.Now()
 	db.lock(MODE_READ_WRITE)
+	db.resetReplication()
 	if db.config.persist {
 		err := os.MkdirAll(db.config.dirPath, os.ModePerm)
 		if err != nil {
+			db.unlock(MODE_READ_WRITE)
 			return errors.Annotate(err, "error: db: failed to create stitch directory")
 		}
 		bktStmts, err := db.readConfigFileBuckets()
 		if err != nil {
+			db.unlock(MODE_READ_WRITE)
 			return errors.Annotate(err, "error: db: failed to read stitch file")
 		}
 		loadStart := time.Now()
@@ -135,11 +171,19 @@ func (db *StitchDB) Open() error {
 			if bktStmtParts != nil && len(bktStmtParts) > 0 {
 				bucket, err := NewBucketFromStmt(db, bktStmts[bktName])
 				if err != nil {
-					return errors.Annotate(err, "error: db: failed to create bucket from statement")
+					openErr.add(bktName, errors.Annotate(err, "error: db: failed to create bucket from statement"))
+					//Keep the statement so that rewriting the bucket config file does not discard the bucket.
+					db.unloaded[bktName] = []byte("CREATE:" + strings.Join(bktStmtParts, ":") + "\n")
+					se.BucketList = append(se.BucketList, bktName)
+					continue
 				}
+				db.applyConfigValidator(bktName, bucket.options)
 				db.buckets[bktName] = bucket
-				db.buckets[bktName].openBucket(db.getDBFilePath(bktName + BUCKET_FILE_EXTENSION))
-				//fmt.Println(db.getDBFilePath(bktName + BUCKET_FILE_EXTENSION))
+				err = db.buckets[bktName].openBucket(db.getDBFilePath(bktName + BUCKET_FILE_EXTENSION))
+				if err != nil {
+					openErr.add(bktName, err)
+				}
+				se.RecordsLoaded[bktName] = db.buckets[bktName].loaded
 			}
 			se.BucketList = append(se.BucketList, bktName)
 		}
@@ -151,8 +195,25 @@ func (db *StitchDB) Open() error {
 	if db.config.performanceMonitor {
 		db.systemperf.openBucket(db.getDBFilePath("_sysperf" + BUCKET_FILE_EXTENSION))
 	}
+	db.loaded = se.RecordsLoaded
+	//Resume log sequence numbers after the highest number in the bucket files.
+	db.lsn = db.system.lsn
+	if db.systemperf != nil && db.systemperf.lsn > db.lsn {
+		db.lsn = db.systemperf.lsn
+	}
+	for _, b := range db.buckets {
+		if b.lsn > db.lsn {
+			db.lsn = b.lsn
+		}
+	}
 	db.open = true
-	go db.runManager()
+	db.managed = time.Now()
+	atomic.StoreInt32(&db.readonly, 0)
+	//The managers are started while the db is locked so that a bucket created once the lock is released is not also
+	//given a manager here.
+	if !db.config.noManager {
+		db.runManager()
+	}
 	db.unlock(MODE_READ_WRITE)
 	db.Update("_sys", func(t *Tx) error {
 		entryOptions, err := NewEntryOptions()
@@ -170,26 +231,234 @@ func (db *StitchDB) Open() error {
 		}
 		return err
 	})
+	if len(openErr.Buckets) > 0 {
+		return openErr
+	}
+	return nil
+}
+
+//OpenError is returned by Open when one or more buckets could not be loaded. Buckets that failed to load remain in the db
+//but are not open; transactions against them return an error.
+type OpenError struct {
+	Buckets map[string]error //Errors encountered keyed by the name of the bucket that failed to load.
+}
+
+//add records the error for the named bucket.
+func (e *OpenError) add(bucket string, err error) {
+	if e.Buckets == nil {
+		e.Buckets = make(map[string]error)
+	}
+	e.Buckets[bucket] = err
+}
+
+//Error returns a description of each bucket that failed to load.
+func (e *OpenError) Error() string {
+	names := make([]string, 0, len(e.Buckets))
+	for name := range e.Buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, name+": "+e.Buckets[name].Error())
+	}
+	return "error: db: failed to load buckets; " + strings.Join(msgs, "; ")
+}
+
+//RecordsLoaded returns the number of records replayed from each bucket's file by the last call to Open.
+func (db *StitchDB) RecordsLoaded() map[string]int {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	loaded := make(map[string]int, len(db.loaded))
+	for name, n := range db.loaded {
+		loaded[name] = n
+	}
+	return loaded
+}
+
+//Stats returns a snapshot of the measurements kept by the default metrics. The snapshot is empty if the performance
+//monitor is disabled or a Metrics implementation was provided using UseMetrics.
+func (db *StitchDB) Stats() Stats {
+	if m, ok := db.config.metrics.(*memMetrics); ok {
+		return m.snapshot()
+	}
+	return Stats{}
+}
+
+//Usage returns the number of entries and the total size in bytes of their keys and values across all buckets of the db
+//excluding the system buckets, as limited by MaxTotalEntries and MaxTotalBytes.
+func (db *StitchDB) Usage() (entries int64, bytes int64) {
+	return atomic.LoadInt64(&db.totalEntries), atomic.LoadInt64(&db.totalBytes)
+}
+
+//nextLSN issues the next log sequence number.
+func (db *StitchDB) nextLSN() uint64 {
+	return atomic.AddUint64(&db.lsn, 1)
+}
+
+//currentLSN returns the highest log sequence number issued.
+func (db *StitchDB) currentLSN() uint64 {
+	return atomic.LoadUint64(&db.lsn)
+}
+
+//recordLSN writes the highest log sequence number issued to the system bucket file so that numbers are not reissued
+//after the db is reopened.
+func (db *StitchDB) recordLSN() {
+	lsn := db.currentLSN()
+	if !db.config.persist || lsn == 0 {
+		return
+	}
+	db.system.lock(MODE_READ_WRITE)
+	defer db.system.unlock(MODE_READ_WRITE)
+	err := db.system.appendAOFBuf(sequenceStmt(lsn))
+	if err == nil {
+		err = db.system.writeAOFBuf()
+	}
+	if err != nil {
+		db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to record log sequence number")))
+	}
+}
+
+//ReadOnly returns true if a bucket file write failed under the WRITE_FAIL_READ_ONLY policy. Read/write transactions are
+//rejected until the db is closed and opened again.
+func (db *StitchDB) ReadOnly() bool {
+	return atomic.LoadInt32(&db.readonly) == 1
+}
+
+//setReadOnly makes the db reject read/write transactions.
+func (db *StitchDB) setReadOnly() {
+	if atomic.CompareAndSwapInt32(&db.readonly, 0, 1) {
+		db.config.logger.Errorf("%v", errors.New("error: db: db is read only after a failed bucket file write"))
+	}
+}
+
+//IsOpen returns true if the db is open.
+func (db *StitchDB) IsOpen() bool {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	return db.open
+}
+
+//BucketIsOpen returns true if the db is open and the bucket specified by the bucket name provided is open. A bucket that
+//failed to load is not open. Returns false if the db is closed. Returns an error if the db is open and the bucket is
+//invalid.
+func (db *StitchDB) BucketIsOpen(name string) (bool, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return false, nil
+	}
+	b, err := db.getBucket(name)
+	if err != nil || b == nil {
+		return false, errors.New("error: db: invalid bucket")
+	}
+	b.lock(MODE_READ)
+	defer b.unlock(MODE_READ)
+	return b.open, nil
+}
+
+//BucketFilePath returns the path of the file backing the bucket specified by the bucket name provided. The path is the
+//bucket file name joined to the DirPath of the config and is relative if the DirPath is relative. Returns an error if the
+//db is closed or not persistent, or if the bucket is invalid.
+func (db *StitchDB) BucketFilePath(name string) (string, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return "", errors.New("error: db: db is closed")
+	}
+	if !db.config.persist {
+		return "", errors.New("error: db: db is not persistent")
+	}
+	if b, err := db.getBucket(name); err != nil || b == nil {
+		return "", errors.New("error: db: invalid bucket")
+	}
+	return db.getDBFilePath(strings.TrimSpace(name) + BUCKET_FILE_EXTENSION), nil
+}
+
+//Healthy checks that the db is open, that the db and bucket managers have run within the last few of their intervals
+//unless the managers are disabled, and in persistent mode that the bucket config file and the file of each open bucket
+//are writable. Returns nil if the db is healthy otherwise an error describing the first problem found.
+func (db *StitchDB) Healthy() error {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	stale := time.Now().Add(-HEALTHY_MANAGE_INTERVALS * db.config.manageFrequency)
+	if db.config.noManager {
+		//Managers do not run; maintenance is scheduled with RunMaintenance.
+		stale = time.Time{}
+	}
+	if db.managed.Before(stale) {
+		return errors.New("error: db: db manager has not run since " + db.managed.Format(time.RFC3339))
+	}
+	if db.config.persist {
+		if err := healthyFile(db.bktcfgf); err != nil {
+			return errors.Annotate(err, "error: db: bucket config file is not writable")
+		}
+	}
+	buckets := []*Bucket{db.system}
+	if db.config.performanceMonitor {
+		buckets = append(buckets, db.systemperf)
+	}
+	for _, b := range db.buckets {
+		buckets = append(buckets, b)
+	}
+	//Bucket managers run at the shorter of the checkpoint and sweep intervals.
+	interval := db.config.checkpointInterval()
+	if sweep := db.config.sweepInterval(); sweep < interval {
+		interval = sweep
+	}
+	if !db.config.noManager {
+		stale = time.Now().Add(-HEALTHY_MANAGE_INTERVALS * interval)
+	}
+	for _, b := range buckets {
+		if err := b.healthy(stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//healthyFile returns an error if the file is not open for writing or no longer exists at its path.
+func healthyFile(f *os.File) error {
+	if f == nil {
+		return errors.New("error: db: file is not open")
+	}
+	if _, err := f.Write(nil); err != nil {
+		return err
+	}
+	if _, err := os.Stat(f.Name()); err != nil {
+		return err
+	}
 	return nil
 }
 
 //Close closes each bucket including system, flushes bucket config file, and closes the file. Waits until all bucket
-//managers have exited.
+//managers have exited. Close is the durability boundary for a graceful shutdown: buffered statements are written and each
+//bucket file and the bucket config file are synced regardless of the Sync frequency. Every bucket is closed even if
+//closing an earlier bucket fails; the first error is returned.
 func (db *StitchDB) Close() error {
 	db.lock(MODE_READ_WRITE)
 	defer db.unlock(MODE_READ_WRITE)
 	if !db.open {
 		return errors.New("error: db: db is closed")
 	}
+	var cerr error
 	for key := range db.buckets {
-		err := db.buckets[key].close()
-		if err != nil {
-			return errors.Annotate(err, "error: db: failed to close bucket")
+		if err := db.buckets[key].close(); err != nil && cerr == nil {
+			cerr = errors.Annotate(err, "error: db: failed to close bucket")
 		}
 		db.buckets[key] = nil
 	}
-	db.system.close()
-	db.systemperf.close()
+	if err := db.system.close(); err != nil && cerr == nil {
+		cerr = errors.Annotate(err, "error: db: failed to close system bucket")
+	}
+	if db.systemperf != nil {
+		if err := db.systemperf.close(); err != nil && cerr == nil {
+			cerr = errors.Annotate(err, "error: db: failed to close system performance bucket")
+		}
+	}
 	if db.config.persist && db.bktcfgf != nil {
 		err := db.bktcfgf.Sync()
 		if err != nil {
@@ -200,66 +469,32 @@ func (db *StitchDB) Close() error {
 			return errors.Annotate(err, "errors: db: failed to close bucket config file")
 		}
 	}
+	db.closeStreams()
 	db.open = false
 	db.buckets = nil
+	db.unloaded = nil
 	db.system = nil
 	db.systemperf = nil
 	db.bktcfgf = nil
 	// Pause for manageFrequency * 2 to allow bucket managers to exit gracefully.
 	//time.Sleep(db.config.manageFrequency * 2)
-	return nil
+	return cerr
 }
 
 //runManager is the main manager loop for the database manager. Writes entries to AOF, compaction, and file flushes.
-//runManager also starts bucket managers for each bucket in the db.
+//runManager also starts bucket managers for each bucket in the db. It is assumed the caller obtains a lock on the db.
 func (db *StitchDB) runManager() error {
 	go func() {
 		mngct := time.NewTicker(db.config.manageFrequency)
 		defer mngct.Stop()
 		for range mngct.C {
+			db.lock(MODE_READ_WRITE)
 			if !db.open {
+				db.unlock(MODE_READ_WRITE)
 				break
 			}
-			db.lock(MODE_READ_WRITE)
-			if db.config.persist {
-				if len(db.buckets)*db.config.bucketFileMultLimit > db.bktcfgfrc {
-					//Clear file
-					err := db.bktcfgf.Truncate(0)
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: db: failed to truncate bucket config file")))
-						continue
-					}
-					_, err = db.bktcfgf.Seek(0, 0)
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: db: failed to seek to bucket config file")))
-						continue
-					}
-					//Rewrite file
-					for key := range db.buckets {
-						stmt := db.buckets[key].bucketCreateStmt()
-						_, err := db.bktcfgf.Write(stmt)
-						if err != nil {
-							fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: db: failed to write bucket config file")))
-							continue
-						}
-					}
-					db.bktcfgfrc = len(db.buckets)
-					if db.config.syncFreq == EACH {
-						err := db.bktcfgf.Sync()
-						if err != nil {
-							fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: db: failed to sync bucket config file")))
-							continue
-						}
-					}
-				}
-				if db.config.syncFreq == MNGFREQ {
-					err := db.bktcfgf.Sync()
-					if err != nil {
-						fmt.Println(errors.ErrorStack(errors.Annotate(err, "error: db: failed to sync bucket config file")))
-						continue
-					}
-				}
-			}
+			db.managed = time.Now()
+			db.manage()
 			db.unlock(MODE_READ_WRITE)
 
 			//Todo (cbergoon): Add SysPerf Logic/Write
@@ -274,6 +509,91 @@ func (db *StitchDB) runManager() error {
 	for key := range db.buckets {
 		go db.buckets[key].manager()
 	}
+	if db.config.scrubFrequency > 0 {
+		go db.runScrubber()
+	}
+	return nil
+}
+
+//manage rewrites the bucket config file if it has grown past the compaction limit and syncs it as configured. Errors are
+//written to the logger. It is assumed the the caller obtains a lock on the db.
+func (db *StitchDB) manage() {
+	if db.config.persist {
+		if (len(db.buckets)+len(db.unloaded))*db.config.bucketFileMultLimit > db.bktcfgfrc {
+			//Clear file
+			err := db.bktcfgf.Truncate(0)
+			if err != nil {
+				db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to truncate bucket config file")))
+				return
+			}
+			_, err = db.bktcfgf.Seek(0, 0)
+			if err != nil {
+				db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to seek to bucket config file")))
+				return
+			}
+			//Rewrite file
+			for key := range db.buckets {
+				stmt := db.buckets[key].bucketCreateStmt()
+				_, err := db.bktcfgf.Write(stmt)
+				if err != nil {
+					db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to write bucket config file")))
+					continue
+				}
+			}
+			for key := range db.unloaded {
+				_, err := db.bktcfgf.Write(db.unloaded[key])
+				if err != nil {
+					db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to write bucket config file")))
+					continue
+				}
+			}
+			db.bktcfgfrc = len(db.buckets) + len(db.unloaded)
+			if db.config.syncFreq == EACH {
+				err := db.bktcfgf.Sync()
+				if err != nil {
+					db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to sync bucket config file")))
+					return
+				}
+			}
+		}
+		if db.config.syncFreq == MNGFREQ {
+			err := db.bktcfgf.Sync()
+			if err != nil {
+				db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to sync bucket config file")))
+				return
+			}
+		}
+	}
+}
+
+//RunMaintenance runs a pass of the db and bucket managers synchronously: the bucket config file is rewritten if required,
+//each bucket file is checkpointed and compacted if required, and expired entries, tombstones, and invalidations are
+//swept. Errors encountered by the pass are written to the logger as they are by the managers. Intended for use with
+//DisableManager but may be called at any time. Returns an error if the db is closed.
+func (db *StitchDB) RunMaintenance() error {
+	db.lock(MODE_READ_WRITE)
+	defer db.unlock(MODE_READ_WRITE)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	db.managed = time.Now()
+	db.manage()
+	buckets := []*Bucket{db.system}
+	if db.systemperf != nil {
+		buckets = append(buckets, db.systemperf)
+	}
+	for _, b := range db.buckets {
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.lock(MODE_READ_WRITE)
+		if b.open {
+			b.managed = time.Now()
+			b.checkpoint()
+			b.sweep()
+		}
+		b.unlock(MODE_READ_WRITE)
+	}
 	return nil
 }
 
@@ -312,9 +632,17 @@ func (db *StitchDB) getBucket(name string) (*Bucket, error) {
 }
 
 //View creates a read only transaction and passes the open transaction to the provided function. The created transaction
-//will provide read only access to the bucket specified by the bucket name provided. Returns an error if the db is closed
-//or the bucket is invalid.
+//will provide read only access to the bucket specified by the bucket name provided. The transaction waits for a read/write
+//transaction on the bucket to complete and never observes uncommitted changes; see the isolation section of the package
+//documentation. Returns an error if the db is closed or the bucket is invalid. If f returns an error the transaction is
+//rolled back and the error is returned.
 func (db *StitchDB) View(bucket string, f func(t *Tx) error) error {
+	return db.ViewTimeout(bucket, 0, f)
+}
+
+//ViewTimeout behaves as View waiting at most timeout for the lock on the bucket. Returns a lock timeout error without
+//calling f if the lock was not obtained before the timeout. A timeout that is not positive waits indefinitely.
+func (db *StitchDB) ViewTimeout(bucket string, timeout time.Duration, f func(t *Tx) error) error {
 	db.lock(MODE_READ)
 	defer db.unlock(MODE_READ)
 	if !db.open {
@@ -327,14 +655,209 @@ func (db *StitchDB) View(bucket string, f func(t *Tx) error) error {
 	if b == nil {
 		return errors.New("error: db: invalid bucket")
 	}
-	err = b.handleTx(MODE_READ, f)
+	err = b.handleTx(MODE_READ, timeout, f)
 	return err
 }
 
+//AscendAll iterates over the live entries of every bucket of the db calling the provided function f with the name of the
+//bucket and the entry. Buckets are visited in name order, each in its own read only transaction, and the entries of a
+//bucket are visited in the default key ordering. Expired and invalid entries, the system buckets, and buckets that
+//failed to load are skipped. Iteration terminates when every bucket has been visited or f returns false. Returns an
+//error if the db is closed or if a transaction could not be started.
+func (db *StitchDB) AscendAll(f func(bucket string, e *Entry) bool) error {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stop := false
+	for _, name := range names {
+		b := db.buckets[name]
+		b.lock(MODE_READ)
+		open := b.open
+		b.unlock(MODE_READ)
+		if !open {
+			continue
+		}
+		err := b.handleTx(MODE_READ, 0, func(t *Tx) error {
+			return t.Ascend("", func(e *Entry) bool {
+				if e.IsExpired() || e.IsInvalid() {
+					return true
+				}
+				stop = !f(name, e)
+				return !stop
+			})
+		})
+		if err != nil {
+			return errors.Annotate(err, "error: db: failed to iterate bucket "+name)
+		}
+		if stop {
+			return nil
+		}
+	}
+	return nil
+}
+
+//MightContain checks the bloom filter of the bucket specified by the bucket name provided for the key without starting a
+//transaction. Returns false if the key is definitely not in the bucket and true if it may be. Always returns true if
+//the bucket does not have a bloom filter and false if the db is closed or the bucket is invalid.
+func (db *StitchDB) MightContain(bucket string, key string) bool {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return false
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil || b == nil {
+		return false
+	}
+	if b.bloom == nil {
+		return true
+	}
+	return b.bloom.mightContain(key)
+}
+
+//SetMergeFunc sets the merge function of the bucket specified by the bucket name provided. Merge functions are not
+//persisted with the bucket so a bucket created with MergeFunc must have its merge function set again after the db is
+//opened. A nil function removes the merge function. Returns an error if the db is closed or the bucket is invalid.
+func (db *StitchDB) SetMergeFunc(bucket string, f func(existing, incoming *Entry) (*Entry, error)) error {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil || b == nil {
+		return errors.New("error: db: invalid bucket")
+	}
+	b.lock(MODE_READ_WRITE)
+	b.options.merge = f
+	b.unlock(MODE_READ_WRITE)
+	return nil
+}
+
+//applyConfigValidator sets the validator and validation failure policy registered with BucketValidator for the bucket
+//with the provided name on the options provided unless the options already set a validator.
+func (db *StitchDB) applyConfigValidator(bktName string, options *BucketOptions) {
+	if v, ok := db.config.validators[bktName]; ok && options.validator == nil {
+		options.validator, options.valpolicy = v.validator, v.policy
+	}
+}
+
+//SetValidator sets the validator of the bucket specified by the bucket name provided; see Validator. Validators are not
+//persisted with the bucket so a bucket created with Validator must have its validator set again after the db is opened;
+//entries already loaded are not validated. Use the BucketValidator config option to validate the entries replayed when
+//the db is opened. A nil function removes the validator. Returns an error if the db is closed
+//or the bucket is invalid.
+func (db *StitchDB) SetValidator(bucket string, f func(e *Entry) error) error {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil || b == nil {
+		return errors.New("error: db: invalid bucket")
+	}
+	b.lock(MODE_READ_WRITE)
+	b.options.validator = f
+	b.unlock(MODE_READ_WRITE)
+	return nil
+}
+
+//Begin creates a transaction with the specified mode on the bucket specified by the bucket name provided and returns it
+//for the caller to complete. The bucket, but not the db, is locked until the transaction is completed by exactly one call
+//to CommitTx or RollbackTx. In developer mode a transaction that is garbage collected without being completed is logged.
+//Returns an error if the db is closed, the bucket is invalid, or the mode is unrecognized.
+func (db *StitchDB) Begin(bucket string, mode RWMode) (*Tx, error) {
+	return db.BeginTimeout(bucket, mode, 0)
+}
+
+//BeginTimeout behaves as Begin waiting at most timeout for the lock on the bucket so that a caller can fail fast rather
+//than block behind a stuck transaction. Returns a lock timeout error if the lock was not obtained before the timeout. A
+//timeout that is not positive waits indefinitely.
+func (db *StitchDB) BeginTimeout(bucket string, mode RWMode, timeout time.Duration) (*Tx, error) {
+	if mode != MODE_READ && mode != MODE_READ_WRITE {
+		return nil, errors.New("error: db: invalid transaction mode")
+	}
+	db.lock(MODE_READ)
+	if !db.open {
+		db.unlock(MODE_READ)
+		return nil, errors.New("error: db: db is closed")
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil || b == nil {
+		db.unlock(MODE_READ)
+		return nil, errors.New("error: db: invalid bucket")
+	}
+	tx, err := b.startTx(mode, timeout)
+	db.unlock(MODE_READ)
+	if err != nil {
+		return nil, err
+	}
+	tx.manual = true
+	db.registerTx(tx)
+	if db.config.developer {
+		runtime.SetFinalizer(tx, func(t *Tx) {
+			if !t.done {
+				t.db.config.logger.Errorf("error: db: transaction on bucket %v was never committed or rolled back", t.bkt.name)
+			}
+		})
+	}
+	return tx, nil
+}
+
+//ActiveTransactions returns a description of each transaction, including transactions of the system buckets, that holds
+//the lock on its bucket ordered by the time the lock was obtained. Transactions waiting for a lock are not included.
+func (db *StitchDB) ActiveTransactions() []TxInfo {
+	db.txlock.Lock()
+	defer db.txlock.Unlock()
+	infos := make([]TxInfo, 0, len(db.active))
+	for _, info := range db.active {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+//registerTx records the transaction as active once it holds the lock on its bucket and sets its start time. The
+//transaction is recorded by id so that an abandoned transaction can still be garbage collected.
+func (db *StitchDB) registerTx(t *Tx) {
+	db.txlock.Lock()
+	defer db.txlock.Unlock()
+	if db.active == nil {
+		db.active = make(map[uint64]TxInfo)
+	}
+	db.txseq++
+	t.id = db.txseq
+	t.started = time.Now()
+	db.active[t.id] = TxInfo{Bucket: t.bkt.name, Mode: t.mode, Started: t.started, Manual: t.manual}
+}
+
+//deregisterTx removes the transaction from the active transactions when it releases the lock on its bucket.
+func (db *StitchDB) deregisterTx(t *Tx) {
+	db.txlock.Lock()
+	defer db.txlock.Unlock()
+	delete(db.active, t.id)
+}
+
 //Update creates a read only transaction and passes the open transaction to the provided function. The created transaction
 //will provide read/write access to the bucket specified by the bucket name provided. Returns an error if the db is closed
-//or the bucket is invalid.
+//or the bucket is invalid. If f returns an error the transaction is rolled back and the error is returned.
 func (db *StitchDB) Update(bucket string, f func(t *Tx) error) error {
+	return db.UpdateTimeout(bucket, 0, f)
+}
+
+//UpdateTimeout behaves as Update waiting at most timeout for the lock on the bucket. Returns a lock timeout error
+//without calling f if the lock was not obtained before the timeout. A timeout that is not positive waits indefinitely.
+func (db *StitchDB) UpdateTimeout(bucket string, timeout time.Duration, f func(t *Tx) error) error {
 	db.lock(MODE_READ)
 	defer db.unlock(MODE_READ)
 	if !db.open {
@@ -347,11 +870,13 @@ func (db *StitchDB) Update(bucket string, f func(t *Tx) error) error {
 	if b == nil {
 		return errors.New("error: db: invalid bucket")
 	}
-	err = b.handleTx(MODE_READ_WRITE, f)
+	err = b.handleTx(MODE_READ_WRITE, timeout, f)
 	return err
 }
 
-//CreateBucket creates and opens a new bucket.
+//CreateBucket creates and opens a new bucket. If a bucket file for the bucket already exists, such as the file of a dropped
+//bucket, the file is loaded into the created bucket unless the db is configured with RejectExistingBucketFiles. Returns an
+//error if the db is closed, the bucket already exists, or the bucket file exists and existing files are rejected.
 func (db *StitchDB) CreateBucket(name string, options *BucketOptions) error {
 	db.lock(MODE_READ_WRITE)
 	defer db.unlock(MODE_READ_WRITE)
@@ -367,7 +892,18 @@ func (db *StitchDB) CreateBucket(name string, options *BucketOptions) error {
 		return errors.New("error: db: bucket already exists")
 	}
 	bktName := strings.TrimSpace(name)
+	if _, ok := db.unloaded[bktName]; ok {
+		return errors.New("error: db: bucket already exists but could not be loaded; drop the bucket first")
+	}
 	bktFilePath := db.getDBFilePath(bktName + BUCKET_FILE_EXTENSION)
+	if db.config.persist && db.config.rejectExisting {
+		if _, err := os.Stat(bktFilePath); err == nil {
+			return errors.New("error: db: bucket file already exists")
+		} else if !os.IsNotExist(err) {
+			return errors.Annotate(err, "error: db: failed to check bucket file")
+		}
+	}
+	db.applyConfigValidator(bktName, options)
 	bucket, err := newBucket(db, options, bktName)
 	if err != nil {
 		return errors.Annotate(err, "error: db: failed to create bucket")
@@ -394,7 +930,9 @@ func (db *StitchDB) CreateBucket(name string, options *BucketOptions) error {
 		}
 	}
 
-	go db.buckets[bktName].manager()
+	if !db.config.noManager {
+		go db.buckets[bktName].manager()
+	}
 	return nil
 }
 
@@ -406,14 +944,22 @@ func (db *StitchDB) DropBucket(name string) error {
 		return errors.New("error: db: db is closed")
 	}
 	bktName := strings.TrimSpace(name)
-	bucket, err := db.getBucket(bktName)
-	if err != nil {
-		return errors.Annotate(err, "error: db: invalid bucket")
+	var stmt []byte
+	if _, ok := db.unloaded[bktName]; ok {
+		stmt = (&Bucket{name: bktName}).bucketDropStmt()
+		delete(db.unloaded, bktName)
+	} else {
+		bucket, err := db.getBucket(bktName)
+		if err != nil {
+			return errors.Annotate(err, "error: db: invalid bucket")
+		}
+		stmt = bucket.bucketDropStmt()
+		bucket.close()
+		//The dropped bucket file is no longer replayed; record the highest log sequence number in the system bucket.
+		db.recordLSN()
+		bucket = nil
+		delete(db.buckets, bktName)
 	}
-	stmt := bucket.bucketDropStmt()
-	bucket.close()
-	bucket = nil
-	delete(db.buckets, bktName)
 
 	if db.config.persist && db.bktcfgf != nil {
 		_, err := db.bktcfgf.Write(stmt)
@@ -431,6 +977,213 @@ func (db *StitchDB) DropBucket(name string) error {
 	return nil
 }
 
+//ReplaceBucket atomically replaces the contents of the bucket specified by the bucket name provided. A shadow bucket with
+//the options and indexes of the bucket is created and passed to build in a read/write transaction. If build succeeds the
+//shadow bucket file replaces the bucket file and the contents of the shadow bucket replace the contents of the bucket
+//under a lock, so transactions on the bucket observe either the previous or the replaced contents. The bucket can be
+//read and written while build executes; those writes are discarded by the replacement. Returns an error if the db is
+//closed, the bucket is invalid or was dropped during build, build returns an error, or the replacement failed to persist.
+func (db *StitchDB) ReplaceBucket(name string, build func(*Tx) error) error {
+	db.lock(MODE_READ)
+	if !db.open {
+		db.unlock(MODE_READ)
+		return errors.New("error: db: db is closed")
+	}
+	bktName := strings.TrimSpace(name)
+	bucket, ok := db.buckets[bktName]
+	if !ok || bucket == nil {
+		db.unlock(MODE_READ)
+		return errors.New("error: db: invalid bucket")
+	}
+	bucket.lock(MODE_READ)
+	shadow, err := newBucket(db, bucket.options, bktName)
+	if err == nil {
+		for pattern, index := range bucket.indexes {
+			shadow.indexes[pattern], _ = NewIndex(pattern, index.vtype, shadow)
+		}
+	}
+	bucket.unlock(MODE_READ)
+	shadowFilePath := db.getDBFilePath(bktName + BUCKET_REPLACE_FILE_EXTENSION)
+	if err == nil {
+		//Remove any shadow bucket file left by a replacement that did not complete.
+		if rerr := os.Remove(shadowFilePath); rerr != nil && !os.IsNotExist(rerr) {
+			err = rerr
+		} else {
+			err = shadow.openBucket(shadowFilePath)
+		}
+	}
+	db.unlock(MODE_READ)
+	if err != nil {
+		db.discardShadowBucket(shadow, shadowFilePath)
+		return errors.Annotate(err, "error: db: failed to create shadow bucket")
+	}
+
+	//The db is not locked while the shadow bucket is built so that other buckets remain available.
+	if err := shadow.handleTx(MODE_READ_WRITE, 0, build); err != nil {
+		db.discardShadowBucket(shadow, shadowFilePath)
+		return err
+	}
+	if db.config.persist {
+		if err := shadow.flushAOFBuf(); err != nil {
+			db.discardShadowBucket(shadow, shadowFilePath)
+			return errors.Annotate(err, "error: db: failed to write shadow bucket file")
+		}
+		if err := shadow.file.Sync(); err != nil {
+			db.discardShadowBucket(shadow, shadowFilePath)
+			return errors.Annotate(err, "error: db: failed to sync shadow bucket file")
+		}
+	}
+
+	db.lock(MODE_READ_WRITE)
+	defer db.unlock(MODE_READ_WRITE)
+	if !db.open || db.buckets[bktName] != bucket {
+		db.discardShadowBucket(shadow, shadowFilePath)
+		return errors.New("error: db: bucket was closed or dropped during replace")
+	}
+	bucket.lock(MODE_READ_WRITE)
+	defer bucket.unlock(MODE_READ_WRITE)
+	if db.config.persist {
+		bktFilePath := db.getDBFilePath(bktName + BUCKET_FILE_EXTENSION)
+		if err := os.Rename(shadowFilePath, bktFilePath); err != nil {
+			db.discardShadowBucket(shadow, shadowFilePath)
+			return errors.Annotate(err, "error: db: failed to rename shadow bucket file")
+		}
+		//The bucket file of the bucket was replaced; statements for the previous contents are discarded.
+		bucket.file.Close()
+		bucket.file, bucket.aofbuf = shadow.file, nil
+	}
+	bucket.data, bucket.eviction, bucket.invalidation = shadow.data, shadow.eviction, shadow.invalidation
+	bucket.rtree, bucket.tombstones, bucket.bloom = shadow.rtree, shadow.tombstones, shadow.bloom
+	bucket.indexes, bucket.insertion, bucket.counters = shadow.indexes, shadow.insertion, shadow.counters
+	for _, index := range bucket.indexes {
+		index.bkt = bucket
+	}
+	bucket.rct, bucket.aofct, bucket.lastWrite = shadow.rct, shadow.aofct, shadow.lastWrite
+	//Queries and stale reads cached for the previous contents are discarded.
+	bucket.qgen++
+	bucket.stale = shadow.stale
+	//The totals of the db hold the entries of both buckets; the discarded contents are removed from them.
+	bucket.release()
+	bucket.entries, bucket.bytes = shadow.entries, shadow.bytes
+	return nil
+}
+
+//RebuildBucket rebuilds the bucket specified by the bucket name provided with new options such as a different
+//BTreeDegree. Every entry, tombstone, counter, and index of the bucket is inserted into trees built with the new options,
+//keeping the expiry and invalidation times, versions, and log sequence numbers of the entries, and the rebuilt trees
+//replace those of the bucket. The new options are recorded in the bucket config file and the bucket file is rewritten
+//from the rebuilt bucket so that options such as Compress apply to the entries already written. The merge function and
+//validator of the bucket are kept unless the new options set them; MaxReaders takes effect when the db is next opened.
+//The db is locked while the bucket is rebuilt. Returns an error if the db is closed, the bucket is invalid, the options
+//are nil or set System, or an entry of the bucket is not valid under the new options, in which case the bucket is
+//unchanged, or if the rebuilt bucket failed to persist.
+func (db *StitchDB) RebuildBucket(name string, newOpts *BucketOptions) error {
+	db.lock(MODE_READ_WRITE)
+	defer db.unlock(MODE_READ_WRITE)
+	if !db.open {
+		return errors.New("error: db: db is closed")
+	}
+	if newOpts == nil || newOpts.system {
+		return errors.New("error: db: invalid bucket options")
+	}
+	bktName := strings.TrimSpace(name)
+	bucket, ok := db.buckets[bktName]
+	if !ok || bucket == nil {
+		return errors.New("error: db: invalid bucket")
+	}
+	bucket.lock(MODE_READ_WRITE)
+	defer bucket.unlock(MODE_READ_WRITE)
+	if newOpts.merge == nil {
+		newOpts.merge = bucket.options.merge
+	}
+	if newOpts.validator == nil {
+		newOpts.validator, newOpts.valpolicy = bucket.options.validator, bucket.options.valpolicy
+	}
+	shadow, err := newBucket(db, newOpts, bktName)
+	if err != nil {
+		return errors.Annotate(err, "error: db: failed to create rebuilt bucket")
+	}
+	for pattern, index := range bucket.indexes {
+		shadow.indexes[pattern], _ = NewIndex(pattern, index.vtype, shadow)
+	}
+	shadow.idxsusp = bucket.idxsusp
+	bucket.data.Ascend(func(item btree.Item) bool {
+		e := item.(*Entry)
+		if newOpts.maxkeyln > 0 && len(e.k) > newOpts.maxkeyln {
+			err = errors.New("error: db: key of entry " + e.k + " exceeds the maximum key length of the new options")
+		} else {
+			err = shadow.checkLocation(e)
+		}
+		if err != nil {
+			return false
+		}
+		shadow.insert(e)
+		return true
+	})
+	if err != nil {
+		shadow.release()
+		return errors.Annotate(err, "error: db: bucket cannot be rebuilt with the new options")
+	}
+	bucket.tombstones.Ascend(func(item btree.Item) bool {
+		shadow.tombstones.ReplaceOrInsert(item)
+		return true
+	})
+	for cname, c := range bucket.counters {
+		shadow.counters[cname] = c
+	}
+
+	if db.config.persist && db.bktcfgf != nil {
+		if _, err := db.bktcfgf.Write(shadow.bucketCreateStmt()); err != nil {
+			shadow.release()
+			return errors.Annotate(err, "error: db: failed to write to bucket config file")
+		}
+		db.bktcfgfrc++
+		if db.config.syncFreq == EACH {
+			if err := db.bktcfgf.Sync(); err != nil {
+				shadow.release()
+				return errors.Annotate(err, "error: db: failed to sync bucket config file")
+			}
+		}
+	}
+	bucket.options = newOpts
+	bucket.data, bucket.eviction, bucket.invalidation = shadow.data, shadow.eviction, shadow.invalidation
+	bucket.rtree, bucket.tombstones, bucket.bloom = shadow.rtree, shadow.tombstones, shadow.bloom
+	bucket.indexes, bucket.insertion, bucket.counters = shadow.indexes, shadow.insertion, shadow.counters
+	for _, index := range bucket.indexes {
+		index.bkt = bucket
+	}
+	//The cursor of limited sweeps may reference an entry of the previous trees.
+	bucket.sweepcur = nil
+	bucket.qgen++
+	bucket.stale = shadow.stale
+	//The totals of the db hold the entries of both buckets; the previous trees are removed from them.
+	bucket.release()
+	bucket.entries, bucket.bytes = shadow.entries, shadow.bytes
+	if db.config.persist && bucket.file != nil {
+		if err := bucket.compactLog(); err != nil {
+			return errors.Annotate(err, "error: db: bucket was rebuilt but the bucket file failed to be rewritten")
+		}
+	}
+	return nil
+}
+
+//discardShadowBucket closes the shadow bucket created by ReplaceBucket and removes its bucket file.
+func (db *StitchDB) discardShadowBucket(shadow *Bucket, path string) {
+	if shadow == nil {
+		return
+	}
+	shadow.release()
+	if !db.config.persist {
+		return
+	}
+	if shadow.file != nil {
+		shadow.file.Close()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		db.config.logger.Errorf("%v", errors.ErrorStack(errors.Annotate(err, "error: db: failed to remove shadow bucket file")))
+	}
+}
+
 //lock is a helper function to obtain a lock on the db appropriately based on the RW modifier of the transaction.
 func (db *StitchDB) lock(mode RWMode) {
 	if mode == MODE_READ {
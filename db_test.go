@@ -4,9 +4,18 @@
 package stitchdb
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/cbergoon/btree"
+	"github.com/tidwall/gjson"
 )
 
 func TestNewStitchDB(t *testing.T) {
@@ -51,6 +60,42 @@ func TestStitchDB_Open(t *testing.T) {
 	if !db.open {
 		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
 	}
+	db.Close()
+}
+
+func TestStitchDB_OpenWithoutPerformanceMonitor(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if err := db.Open(); err != nil || !db.open {
+		t.Fatalf("Failure: db.Open() without PerformanceMonitor expected open db got error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("noperf", opts)
+	db.Update("noperf", func(tx *Tx) error {
+		e, _ := NewEntry("key", "{}", false, nil)
+		_, err := tx.Set(e)
+		return err
+	})
+	high := db.currentLSN()
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if err := db.Open(); err != nil || !db.open {
+		t.Fatalf("Failure: db.Open() reopening without PerformanceMonitor expected open db got error \"%v\"", err)
+	}
+	if db.currentLSN() < high {
+		t.Errorf("Failure: expected reopened db to resume after LSN %d got %d", high, db.currentLSN())
+	}
+	db.DropBucket("noperf")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
 }
 
 func TestStitchDB_Close(t *testing.T) {
@@ -344,3 +389,1949 @@ func TestStitchDB_DropBucket(t *testing.T) {
 		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
 	}
 }
+
+func TestStitchDB_ReopenOrder(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("order", opts)
+	db.Update("order", func(t *Tx) error {
+		for i := 0; i < 128; i++ {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key-"+strconv.Itoa((i*37)%128), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			t.Set(e)
+		}
+		return nil
+	})
+	var before []string
+	db.View("order", func(t *Tx) error {
+		return t.Ascend("", func(e *Entry) bool {
+			before = append(before, e.k)
+			return true
+		})
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	var after []string
+	db.View("order", func(t *Tx) error {
+		return t.Ascend("", func(e *Entry) bool {
+			after = append(after, e.k)
+			return true
+		})
+	})
+	if len(before) != 128 || len(after) != len(before) {
+		t.Errorf("Failure: expected 128 entries before and after reopen got %v and %v", len(before), len(after))
+	}
+	for i := 0; i < len(before) && i < len(after); i++ {
+		if before[i] != after[i] {
+			t.Errorf("Failure: ascend order differs after reopen at %v; %v != %v", i, before[i], after[i])
+			break
+		}
+	}
+	db.DropBucket("order")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_OpenPartial(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("partial-good", opts)
+	db.CreateBucket("partial-bad", opts)
+	for _, name := range []string{"partial-good", "partial-bad"} {
+		db.Update(name, func(t *Tx) error {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key", "{ \"value\":\"1\"}", false, eopt)
+			t.Set(e)
+			return nil
+		})
+	}
+	db.Close()
+	badFile := db.getDBFilePath("partial-bad" + BUCKET_FILE_EXTENSION)
+	if err := ioutil.WriteFile(badFile, []byte("not-a-size\n"), 0666); err != nil {
+		t.Errorf("Failure: failed to corrupt bucket file \"%v\"", err)
+	}
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	err = db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	openErr, ok := err.(*OpenError)
+	if !ok {
+		t.Errorf("Failure: db.Open() expected *OpenError got \"%v\"", err)
+	} else {
+		if _, failed := openErr.Buckets["partial-bad"]; !failed || len(openErr.Buckets) != 1 {
+			t.Errorf("Failure: expected only partial-bad to fail got %v", openErr.Buckets)
+		}
+	}
+	err = db.View("partial-good", func(t *Tx) error {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key", "", false, eopt)
+		_, err := t.Get(e)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Failure: expected partial-good to be usable got error \"%v\"", err)
+	}
+	err = db.View("partial-bad", func(t *Tx) error {
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Failure: expected error using partial-bad got nil")
+	}
+	db.DropBucket("partial-good")
+	db.DropBucket("partial-bad")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	os.Remove(badFile)
+}
+
+func TestStitchDB_OpenUnloadedStmt(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(50*time.Millisecond), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("unloaded-good", opts)
+	db.Close()
+	bad := "CREATE:unloaded-bad:x:0:0:0:0:0\n"
+	f, err := os.OpenFile(db.getDBFilePath(BUCKET_CONFIG_FILE), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Errorf("Failure: failed to open bucket config file \"%v\"", err)
+	}
+	f.WriteString(bad)
+	f.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	err = db.Open()
+	if openErr, ok := err.(*OpenError); !ok || openErr.Buckets["unloaded-bad"] == nil {
+		t.Errorf("Failure: db.Open() expected unloaded-bad to fail got \"%v\"", err)
+	}
+	//Allow the db manager to rewrite the bucket config file.
+	time.Sleep(200 * time.Millisecond)
+	data, _ := ioutil.ReadFile(db.getDBFilePath(BUCKET_CONFIG_FILE))
+	if !strings.Contains(string(data), bad) || !strings.Contains(string(data), "CREATE:unloaded-good:") {
+		t.Errorf("Failure: expected bucket config file to keep both buckets got %q", data)
+	}
+	if err := db.CreateBucket("unloaded-bad", opts); err == nil {
+		t.Errorf("Failure: db.CreateBucket(\"unloaded-bad\", opts) expected error got nil")
+	}
+	if err := db.DropBucket("unloaded-bad"); err != nil {
+		t.Errorf("Failure: db.DropBucket(\"unloaded-bad\") returned error \"%v\"", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	data, _ = ioutil.ReadFile(db.getDBFilePath(BUCKET_CONFIG_FILE))
+	if strings.Contains(string(data), "unloaded-bad") {
+		t.Errorf("Failure: expected dropped bucket to be removed from bucket config file got %q", data)
+	}
+	db.DropBucket("unloaded-good")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_AOFBufferSize(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), AOFBufferSize(64))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("aofbuf", opts)
+	err = db.Update("aofbuf", func(t *Tx) error {
+		for i := 0; i < 128; i++ {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			t.Set(e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failure: db.Update(...) returned error \"%v\"", err)
+	}
+	if len(db.buckets["aofbuf"].aofbuf) != 0 {
+		t.Errorf("Failure: expected empty aof buffer after commit got length %v", len(db.buckets["aofbuf"].aofbuf))
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	count := 0
+	db.View("aofbuf", func(t *Tx) error {
+		return t.Ascend("", func(e *Entry) bool {
+			count++
+			return true
+		})
+	})
+	if count != 128 {
+		t.Errorf("Failure: expected 128 entries after reopen got %v", count)
+	}
+	db.DropBucket("aofbuf")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_CommitWriteError(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("writeerr", opts)
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	e2, _ := NewEntry("key-2", "{ \"value\":\"2\"}", false, eopt)
+	db.Update("writeerr", func(tx *Tx) error {
+		_, err := tx.Set(e1)
+		return err
+	})
+	err = db.Update("writeerr", func(tx *Tx) error {
+		tx.Set(e2)
+		tx.Delete(e1)
+		//Fail the commit's write to the bucket file.
+		return tx.bkt.file.Close()
+	})
+	if err == nil {
+		t.Errorf("Failure: db.Update(...) expected write error got nil")
+	}
+	db.View("writeerr", func(tx *Tx) error {
+		if e, _ := tx.Get(e1); e == nil {
+			t.Errorf("Failure: expected key-1 after failed commit got nil")
+		}
+		if e, _ := tx.Get(e2); e != nil {
+			t.Errorf("Failure: expected no key-2 after failed commit got %v", e)
+		}
+		return nil
+	})
+	bkt := db.buckets["writeerr"]
+	bkt.file, err = os.OpenFile(db.getDBFilePath("writeerr"+BUCKET_FILE_EXTENSION), os.O_RDWR, 0666)
+	if err != nil {
+		t.Errorf("Failure: failed to reopen bucket file \"%v\"", err)
+	}
+	bkt.file.Seek(0, io.SeekEnd)
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("writeerr", func(tx *Tx) error {
+		if e, _ := tx.Get(e1); e == nil {
+			t.Errorf("Failure: expected key-1 after reopen got nil")
+		}
+		if e, _ := tx.Get(e2); e != nil {
+			t.Errorf("Failure: expected no key-2 after reopen got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("writeerr")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_WriteRetry(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), WriteRetry(2, 10*time.Millisecond), OnWriteFailure(WRITE_FAIL_READ_ONLY))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("writeretry", opts)
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, nil)
+	start := time.Now()
+	err = db.Update("writeretry", func(tx *Tx) error {
+		tx.Set(e1)
+		//Fail every write of the commit to the bucket file by replacing it with a read only handle.
+		tx.bkt.file.Close()
+		var err error
+		tx.bkt.file, err = os.Open(db.getDBFilePath("writeretry" + BUCKET_FILE_EXTENSION))
+		return err
+	})
+	if err == nil {
+		t.Errorf("Failure: db.Update(...) expected write error got nil")
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("Failure: expected failed write to be retried with backoff for at least 30ms got %v", d)
+	}
+	if !db.ReadOnly() {
+		t.Errorf("Failure: db.ReadOnly() expected true after failed write got false")
+	}
+	if err := db.Update("writeretry", func(tx *Tx) error { return nil }); err == nil {
+		t.Errorf("Failure: db.Update(...) expected error for read only db got nil")
+	}
+	if err := db.View("writeretry", func(tx *Tx) error { return nil }); err != nil {
+		t.Errorf("Failure: db.View(...) returned error \"%v\" for read only db", err)
+	}
+	bkt := db.buckets["writeretry"]
+	bkt.file.Close()
+	bkt.file, err = os.OpenFile(db.getDBFilePath("writeretry"+BUCKET_FILE_EXTENSION), os.O_RDWR, 0666)
+	if err != nil {
+		t.Errorf("Failure: failed to reopen bucket file \"%v\"", err)
+	}
+	bkt.file.Seek(0, io.SeekEnd)
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if db.ReadOnly() {
+		t.Errorf("Failure: db.ReadOnly() expected false after reopen got true")
+	}
+	err = db.Update("writeretry", func(tx *Tx) error {
+		_, err := tx.Set(e1)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Failure: db.Update(...) returned error \"%v\" after reopen", err)
+	}
+	db.DropBucket("writeretry")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_CloseSyncNone(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(NONE), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("closesync", opts)
+	db.Update("closesync", func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	//Leave a statement in the write buffer so that only Close writes it to the bucket file.
+	b := db.buckets["closesync"]
+	b.lock(MODE_READ_WRITE)
+	e, _ := NewEntry("key-buffered", "{}", false, nil)
+	b.appendAOFBuf(e.EntryInsertStmt())
+	b.unlock(MODE_READ_WRITE)
+	if err := db.Close(); err != nil {
+		t.Errorf("Failure: db.Close() returned error \"%v\"", err)
+	}
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("closesync", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 101 {
+			t.Errorf("Failure: expected 101 entries after reopen got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-buffered"}); e == nil {
+			t.Error("Failure: expected buffered entry after reopen got nil")
+		}
+		return nil
+	})
+	db.DropBucket("closesync")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_MmapReplay(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), MmapReplay)
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("mmapreplay", opts)
+	db.Update("mmapreplay", func(tx *Tx) error {
+		//Enough entries that the file is replayed in several batches.
+		for i := 0; i < 3000; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"i\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		tx.Delete(&Entry{k: "key-0"})
+		return nil
+	})
+	if err := db.Close(); err != nil {
+		t.Errorf("Failure: db.Close() returned error \"%v\"", err)
+	}
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("mmapreplay", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 2999 {
+			t.Errorf("Failure: expected 2999 entries after reopen got %d", size)
+		}
+		//Values are copied out of the region, which is unmapped once the file is replayed.
+		if e, _ := tx.Get(&Entry{k: "key-2500"}); e == nil || e.v != "{\"i\":2500}" {
+			t.Errorf("Failure: expected key-2500 to hold its value after reopen got %v", e)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-0"}); e != nil {
+			t.Errorf("Failure: expected deleted entry to be nil after reopen got %v", e)
+		}
+		return nil
+	})
+	//Writes after a mapped replay must be appended to the bucket file.
+	db.Update("mmapreplay", func(tx *Tx) error {
+		e, _ := NewEntry("key-after", "{}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("mmapreplay", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 3000 {
+			t.Errorf("Failure: expected 3000 entries after second reopen got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-after"}); e == nil {
+			t.Error("Failure: expected entry written after mapped replay got nil")
+		}
+		return nil
+	})
+	db.DropBucket("mmapreplay")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_Stats(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("stats", opts)
+	db.Update("stats", func(t *Tx) error {
+		for i := 0; i < 4; i++ {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			t.Set(e)
+		}
+		return nil
+	})
+	db.View("stats", func(t *Tx) error {
+		return t.Ascend("", func(e *Entry) bool {
+			return true
+		})
+	})
+	st := db.Stats()
+	if st.Durations["stats"][METRIC_COMMIT].Count != 1 {
+		t.Errorf("Failure: expected 1 commit recorded got %v", st.Durations["stats"][METRIC_COMMIT].Count)
+	}
+	if st.Counts["stats"][METRIC_COMMIT_ENTRIES] != 4 {
+		t.Errorf("Failure: expected 4 committed entries recorded got %v", st.Counts["stats"][METRIC_COMMIT_ENTRIES])
+	}
+	if st.Durations["stats"][METRIC_ROLLBACK].Count != 1 {
+		t.Errorf("Failure: expected 1 rollback recorded got %v", st.Durations["stats"][METRIC_ROLLBACK].Count)
+	}
+	if st.Durations["stats"][METRIC_SCAN].Count != 1 || st.Counts["stats"][METRIC_SCAN_ENTRIES] != 4 {
+		t.Errorf("Failure: expected 1 scan of 4 entries recorded got %v scans of %v entries", st.Durations["stats"][METRIC_SCAN].Count, st.Counts["stats"][METRIC_SCAN_ENTRIES])
+	}
+	db.DropBucket("stats")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_ReplayProgress(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("replay", opts)
+	db.Update("replay", func(t *Tx) error {
+		for i := 0; i < 2048; i++ {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			t.Set(e)
+		}
+		return nil
+	})
+	db.Close()
+	var progress []int
+	c, _ = NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), ReplayProgress(func(bucket string, recordsLoaded int) {
+		if bucket == "replay" {
+			progress = append(progress, recordsLoaded)
+		}
+	}))
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if len(progress) < 2 || progress[len(progress)-1] != 2048 {
+		t.Errorf("Failure: expected progress reported in batches ending at 2048 got %v", progress)
+	}
+	if db.RecordsLoaded()["replay"] != 2048 {
+		t.Errorf("Failure: expected 2048 records loaded for replay got %v", db.RecordsLoaded()["replay"])
+	}
+	db.DropBucket("replay")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_Begin(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("begin", opts)
+	if _, err := db.Begin("missing", MODE_READ); err == nil {
+		t.Errorf("Failure: db.Begin(\"missing\", MODE_READ) expected error got nil")
+	}
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	e2, _ := NewEntry("key-2", "{ \"value\":\"2\"}", false, eopt)
+	tx, err := db.Begin("begin", MODE_READ_WRITE)
+	if err != nil {
+		t.Errorf("Failure: db.Begin(\"begin\", MODE_READ_WRITE) returned error \"%v\"", err)
+	}
+	tx.Set(e1)
+	if err := tx.CommitTx(); err != nil {
+		t.Errorf("Failure: tx.CommitTx() returned error \"%v\"", err)
+	}
+	if err := tx.CommitTx(); err == nil {
+		t.Errorf("Failure: second tx.CommitTx() expected error got nil")
+	}
+	tx, err = db.Begin("begin", MODE_READ_WRITE)
+	if err != nil {
+		t.Errorf("Failure: db.Begin(\"begin\", MODE_READ_WRITE) returned error \"%v\"", err)
+	}
+	tx.Set(e2)
+	if err := tx.RollbackTx(); err != nil {
+		t.Errorf("Failure: tx.RollbackTx() returned error \"%v\"", err)
+	}
+	db.View("begin", func(tx *Tx) error {
+		if err := tx.CommitTx(); err == nil {
+			t.Errorf("Failure: tx.CommitTx() within View expected error got nil")
+		}
+		if e, _ := tx.Get(e1); e == nil {
+			t.Errorf("Failure: expected key-1 committed got nil")
+		}
+		if e, _ := tx.Get(e2); e != nil {
+			t.Errorf("Failure: expected key-2 rolled back got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("begin")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_LockTimeout(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("locktimeout", opts)
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	//The lock is held by a transaction started on another goroutine; see TestStitchDB_Reentrant.
+	txc := make(chan *Tx)
+	go func() {
+		tx, err := db.Begin("locktimeout", MODE_READ_WRITE)
+		if err != nil {
+			t.Errorf("Failure: db.Begin(\"locktimeout\", MODE_READ_WRITE) returned error \"%v\"", err)
+		}
+		txc <- tx
+	}()
+	tx := <-txc
+	called := false
+	err = db.UpdateTimeout("locktimeout", 50*time.Millisecond, func(tx *Tx) error {
+		called = true
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "lock timeout") {
+		t.Errorf("Failure: db.UpdateTimeout() with held lock expected lock timeout error got \"%v\"", err)
+	}
+	if called {
+		t.Errorf("Failure: db.UpdateTimeout() with held lock expected f not to be called")
+	}
+	if err := db.ViewTimeout("locktimeout", 50*time.Millisecond, func(tx *Tx) error { return nil }); err == nil {
+		t.Errorf("Failure: db.ViewTimeout() with held lock expected error got nil")
+	}
+	if _, err := db.BeginTimeout("locktimeout", MODE_READ_WRITE, 50*time.Millisecond); err == nil {
+		t.Errorf("Failure: db.BeginTimeout() with held lock expected error got nil")
+	}
+	tx.Set(e1)
+	if err := tx.CommitTx(); err != nil {
+		t.Errorf("Failure: tx.CommitTx() returned error \"%v\"", err)
+	}
+	err = db.UpdateTimeout("locktimeout", 1*time.Second, func(tx *Tx) error {
+		if e, _ := tx.Get(e1); e == nil {
+			t.Errorf("Failure: expected key-1 committed got nil")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failure: db.UpdateTimeout() after commit returned error \"%v\"", err)
+	}
+	if err := db.ViewTimeout("locktimeout", 0, func(tx *Tx) error { return nil }); err != nil {
+		t.Errorf("Failure: db.ViewTimeout() with no timeout returned error \"%v\"", err)
+	}
+	db.DropBucket("locktimeout")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_ActiveTransactions(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("active", opts)
+	before := time.Now()
+	tx, err := db.Begin("active", MODE_READ_WRITE)
+	if err != nil {
+		t.Errorf("Failure: db.Begin(\"active\", MODE_READ_WRITE) returned error \"%v\"", err)
+	}
+	var found *TxInfo
+	for _, info := range db.ActiveTransactions() {
+		if info.Bucket == "active" {
+			info := info
+			found = &info
+		}
+	}
+	if found == nil {
+		t.Errorf("Failure: db.ActiveTransactions() expected transaction on bucket active got none")
+	} else if found.Mode != MODE_READ_WRITE || !found.Manual || found.Started.Before(before) {
+		t.Errorf("Failure: db.ActiveTransactions() expected manual read/write transaction started after %v got %+v", before, *found)
+	}
+	if err := tx.RollbackTx(); err != nil {
+		t.Errorf("Failure: tx.RollbackTx() returned error \"%v\"", err)
+	}
+	db.View("active", func(tx *Tx) error {
+		n := 0
+		for _, info := range db.ActiveTransactions() {
+			if info.Bucket == "active" {
+				n++
+				if info.Mode != MODE_READ || info.Manual {
+					t.Errorf("Failure: db.ActiveTransactions() within View expected read only transaction got %+v", info)
+				}
+			}
+		}
+		if n != 1 {
+			t.Errorf("Failure: db.ActiveTransactions() within View expected 1 transaction on bucket active got %v", n)
+		}
+		return nil
+	})
+	for _, info := range db.ActiveTransactions() {
+		if info.Bucket == "active" {
+			t.Errorf("Failure: db.ActiveTransactions() after completion expected no transaction on bucket active got %+v", info)
+		}
+	}
+	db.DropBucket("active")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_Reentrant(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), DetectReentrantTx)
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("reentrant", opts)
+	db.CreateBucket("reentrant-other", opts)
+	locked := func(err error) bool {
+		return err != nil && strings.Contains(err.Error(), "already locked by this goroutine")
+	}
+	noop := func(tx *Tx) error { return nil }
+	db.Update("reentrant", func(tx *Tx) error {
+		if err := db.Update("reentrant", noop); !locked(err) {
+			t.Errorf("Failure: db.Update() within Update on same bucket expected already locked error got \"%v\"", err)
+		}
+		if err := db.View("reentrant", noop); !locked(err) {
+			t.Errorf("Failure: db.View() within Update on same bucket expected already locked error got \"%v\"", err)
+		}
+		if err := db.Update("reentrant-other", noop); err != nil {
+			t.Errorf("Failure: db.Update() within Update on other bucket returned error \"%v\"", err)
+		}
+		return nil
+	})
+	db.View("reentrant", func(tx *Tx) error {
+		if err := db.Update("reentrant", noop); !locked(err) {
+			t.Errorf("Failure: db.Update() within View on same bucket expected already locked error got \"%v\"", err)
+		}
+		if err := db.View("reentrant", noop); !locked(err) {
+			t.Errorf("Failure: db.View() within View on same bucket expected already locked error got \"%v\"", err)
+		}
+		return nil
+	})
+	tx, err := db.Begin("reentrant", MODE_READ_WRITE)
+	if err != nil {
+		t.Errorf("Failure: db.Begin(\"reentrant\", MODE_READ_WRITE) returned error \"%v\"", err)
+	}
+	if _, err := db.Begin("reentrant", MODE_READ_WRITE); !locked(err) {
+		t.Errorf("Failure: second db.Begin() on same bucket expected already locked error got \"%v\"", err)
+	}
+	//Another goroutine waits for the lock rather than failing.
+	done := make(chan error)
+	go func() {
+		done <- db.UpdateTimeout("reentrant", 50*time.Millisecond, noop)
+	}()
+	if err := <-done; err == nil || locked(err) {
+		t.Errorf("Failure: db.UpdateTimeout() from other goroutine expected lock timeout error got \"%v\"", err)
+	}
+	tx.RollbackTx()
+	if err := db.Update("reentrant", noop); err != nil {
+		t.Errorf("Failure: db.Update() after rollback returned error \"%v\"", err)
+	}
+	db.DropBucket("reentrant")
+	db.DropBucket("reentrant-other")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_Validator(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	os.Remove(db.getDBFilePath("validate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	requireN := func(e *Entry) error {
+		if !gjson.Get(e.GetValue(), "n").Exists() {
+			return fmt.Errorf("missing field n")
+		}
+		return nil
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("validate", opts)
+	db.Update("validate", func(tx *Tx) error {
+		for _, kv := range [][2]string{{"key-1", "{\"n\":1}"}, {"key-2", "{\"x\":2}"}, {"key-3", "{\"n\":3}"}} {
+			e, _ := NewEntry(kv[0], kv[1], false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	if err := db.SetValidator("missing", requireN); err == nil {
+		t.Error("Failure: db.SetValidator(\"missing\") expected error got nil")
+	}
+	db.SetValidator("validate", requireN)
+	db.Update("validate", func(tx *Tx) error {
+		e3, _ := NewEntry("key-3", "{\"x\":3}", false, nil)
+		if _, err := tx.Set(e3); err == nil || !strings.Contains(err.Error(), "missing field n") {
+			t.Errorf("Failure: tx.Set() of invalid entry expected validation error got \"%v\"", err)
+		}
+		if _, ok := tx.rbctx.backward["key-3"]; ok {
+			t.Error("Failure: expected rejected set not to record rollback state")
+		}
+		if _, err := tx.UpdateValue("key-1", "{\"x\":1}"); err == nil {
+			t.Error("Failure: tx.UpdateValue() to invalid value expected validation error got nil")
+		}
+		e4, _ := NewEntry("key-4", "{\"n\":4}", false, nil)
+		if _, err := tx.Set(e4); err != nil {
+			t.Errorf("Failure: tx.Set() of valid entry returned error \"%v\"", err)
+		}
+		return nil
+	})
+	//Replaying the file of the dropped bucket validates the entries written before the validator was set.
+	db.DropBucket("validate")
+	vopts, _ := NewBucketOptions(BTreeDegree(32), Validator(requireN))
+	if err := db.CreateBucket("validate", vopts); err == nil {
+		t.Error("Failure: db.CreateBucket() over file with invalid entry expected error got nil")
+	}
+	db.DropBucket("validate")
+	if _, err := NewBucketOptions(OnValidationFailure(ValidationPolicy(9))); err == nil {
+		t.Error("Failure: NewBucketOptions(OnValidationFailure(9)) expected error got nil")
+	}
+	qopts, _ := NewBucketOptions(BTreeDegree(32), Validator(requireN), OnValidationFailure(VALIDATE_QUARANTINE))
+	if err := db.CreateBucket("validate", qopts); err != nil {
+		t.Errorf("Failure: db.CreateBucket() with quarantine policy returned error \"%v\"", err)
+	}
+	db.View("validate", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 3 {
+			t.Errorf("Failure: expected 3 valid entries after quarantine got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-2"}); e != nil {
+			t.Errorf("Failure: expected key-2 to be quarantined got %v", e)
+		}
+		return nil
+	})
+	q, err := ioutil.ReadFile(db.getDBFilePath("validate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	if err != nil || !strings.Contains(string(q), "INSERT~key-2~{\"x\":2}") {
+		t.Errorf("Failure: expected quarantine file to hold key-2 got %q error \"%v\"", q, err)
+	}
+	os.Remove(db.getDBFilePath("validate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	db.DropBucket("validate")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_OpenValidator(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	os.Remove(db.getDBFilePath("openvalidate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("openvalidate", opts)
+	db.Update("openvalidate", func(tx *Tx) error {
+		for _, kv := range [][2]string{{"key-1", "{\"n\":1}"}, {"key-2", "{\"x\":2}"}, {"key-3", "{\"n\":3}"}} {
+			e, _ := NewEntry(kv[0], kv[1], false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	db.Close()
+
+	requireN := func(e *Entry) error {
+		if !gjson.Get(e.GetValue(), "n").Exists() {
+			return fmt.Errorf("missing field n")
+		}
+		return nil
+	}
+	if _, err := NewConfig(BucketValidator("openvalidate", nil, VALIDATE_REJECT)); err == nil {
+		t.Error("Failure: NewConfig(BucketValidator(nil)) expected error got nil")
+	}
+	if _, err := NewConfig(BucketValidator("openvalidate", requireN, ValidationPolicy(9))); err == nil {
+		t.Error("Failure: NewConfig(BucketValidator(9)) expected error got nil")
+	}
+	c, _ = NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10), BucketValidator(" openvalidate ", requireN, VALIDATE_QUARANTINE))
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if err := db.Open(); err != nil {
+		t.Errorf("Failure: db.Open() with bucket validator returned error \"%v\"", err)
+	}
+	db.View("openvalidate", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 2 {
+			t.Errorf("Failure: expected 2 valid entries after reopen got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-2"}); e != nil {
+			t.Errorf("Failure: expected key-2 to be quarantined got %v", e)
+		}
+		return nil
+	})
+	q, err := ioutil.ReadFile(db.getDBFilePath("openvalidate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	if err != nil || !strings.Contains(string(q), "INSERT~key-2~{\"x\":2}") {
+		t.Errorf("Failure: expected quarantine file to hold key-2 got %q error \"%v\"", q, err)
+	}
+	db.Update("openvalidate", func(tx *Tx) error {
+		e, _ := NewEntry("key-4", "{\"x\":4}", false, nil)
+		if _, err := tx.Set(e); err == nil {
+			t.Error("Failure: tx.Set() of invalid entry after reopen expected validation error got nil")
+		}
+		return nil
+	})
+	os.Remove(db.getDBFilePath("openvalidate" + BUCKET_QUARANTINE_FILE_EXTENSION))
+	db.DropBucket("openvalidate")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_BeginManager(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(50*time.Millisecond), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("begin-manager", opts)
+	tx, err := db.Begin("begin-manager", MODE_READ_WRITE)
+	if err != nil {
+		t.Errorf("Failure: db.Begin(\"begin-manager\", MODE_READ_WRITE) returned error \"%v\"", err)
+	}
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	tx.Set(e)
+	//Allow the db manager to request the db lock while the transaction is open.
+	time.Sleep(200 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		done <- tx.CommitTx()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failure: tx.CommitTx() returned error \"%v\"", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failure: tx.CommitTx() did not return while the db manager was running")
+	}
+	db.DropBucket("begin-manager")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_MightContain(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4), BloomFilter(128))
+	db.CreateBucket("bloom", opts)
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	db.Update("bloom", func(t *Tx) error {
+		t.Set(e)
+		return nil
+	})
+	if !db.MightContain("bloom", "key-1") {
+		t.Errorf("Failure: db.MightContain(\"bloom\", \"key-1\") expected true got false")
+	}
+	db.Update("bloom", func(t *Tx) error {
+		t.Delete(e)
+		return nil
+	})
+	if db.MightContain("bloom", "key-1") {
+		t.Errorf("Failure: db.MightContain(\"bloom\", \"key-1\") after delete expected false got true")
+	}
+	if !db.MightContain("test", "key-1") {
+		t.Errorf("Failure: db.MightContain(\"test\", \"key-1\") without a filter expected true got false")
+	}
+	db.DropBucket("bloom")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_IdleCompaction(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(50*time.Millisecond), Developer, PerformanceMonitor, BucketFileMultLimit(10), IdleCompaction(100*time.Millisecond))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("idle", opts)
+	for i := 0; i < 5; i++ {
+		db.Update("idle", func(t *Tx) error {
+			eopt, _ := NewEntryOptions()
+			e, _ := NewEntry("key-1", "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			t.Set(e)
+			return nil
+		})
+	}
+	time.Sleep(400 * time.Millisecond)
+	file := db.getDBFilePath("idle" + BUCKET_FILE_EXTENSION)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Errorf("Failure: failed to read bucket file \"%v\"", err)
+	}
+	if n := strings.Count(string(data), "INSERT~"); n != 1 {
+		t.Errorf("Failure: expected 1 statement in bucket file after idle compaction got %v", n)
+	}
+	db.Update("idle", func(t *Tx) error {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key-2", "{ \"value\":\"2\"}", false, eopt)
+		t.Set(e)
+		return nil
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("idle", func(tx *Tx) error {
+		e1, _ := tx.Get(&Entry{k: "key-1"})
+		e2, _ := tx.Get(&Entry{k: "key-2"})
+		if e1 == nil || e1.v != "{ \"value\":\"4\"}" || e2 == nil {
+			t.Errorf("Failure: expected key-1 and key-2 after reopen got %v and %v", e1, e2)
+		}
+		return nil
+	})
+	db.DropBucket("idle")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_SetMergeFunc(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Errorf("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	merge := func(existing, incoming *Entry) (*Entry, error) {
+		return NewEntry(incoming.k, existing.v+incoming.v, false, nil)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4), MergeFunc(merge))
+	db.CreateBucket("setmerge", opts)
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if err := db.SetMergeFunc("missing", merge); err == nil {
+		t.Errorf("Failure: db.SetMergeFunc(\"missing\", merge) expected error got nil")
+	}
+	if err := db.SetMergeFunc("setmerge", merge); err != nil {
+		t.Errorf("Failure: db.SetMergeFunc(\"setmerge\", merge) returned error \"%v\"", err)
+	}
+	eopt, _ := NewEntryOptions()
+	db.Update("setmerge", func(tx *Tx) error {
+		e, _ := NewEntry("key", "a", false, eopt)
+		tx.Set(e)
+		e, _ = NewEntry("key", "b", false, eopt)
+		tx.Set(e)
+		return nil
+	})
+	db.View("setmerge", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "key"}); e == nil || e.v != "ab" {
+			t.Errorf("Failure: expected merged value ab got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("setmerge")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_ReplaceBucket(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("replace", opts)
+	keys := func() string {
+		var ks []string
+		db.View("replace", func(tx *Tx) error {
+			return tx.Ascend("value", func(e *Entry) bool {
+				ks = append(ks, e.k)
+				return true
+			})
+		})
+		return strings.Join(ks, ",")
+	}
+	db.Update("replace", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		for i := 0; i < 3; i++ {
+			e, _ := NewEntry("old-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	err = db.ReplaceBucket("replace", func(tx *Tx) error {
+		e, _ := NewEntry("new-0", "{ \"value\":0}", false, nil)
+		tx.Set(e)
+		return fmt.Errorf("build")
+	})
+	if err == nil || err.Error() != "build" {
+		t.Errorf("Failure: db.ReplaceBucket(...) expected build error got \"%v\"", err)
+	}
+	if got := keys(); got != "old-0,old-1,old-2" {
+		t.Errorf("Failure: expected bucket unchanged after failed replace got %v", got)
+	}
+	if _, err := os.Stat(db.getDBFilePath("replace" + BUCKET_REPLACE_FILE_EXTENSION)); !os.IsNotExist(err) {
+		t.Errorf("Failure: expected shadow bucket file to be removed got \"%v\"", err)
+	}
+	err = db.ReplaceBucket("replace", func(tx *Tx) error {
+		//The shadow bucket starts empty with the indexes of the bucket.
+		if got := keys(); got != "old-0,old-1,old-2" {
+			t.Errorf("Failure: expected bucket unchanged during replace got %v", got)
+		}
+		for i := 2; i >= 0; i-- {
+			e, _ := NewEntry("new-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(10-i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failure: db.ReplaceBucket(...) returned error \"%v\"", err)
+	}
+	if got := keys(); got != "new-2,new-1,new-0" {
+		t.Errorf("Failure: expected replaced bucket in index order got %v", got)
+	}
+	db.Update("replace", func(tx *Tx) error {
+		e, _ := NewEntry("new-3", "{ \"value\":20}", false, nil)
+		_, err := tx.Set(e)
+		return err
+	})
+	if err := db.ReplaceBucket("invalid", func(tx *Tx) error { return nil }); err == nil {
+		t.Error("Failure: db.ReplaceBucket(\"invalid\", ...) expected error got nil")
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.Update("replace", func(tx *Tx) error {
+		return tx.CreateIndex("value", INT_INDEX)
+	})
+	if got := keys(); got != "new-2,new-1,new-0,new-3" {
+		t.Errorf("Failure: expected replaced bucket after reopen got %v", got)
+	}
+	db.DropBucket("replace")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_RebuildBucket(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Hour))
+	db.CreateBucket("rebuild", opts)
+	expires := time.Now().Add(time.Hour)
+	db.Update("rebuild", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		for i := 0; i < 50; i++ {
+			eopts, _ := NewEntryOptions(ExpireTime(expires))
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"value\":"+strconv.Itoa(50-i)+"}", false, eopts)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("key-0", "{\"value\":50}", false, nil)
+		tx.Set(e)
+		e, _ = NewEntry("deleted", "{}", false, nil)
+		tx.Set(e)
+		tx.IncrCounter("hits", 7)
+		return nil
+	})
+	db.Update("rebuild", func(tx *Tx) error {
+		_, err := tx.Delete(&Entry{k: "deleted"})
+		return err
+	})
+	check := func(when string, index bool) {
+		db.View("rebuild", func(tx *Tx) error {
+			if size, _ := tx.Size(""); size != 50 {
+				t.Errorf("Failure: expected 50 entries %s got %d", when, size)
+			}
+			e, _ := tx.Get(&Entry{k: "key-0"})
+			if e == nil || e.Version() != 2 || e.opts.doesExp {
+				t.Errorf("Failure: expected key-0 at version 2 without expiry %s got %v", when, e)
+			}
+			e, _ = tx.Get(&Entry{k: "key-1"})
+			if e == nil || !e.ExpiresAt().Equal(expires) {
+				t.Errorf("Failure: expected key-1 to keep its expiry %s got %v", when, e)
+			}
+			if ts, _ := tx.GetWithTombstone("deleted"); ts == nil {
+				t.Errorf("Failure: expected tombstone of deleted entry %s", when)
+			}
+			if counters, _ := tx.Counters(); counters["hits"] != 7 {
+				t.Errorf("Failure: expected counter hits to be 7 %s got %d", when, counters["hits"])
+			}
+			if index {
+				var first string
+				tx.Ascend("value", func(e *Entry) bool {
+					first = e.k
+					return false
+				})
+				if first != "key-49" {
+					t.Errorf("Failure: expected index to begin with key-49 %s got %q", when, first)
+				}
+			}
+			return nil
+		})
+	}
+	newOpts, _ := NewBucketOptions(BTreeDegree(4), TombstoneRetention(time.Hour), Compress)
+	if err := db.RebuildBucket("rebuild", newOpts); err != nil {
+		t.Errorf("Failure: db.RebuildBucket() returned error \"%v\"", err)
+	}
+	if b := db.buckets["rebuild"]; b.options.btdeg != 4 || !b.options.compress {
+		t.Errorf("Failure: expected rebuilt bucket to have degree 4 and compression got %d and %v", b.options.btdeg, b.options.compress)
+	}
+	check("after rebuild", true)
+	//Options that an entry of the bucket does not satisfy leave the bucket unchanged.
+	invalidOpts, _ := NewBucketOptions(BTreeDegree(8), MaxKeyLength(4))
+	if err := db.RebuildBucket("rebuild", invalidOpts); err == nil {
+		t.Error("Failure: db.RebuildBucket() with a maximum key length below a key expected error got nil")
+	}
+	if err := db.RebuildBucket("rebuild", nil); err == nil {
+		t.Error("Failure: db.RebuildBucket() with nil options expected error got nil")
+	}
+	if err := db.RebuildBucket("missing", newOpts); err == nil {
+		t.Error("Failure: db.RebuildBucket() of a missing bucket expected error got nil")
+	}
+	check("after failed rebuild", true)
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	if b := db.buckets["rebuild"]; b == nil || b.options.btdeg != 4 || !b.options.compress {
+		t.Error("Failure: expected rebuilt bucket options after reopen")
+	}
+	check("after reopen", false)
+	db.DropBucket("rebuild")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_Healthy(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(50*time.Millisecond), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if err := db.Healthy(); err == nil {
+		t.Error("Failure: db.Healthy() expected error before open got nil")
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("healthy", opts)
+	//Wait for the managers to run more than the allowed number of intervals since open.
+	time.Sleep(250 * time.Millisecond)
+	if err := db.Healthy(); err != nil {
+		t.Errorf("Failure: db.Healthy() returned error \"%v\"", err)
+	}
+	b := db.buckets["healthy"]
+	b.lock(MODE_READ_WRITE)
+	file := b.file
+	file.Close()
+	b.unlock(MODE_READ_WRITE)
+	if err := db.Healthy(); err == nil || !strings.Contains(err.Error(), "healthy") {
+		t.Errorf("Failure: db.Healthy() expected error for closed bucket file got \"%v\"", err)
+	}
+	b.lock(MODE_READ_WRITE)
+	b.file, _ = os.OpenFile(file.Name(), os.O_RDWR, 0666)
+	b.file.Seek(0, io.SeekEnd)
+	b.unlock(MODE_READ_WRITE)
+	if err := db.Healthy(); err != nil {
+		t.Errorf("Failure: db.Healthy() returned error \"%v\" after reopening bucket file", err)
+	}
+	db.DropBucket("healthy")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	if err := db.Healthy(); err == nil {
+		t.Error("Failure: db.Healthy() expected error after close got nil")
+	}
+}
+
+func TestStitchDB_SweepFrequency(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), SweepFrequency(20*time.Millisecond), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("sweep", opts)
+	eopts, _ := NewEntryOptions(ExpireTime(time.Now().Add(50 * time.Millisecond)))
+	db.Update("sweep", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{}", false, eopts)
+		_, err := tx.Set(e)
+		return err
+	})
+	//Expired entries are swept although the bucket file is not checkpointed until the manage frequency elapses.
+	time.Sleep(300 * time.Millisecond)
+	b := db.buckets["sweep"]
+	b.lock(MODE_READ)
+	size := b.data.Len()
+	b.unlock(MODE_READ)
+	if size != 0 {
+		t.Errorf("Failure: expected expired entry to be swept got %d entries", size)
+	}
+	if err := db.Healthy(); err != nil {
+		t.Errorf("Failure: db.Healthy() returned error \"%v\"", err)
+	}
+	db.DropBucket("sweep")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_SweepLimit(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), DisableManager, SweepLimit(3), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("sweeplimit", opts)
+	now := time.Now()
+	set := func(key string, exp time.Time) {
+		db.Update("sweeplimit", func(tx *Tx) error {
+			eopts, _ := NewEntryOptions(ExpireTime(exp))
+			e, _ := NewEntry(key, "{}", false, eopts)
+			_, err := tx.Set(e)
+			return err
+		})
+	}
+	for i := 0; i < 6; i++ {
+		set("key-"+strconv.Itoa(i), now.Add(-time.Duration(10-i)*time.Minute))
+	}
+	b := db.buckets["sweeplimit"]
+	sweep := func() []string {
+		var keys []string
+		b.lock(MODE_READ_WRITE)
+		b.sweep()
+		b.data.Ascend(func(item btree.Item) bool {
+			keys = append(keys, item.(*Entry).k)
+			return true
+		})
+		b.unlock(MODE_READ_WRITE)
+		return keys
+	}
+	if keys := sweep(); strings.Join(keys, ",") != "key-3,key-4,key-5" {
+		t.Errorf("Failure: expected first sweep to remove the three earliest expired entries got %v", keys)
+	}
+	//Entries that expired before the cursor are removed after the sweep wraps around.
+	set("key-early", now.Add(-time.Hour))
+	if keys := sweep(); strings.Join(keys, ",") != "key-early" {
+		t.Errorf("Failure: expected second sweep to resume after the cursor and wrap around got %v", keys)
+	}
+	if keys := sweep(); len(keys) != 0 {
+		t.Errorf("Failure: expected third sweep to remove the remaining entry got %v", keys)
+	}
+	db.DropBucket("sweeplimit")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_RunMaintenance(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(10*time.Millisecond), DisableManager, Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("maintenance", opts)
+	eopts, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("maintenance", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{}", false, eopts)
+		_, err := tx.Set(e)
+		return err
+	})
+	size := func() int {
+		b := db.buckets["maintenance"]
+		b.lock(MODE_READ)
+		defer b.unlock(MODE_READ)
+		return b.data.Len()
+	}
+	//No manager sweeps the expired entry and the db is healthy without managers running.
+	time.Sleep(100 * time.Millisecond)
+	if n := size(); n != 1 {
+		t.Errorf("Failure: expected expired entry to remain without a manager got %d entries", n)
+	}
+	if err := db.Healthy(); err != nil {
+		t.Errorf("Failure: db.Healthy() returned error \"%v\" with managers disabled", err)
+	}
+	if err := db.RunMaintenance(); err != nil {
+		t.Errorf("Failure: db.RunMaintenance() returned error \"%v\"", err)
+	}
+	if n := size(); n != 0 {
+		t.Errorf("Failure: expected expired entry to be swept by db.RunMaintenance() got %d entries", n)
+	}
+	db.DropBucket("maintenance")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	if err := db.RunMaintenance(); err == nil {
+		t.Error("Failure: db.RunMaintenance() expected error for closed db got nil")
+	}
+}
+
+func TestStitchDB_ReadCommitted(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("isolation", opts)
+	count := func() int {
+		n := 0
+		db.View("isolation", func(tx *Tx) error {
+			n, _ = tx.Size("")
+			return nil
+		})
+		return n
+	}
+	//A reader started while a writer is in progress waits for it and observes none of a rolled back transaction and
+	//every change of a committed transaction.
+	for _, commit := range []bool{false, true} {
+		tx, _ := db.Begin("isolation", MODE_READ_WRITE)
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, nil)
+			tx.Set(e)
+		}
+		read := make(chan int)
+		go func() { read <- count() }()
+		select {
+		case n := <-read:
+			t.Errorf("Failure: expected reader to wait for the writer got %d entries", n)
+		case <-time.After(50 * time.Millisecond):
+		}
+		expected := 0
+		if commit {
+			tx.CommitTx()
+			expected = 10
+		} else {
+			tx.RollbackTx()
+		}
+		if n := <-read; n != expected {
+			t.Errorf("Failure: expected reader to observe %d entries after the writer completed (commit %v) got %d", expected, commit, n)
+		}
+	}
+	//Concurrent readers never observe a partially applied commit.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for v := 0; v < 50; v++ {
+			db.Update("isolation", func(tx *Tx) error {
+				for i := 0; i < 10; i++ {
+					e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"v\":"+strconv.Itoa(v)+"}", false, nil)
+					tx.Set(e)
+				}
+				return nil
+			})
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		db.View("isolation", func(tx *Tx) error {
+			values := make(map[string]bool)
+			tx.Ascend("", func(e *Entry) bool {
+				values[e.GetValue()] = true
+				return true
+			})
+			if len(values) != 1 {
+				t.Errorf("Failure: expected reader to observe the values of a single commit got %v", values)
+			}
+			return nil
+		})
+	}
+	db.DropBucket("isolation")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_OpenInterruptedCompaction(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("compaction", opts)
+	db.Update("compaction", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	db.Close()
+	file, tmp := db.getDBFilePath("compaction"+BUCKET_FILE_EXTENSION), db.getDBFilePath("compaction"+BUCKET_TMP_FILE_EXTENSION)
+	reopen := func() int {
+		db, err = NewStitchDB(c)
+		if err != nil {
+			t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+		}
+		if err := db.Open(); err != nil {
+			t.Errorf("Failure: db.Open() returned error \"%v\"", err)
+		}
+		n := 0
+		db.View("compaction", func(tx *Tx) error {
+			n, _ = tx.Size("")
+			return nil
+		})
+		return n
+	}
+	//Interrupted before the rename: the bucket file is complete and the partial temporary file is removed.
+	ioutil.WriteFile(tmp, []byte("52\nINSERT~key-partial"), 0666)
+	if n := reopen(); n != 10 {
+		t.Errorf("Failure: expected 10 entries after interrupted compaction got %d", n)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Failure: expected temporary bucket file to be removed got \"%v\"", err)
+	}
+	//A stale temporary file does not corrupt the next compaction.
+	ioutil.WriteFile(tmp, bytes.Repeat([]byte("garbage\n"), 4096), 0666)
+	b := db.buckets["compaction"]
+	b.lock(MODE_READ_WRITE)
+	if err := b.compactLog(); err != nil {
+		t.Errorf("Failure: b.compactLog() returned error \"%v\"", err)
+	}
+	b.unlock(MODE_READ_WRITE)
+	db.Close()
+	if n := reopen(); n != 10 {
+		t.Errorf("Failure: expected 10 entries after compaction over stale temporary file got %d", n)
+	}
+	db.Close()
+	//Interrupted after the bucket file was removed: the synced temporary file becomes the bucket file.
+	os.Rename(file, tmp)
+	if n := reopen(); n != 10 {
+		t.Errorf("Failure: expected 10 entries recovered from temporary bucket file got %d", n)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Failure: expected temporary bucket file to be renamed got \"%v\"", err)
+	}
+	db.DropBucket("compaction")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_MaxTotals(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/totals/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10), MaxTotalEntries(5), MaxTotalBytes(60))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("totals-a", opts)
+	db.CreateBucket("totals-b", opts)
+	set := func(bucket, key, value string) error {
+		return db.Update(bucket, func(tx *Tx) error {
+			e, _ := NewEntry(key, value, false, nil)
+			_, err := tx.Set(e)
+			return err
+		})
+	}
+	//Each entry is 4 bytes of key and 2 bytes of value.
+	for i := 0; i < 3; i++ {
+		if err := set("totals-a", "a-0"+strconv.Itoa(i), "{}"); err != nil {
+			t.Errorf("Failure: set(\"totals-a\", ...) returned error \"%v\"", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := set("totals-b", "b-0"+strconv.Itoa(i), "{}"); err != nil {
+			t.Errorf("Failure: set(\"totals-b\", ...) returned error \"%v\"", err)
+		}
+	}
+	if entries, bytes := db.Usage(); entries != 5 || bytes != 30 {
+		t.Errorf("Failure: db.Usage() expected 5 entries and 30 bytes got %d and %d", entries, bytes)
+	}
+	if err := set("totals-b", "b-02", "{}"); err == nil {
+		t.Error("Failure: expected set beyond max total entries to fail got nil")
+	}
+	//Replacing an entry does not add to the entry count but is limited by size.
+	if err := set("totals-a", "a-00", "{\"v\":1}"); err != nil {
+		t.Errorf("Failure: expected replacing set within limits to succeed got \"%v\"", err)
+	}
+	if err := set("totals-a", "a-00", "{\"value\":\"0123456789012345678901234567890123456789\"}"); err == nil {
+		t.Error("Failure: expected set beyond max total bytes to fail got nil")
+	}
+	db.Update("totals-a", func(tx *Tx) error {
+		_, err := tx.Delete(&Entry{k: "a-01"})
+		return err
+	})
+	if err := set("totals-b", "b-02", "{}"); err != nil {
+		t.Errorf("Failure: expected set after delete to succeed got \"%v\"", err)
+	}
+	//A rolled back transaction leaves the totals unchanged.
+	before, _ := db.Usage()
+	db.Update("totals-b", func(tx *Tx) error {
+		tx.Delete(&Entry{k: "b-00"})
+		tx.Delete(&Entry{k: "b-01"})
+		return fmt.Errorf("rollback")
+	})
+	if after, _ := db.Usage(); after != before {
+		t.Errorf("Failure: expected %d entries after rollback got %d", before, after)
+	}
+	db.DropBucket("totals-b")
+	if entries, bytes := db.Usage(); entries != 2 || bytes != 17 {
+		t.Errorf("Failure: db.Usage() expected 2 entries and 17 bytes after drop got %d and %d", entries, bytes)
+	}
+	db.Close()
+	if entries, _ := db.Usage(); entries != 0 {
+		t.Errorf("Failure: db.Usage() expected 0 entries after close got %d", entries)
+	}
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if entries, bytes := db.Usage(); entries != 2 || bytes != 17 {
+		t.Errorf("Failure: db.Usage() expected 2 entries and 17 bytes after reopen got %d and %d", entries, bytes)
+	}
+	db.DropBucket("totals-a")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_IsOpen(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if db.IsOpen() {
+		t.Error("Failure: db.IsOpen() expected false before open got true")
+	}
+	db.Open()
+	if !db.IsOpen() {
+		t.Error("Failure: db.IsOpen() expected true after open got false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("isopen", opts)
+	if open, err := db.BucketIsOpen("isopen"); !open || err != nil {
+		t.Errorf("Failure: db.BucketIsOpen(\"isopen\") expected true got %v, \"%v\"", open, err)
+	}
+	if open, err := db.BucketIsOpen("_sys"); !open || err != nil {
+		t.Errorf("Failure: db.BucketIsOpen(\"_sys\") expected true got %v, \"%v\"", open, err)
+	}
+	if _, err := db.BucketIsOpen("invalid"); err == nil {
+		t.Error("Failure: db.BucketIsOpen(\"invalid\") expected error got nil")
+	}
+	db.DropBucket("isopen")
+	if _, err := db.BucketIsOpen("isopen"); err == nil {
+		t.Error("Failure: db.BucketIsOpen(\"isopen\") expected error after drop got nil")
+	}
+	db.Close()
+	if db.IsOpen() {
+		t.Error("Failure: db.IsOpen() expected false after close got true")
+	}
+	if open, err := db.BucketIsOpen("_sys"); open || err != nil {
+		t.Errorf("Failure: db.BucketIsOpen(\"_sys\") expected false after close got %v, \"%v\"", open, err)
+	}
+}
+
+func TestStitchDB_CreateBucketExistingFile(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+		if reject {
+			c, _ = NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10), RejectExistingBucketFiles)
+		}
+		db, err := NewStitchDB(c)
+		if err != nil {
+			t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+		}
+		db.Open()
+		if !db.open {
+			t.Error("Failure: db.Open() expected db to be open got db.open == false")
+		}
+		opts, _ := NewBucketOptions(BTreeDegree(32))
+		if err := db.CreateBucket("existing", opts); err != nil {
+			t.Errorf("Failure: db.CreateBucket(\"existing\") returned error \"%v\"", err)
+		}
+		db.Update("existing", func(tx *Tx) error {
+			e, _ := NewEntry("key-1", "{}", false, nil)
+			_, err := tx.Set(e)
+			return err
+		})
+		//Dropping the bucket leaves its file in place.
+		db.DropBucket("existing")
+		err = db.CreateBucket("existing", opts)
+		if reject {
+			if err == nil {
+				t.Error("Failure: db.CreateBucket(\"existing\") expected error for existing bucket file got nil")
+			}
+			if _, err := db.BucketIsOpen("existing"); err == nil {
+				t.Error("Failure: db.BucketIsOpen(\"existing\") expected error for rejected bucket got nil")
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Failure: db.CreateBucket(\"existing\") returned error \"%v\"", err)
+			}
+			db.View("existing", func(tx *Tx) error {
+				if e, _ := tx.Get(&Entry{k: "key-1"}); e == nil {
+					t.Error("Failure: expected key-1 loaded from existing bucket file got nil")
+				}
+				return nil
+			})
+			db.DropBucket("existing")
+		}
+		os.Remove(db.getDBFilePath("existing" + BUCKET_FILE_EXTENSION))
+		db.Close()
+		if db.open {
+			t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+		}
+	}
+}
+
+func TestStitchDB_Compress(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), Compress)
+	db.CreateBucket("compress", opts)
+	value := "{\"name\": \"" + strings.Repeat("stitch", 64) + "\"}"
+	db.Update("compress", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), value, false, nil)
+			tx.Set(e)
+		}
+		e, _ := NewBinaryEntry("bin", []byte{0, 1, '~', '\n'}, nil)
+		tx.Set(e)
+		return nil
+	})
+	if err := db.Close(); err != nil {
+		t.Errorf("Failure: db.Close() returned error \"%v\"", err)
+	}
+	data, _ := ioutil.ReadFile(db.getDBFilePath("compress" + BUCKET_FILE_EXTENSION))
+	if bytes.Contains(data, []byte("stitch")) {
+		t.Error("Failure: expected bucket file not to contain uncompressed values")
+	}
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("compress", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 11 {
+			t.Errorf("Failure: expected 11 entries after reopen got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-3"}); e == nil || e.GetValue() != value {
+			t.Errorf("Failure: expected compressed entry to load as written got %v", e)
+		}
+		if e, _ := tx.Get(&Entry{k: "bin"}); e == nil || !bytes.Equal(e.GetBytes(), []byte{0, 1, '~', '\n'}) {
+			t.Errorf("Failure: expected compressed binary entry to load as written got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("compress")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_BucketFilePath(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if _, err := db.BucketFilePath("filepath"); err == nil {
+		t.Error("Failure: db.BucketFilePath(\"filepath\") expected error for closed db got nil")
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("filepath", opts)
+	db.Update("filepath", func(tx *Tx) error {
+		e, _ := NewEntry("key", "{}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	path, err := db.BucketFilePath("filepath")
+	if err != nil {
+		t.Errorf("Failure: db.BucketFilePath(\"filepath\") returned error \"%v\"", err)
+	}
+	if path != "stitch/test/db/filepath"+BUCKET_FILE_EXTENSION {
+		t.Errorf("Failure: db.BucketFilePath(\"filepath\") expected \"stitch/test/db/filepath%s\" got %q", BUCKET_FILE_EXTENSION, path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Failure: expected bucket file at %q got error \"%v\"", path, err)
+	}
+	if p, _ := db.BucketFilePath(" filepath "); p != path {
+		t.Errorf("Failure: db.BucketFilePath(\" filepath \") expected %q got %q", path, p)
+	}
+	if _, err := db.BucketFilePath("missing"); err == nil {
+		t.Error("Failure: db.BucketFilePath(\"missing\") expected error got nil")
+	}
+	db.DropBucket("filepath")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_AscendAll(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if err := db.AscendAll(func(bucket string, e *Entry) bool { return true }); err == nil {
+		t.Error("Failure: db.AscendAll(...) expected error for closed db got nil")
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	for _, name := range []string{"all-b", "all-a"} {
+		db.CreateBucket(name, opts)
+		db.Update(name, func(tx *Tx) error {
+			for i := 0; i < 2; i++ {
+				e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, nil)
+				tx.Set(e)
+			}
+			eopts, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+			e, _ := NewEntry("key-expired", "{}", false, eopts)
+			tx.Set(e)
+			return nil
+		})
+	}
+	var visited []string
+	err = db.AscendAll(func(bucket string, e *Entry) bool {
+		if strings.HasPrefix(bucket, "all-") {
+			visited = append(visited, bucket+"/"+e.k)
+		}
+		return true
+	})
+	if err != nil {
+		t.Errorf("Failure: db.AscendAll(...) returned error \"%v\"", err)
+	}
+	if got := strings.Join(visited, ","); got != "all-a/key-0,all-a/key-1,all-b/key-0,all-b/key-1" {
+		t.Errorf("Failure: db.AscendAll(...) expected live entries of each bucket in order got %v", got)
+	}
+	visited = nil
+	db.AscendAll(func(bucket string, e *Entry) bool {
+		visited = append(visited, bucket+"/"+e.k)
+		return len(visited) < 3
+	})
+	if len(visited) != 3 {
+		t.Errorf("Failure: expected db.AscendAll(...) to stop after 3 entries got %d", len(visited))
+	}
+	db.DropBucket("all-a")
+	db.DropBucket("all-b")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
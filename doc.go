@@ -3,5 +3,24 @@
 
 // StitchDB is a yet another key-value store with support for geolocation, indexes, transactions,
 // expiration, and invalidation. The store is a persisted store that writes to an append only file.
+//
+// Isolation
+//
+// Transactions are isolated per bucket and are at least read committed: a transaction never observes the uncommitted
+// changes of another transaction. A read/write transaction holds the bucket exclusively from the time it starts until
+// it is committed or rolled back, and read only transactions share the bucket with each other but not with a read/write
+// transaction, so a read only transaction observes either every change of a commit or none of them. Changes of a
+// transaction that is rolled back, including a commit that fails to persist, are never observed. The bucket manager
+// holds the bucket exclusively while it sweeps expired entries. No isolation is provided across buckets; transactions
+// on different buckets run independently and a set of read only transactions over several buckets may observe the
+// buckets at different points in time.
+//
+// Because a transaction holds its bucket until it completes, a goroutine must not start a transaction on a bucket while
+// a transaction it started on the same bucket is open, such as calling Update or View from within the function passed
+// to Update or View for the same bucket. A nested read/write transaction can never obtain the bucket, and a nested read
+// only transaction deadlocks once a read/write transaction is waiting for the bucket or the bucket has MaxReaders
+// readers. With the DetectReentrantTx config option starting such a transaction returns an error reporting that the
+// bucket is already locked by this goroutine rather than deadlocking. Transactions started with Begin are attributed to
+// the goroutine that called Begin.
 
 package stitchdb // import "github.com/cbergoon/stitchdb"
@@ -4,7 +4,12 @@
 package stitchdb
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cbergoon/btree"
@@ -13,17 +18,39 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-//Entry represents an item to be stored in the database.
+//ENTRY_FORMAT_VERSION is the version of the record format written by Entry.Marshal. Version 2 records expiry and
+//invalidation times with nanosecond precision; version 1 records are still read.
+const ENTRY_FORMAT_VERSION int = 2
+
+//VALUE_FLAG_BINARY and VALUE_FLAG_COMPRESSED are the bits of the flag following the entry options of a statement.
+//VALUE_FLAG_BINARY marks a binary value and VALUE_FLAG_COMPRESSED marks a value that is gzip compressed; both are written
+//base64 encoded.
+const (
+	VALUE_FLAG_BINARY     int = 1
+	VALUE_FLAG_COMPRESSED int = 2
+)
+
+//Entry represents an item to be stored in the database. Entries passed to iterator callbacks or returned from a
+//transaction are owned by the db and must not be modified; use Clone to retain a copy of an entry beyond the transaction.
 type Entry struct {
 	k        string        //Key of the entry.
 	v        string        //Value (as JSON) of the entry.
 	opts     *EntryOptions //Entry configuration.
 	invalid  bool          //Indicates validity of the entry.
 	location rtreego.Point //Geo representation if geo-enabled.
+	deleted  time.Time     //Time the entry was deleted if the entry is a tombstone.
+	binary   bool          //Indicates the value holds raw bytes rather than JSON.
+	lsn      uint64        //Log sequence number of the commit that last wrote the entry; zero if not committed.
+	version  uint64        //Version of the entry incremented by each Set of its key; zero if never set.
+	stale    bool          //Indicates the entry was returned from the stale read cache of the bucket.
 }
 
-//NewEntry creates a new entry object with the provided values. Returns an error if the default options failed to create.
+//NewEntry creates a new entry object with the provided values. The value may be empty for an entry that only records
+//the presence of its key. Returns an error if the key is empty or if the default options failed to create.
 func NewEntry(k string, v string, geo bool, options *EntryOptions) (*Entry, error) {
+	if k == "" {
+		return nil, errors.New("error: entry: key must not be empty")
+	}
 	opts, err := NewEntryOptions()
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry: failed to create entry options")
@@ -33,13 +60,7 @@ func NewEntry(k string, v string, geo bool, options *EntryOptions) (*Entry, erro
 	}
 	var l rtreego.Point
 	if geo {
-		ljson := gjson.Get(v, "coords")
-		if ljson.Exists() {
-			ljson.ForEach(func(key, value gjson.Result) bool {
-				l = append(l, value.Float())
-				return true // keep iterating
-			})
-		}
+		l = parseLocation(v)
 	}
 	return &Entry{
 		k:        k,
@@ -50,8 +71,33 @@ func NewEntry(k string, v string, geo bool, options *EntryOptions) (*Entry, erro
 }
 
 //NewEntryWithGeo creates a new entry; the entry value is expected to provide a "coords" field in the json provided.
-//Returns an error if the default options failed to create.
+//Returns an error if the key is empty or if the default options failed to create.
 func NewEntryWithGeo(k string, v string, options *EntryOptions) (*Entry, error) {
+	if k == "" {
+		return nil, errors.New("error: entry: key must not be empty")
+	}
+	opts, err := NewEntryOptions()
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: failed to create entry options")
+	}
+	if options != nil {
+		opts = options
+	}
+	return &Entry{
+		k:        k,
+		v:        v,
+		opts:     opts,
+		location: parseLocation(v),
+	}, nil
+}
+
+//NewBinaryEntry creates a new entry holding the provided bytes as its value. The value is not parsed as JSON so binary
+//entries are not geo-located and are not added to indexes. Returns an error if the key is empty or if the default options
+//failed to create.
+func NewBinaryEntry(k string, v []byte, options *EntryOptions) (*Entry, error) {
+	if k == "" {
+		return nil, errors.New("error: entry: key must not be empty")
+	}
 	opts, err := NewEntryOptions()
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry: failed to create entry options")
@@ -59,6 +105,17 @@ func NewEntryWithGeo(k string, v string, options *EntryOptions) (*Entry, error)
 	if options != nil {
 		opts = options
 	}
+	return &Entry{
+		k:      k,
+		v:      string(v),
+		opts:   opts,
+		binary: true,
+	}, nil
+}
+
+//parseLocation reads the "coords" field of the provided json value into a point. Returns nil if the value does not
+//contain a "coords" field.
+func parseLocation(v string) rtreego.Point {
 	var l rtreego.Point
 	ljson := gjson.Get(v, "coords")
 	if ljson.Exists() {
@@ -67,29 +124,98 @@ func NewEntryWithGeo(k string, v string, options *EntryOptions) (*Entry, error)
 			return true // keep iterating
 		})
 	}
-	return &Entry{
-		k:        k,
-		v:        v,
-		opts:     opts,
-		location: l,
-	}, nil
+	return l
 }
 
-//Less is the comparator provided used to build the indexes over a bucket.
+//Less is the comparator provided used to build the indexes over a bucket. The default (key) ordering is a strict
+//ordering on the entry key so the order of the main tree depends only on the keys present and not on the order in
+//which the entries were inserted. Entries that expire or invalidate at the same time are ordered by key so that each
+//occupies its own position in the eviction and invalidation trees.
 func (e *Entry) Less(than btree.Item, itype interface{}) bool {
-	tl := than.(*Entry)
 	switch i := itype.(type) {
 	case *eItype:
-		return e.ExpiresAt().Before(tl.ExpiresAt()) //Todo: May need to catch edge case
+		tl := than.(*Entry)
+		if !e.ExpiresAt().Equal(tl.ExpiresAt()) {
+			return e.ExpiresAt().Before(tl.ExpiresAt())
+		}
+		return e.k < tl.k
 	case *iItype:
-		return e.InvalidatesAt().Before(tl.InvalidatesAt()) //Todo: May need to catch edge case
+		tl := than.(*Entry)
+		if !e.InvalidatesAt().Equal(tl.InvalidatesAt()) {
+			return e.InvalidatesAt().Before(tl.InvalidatesAt())
+		}
+		return e.k < tl.k
+	case *sItype:
+		tl := than.(*Entry)
+		if e.lsn != tl.lsn {
+			return e.lsn < tl.lsn
+		}
+		return e.k < tl.k
 	case *Index:
-		return i.less(e, tl)
+		if p, ok := than.(*indexPivot); ok {
+			if p.keyed {
+				return i.less(e, p.e)
+			}
+			c := i.compare(e, p.e)
+			return c < 0 || (c == 0 && p.high)
+		}
+		return i.less(e, than.(*Entry))
 	default:
-		return e.k < tl.k
+		return e.k < than.(*Entry).k
 	}
 }
 
+//Clone returns a deep copy of the entry including the key, value, options, location, and timestamps. The returned entry
+//shares no state with the original entry.
+func (e *Entry) Clone() *Entry {
+	c := &Entry{
+		k:       e.k,
+		v:       e.v,
+		invalid: e.invalid,
+		deleted: e.deleted,
+		binary:  e.binary,
+		lsn:     e.lsn,
+		version: e.version,
+	}
+	if e.opts != nil {
+		opts := *e.opts
+		c.opts = &opts
+	}
+	if e.location != nil {
+		c.location = make(rtreego.Point, len(e.location))
+		copy(c.location, e.location)
+	}
+	return c
+}
+
+//persists checks if the entry is written to the bucket file.
+func (e *Entry) persists() bool {
+	return e.opts == nil || !e.opts.noPersist
+}
+
+//size returns the number of bytes of the key and value of the entry counted toward MaxTotalBytes.
+func (e *Entry) size() int64 {
+	return int64(len(e.k) + len(e.v))
+}
+
+//LSN returns the log sequence number of the commit that last wrote the entry. Log sequence numbers increase with each
+//committed write across the db and survive restarts. Returns zero if the entry has not been committed.
+func (e *Entry) LSN() uint64 {
+	return e.lsn
+}
+
+//Version returns the version of the entry. Each Set of a key stores the entry with a version one greater than the live
+//entry it replaces, starting at one; the version is persisted with the entry. Returns zero if the entry has not been set.
+func (e *Entry) Version() uint64 {
+	return e.version
+}
+
+//IsStale returns true if the entry was returned by StitchDB.GetTimeout from the stale read cache of the bucket rather than
+//read from the bucket; the entry may have been changed or deleted since.
+func (e *Entry) IsStale() bool {
+	return e.stale
+}
+
 //IsExpired checks if the expire time for an entry has passed.
 func (e *Entry) IsExpired() bool {
 	if e.opts.doesExp {
@@ -115,6 +241,16 @@ func (e *Entry) IsInvalid() bool {
 	return false
 }
 
+//IsTombstone checks if the entry is a tombstone left by the deletion of an entry.
+func (e *Entry) IsTombstone() bool {
+	return !e.deleted.IsZero()
+}
+
+//DeletedAt returns the time that an entry was deleted. Returns the zero time if the entry is not a tombstone.
+func (e *Entry) DeletedAt() time.Time {
+	return e.deleted
+}
+
 //ExpiresAt returns the time that an entry will expire.
 func (e *Entry) ExpiresAt() time.Time {
 	return e.opts.expTime
@@ -132,6 +268,16 @@ func (e *Entry) Bounds() *rtreego.Rect {
 	return e.location.ToRect(e.opts.tol)
 }
 
+//IsBinary checks if the entry holds a binary value created by NewBinaryEntry.
+func (e *Entry) IsBinary() bool {
+	return e.binary
+}
+
+//GetBytes returns the value of the entry as bytes.
+func (e *Entry) GetBytes() []byte {
+	return []byte(e.v)
+}
+
 //GetKey returns the key value of the entry.
 func (e *Entry) GetKey() string {
 	return e.k
@@ -157,17 +303,45 @@ func GetEntryComparator() func(obj1, obj2 rtreego.Spatial) bool {
 //	return
 //}
 
-//EntryInsertStmt builds and returns the insert statement for a given entity.
+//EntryInsertStmt builds and returns the insert statement for a given entity. Binary values are written base64 encoded
+//followed by a trailing binary flag after the entry options. The log sequence number of a committed entry follows the
+//binary flag and the version of the entry follows the log sequence number.
 func (e *Entry) EntryInsertStmt() []byte {
+	return e.insertStmt(false)
+}
+
+//insertStmt builds and returns the insert statement for the entry. If compress is true the value is written gzip
+//compressed and base64 encoded and the flag following the entry options records that the value is compressed.
+func (e *Entry) insertStmt(compress bool) []byte {
 	var buf, cbuf []byte
 
 	cbuf = append(cbuf, "INSERT"...)
 	cbuf = append(cbuf, '~')
 	cbuf = append(cbuf, e.k...)
 	cbuf = append(cbuf, '~')
-	cbuf = append(cbuf, e.v...)
+	flag := boolToInt(e.binary)
+	if compress {
+		cbuf = append(cbuf, base64.StdEncoding.EncodeToString(compressValue(e.v))...)
+		flag |= VALUE_FLAG_COMPRESSED
+	} else if e.binary {
+		cbuf = append(cbuf, base64.StdEncoding.EncodeToString([]byte(e.v))...)
+	} else {
+		cbuf = append(cbuf, e.v...)
+	}
 	cbuf = append(cbuf, '~')
 	cbuf = append(cbuf, e.opts.entryOptionsCreateStmt()...)
+	if flag != 0 || e.lsn > 0 || e.version > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.Itoa(flag)...)
+	}
+	if e.lsn > 0 || e.version > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.FormatUint(e.lsn, 10)...)
+	}
+	if e.version > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.FormatUint(e.version, 10)...)
+	}
 	cbuf = append(cbuf, '\n')
 
 	buf = append(buf, strconv.Itoa(len(cbuf))...)
@@ -177,7 +351,8 @@ func (e *Entry) EntryInsertStmt() []byte {
 	return buf
 }
 
-//EntryDeleteStmt builds and returns the delete statement for a given entity.
+//EntryDeleteStmt builds and returns the delete statement for a given entity. The log sequence number of the deletion
+//follows the entry options in the position it has in the insert statement.
 func (e *Entry) EntryDeleteStmt() []byte {
 	var buf, cbuf []byte
 
@@ -188,6 +363,67 @@ func (e *Entry) EntryDeleteStmt() []byte {
 	cbuf = append(cbuf, e.v...)
 	cbuf = append(cbuf, '~')
 	cbuf = append(cbuf, e.opts.entryOptionsCreateStmt()...)
+	if e.lsn > 0 {
+		cbuf = append(cbuf, "~0~"...)
+		cbuf = append(cbuf, strconv.FormatUint(e.lsn, 10)...)
+	}
+	cbuf = append(cbuf, '\n')
+
+	buf = append(buf, strconv.Itoa(len(cbuf))...)
+	buf = append(buf, '\n')
+	buf = append(buf, cbuf...)
+
+	return buf
+}
+
+//EntryExpireStmt builds and returns the expire statement for a given entity. The statement records only the key, expiry
+//time, and log sequence number of the entry.
+func (e *Entry) EntryExpireStmt() []byte {
+	var buf, cbuf []byte
+
+	cbuf = append(cbuf, "EXPIRE"...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, e.k...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, formatStmtTime(e.ExpiresAt())...)
+	if e.lsn > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.FormatUint(e.lsn, 10)...)
+	}
+	cbuf = append(cbuf, '\n')
+
+	buf = append(buf, strconv.Itoa(len(cbuf))...)
+	buf = append(buf, '\n')
+	buf = append(buf, cbuf...)
+
+	return buf
+}
+
+//EntryTombstoneStmt builds and returns the tombstone statement for a given entity. The statement records the value and
+//options of the deleted entry followed by the binary flag, the time of the deletion, and the log sequence number of the
+//deletion.
+func (e *Entry) EntryTombstoneStmt() []byte {
+	var buf, cbuf []byte
+
+	cbuf = append(cbuf, "TOMBSTONE"...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, e.k...)
+	cbuf = append(cbuf, '~')
+	if e.binary {
+		cbuf = append(cbuf, base64.StdEncoding.EncodeToString([]byte(e.v))...)
+	} else {
+		cbuf = append(cbuf, e.v...)
+	}
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, e.opts.entryOptionsCreateStmt()...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, strconv.Itoa(boolToInt(e.binary))...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, strconv.FormatInt(e.deleted.UnixNano(), 10)...)
+	if e.lsn > 0 {
+		cbuf = append(cbuf, '~')
+		cbuf = append(cbuf, strconv.FormatUint(e.lsn, 10)...)
+	}
 	cbuf = append(cbuf, '\n')
 
 	buf = append(buf, strconv.Itoa(len(cbuf))...)
@@ -204,8 +440,33 @@ func NewEntryFromStmt(stmtParts []string) (*Entry, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry: failed to parse entry options")
 	}
+	var flag int
+	if len(stmtParts) > 8 {
+		if flag, err = strconv.Atoi(strings.TrimSpace(stmtParts[8])); err != nil {
+			return nil, errors.Annotate(err, "error: entry: failed to parse value flag")
+		}
+	}
 	var entry *Entry
-	if gjson.Get(stmtParts[2], "coords").Exists() {
+	if flag&VALUE_FLAG_COMPRESSED != 0 {
+		v, derr := base64.StdEncoding.DecodeString(stmtParts[2])
+		if derr != nil {
+			return nil, errors.Annotate(derr, "error: entry: failed to decode compressed value")
+		}
+		if v, derr = decompressValue(v); derr != nil {
+			return nil, derr
+		}
+		if flag&VALUE_FLAG_BINARY != 0 {
+			entry, err = NewBinaryEntry(stmtParts[1], v, opts)
+		} else {
+			entry, err = NewEntry(stmtParts[1], string(v), gjson.GetBytes(v, "coords").Exists(), opts)
+		}
+	} else if flag&VALUE_FLAG_BINARY != 0 {
+		v, derr := base64.StdEncoding.DecodeString(stmtParts[2])
+		if derr != nil {
+			return nil, errors.Annotate(derr, "error: entry: failed to decode binary value")
+		}
+		entry, err = NewBinaryEntry(stmtParts[1], v, opts)
+	} else if gjson.Get(stmtParts[2], "coords").Exists() {
 		entry, err = NewEntry(stmtParts[1], stmtParts[2], true, opts)
 	} else {
 		entry, err = NewEntry(stmtParts[1], stmtParts[2], false, opts)
@@ -213,5 +474,123 @@ func NewEntryFromStmt(stmtParts []string) (*Entry, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry: failed to create entry")
 	}
+	if (stmtParts[0] == "INSERT" || stmtParts[0] == "DELETE") && len(stmtParts) > 9 {
+		entry.lsn, err = strconv.ParseUint(strings.TrimSpace(stmtParts[9]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: entry: failed to parse log sequence number")
+		}
+	}
+	if stmtParts[0] == "INSERT" && len(stmtParts) > 10 {
+		entry.version, err = strconv.ParseUint(strings.TrimSpace(stmtParts[10]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: entry: failed to parse version")
+		}
+	}
+	return entry, nil
+}
+
+//sequenceStmt builds and returns the sequence statement recording the highest log sequence number issued by the db.
+func sequenceStmt(lsn uint64) []byte {
+	var buf, cbuf []byte
+
+	cbuf = append(cbuf, "SEQUENCE"...)
+	cbuf = append(cbuf, '~')
+	cbuf = append(cbuf, strconv.FormatUint(lsn, 10)...)
+	cbuf = append(cbuf, '\n')
+
+	buf = append(buf, strconv.Itoa(len(cbuf))...)
+	buf = append(buf, '\n')
+	buf = append(buf, cbuf...)
+
+	return buf
+}
+
+//newTombstoneFromStmt parses the tombstone statement provided and returns the tombstone it represents. Returns an error if
+//the statement could not be parsed.
+func newTombstoneFromStmt(stmtParts []string) (*Entry, error) {
+	if len(stmtParts) < 10 {
+		return nil, errors.New("error: entry: invalid tombstone statement")
+	}
+	entry, err := NewEntryFromStmt(stmtParts)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := strconv.ParseInt(strings.TrimSpace(stmtParts[9]), 10, 64)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: failed to parse deletion time")
+	}
+	entry.deleted = time.Unix(0, deleted)
+	if len(stmtParts) > 10 {
+		entry.lsn, err = strconv.ParseUint(strings.TrimSpace(stmtParts[10]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotate(err, "error: entry: failed to parse log sequence number")
+		}
+	}
 	return entry, nil
 }
+
+//Marshal returns the entry encoded in the record format of the bucket file preceded by a line holding the version of the
+//format. The record holds the key, value, and options of the entry; times are recorded with nanosecond precision as they
+//are in the bucket file. Returns an error if the key or the value of a non-binary entry cannot be represented in the format.
+func (e *Entry) Marshal() ([]byte, error) {
+	if e.k == "" || strings.ContainsAny(e.k, "~\n") {
+		return nil, errors.New("error: entry: key cannot be represented in the record format")
+	}
+	if !e.binary && strings.Contains(e.v, "~") {
+		return nil, errors.New("error: entry: value cannot be represented in the record format")
+	}
+	buf := []byte("v" + strconv.Itoa(ENTRY_FORMAT_VERSION) + "\n")
+	return append(buf, e.EntryInsertStmt()...), nil
+}
+
+//UnmarshalEntry returns the entry encoded by Entry.Marshal. Returns an error if the data is not a complete record or was
+//written by a newer version of the format.
+func UnmarshalEntry(data []byte) (*Entry, error) {
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "v") {
+		return nil, errors.New("error: entry: invalid record; missing format version")
+	}
+	version, err := strconv.Atoi(lines[0][1:])
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: invalid record; unusable format version")
+	}
+	if version < 1 || version > ENTRY_FORMAT_VERSION {
+		return nil, errors.New("error: entry: unsupported record format version " + lines[0][1:])
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: invalid record; missing or unusable entry length")
+	}
+	if size != len(lines[2]) {
+		return nil, errors.New("error: entry: invalid record; entry length is invalid")
+	}
+	stype, sparts, err := parseEntryStmtTypeName(strings.TrimSuffix(lines[2], "\n"))
+	if err != nil || stype != "INSERT" || len(sparts) < 8 {
+		return nil, errors.New("error: entry: invalid record; expected insert statement")
+	}
+	return NewEntryFromStmt(sparts)
+}
+
+//compressValue returns the value gzip compressed.
+func compressValue(v string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	//Writes to a bytes.Buffer do not fail.
+	zw.Write([]byte(v))
+	zw.Close()
+	return buf.Bytes()
+}
+
+//decompressValue returns the gzip compressed value decompressed. Returns an error if the value is not gzip compressed.
+func decompressValue(v []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: failed to decompress value")
+	}
+	defer zr.Close()
+	d, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: entry: failed to decompress value")
+	}
+	return d, nil
+}
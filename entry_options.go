@@ -13,11 +13,14 @@ import (
 
 //EntryOptions represents the configuration for an entry determining how an entry will function within a bucket.
 type EntryOptions struct {
-	doesExp bool      //Indicates if the entry will expire at expTime.
-	doesInv bool      //Indicates if the entry will invalidate at invTime.
-	expTime time.Time //Time at which the entry will expire if doesExp is true.
-	invTime time.Time //Time at which the entry will invalidate if doesInv is true.
-	tol     float64   //Tolerance of the entry's geo-location. Used to create a rectangle to insert into rtree.
+	doesExp   bool          //Indicates if the entry will expire at expTime.
+	doesInv   bool          //Indicates if the entry will invalidate at invTime.
+	expTime   time.Time     //Time at which the entry will expire if doesExp is true.
+	invTime   time.Time     //Time at which the entry will invalidate if doesInv is true.
+	tol       float64       //Tolerance of the entry's geo-location. Used to create a rectangle to insert into rtree.
+	noPersist bool          //Indicates the entry is kept in memory only and never written to the bucket file.
+	expTTL    time.Duration //Duration after which the entry expires if set with ExpireAfter; not persisted.
+	invTTL    time.Duration //Duration after which the entry invalidates if set with InvalidAfter; not persisted.
 }
 
 //ExpireTime sets the time the entry will expire and enables expiration for the entry.
@@ -38,6 +41,34 @@ func InvalidTime(time time.Time) func(*EntryOptions) error {
 	}
 }
 
+//ExpireAfter enables expiration for the entry at the provided duration from now. When used in the default entry options
+//of a bucket the duration is measured from the time each entry is set.
+func ExpireAfter(ttl time.Duration) func(*EntryOptions) error {
+	return func(e *EntryOptions) error {
+		if ttl <= 0 {
+			return errors.New("error: entry_options: ttl must be greater than zero")
+		}
+		e.doesExp = true
+		e.expTime = time.Now().Add(ttl)
+		e.expTTL = ttl
+		return nil
+	}
+}
+
+//InvalidAfter enables invalidation for the entry at the provided duration from now. When used in the default entry
+//options of a bucket the duration is measured from the time each entry is set.
+func InvalidAfter(ttl time.Duration) func(*EntryOptions) error {
+	return func(e *EntryOptions) error {
+		if ttl <= 0 {
+			return errors.New("error: entry_options: ttl must be greater than zero")
+		}
+		e.doesInv = true
+		e.invTime = time.Now().Add(ttl)
+		e.invTTL = ttl
+		return nil
+	}
+}
+
 //Tol sets the tolerance (accuracy) of the geo-location for the entry primarily used to build the rtree.
 func Tol(t float64) func(*EntryOptions) error {
 	return func(e *EntryOptions) error {
@@ -46,6 +77,13 @@ func Tol(t float64) func(*EntryOptions) error {
 	}
 }
 
+//NoPersist excludes the entry from persistence. The entry is kept in memory and in the indexes of the bucket but is not
+//written to the bucket file and will not be present after the db is reopened.
+func NoPersist(e *EntryOptions) error {
+	e.noPersist = true
+	return nil
+}
+
 //NewEntryOptions creates a new entry using the provided option modifiers.
 func NewEntryOptions(options ...func(*EntryOptions) error) (*EntryOptions, error) {
 	c := &EntryOptions{}
@@ -66,9 +104,9 @@ func (e *EntryOptions) entryOptionsCreateStmt() []byte {
 		cbuf = append(cbuf, '~')
 		cbuf = append(cbuf, strconv.Itoa(boolToInt(e.doesInv))...)
 		cbuf = append(cbuf, '~')
-		cbuf = append(cbuf, strconv.FormatInt(e.expTime.Unix(), 10)...)
+		cbuf = append(cbuf, formatStmtTime(e.expTime)...)
 		cbuf = append(cbuf, '~')
-		cbuf = append(cbuf, strconv.FormatInt(e.invTime.Unix(), 10)...)
+		cbuf = append(cbuf, formatStmtTime(e.invTime)...)
 		cbuf = append(cbuf, '~')
 		cbuf = append(cbuf, strconv.FormatFloat(e.tol, 'f', -1, 64)...)
 	} else {
@@ -96,18 +134,14 @@ func NewEntryOptionsFromStmt(stmt []string) (*EntryOptions, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry_options: failed to parse entry options")
 	}
-	ets := strings.TrimSpace(stmt[2])
-	expInt, err := strconv.ParseInt(ets, 10, 64)
+	expTime, err := parseStmtTime(stmt[2])
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry_options: failed to parse entry options")
 	}
-	expTime := time.Unix(expInt, 0)
-	its := strings.TrimSpace(stmt[3])
-	invInt, err := strconv.ParseInt(its, 10, 64)
+	invTime, err := parseStmtTime(stmt[3])
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry_options: failed to parse entry options")
 	}
-	invTime := time.Unix(invInt, 0)
 	tol, err := strconv.ParseFloat(strings.TrimSpace(stmt[4]), 64)
 	if err != nil {
 		return nil, errors.Annotate(err, "error: entry_options: failed to parse entry options")
@@ -37,6 +37,22 @@ func TestInvalidTime(t *testing.T) {
 	}
 }
 
+func TestExpireAfter(t *testing.T) {
+	entryOptions, err := NewEntryOptions(ExpireAfter(time.Minute), InvalidAfter(time.Hour))
+	if err != nil {
+		t.Errorf("Failure: NewEntryOptions(ExpireAfter(time.Minute), InvalidAfter(time.Hour)) returned error \"%v\"", err)
+	}
+	if !entryOptions.doesExp || entryOptions.expTTL != time.Minute || entryOptions.expTime.Before(time.Now()) {
+		t.Errorf("Failure: NewEntryOptions(ExpireAfter(time.Minute)) expected expiry in 1m got %v", entryOptions.expTime)
+	}
+	if !entryOptions.doesInv || entryOptions.invTTL != time.Hour || entryOptions.invTime.Before(time.Now()) {
+		t.Errorf("Failure: NewEntryOptions(InvalidAfter(time.Hour)) expected invalidation in 1h got %v", entryOptions.invTime)
+	}
+	if _, err := NewEntryOptions(ExpireAfter(0)); err == nil {
+		t.Error("Failure: NewEntryOptions(ExpireAfter(0)) expected error got nil")
+	}
+}
+
 func TestTol(t *testing.T) {
 	entryOptions, err := NewEntryOptions(Tol(9.99))
 	if err != nil {
@@ -77,8 +93,8 @@ func TestEntryOptions_entryOptionsCreateStmt(t *testing.T) {
 		t.Errorf("Failure: NewEntryOptions(ExpireTime(), InvalidTime(now), Tol(9.99)) returned error \"%v\"", err)
 	}
 	opts := entryOptions.entryOptionsCreateStmt()
-	if len(opts) != 30 {
-		t.Errorf("Failure: entryOptionsCreateStmt() statement expected length 30 got length %v", len(opts))
+	if len(opts) != 50 {
+		t.Errorf("Failure: entryOptionsCreateStmt() statement expected length 50 got length %v", len(opts))
 	}
 }
 
@@ -89,8 +105,8 @@ func TestNewEntryOptionsFromStmt(t *testing.T) {
 		t.Errorf("Failure: NewEntryOptions(ExpireTime(), InvalidTime(now), Tol(9.99)) returned error \"%v\"", err)
 	}
 	opts := entryOptions.entryOptionsCreateStmt()
-	if len(opts) != 30 {
-		t.Errorf("Failure: entryOptionsCreateStmt() statement expected length 30 got length %v", len(opts))
+	if len(opts) != 50 {
+		t.Errorf("Failure: entryOptionsCreateStmt() statement expected length 50 got length %v", len(opts))
 	}
 	parts := strings.Split(string(opts), "~")
 	newEntryOptions, err := NewEntryOptionsFromStmt(parts)
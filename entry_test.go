@@ -4,6 +4,8 @@
 package stitchdb
 
 import (
+	"bytes"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -52,6 +54,15 @@ func TestNewEntry(t *testing.T) {
 	if entry2.location[2] != 5.0 {
 		t.Errorf("Failure: Expected entry2.location[2] == 5.0 got entry2.location[2] == %v", entry2.location[2])
 	}
+	if _, err := NewEntry("", "{}", false, options); err == nil {
+		t.Error("Failure: NewEntry(\"\", ...) expected error for empty key got nil")
+	}
+	if _, err := NewEntryWithGeo("", "{}", options); err == nil {
+		t.Error("Failure: NewEntryWithGeo(\"\", ...) expected error for empty key got nil")
+	}
+	if _, err := NewBinaryEntry("", nil, options); err == nil {
+		t.Error("Failure: NewBinaryEntry(\"\", ...) expected error for empty key got nil")
+	}
 }
 
 func TestNewEntryWithGeo(t *testing.T) {
@@ -114,6 +125,53 @@ func TestEntry_Less(t *testing.T) {
 	}
 }
 
+func TestEntry_Clone(t *testing.T) {
+	options, err := NewEntryOptions(ExpireTime(time.Now()), InvalidTime(time.Now()), Tol(9.9))
+	if err != nil {
+		t.Errorf("Failure: NewEntryOptions(ExpireTime(time.Now()), InvalidTime(time.Now()), Tol(9.9)) returned error \"%v\"", err)
+	}
+	entry1, err := NewEntryWithGeo("Test01", "{\"coords\": [1.0, 3.0, 4.0]}", options)
+	if err != nil {
+		t.Errorf("Failure: NewEntryWithGeo(\"Test01\", \"{\"coords\": [1.0, 3.0, 4.0]}\", options) returned error \"%v\"", err)
+	}
+	clone := entry1.Clone()
+	if clone.k != entry1.k || clone.v != entry1.v {
+		t.Errorf("Failure: Expected clone to have key and value of entry1 got %v and %v", clone.k, clone.v)
+	}
+	if clone.opts == entry1.opts || *clone.opts != *entry1.opts {
+		t.Errorf("Failure: Expected clone.opts to be an equal copy of entry1.opts")
+	}
+	clone.location[0] = 9.0
+	clone.opts.tol = 1.0
+	if entry1.location[0] != 1.0 || entry1.opts.tol != 9.9 {
+		t.Errorf("Failure: Modifying clone modified entry1")
+	}
+}
+
+func TestNewBinaryEntry(t *testing.T) {
+	value := []byte{0x00, '~', '\n', 0xff, '{'}
+	entry1, err := NewBinaryEntry("Test01", value, nil)
+	if err != nil {
+		t.Errorf("Failure: NewBinaryEntry(\"Test01\", value, nil) returned error \"%v\"", err)
+	}
+	if !entry1.IsBinary() || !bytes.Equal(entry1.GetBytes(), value) {
+		t.Errorf("Failure: Expected binary entry with value %v got %v", value, entry1.GetBytes())
+	}
+	stmt := entry1.EntryInsertStmt()
+	body := string(stmt[bytes.IndexByte(stmt, '\n')+1:])
+	_, parts, err := parseEntryStmtTypeName(body)
+	if err != nil {
+		t.Errorf("Failure: parseEntryStmtTypeName(body) returned error \"%v\"", err)
+	}
+	entry2, err := NewEntryFromStmt(parts)
+	if err != nil {
+		t.Errorf("Failure: NewEntryFromStmt(parts) returned error \"%v\"", err)
+	}
+	if !entry2.IsBinary() || entry2.k != "Test01" || !bytes.Equal(entry2.GetBytes(), value) {
+		t.Errorf("Failure: Expected parsed binary entry with value %v got %v", value, entry2.GetBytes())
+	}
+}
+
 func TestEntry_IsExpired(t *testing.T) {
 	options1, err := NewEntryOptions(ExpireTime(time.Now().Add(-1*time.Second)), InvalidTime(time.Now().Add(-1*time.Second)), Tol(9.9))
 	if err != nil {
@@ -286,15 +344,15 @@ func TestEntry_EntryInsertStmt(t *testing.T) {
 		t.Errorf("Failure: NewEntry(\"Test03\", \"{\"coords\": [1.0, 3.0]}\", true, options) returned error \"%v\"", err)
 	}
 	stmt1i := entry1.EntryInsertStmt()
-	if len(stmt1i) != 48 {
+	if len(stmt1i) != 68 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt1i))
 	}
 	stmt2i := entry2.EntryInsertStmt()
-	if len(stmt2i) != 75 {
+	if len(stmt2i) != 95 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt2i))
 	}
 	stmt3i := entry3.EntryInsertStmt()
-	if len(stmt3i) != 70 {
+	if len(stmt3i) != 90 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt3i))
 	}
 }
@@ -317,19 +375,44 @@ func TestEntry_EntryDeleteStmt(t *testing.T) {
 		t.Errorf("Failure: NewEntry(\"Test03\", \"{\"coords\": [1.0, 3.0]}\", true, options) returned error \"%v\"", err)
 	}
 	stmt1d := entry1.EntryDeleteStmt()
-	if len(stmt1d) != 48 {
+	if len(stmt1d) != 68 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt1d))
 	}
 	stmt2d := entry2.EntryDeleteStmt()
-	if len(stmt2d) != 75 {
+	if len(stmt2d) != 95 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt2d))
 	}
 	stmt3d := entry3.EntryDeleteStmt()
-	if len(stmt3d) != 70 {
+	if len(stmt3d) != 90 {
 		t.Errorf("Failure: Expected statement length 10 got %v", len(stmt3d))
 	}
 }
 
+func TestEntry_EntryTombstoneStmt(t *testing.T) {
+	options, err := NewEntryOptions()
+	if err != nil {
+		t.Errorf("Failure: NewEntryOptions() returned error \"%v\"", err)
+	}
+	deleted := time.Now()
+	ts := &Entry{k: "Test01", v: "{ \"value\":\"1\"}", opts: options, deleted: deleted}
+	stmt := string(ts.EntryTombstoneStmt())
+	stmt = stmt[strings.Index(stmt, "\n")+1:]
+	stype, parts, err := parseEntryStmtTypeName(stmt)
+	if err != nil || stype != "TOMBSTONE" {
+		t.Errorf("Failure: parseEntryStmtTypeName(stmt) expected TOMBSTONE got %v, \"%v\"", stype, err)
+	}
+	e, err := newTombstoneFromStmt(parts)
+	if err != nil {
+		t.Errorf("Failure: newTombstoneFromStmt(parts) returned error \"%v\"", err)
+	}
+	if e == nil || e.k != ts.k || e.v != ts.v || !e.IsTombstone() || !e.DeletedAt().Equal(deleted.Round(0)) {
+		t.Errorf("Failure: newTombstoneFromStmt(parts) expected %v got %v", ts, e)
+	}
+	if _, err := newTombstoneFromStmt(parts[:5]); err == nil {
+		t.Errorf("Failure: newTombstoneFromStmt(parts[:5]) expected error got nil")
+	}
+}
+
 func TestNewEntryFromStmt(t *testing.T) {
 	options, err := NewEntryOptions(ExpireTime(time.Now()), InvalidTime(time.Now()), Tol(9.9))
 	if err != nil {
@@ -469,3 +552,76 @@ func TestEntry_GetValue(t *testing.T) {
 		t.Errorf("Failure: Invalid key for entry expected \"{\"mode\":3,\"bucket\":\"test\"}\" got %v", entry3.GetValue())
 	}
 }
+
+func TestEntry_Marshal(t *testing.T) {
+	options, _ := NewEntryOptions(ExpireTime(time.Unix(1500000000, 0)), Tol(0.5))
+	entries := []*Entry{}
+	e, _ := NewEntry("Test01", "{\"value\": 1, \"coords\": [1.0, 3.0]}", true, options)
+	entries = append(entries, e)
+	e, _ = NewBinaryEntry("Test02", []byte{0, '~', '\n', 255}, nil)
+	entries = append(entries, e)
+	for _, e := range entries {
+		data, err := e.Marshal()
+		if err != nil {
+			t.Errorf("Failure: e.Marshal() returned error \"%v\"", err)
+		}
+		u, err := UnmarshalEntry(data)
+		if err != nil {
+			t.Errorf("Failure: UnmarshalEntry(%q) returned error \"%v\"", data, err)
+			continue
+		}
+		if u.k != e.k || u.v != e.v || u.binary != e.binary || !verifyEntry(e, u) || len(u.location) != len(e.location) {
+			t.Errorf("Failure: UnmarshalEntry(e.Marshal()) expected %v got %v", e, u)
+		}
+	}
+	if _, err := (&Entry{k: "Test~03", v: "{}", opts: options}).Marshal(); err == nil {
+		t.Error("Failure: e.Marshal() expected error for key containing '~' got nil")
+	}
+	if _, err := (&Entry{k: "Test03", v: "{\"value\": \"~\"}", opts: options}).Marshal(); err == nil {
+		t.Error("Failure: e.Marshal() expected error for value containing '~' got nil")
+	}
+	data, _ := entries[0].Marshal()
+	for _, invalid := range [][]byte{nil, data[:len(data)-2], append([]byte("v"+strconv.Itoa(ENTRY_FORMAT_VERSION+1)), data[2:]...), []byte("v1\n4\nDROP\n"), []byte("v1\n9\nINSERT~a\n")} {
+		if _, err := UnmarshalEntry(invalid); err == nil {
+			t.Errorf("Failure: UnmarshalEntry(%q) expected error got nil", invalid)
+		}
+	}
+	//Version 1 records hold times as whole seconds.
+	v1 := []byte("v1\n45\nINSERT~Test04~{}~1~0~1500000000~0~0.01~0~0~0\n")
+	if u, err := UnmarshalEntry(v1); err != nil || !u.ExpiresAt().Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("Failure: UnmarshalEntry(%q) expected expiry at %v got %v and error \"%v\"", v1, time.Unix(1500000000, 0), u, err)
+	}
+}
+
+func TestEntry_insertStmtCompressed(t *testing.T) {
+	value := "{\"name\": \"" + strings.Repeat("stitch", 64) + "\"}"
+	entry, _ := NewEntry("key", value, false, nil)
+	binary, _ := NewBinaryEntry("bin", []byte(strings.Repeat("\x00\x01~\n", 64)), nil)
+	for _, e := range []*Entry{entry, binary} {
+		stmt := e.insertStmt(true)
+		if len(stmt) >= len(e.EntryInsertStmt()) {
+			t.Errorf("Failure: expected compressed statement to be shorter than %d got %d", len(e.EntryInsertStmt()), len(stmt))
+		}
+		if bytes.Contains(stmt, []byte("stitch")) {
+			t.Error("Failure: expected compressed statement not to contain the value")
+		}
+		parsed, err := NewEntryFromStmt(strings.Split(string(stmt), "~"))
+		if err != nil {
+			t.Errorf("Failure: NewEntryFromStmt(compressed) returned error \"%v\"", err)
+			continue
+		}
+		if parsed.v != e.v || parsed.binary != e.binary {
+			t.Errorf("Failure: expected compressed entry to load as written got %q binary == %v", parsed.v, parsed.binary)
+		}
+	}
+	//Uncompressed statements are still loaded.
+	parsed, err := NewEntryFromStmt(strings.Split(string(binary.EntryInsertStmt()), "~"))
+	if err != nil || parsed.v != binary.v || !parsed.binary {
+		t.Errorf("Failure: expected uncompressed binary entry to load as written got error \"%v\"", err)
+	}
+	stmt := strings.Split(string(entry.insertStmt(true)), "~")
+	stmt[2] = "bm90IGd6aXA="
+	if _, err := NewEntryFromStmt(stmt); err == nil {
+		t.Error("Failure: NewEntryFromStmt(...) expected error for value that is not compressed got nil")
+	}
+}
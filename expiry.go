@@ -0,0 +1,62 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+//EXPIRY_BUFFER_SIZE is the number of expired entries an expiry channel buffers before further entries are dropped.
+const EXPIRY_BUFFER_SIZE int = 1024
+
+//ExpiryChannel returns a channel receiving the entries of the bucket specified by the bucket name provided as they are
+//removed by the bucket manager after expiring, along with a function that unsubscribes and closes the channel. Entries
+//are sent without blocking the manager; an entry that expires while EXPIRY_BUFFER_SIZE entries are waiting to be
+//received is dropped for the channel. The channel is closed when the unsubscribe function is called, the bucket is
+//dropped, or the db is closed. If the db is closed or the bucket is invalid the returned channel is already closed.
+func (db *StitchDB) ExpiryChannel(bucket string) (<-chan *Entry, func()) {
+	ch := make(chan *Entry, EXPIRY_BUFFER_SIZE)
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	b, err := db.getBucket(bucket)
+	if !db.open || err != nil || b == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	b.expirylock.Lock()
+	defer b.expirylock.Unlock()
+	b.expiry = append(b.expiry, ch)
+	return ch, func() { b.unsubscribeExpiry(ch) }
+}
+
+//notifyExpired sends the expired entry to each expiry channel of the bucket that has room for it.
+func (b *Bucket) notifyExpired(e *Entry) {
+	b.expirylock.Lock()
+	defer b.expirylock.Unlock()
+	for _, ch := range b.expiry {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+//unsubscribeExpiry removes and closes the expiry channel if it is subscribed to the bucket.
+func (b *Bucket) unsubscribeExpiry(ch chan *Entry) {
+	b.expirylock.Lock()
+	defer b.expirylock.Unlock()
+	for i, c := range b.expiry {
+		if c == ch {
+			b.expiry = append(b.expiry[:i], b.expiry[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+//closeExpiry closes each expiry channel of the bucket.
+func (b *Bucket) closeExpiry() {
+	b.expirylock.Lock()
+	defer b.expirylock.Unlock()
+	for _, ch := range b.expiry {
+		close(ch)
+	}
+	b.expiry = nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_ExpiryChannel(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), DisableManager, Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("expiry", opts)
+	ch, unsubscribe := db.ExpiryChannel("expiry")
+	other, unsubscribeOther := db.ExpiryChannel("expiry")
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("expiry", func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, expired)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("key-live", "{}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	unsubscribeOther()
+	unsubscribeOther()
+	if _, ok := <-other; ok {
+		t.Error("Failure: expected unsubscribed expiry channel to be closed")
+	}
+	db.RunMaintenance()
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-ch:
+			if e.k != "key-"+strconv.Itoa(i) {
+				t.Errorf("Failure: expected expired entry key-%d got %v", i, e.k)
+			}
+		default:
+			t.Errorf("Failure: expected expired entry key-%d on expiry channel got none", i)
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("Failure: expected no further expired entries got %v", e.k)
+	default:
+	}
+	//Entries are dropped rather than blocking the manager once the buffer is full.
+	db.Update("expiry", func(tx *Tx) error {
+		for i := 0; i < EXPIRY_BUFFER_SIZE+10; i++ {
+			e, _ := NewEntry("full-"+strconv.Itoa(i), "{}", false, expired)
+			tx.Set(e)
+		}
+		return nil
+	})
+	db.RunMaintenance()
+	if len(ch) != EXPIRY_BUFFER_SIZE {
+		t.Errorf("Failure: expected expiry channel to hold %d entries got %d", EXPIRY_BUFFER_SIZE, len(ch))
+	}
+	db.DropBucket("expiry")
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != EXPIRY_BUFFER_SIZE {
+		t.Errorf("Failure: expected %d buffered entries before the channel closed got %d", EXPIRY_BUFFER_SIZE, n)
+	}
+	unsubscribe()
+	if closed, _ := db.ExpiryChannel("missing"); closed != nil {
+		if _, ok := <-closed; ok {
+			t.Error("Failure: expected expiry channel of an invalid bucket to be closed")
+		}
+	}
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestStitchDB_ExpiryStats(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), DisableManager, Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Nanosecond))
+	db.CreateBucket("expirystats", opts)
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("expirystats", func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, expired)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("key-live", "{}", false, nil)
+		tx.Set(e)
+		e, _ = NewEntry("key-deleted", "{}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	db.Update("expirystats", func(tx *Tx) error {
+		_, err := tx.Delete(&Entry{k: "key-deleted"})
+		return err
+	})
+	time.Sleep(time.Millisecond)
+	db.RunMaintenance()
+	db.RunMaintenance()
+	st := db.Stats()
+	counts := st.Counts["expirystats"]
+	if counts[METRIC_EXPIRED] != 3 || counts[METRIC_EXPIRED_BYTES] != 21 {
+		t.Errorf("Failure: expected 3 expired entries of 21 bytes got %d of %d bytes", counts[METRIC_EXPIRED], counts[METRIC_EXPIRED_BYTES])
+	}
+	if counts[METRIC_TOMBSTONES_REAPED] != 1 {
+		t.Errorf("Failure: expected 1 reaped tombstone got %d", counts[METRIC_TOMBSTONES_REAPED])
+	}
+	if sweeps := st.Durations["expirystats"][METRIC_SWEEP]; sweeps.Count != 2 {
+		t.Errorf("Failure: expected 2 recorded sweeps got %d", sweeps.Count)
+	}
+	db.DropBucket("expirystats")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/tidwall/gjson"
+)
+
+//ExportFormat represents the format entries are written in by Export.
+type ExportFormat int
+
+const (
+	//EXPORT_NDJSON writes the value of each entry on its own line.
+	EXPORT_NDJSON ExportFormat = iota
+	//EXPORT_CSV writes a header row of the key followed by the top level fields of the entry values and a row per entry.
+	EXPORT_CSV
+)
+
+//Export writes the live entries of the bucket specified by the bucket name provided to w in key order in the format
+//provided. Entries are written as the bucket is iterated in a read only transaction so the bucket is not copied;
+//expired, invalid, and binary entries are skipped. EXPORT_NDJSON writes each value compacted to a single line; entries
+//with an empty value have no line.
+//EXPORT_CSV scans the bucket once to collect the names of the top level fields of the values in the order they are
+//first seen and then writes a column for the key followed by a column per field; string fields are written as their
+//value and other fields as JSON, and fields missing from a value are empty. Values that are not JSON objects have no
+//fields. Returns an error if the db is closed, the bucket is invalid, the format is unrecognized, or an entry could not
+//be written to w; entries before the failing entry remain written.
+func (db *StitchDB) Export(bucket string, w io.Writer, format ExportFormat) error {
+	if format != EXPORT_NDJSON && format != EXPORT_CSV {
+		return errors.New("error: db: invalid export format")
+	}
+	return db.View(bucket, func(t *Tx) error {
+		var werr error
+		live := func(f func(e *Entry) bool) {
+			t.Ascend("", func(e *Entry) bool {
+				if e.IsExpired() || e.IsInvalid() || e.binary {
+					return true
+				}
+				return f(e)
+			})
+		}
+		if format == EXPORT_NDJSON {
+			var buf bytes.Buffer
+			live(func(e *Entry) bool {
+				if e.v == "" {
+					return true
+				}
+				buf.Reset()
+				if err := json.Compact(&buf, []byte(e.v)); err != nil {
+					buf.Reset()
+					buf.WriteString(e.v)
+				}
+				buf.WriteByte('\n')
+				_, werr = w.Write(buf.Bytes())
+				return werr == nil
+			})
+			if werr != nil {
+				return errors.Annotate(werr, "error: db: failed to write export")
+			}
+			return nil
+		}
+		columns := map[string]int{}
+		header := []string{"key"}
+		live(func(e *Entry) bool {
+			v := gjson.Parse(e.v)
+			if !v.IsObject() {
+				return true
+			}
+			v.ForEach(func(field, _ gjson.Result) bool {
+				if _, ok := columns[field.String()]; !ok {
+					columns[field.String()] = len(header)
+					header = append(header, field.String())
+				}
+				return true
+			})
+			return true
+		})
+		//The csv writer buffers a bounded number of rows before writing them to w.
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return errors.Annotate(err, "error: db: failed to write export")
+		}
+		row := make([]string, len(header))
+		live(func(e *Entry) bool {
+			for i := range row {
+				row[i] = ""
+			}
+			row[0] = e.k
+			if v := gjson.Parse(e.v); v.IsObject() {
+				v.ForEach(func(field, value gjson.Result) bool {
+					if value.Type == gjson.String {
+						row[columns[field.String()]] = value.String()
+					} else {
+						row[columns[field.String()]] = value.Raw
+					}
+					return true
+				})
+			}
+			werr = cw.Write(row)
+			return werr == nil
+		})
+		if werr == nil {
+			cw.Flush()
+			werr = cw.Error()
+		}
+		if werr != nil {
+			return errors.Annotate(werr, "error: db: failed to write export")
+		}
+		return nil
+	})
+}
+
+//ImportOptions holds the options of Import.
+type ImportOptions struct {
+	skipInvalid bool //Indicates if invalid lines are skipped rather than failing the import.
+}
+
+//SkipInvalidLines skips lines that are not valid JSON objects or have no usable key rather than failing the import.
+func SkipInvalidLines(o *ImportOptions) error {
+	o.skipInvalid = true
+	return nil
+}
+
+//Import reads NDJSON from r and sets an entry for each line in a single read/write transaction on the bucket specified
+//by the bucket name provided, returning the number of entries set. The value of the entry is the line compacted and the
+//key is the value of the field at keyField of the line, which uses tidwall/gjson access format and must be a string or
+//a number. Empty lines are ignored. Sets are applied as Set applies them so a line with the key of an earlier line
+//overwrites it. A line that is not a JSON object or has no usable key fails the import unless SkipInvalidLines is
+//provided in which case it is skipped. Returns an error, and sets no entries, if the db is closed, the bucket is
+//invalid, r could not be read, a line is invalid, or an entry could not be set.
+func (db *StitchDB) Import(bucket string, r io.Reader, keyField string, options ...func(*ImportOptions) error) (int, error) {
+	opts := &ImportOptions{}
+	for _, option := range options {
+		if err := option(opts); err != nil {
+			return 0, errors.Annotate(err, "error: db: failed to apply import option")
+		}
+	}
+	count := 0
+	err := db.Update(bucket, func(t *Tx) error {
+		rd := bufio.NewReader(r)
+		var buf bytes.Buffer
+		for line := 1; ; line++ {
+			data, err := rd.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return errors.Annotate(err, "error: db: failed to read import")
+			}
+			if len(bytes.TrimSpace(data)) > 0 {
+				e, lerr := importEntry(t, data, keyField, &buf)
+				if lerr != nil && !opts.skipInvalid {
+					return errors.Annotate(lerr, "error: db: invalid import line "+strconv.Itoa(line))
+				}
+				if lerr == nil {
+					if _, err := t.Set(e); err != nil {
+						return errors.Annotate(err, "error: db: failed to import line "+strconv.Itoa(line))
+					}
+					count++
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//importEntry returns the entry for a line of an import. Returns an error if the line is not a JSON object or the key
+//field is missing, is not a string or number, or is not a valid key for the bucket, or if the line contains '~'.
+func importEntry(t *Tx, data []byte, keyField string, buf *bytes.Buffer) (*Entry, error) {
+	buf.Reset()
+	if err := json.Compact(buf, bytes.TrimSpace(data)); err != nil {
+		return nil, errors.Annotate(err, "error: db: line is not valid json")
+	}
+	v := buf.String()
+	if !gjson.Parse(v).IsObject() {
+		return nil, errors.New("error: db: line is not a json object")
+	}
+	k := gjson.Get(v, keyField)
+	if k.Type != gjson.String && k.Type != gjson.Number {
+		return nil, errors.New("error: db: line has no usable key field " + keyField)
+	}
+	if err := t.checkKey(k.String()); err != nil {
+		return nil, err
+	}
+	//The bucket file separates the fields of a statement with '~'.
+	if strings.ContainsAny(k.String(), "~\n") || strings.Contains(v, "~") {
+		return nil, errors.New("error: db: line cannot be represented in the bucket file")
+	}
+	return NewEntry(k.String(), v, t.bkt.options.geo, nil)
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_Export(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("export", opts)
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("export", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{\"name\": \"a,b\", \"age\": 1}", false, nil)
+		e2, _ := NewEntry("key-2", "{\"age\": 2, \"tags\": [\"x\"]}", false, nil)
+		e3, _ := NewEntry("key-3", "{\"name\": \"gone\"}", false, expired)
+		e4, _ := NewBinaryEntry("key-4", []byte{0, 1}, nil)
+		e5, _ := NewEntry("key-5", "[1, 2]", false, nil)
+		for _, e := range []*Entry{e1, e2, e3, e4, e5} {
+			tx.Set(e)
+		}
+		return nil
+	})
+	var nd bytes.Buffer
+	if err := db.Export("export", &nd, EXPORT_NDJSON); err != nil {
+		t.Errorf("Failure: db.Export(\"export\", ..., EXPORT_NDJSON) returned error \"%v\"", err)
+	}
+	if expected := "{\"name\":\"a,b\",\"age\":1}\n{\"age\":2,\"tags\":[\"x\"]}\n[1,2]\n"; nd.String() != expected {
+		t.Errorf("Failure: db.Export(\"export\", ..., EXPORT_NDJSON) expected %q got %q", expected, nd.String())
+	}
+	var cv bytes.Buffer
+	if err := db.Export("export", &cv, EXPORT_CSV); err != nil {
+		t.Errorf("Failure: db.Export(\"export\", ..., EXPORT_CSV) returned error \"%v\"", err)
+	}
+	if expected := "key,name,age,tags\nkey-1,\"a,b\",1,\nkey-2,,2,\"[\"\"x\"\"]\"\nkey-5,,,\n"; cv.String() != expected {
+		t.Errorf("Failure: db.Export(\"export\", ..., EXPORT_CSV) expected %q got %q", expected, cv.String())
+	}
+	if err := db.Export("export", &cv, ExportFormat(9)); err == nil {
+		t.Error("Failure: db.Export(\"export\", ..., ExportFormat(9)) expected error got nil")
+	}
+	if err := db.Export("missing", &cv, EXPORT_NDJSON); err == nil {
+		t.Error("Failure: db.Export(\"missing\", ...) expected error got nil")
+	}
+	if err := db.Export("export", failingWriter{}, EXPORT_NDJSON); err == nil {
+		t.Error("Failure: db.Export(\"export\", failingWriter{}, ...) expected error got nil")
+	}
+	db.DropBucket("export")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+//failingWriter is a writer that fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestStitchDB_Import(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("import", opts)
+	valid := "{\"id\": \"a\", \"v\": 1}\n\n{\"id\": 2, \"v\": 2}\n{\"id\": \"a\", \"v\": 3}"
+	n, err := db.Import("import", strings.NewReader(valid), "id")
+	if err != nil || n != 3 {
+		t.Errorf("Failure: db.Import(...) expected 3 lines imported got %d and error \"%v\"", n, err)
+	}
+	db.View("import", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 2 {
+			t.Errorf("Failure: expected 2 entries after import got %d", size)
+		}
+		if e, _ := tx.Get(&Entry{k: "a"}); e == nil || e.GetValue() != "{\"id\":\"a\",\"v\":3}" {
+			t.Errorf("Failure: expected later line to overwrite key a got %v", e)
+		}
+		if e, _ := tx.Get(&Entry{k: "2"}); e == nil {
+			t.Error("Failure: expected numeric key field to be imported got nil")
+		}
+		return nil
+	})
+	invalid := "{\"id\": \"b\"}\n{\"id\": \nnot json\n[1]\n{\"v\": 1}\n{\"id\": {}}\n{\"id\": \"c~\"}\n{\"id\": \"c\"}\n"
+	if n, err := db.Import("import", strings.NewReader(invalid), "id"); err == nil || n != 0 {
+		t.Errorf("Failure: db.Import(...) expected error for invalid line got %d and error \"%v\"", n, err)
+	}
+	db.View("import", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "b"}); e != nil {
+			t.Errorf("Failure: expected failed import to be rolled back got %v", e)
+		}
+		return nil
+	})
+	if n, err := db.Import("import", strings.NewReader(invalid), "id", SkipInvalidLines); err != nil || n != 2 {
+		t.Errorf("Failure: db.Import(..., SkipInvalidLines) expected 2 lines imported got %d and error \"%v\"", n, err)
+	}
+	if _, err := db.Import("missing", strings.NewReader(valid), "id"); err == nil {
+		t.Error("Failure: db.Import(\"missing\", ...) expected error got nil")
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("import", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 4 {
+			t.Errorf("Failure: expected 4 entries after reopen got %d", size)
+		}
+		return nil
+	})
+	db.DropBucket("import")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"math"
+	"sort"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/juju/errors"
+)
+
+//EARTH_RADIUS is the mean radius of the earth in meters used to measure great-circle distances in GeoLatLon buckets.
+const EARTH_RADIUS float64 = 6371008.8
+
+//geoPad widens the rectangles searched in GeoLatLon buckets so that locations on their edges intersect them; the rtree
+//does not count rectangles that only touch as intersecting.
+const geoPad float64 = 1e-9
+
+//checkLatLon returns an error if the location is not a latitude in [-90, 90] followed by a longitude in [-180, 180].
+func checkLatLon(l rtreego.Point) error {
+	if len(l) != 2 {
+		return errors.New("error: tx: location must have a latitude and longitude")
+	}
+	if math.IsNaN(l[0]) || l[0] < -90 || l[0] > 90 {
+		return errors.New("error: tx: latitude must be within [-90, 90]")
+	}
+	if math.IsNaN(l[1]) || l[1] < -180 || l[1] > 180 {
+		return errors.New("error: tx: longitude must be within [-180, 180]")
+	}
+	return nil
+}
+
+//checkLocation returns an error if the bucket is a GeoLatLon bucket and the entry has a location that is not a valid
+//latitude and longitude. Entries without a location are not tracked by the rtree and are accepted.
+func (b *Bucket) checkLocation(e *Entry) error {
+	if !b.options.latlon || e.binary {
+		return nil
+	}
+	l := e.location
+	if l == nil {
+		l = parseLocation(e.v)
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	if err := checkLatLon(l); err != nil {
+		return errors.Annotate(err, "error: tx: entry "+e.k+" has an invalid location")
+	}
+	return nil
+}
+
+//haversine returns the great-circle distance in meters between two locations given as latitude and longitude in
+//degrees. The difference of the longitudes only enters the distance through its sine so locations either side of the
+//180th meridian are measured the short way around.
+func haversine(a, b rtreego.Point) float64 {
+	rad := math.Pi / 180
+	dlat, dlon := (b[0]-a[0])*rad, (b[1]-a[1])*rad
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(a[0]*rad)*math.Cos(b[0]*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	h = math.Min(1, math.Max(0, h))
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(h))
+}
+
+//latLonRects returns the rectangles of latitude and longitude in degrees that together bound the locations within the
+//radius in meters of the point. A circle that reaches over a pole is bounded by every longitude and a circle that crosses
+//the 180th meridian is bounded by a rectangle either side of it.
+func latLonRects(p rtreego.Point, radius float64) []*rtreego.Rect {
+	rad := math.Pi / 180
+	r := radius / EARTH_RADIUS
+	latMin, latMax := p[0]-r/rad, p[0]+r/rad
+	lonMin, lonMax := -180.0, 180.0
+	if latMin > -90 && latMax < 90 {
+		//The meridians tangent to the circle bound its longitudes.
+		dlon := math.Asin(math.Sin(r)/math.Cos(p[0]*rad)) / rad
+		lonMin, lonMax = p[1]-dlon, p[1]+dlon
+	}
+	latMin, latMax = math.Max(latMin, -90), math.Min(latMax, 90)
+	rect := func(lonMin, lonMax float64) *rtreego.Rect {
+		rr, _ := rtreego.NewRectFromPoints(rtreego.Point{latMin - geoPad, lonMin - geoPad}, rtreego.Point{latMax + geoPad, lonMax + geoPad})
+		return rr
+	}
+	switch {
+	case lonMax-lonMin >= 360:
+		return []*rtreego.Rect{rect(-180, 180)}
+	case lonMin < -180:
+		return []*rtreego.Rect{rect(lonMin+360, 180), rect(-180, lonMax)}
+	case lonMax > 180:
+		return []*rtreego.Rect{rect(lonMin, 180), rect(-180, lonMax-360)}
+	}
+	return []*rtreego.Rect{rect(lonMin, lonMax)}
+}
+
+//searchLatLon returns the entries of a GeoLatLon bucket within the radius in meters of the point ordered by distance and
+//then key. Entries at exactly the radius are included if inclusive is true.
+func (t *Tx) searchLatLon(pt Point, radius float64, inclusive bool) ([]*Entry, error) {
+	p := rtreegoPoint(pt)
+	if err := checkLatLon(p); err != nil {
+		return nil, errors.Annotate(err, "error: tx: invalid search point")
+	}
+	if radius < 0 || math.IsNaN(radius) {
+		return nil, errors.New("error: tx: radius must not be negative")
+	}
+	dists := make(map[*Entry]float64)
+	for _, rect := range latLonRects(p, radius) {
+		for _, s := range t.bkt.rtree.SearchIntersect(rect) {
+			e := s.(*Entry)
+			if _, ok := dists[e]; ok {
+				continue
+			}
+			if d := haversine(p, e.location); d < radius || (inclusive && d == radius) {
+				dists[e] = d
+			}
+		}
+	}
+	res := make([]*Entry, 0, len(dists))
+	for e := range dists {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if dists[res[i]] != dists[res[j]] {
+			return dists[res[i]] < dists[res[j]]
+		}
+		return res[i].k < res[j].k
+	})
+	return res, nil
+}
+
+//nearestLatLon returns the k entries of a GeoLatLon bucket nearest to the point by great-circle distance ordered by
+//distance and then key. The rtree measures distance in degrees which does not account for the 180th meridian or the
+//narrowing of longitudes toward the poles, so the k entries it finds nearest only bound the distance of the k nearest
+//entries; every entry within the farthest of them is measured to find the nearest.
+func (t *Tx) nearestLatLon(k int, pt Point) ([]*Entry, error) {
+	p := rtreegoPoint(pt)
+	if err := checkLatLon(p); err != nil {
+		return nil, errors.Annotate(err, "error: tx: invalid search point")
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+	var bound float64
+	for _, s := range t.bkt.rtree.NearestNeighbors(k, p) {
+		if s != nil {
+			bound = math.Max(bound, haversine(p, s.(*Entry).location))
+		}
+	}
+	res, err := t.searchLatLon(pt, bound, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > k {
+		res = res[:k]
+	}
+	return res, nil
+}
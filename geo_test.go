@@ -0,0 +1,150 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+func TestHaversine(t *testing.T) {
+	tests := []struct {
+		a, b rtreego.Point
+		want float64
+	}{
+		{rtreego.Point{0, 0}, rtreego.Point{0, 0}, 0},
+		{rtreego.Point{0, 179.5}, rtreego.Point{0, -179.5}, 111195},
+		{rtreego.Point{89.9, 0}, rtreego.Point{89.9, 180}, 22239},
+		{rtreego.Point{90, 0}, rtreego.Point{-90, 0}, math.Pi * EARTH_RADIUS},
+		{rtreego.Point{-45.5, -90}, rtreego.Point{-45.5, 90}, math.Pi / 180 * 89 * EARTH_RADIUS},
+	}
+	for _, test := range tests {
+		if got := haversine(test.a, test.b); math.Abs(got-test.want) > 1 {
+			t.Errorf("Failure: haversine(%v, %v) expected %v got %v", test.a, test.b, test.want, got)
+		}
+	}
+}
+
+func TestLatLonRects(t *testing.T) {
+	//A circle crossing the 180th meridian is bounded either side of it.
+	if rects := latLonRects(rtreego.Point{0, 179.95}, 10000); len(rects) != 2 {
+		t.Errorf("Failure: latLonRects() across the 180th meridian expected 2 rectangles got %d", len(rects))
+	}
+	//A circle over a pole is bounded by every longitude.
+	rects := latLonRects(rtreego.Point{89.95, 10}, 10000)
+	if len(rects) != 1 || rects[0].PointCoord(1) > -180 || rects[0].LengthsCoord(1) < 360 || rects[0].PointCoord(0)+rects[0].LengthsCoord(0) < 90 {
+		t.Errorf("Failure: latLonRects() over a pole expected a rectangle of every longitude got %v", rects)
+	}
+}
+
+func TestTx_GeoLatLon(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), GeoLatLon)
+	db.CreateBucket("geolatlon", opts)
+	entry := func(k, coords string) *Entry {
+		e, _ := NewEntry(k, "{\"coords\":"+coords+"}", true, nil)
+		return e
+	}
+	err = db.Update("geolatlon", func(tx *Tx) error {
+		for _, coords := range []string{"[90.5, 0]", "[-91, 0]", "[0, 180.25]", "[0, -181]", "[45]", "[1, 2, 3]"} {
+			if _, err := tx.Set(entry("invalid", coords)); err == nil {
+				t.Errorf("Failure: tx.Set() with coords %s expected error got nil", coords)
+			}
+		}
+		points := map[string]string{
+			"east":     "[0, 179.99]",
+			"west":     "[0, -179.8]",
+			"far":      "[0, 170]",
+			"dateline": "[10, -180]",
+			"sydney":   "[-33.8688, 151.2093]",
+			"north":    "[90, 0]",
+			"polea":    "[89.9, 0]",
+			"poleb":    "[89.9, 180]",
+		}
+		for k, coords := range points {
+			if _, err := tx.Set(entry(k, coords)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failure: tx.Set() with valid coords returned error \"%v\"", err)
+	}
+	keys := func(entries []*Entry) []string {
+		var res []string
+		for _, e := range entries {
+			res = append(res, e.k)
+		}
+		return res
+	}
+	expect := func(what string, got []string, want ...string) {
+		if len(got) != len(want) {
+			t.Errorf("Failure: %s expected %v got %v", what, want, got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Failure: %s expected %v got %v", what, want, got)
+				return
+			}
+		}
+	}
+	db.View("geolatlon", func(tx *Tx) error {
+		entries, _ := tx.SearchWithinRadius(Point{0, -179.95}, 10000)
+		expect("search across the 180th meridian", keys(entries), "east")
+		entries, _ = tx.SearchWithinRadius(Point{10, 179.95}, 10000)
+		expect("search of a longitude of -180 from the east", keys(entries), "dateline")
+		entries, _ = tx.SearchWithinRadius(Point{89.9, 0}, 25000)
+		expect("search over the pole", keys(entries), "polea", "north", "poleb")
+		entries, _ = tx.SearchWithinRadius(Point{-33.87, 151.21}, 1000)
+		expect("search of negative fractional coords", keys(entries), "sydney")
+		//The rtree finds west nearest in degrees; east is nearer around the globe.
+		e, _ := tx.NearestNeighbor(Point{0, -179.95})
+		if e == nil || e.k != "east" {
+			t.Errorf("Failure: tx.NearestNeighbor() across the 180th meridian expected east got %v", e)
+		}
+		entries, _ = tx.NearestNeighbors(3, Point{0, -179.95})
+		expect("nearest neighbors across the 180th meridian", keys(entries), "east", "west", "dateline")
+		entries, _ = tx.NearestNeighbors(2, Point{89.95, -90})
+		expect("nearest neighbors near the pole", keys(entries), "north", "polea")
+		if _, err := tx.SearchWithinRadius(Point{95, 0}, 1000); err == nil {
+			t.Error("Failure: tx.SearchWithinRadius() with invalid point expected error got nil")
+		}
+		if _, err := tx.SearchWithinRadius(Point{0, 0}, -1); err == nil {
+			t.Error("Failure: tx.SearchWithinRadius() with negative radius expected error got nil")
+		}
+		if _, err := tx.NearestNeighbors(1, Point{0, 200}); err == nil {
+			t.Error("Failure: tx.NearestNeighbors() with invalid point expected error got nil")
+		}
+		return nil
+	})
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	db.Update("geolatlon", func(tx *Tx) error {
+		if _, err := tx.Set(entry("invalid", "[100, 0]")); err == nil {
+			t.Error("Failure: tx.Set() with invalid coords after reopen expected error got nil")
+		}
+		return nil
+	})
+	db.View("geolatlon", func(tx *Tx) error {
+		entries, _ := tx.SearchWithinRadius(Point{0, -179.95}, 10000)
+		expect("search across the 180th meridian after reopen", keys(entries), "east")
+		return nil
+	})
+	db.DropBucket("geolatlon")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -4,6 +4,10 @@
 package stitchdb
 
 import (
+	"path"
+	"strconv"
+	"strings"
+
 	"github.com/cbergoon/btree"
 	"github.com/tidwall/gjson"
 )
@@ -20,6 +24,8 @@ const (
 	INT_INDEX
 	//FLOAT_INDEX indicates data sorting should assume float.
 	FLOAT_INDEX
+	//CASE_INSENSITIVE_STRING_INDEX indicates data sorting should assume string compared without regard to case.
+	CASE_INSENSITIVE_STRING_INDEX
 )
 
 //Index represents an index for a bucket. Buckets can have multiple indexes but indexes cannot have entries from multiple
@@ -29,6 +35,72 @@ type Index struct {
 	ppath string         //Path to field that the index will be ordered using. Uses tidwall/gjson access format.
 	vtype IndexValueType //Defines the type of the field in question and determines how the value will be compared.
 	bkt   *Bucket        //Reference back to the bucket the the index is built using.
+	multi bool           //Indicates that the path contains wildcards; the tree holds an item for each matching field.
+}
+
+//IndexStats describes the selectivity of an index.
+type IndexStats struct {
+	Distinct int //Number of distinct values of the index field among live entries.
+	Entries  int //Number of live entries in the index.
+}
+
+//indexPivot is a search bound for an index tree. Only the index field value of the entry is considered; the pivot orders
+//before every entry with an equal field value, or after every such entry if high is set. A keyed pivot also considers
+//the key of the entry and orders among the entries with an equal field value as an entry with the key would.
+type indexPivot struct {
+	e     *Entry
+	high  bool
+	keyed bool
+}
+
+//Less compares the pivot to an item of the index tree provided. Implements btree.Item.
+func (p *indexPivot) Less(than btree.Item, itype interface{}) bool {
+	i := itype.(*Index)
+	c := i.compareValues(gjson.Get(p.e.v, i.ppath), i.itemValue(than))
+	if p.keyed {
+		return c < 0 || (c == 0 && p.e.k < itemEntry(than).k)
+	}
+	return c < 0 || (c == 0 && !p.high)
+}
+
+//indexItem is an item of the tree of an index with a wildcard path holding an entry and the value of one of the fields
+//of the entry matching the path. An entry is held once for each matching field; items are ordered by field value, then
+//by key, then by the path of the field.
+type indexItem struct {
+	e     *Entry
+	field string       //Path of the field of the entry matching the index path.
+	v     gjson.Result //Value of the field.
+}
+
+//Less compares the item to an item of the index tree or a pivot provided. Implements btree.Item.
+func (it *indexItem) Less(than btree.Item, itype interface{}) bool {
+	i := itype.(*Index)
+	if p, ok := than.(*indexPivot); ok {
+		c := i.compareValues(it.v, gjson.Get(p.e.v, i.ppath))
+		if p.keyed {
+			return c < 0 || (c == 0 && it.e.k < p.e.k)
+		}
+		return c < 0 || (c == 0 && p.high)
+	}
+	tl := than.(*indexItem)
+	if c := i.compareValues(it.v, tl.v); c != 0 {
+		return c < 0
+	}
+	if it.e.k != tl.e.k {
+		return it.e.k < tl.e.k
+	}
+	return it.field < tl.field
+}
+
+//itemEntry returns the entry of an item of an index tree. Returns nil if the item is nil.
+func itemEntry(item btree.Item) *Entry {
+	switch it := item.(type) {
+	case *Entry:
+		return it
+	case *indexItem:
+		return it.e
+	}
+	return nil
 }
 
 //NewIndex returns an index for the values provided. The index will be initialized but NOT built.
@@ -37,82 +109,220 @@ func NewIndex(ppath string, vtype IndexValueType, bkt *Bucket) (*Index, error) {
 		ppath: ppath,
 		bkt:   bkt,
 		vtype: vtype,
+		multi: strings.ContainsAny(ppath, "*?"),
 	}
 	index.t = btree.New(bkt.options.btdeg, index)
 	return index, nil
 }
 
 //less is a comparator for the index tree that utilizes the IndexValueType to determine how to compare the entries. The
-//comparator also retrieves the field value from the entry value json string.
+//comparator also retrieves the field value from the entry value json string. Entries with equal field values are
+//ordered by key so that every entry occupies its own position in the tree.
 func (i *Index) less(x, y *Entry) bool {
+	if c := i.compare(x, y); c != 0 {
+		return c < 0
+	}
+	return x.k < y.k
+}
+
+//compare compares the index field values of the entries using the IndexValueType. Returns -1 if x is less than y, 1 if x
+//is greater than y, and 0 if the values are equal.
+func (i *Index) compare(x, y *Entry) int {
+	return i.compareValues(gjson.Get(x.v, i.ppath), gjson.Get(y.v, i.ppath))
+}
+
+//compareValues compares index field values that have been parsed from entries as compare does.
+func (i *Index) compareValues(xv, yv gjson.Result) int {
+	var less, greater bool
 	switch i.vtype {
 	case INT_INDEX:
-		return gjson.Get(x.v, i.ppath).Int() < gjson.Get(y.v, i.ppath).Int()
+		less, greater = xv.Int() < yv.Int(), xv.Int() > yv.Int()
 	case UINT_INDEX:
-		return gjson.Get(x.v, i.ppath).Uint() < gjson.Get(y.v, i.ppath).Uint()
+		less, greater = xv.Uint() < yv.Uint(), xv.Uint() > yv.Uint()
 	case FLOAT_INDEX:
-		return gjson.Get(x.v, i.ppath).Float() < gjson.Get(y.v, i.ppath).Float()
-	case STRING_INDEX:
-		return gjson.Get(x.v, i.ppath).Float() < gjson.Get(y.v, i.ppath).Float()
-	default: //Use String Value
-		return gjson.Get(x.v, i.ppath).String() < gjson.Get(y.v, i.ppath).String()
+		less, greater = xv.Float() < yv.Float(), xv.Float() > yv.Float()
+	case CASE_INSENSITIVE_STRING_INDEX:
+		xs, ys := strings.ToLower(xv.String()), strings.ToLower(yv.String())
+		less, greater = xs < ys, xs > ys
+	default: //STRING_INDEX; Use String Value
+		less, greater = xv.String() < yv.String(), xv.String() > yv.String()
 	}
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
 }
 
-//get searches the tree for an entry that matches the provided entry's index field value. Returns the entry if it exists,
-//nil otherwise. If the provided entry does not contain the index field matching the index field path then the function
-//returns nil.
+//itemValue returns the index field value of an item of the index tree.
+func (i *Index) itemValue(item btree.Item) gjson.Result {
+	if it, ok := item.(*indexItem); ok {
+		return it.v
+	}
+	return gjson.Get(item.(*Entry).v, i.ppath)
+}
+
+//items returns the items of the index tree for the entry. The entry is its own item if the path has no wildcards;
+//otherwise an item is returned for each field of the entry matching the path. Binary entries and entries without a
+//matching field have no items.
+func (i *Index) items(e *Entry) []btree.Item {
+	if e.binary {
+		return nil
+	}
+	if !i.multi {
+		if !gjson.Get(e.v, i.ppath).Exists() {
+			return nil
+		}
+		return []btree.Item{e}
+	}
+	var items []btree.Item
+	var walk func(v gjson.Result, field string, parts []string)
+	walk = func(v gjson.Result, field string, parts []string) {
+		if len(parts) == 0 {
+			items = append(items, &indexItem{e: e, field: field, v: v})
+			return
+		}
+		if field != "" {
+			field += "."
+		}
+		if !strings.ContainsAny(parts[0], "*?") {
+			if c := v.Get(parts[0]); c.Exists() {
+				walk(c, field+parts[0], parts[1:])
+			}
+			return
+		}
+		//Wildcards match the keys of objects and the positions of arrays.
+		n := 0
+		v.ForEach(func(key, value gjson.Result) bool {
+			name := key.String()
+			if v.IsArray() {
+				name = strconv.Itoa(n)
+			}
+			n++
+			if ok, _ := path.Match(parts[0], name); ok {
+				walk(value, field+name, parts[1:])
+			}
+			return true
+		})
+	}
+	walk(gjson.Parse(e.v), "", strings.Split(i.ppath, "."))
+	return items
+}
+
+//get searches the tree for an entry that matches the provided entry's index field value. Only the index field of the
+//provided entry needs to be populated. Returns the matching entry with the lowest key if one exists, nil otherwise. If
+//the provided entry does not contain the index field matching the index field path then the function returns nil.
 func (i *Index) get(e *Entry) *Entry {
-	if !gjson.Get(e.v, i.ppath).Exists() {
+	if e.binary || !gjson.Get(e.v, i.ppath).Exists() {
 		return nil
 	}
-	res := i.t.Get(e)
 	var eres *Entry
-	if res != nil {
-		eres = res.(*Entry)
-	}
+	i.t.AscendGreaterOrEqual(&indexPivot{e: e}, func(item btree.Item) bool {
+		if i.compareValues(i.itemValue(item), gjson.Get(e.v, i.ppath)) == 0 {
+			eres = itemEntry(item)
+		}
+		return false
+	})
 	return eres
 }
 
-//insert adds an entry to the index tree. If an entry is replace, the replaced entry is returned, otherwise returns nil.
-//If the provided entry does not contain the index field matching the index field path then the function
-//returns nil.
+//insert adds an entry to the index tree once for each of its items. If an entry is replace, the replaced entry is
+//returned, otherwise returns nil. If the provided entry does not contain the index field matching the index field path
+//then the function returns nil.
 func (i *Index) insert(e *Entry) *Entry {
-	if !gjson.Get(e.v, i.ppath).Exists() {
-		return nil
-	}
 	var epres *Entry
-	pres := i.t.ReplaceOrInsert(e)
-	if pres != nil {
-		epres = pres.(*Entry)
+	for _, item := range i.items(e) {
+		if pres := i.t.ReplaceOrInsert(item); pres != nil {
+			epres = itemEntry(pres)
+		}
 	}
 	return epres
 }
 
-//delete removes an entry from the index tree. Returns the entry that was deleted, if no entry was deleted the the function
-//returns nil. If the provided entry does not contain the index field matching the index field path then the function
+//delete removes each item of an entry from the index tree. Returns the entry that was deleted, if no entry was deleted the
+//the function returns nil. If the provided entry does not contain the index field matching the index field path then the function
 //returns nil.
 func (i *Index) delete(e *Entry) *Entry {
-	if !gjson.Get(e.v, i.ppath).Exists() {
-		return nil
-	}
 	var edres *Entry
-	dres := i.t.Delete(e)
-	if dres != nil {
-		edres = dres.(*Entry)
+	for _, item := range i.items(e) {
+		if dres := i.t.Delete(item); dres != nil {
+			edres = itemEntry(dres)
+		}
 	}
 	return edres
 }
 
-//build iterates over all entries in the bucket attempting to insert each entry into the tree.
+//build iterates over all entries in the bucket attempting to insert each entry into the tree. Binary entries and entries
+//that do not contain the index field are skipped. Entries with equal index field values are ordered by key.
 func (i *Index) build() {
 	i.bkt.data.Ascend(func(item btree.Item) bool {
-		eItem := item.(*Entry)
-		return func(e *Entry) bool {
-			i.insert(e)
+		i.insert(item.(*Entry))
+		return true
+	})
+}
+
+//stats computes the statistics of the index counting only entries that have not expired or been invalidated. Entries
+//with equal field values are adjacent in the index tree so distinct values are counted in a single pass.
+func (i *Index) stats() IndexStats {
+	var st IndexStats
+	var prev btree.Item
+	i.t.Ascend(func(item btree.Item) bool {
+		e := itemEntry(item)
+		if e.IsExpired() || e.IsInvalid() {
+			return true
+		}
+		c := 1
+		if prev != nil {
+			c = i.compareValues(i.itemValue(prev), i.itemValue(item))
+		}
+		if c != 0 {
+			st.Distinct++
+		}
+		//An entry with several matching fields of equal value is counted once for the value.
+		if c != 0 || itemEntry(prev) != e {
+			st.Entries++
+		}
+		prev = item
+		return true
+	})
+	return st
+}
+
+//valueCounts returns the number of entries that have not expired or been invalidated for each distinct index field
+//value. Values are keyed by their representation under the IndexValueType so values that compare equal share a count.
+func (i *Index) valueCounts() map[string]int {
+	counts := make(map[string]int)
+	var prev btree.Item
+	i.t.Ascend(func(item btree.Item) bool {
+		e := itemEntry(item)
+		if e.IsExpired() || e.IsInvalid() {
 			return true
-		}(eItem)
+		}
+		//An entry with several matching fields of equal value is counted once for the value.
+		if prev == nil || itemEntry(prev) != e || i.compareValues(i.itemValue(prev), i.itemValue(item)) != 0 {
+			counts[i.valueString(i.itemValue(item))]++
+		}
+		prev = item
+		return true
 	})
+	return counts
+}
+
+//valueString returns the representation of an index field value under the IndexValueType.
+func (i *Index) valueString(v gjson.Result) string {
+	switch i.vtype {
+	case INT_INDEX:
+		return strconv.FormatInt(v.Int(), 10)
+	case UINT_INDEX:
+		return strconv.FormatUint(v.Uint(), 10)
+	case FLOAT_INDEX:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case CASE_INSENSITIVE_STRING_INDEX:
+		return strings.ToLower(v.String())
+	default: //STRING_INDEX; Use String Value
+		return v.String()
+	}
 }
 
 //rebuild reinitializes the index tree and builds the index using the new index tree.
@@ -3,9 +3,223 @@
 
 package stitchdb
 
-import "testing"
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cbergoon/btree"
+)
 
 func TestNewIndex(t *testing.T) {
 	t.Skip()
 	//Tested implicitly by DB/Bucket Tests
 }
+
+func TestIndex_build(t *testing.T) {
+	c, _ := NewConfig(DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	bkt, err := newBucket(db, opts, "index")
+	if err != nil {
+		t.Errorf("Failure: newBucket(db, opts, \"index\") returned error \"%v\"", err)
+	}
+	for i := 0; i < 256; i++ {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(256-i)+"}", false, eopt)
+		bkt.insert(e)
+	}
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-none", "{ \"other\":1}", false, eopt)
+	bkt.insert(e)
+	index, err := NewIndex("value", INT_INDEX, bkt)
+	if err != nil {
+		t.Errorf("Failure: NewIndex(\"value\", INT_INDEX, bkt) returned error \"%v\"", err)
+	}
+	index.build()
+	if index.t.Len() != 256 {
+		t.Errorf("Failure: expected index.t.Len() == 256 got %v", index.t.Len())
+	}
+	expected := 1
+	index.t.Ascend(func(item btree.Item) bool {
+		if v := item.(*Entry).v; v != "{ \"value\":"+strconv.Itoa(expected)+"}" {
+			t.Errorf("Failure: expected index entry with value %v got %v", expected, v)
+			return false
+		}
+		expected++
+		return true
+	})
+}
+
+func TestIndex_duplicateValues(t *testing.T) {
+	c, _ := NewConfig(DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	bkt, err := newBucket(db, opts, "index")
+	if err != nil {
+		t.Errorf("Failure: newBucket(db, opts, \"index\") returned error \"%v\"", err)
+	}
+	for i := 0; i < 64; i++ {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i%4)+"}", false, eopt)
+		bkt.insert(e)
+	}
+	index, err := NewIndex("value", INT_INDEX, bkt)
+	if err != nil {
+		t.Errorf("Failure: NewIndex(\"value\", INT_INDEX, bkt) returned error \"%v\"", err)
+	}
+	index.build()
+	if index.t.Len() != 64 {
+		t.Errorf("Failure: expected index.t.Len() == 64 got %v", index.t.Len())
+	}
+	if e := index.get(&Entry{v: "{ \"value\":2}"}); e == nil || e.k != "key-10" {
+		t.Errorf("Failure: expected index.get() to return key-10 got %v", e)
+	}
+	if e := index.get(&Entry{v: "{ \"value\":5}"}); e != nil {
+		t.Errorf("Failure: expected index.get() to return nil got %v", e)
+	}
+	if e := index.delete(bkt.get(&Entry{k: "key-10"})); e == nil || e.k != "key-10" {
+		t.Errorf("Failure: expected index.delete() to remove key-10 got %v", e)
+	}
+	if index.t.Len() != 63 {
+		t.Errorf("Failure: expected index.t.Len() == 63 got %v", index.t.Len())
+	}
+	if e := index.get(&Entry{v: "{ \"value\":2}"}); e == nil || e.k != "key-14" {
+		t.Errorf("Failure: expected index.get() to return key-14 got %v", e)
+	}
+	count := 0
+	index.t.AscendRange(&indexPivot{e: &Entry{v: "{ \"value\":1}"}}, &indexPivot{e: &Entry{v: "{ \"value\":2}"}, high: true}, func(item btree.Item) bool {
+		count++
+		return true
+	})
+	if count != 31 {
+		t.Errorf("Failure: expected 31 entries with values 1 and 2 got %v", count)
+	}
+}
+
+func TestIndex_stringValues(t *testing.T) {
+	c, _ := NewConfig(DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	bkt, err := newBucket(db, opts, "index")
+	if err != nil {
+		t.Errorf("Failure: newBucket(db, opts, \"index\") returned error \"%v\"", err)
+	}
+	for i, name := range []string{"delta", "alpha", "charlie", "bravo", "alpha"} {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"name\":\""+name+"\"}", false, eopt)
+		bkt.insert(e)
+	}
+	index, err := NewIndex("name", STRING_INDEX, bkt)
+	if err != nil {
+		t.Errorf("Failure: NewIndex(\"name\", STRING_INDEX, bkt) returned error \"%v\"", err)
+	}
+	index.build()
+	expected := []string{"key-1", "key-4", "key-3", "key-2", "key-0"}
+	var got []string
+	index.t.Ascend(func(item btree.Item) bool {
+		got = append(got, item.(*Entry).k)
+		return true
+	})
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Failure: expected index order %v got %v", expected, got)
+	}
+}
+
+func TestIndex_caseInsensitiveStringValues(t *testing.T) {
+	c, _ := NewConfig(DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	bkt, err := newBucket(db, opts, "index")
+	if err != nil {
+		t.Errorf("Failure: newBucket(db, opts, \"index\") returned error \"%v\"", err)
+	}
+	for i, name := range []string{"banana", "Apple", "cherry", "apple", "Banana"} {
+		eopt, _ := NewEntryOptions()
+		e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"name\":\""+name+"\"}", false, eopt)
+		bkt.insert(e)
+	}
+	index, err := NewIndex("name", CASE_INSENSITIVE_STRING_INDEX, bkt)
+	if err != nil {
+		t.Errorf("Failure: NewIndex(\"name\", CASE_INSENSITIVE_STRING_INDEX, bkt) returned error \"%v\"", err)
+	}
+	index.build()
+	expected := []string{"key-1", "key-3", "key-0", "key-4", "key-2"}
+	var got []string
+	index.t.Ascend(func(item btree.Item) bool {
+		got = append(got, item.(*Entry).k)
+		return true
+	})
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Failure: expected index order %v got %v", expected, got)
+	}
+	if e := index.get(&Entry{v: "{ \"name\":\"CHERRY\"}"}); e == nil || e.k != "key-2" {
+		t.Errorf("Failure: index.get(CHERRY) expected key-2 got %v", e)
+	}
+}
+
+func TestIndex_pattern(t *testing.T) {
+	c, _ := NewConfig(DirPath("stitch/test/db/"))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	bkt, err := newBucket(db, opts, "index")
+	if err != nil {
+		t.Errorf("Failure: newBucket(db, opts, \"index\") returned error \"%v\"", err)
+	}
+	index, _ := NewIndex("attrs.*", STRING_INDEX, bkt)
+	bkt.indexes["attrs.*"] = index
+	for _, kv := range [][2]string{
+		{"key-0", "{\"attrs\": {\"color\": \"red\", \"size\": \"large\", \"shade\": \"red\"}}"},
+		{"key-1", "{\"attrs\": {\"color\": \"blue\"}}"},
+		{"key-2", "{\"attrs\": {}}"},
+		{"key-3", "{\"other\": \"red\"}"},
+		{"key-4", "{\"attrs\": [\"red\", \"small\"]}"},
+	} {
+		e, _ := NewEntry(kv[0], kv[1], false, nil)
+		bkt.insert(e)
+	}
+	var got []string
+	index.t.Ascend(func(item btree.Item) bool {
+		it := item.(*indexItem)
+		got = append(got, it.v.String()+"@"+it.e.k+"."+it.field)
+		return true
+	})
+	expected := "blue@key-1.attrs.color,large@key-0.attrs.size,red@key-0.attrs.color,red@key-0.attrs.shade,red@key-4.attrs.0,small@key-4.attrs.1"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Failure: expected index items %v got %v", expected, strings.Join(got, ","))
+	}
+	if st := index.stats(); st.Distinct != 4 || st.Entries != 5 {
+		t.Errorf("Failure: expected index stats {4 5} got %v", st)
+	}
+	if counts := index.valueCounts(); counts["red"] != 2 || counts["blue"] != 1 {
+		t.Errorf("Failure: expected two entries with red and one with blue got %v", counts)
+	}
+	if e := index.get(&Entry{v: "{\"attrs\": {\"any\": \"small\"}}"}); e == nil || e.k != "key-4" {
+		t.Errorf("Failure: expected index.get(small) to return key-4 got %v", e)
+	}
+	//Overwriting and deleting an entry removes the items of each of its fields.
+	e, _ := NewEntry("key-0", "{\"attrs\": {\"color\": \"green\"}}", false, nil)
+	bkt.insert(e)
+	bkt.delete(&Entry{k: "key-4"})
+	if index.t.Len() != 2 {
+		t.Errorf("Failure: expected index.t.Len() == 2 got %v", index.t.Len())
+	}
+	if keys := bkt.verifyIndex(index); len(keys) != 0 {
+		t.Errorf("Failure: expected consistent index got %v", keys)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+)
+
+//Logger receives the diagnostic output of the db. Implementations must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+//stdLogger is the default logger. Errors are always written to stdout; debug and info messages are written only in
+//developer mode.
+type stdLogger struct {
+	developer bool //Indicates if debug and info messages should be written.
+}
+
+//Debugf writes a debug message to stdout if developer mode is enabled.
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	if l.developer {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+//Infof writes an info message to stdout if developer mode is enabled.
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	if l.developer {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+//Errorf writes an error message to stdout.
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	fmt.Printf(format+"\n", args...)
+}
+
+//nopLogger discards all messages.
+type nopLogger struct{}
+
+//Debugf discards the message.
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+//Infof discards the message.
+func (nopLogger) Infof(format string, args ...interface{}) {}
+
+//Errorf discards the message.
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,158 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	//METRIC_COMMIT is the duration of writing a transaction's changes on commit.
+	METRIC_COMMIT = "commit"
+	//METRIC_COMMIT_ENTRIES is the number of entries written on commit.
+	METRIC_COMMIT_ENTRIES = "commit_entries"
+	//METRIC_ROLLBACK is the duration of reverting a transaction's changes on rollback.
+	METRIC_ROLLBACK = "rollback"
+	//METRIC_SCAN is the duration of an ascend or descend over a bucket or index.
+	METRIC_SCAN = "scan"
+	//METRIC_SCAN_ENTRIES is the number of entries passed to the iterator of a scan.
+	METRIC_SCAN_ENTRIES = "scan_entries"
+	//METRIC_SWEEP is the duration of a sweep of a bucket by the bucket manager.
+	METRIC_SWEEP = "sweep"
+	//METRIC_EXPIRED is the number of expired entries removed by the bucket manager.
+	METRIC_EXPIRED = "expired"
+	//METRIC_EXPIRED_BYTES is the size in bytes of the keys and values of the expired entries removed by the bucket manager.
+	METRIC_EXPIRED_BYTES = "expired_bytes"
+	//METRIC_TOMBSTONES_REAPED is the number of tombstones removed by the bucket manager after their retention.
+	METRIC_TOMBSTONES_REAPED = "tombstones_reaped"
+)
+
+//HistogramBounds are the inclusive upper bounds of the histogram buckets kept by the default metrics. Durations greater
+//than the last bound are counted in a final overflow bucket.
+var HistogramBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+//Metrics receives measurements of db operations. Names are one of the METRIC_* constants. Implementations must be safe
+//for concurrent use.
+type Metrics interface {
+	RecordDuration(bucket, name string, d time.Duration)
+	IncrCount(bucket, name string, n int)
+}
+
+//Histogram is a distribution of recorded durations.
+type Histogram struct {
+	Count  int64         //Number of durations recorded.
+	Sum    time.Duration //Sum of the durations recorded.
+	Min    time.Duration //Smallest duration recorded.
+	Max    time.Duration //Largest duration recorded.
+	Counts []int64       //Number of durations recorded for each of HistogramBounds followed by the overflow bucket.
+}
+
+//observe adds the duration to the histogram.
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HistogramBounds)+1)
+	}
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+	i := 0
+	for i < len(HistogramBounds) && d > HistogramBounds[i] {
+		i++
+	}
+	h.Counts[i]++
+}
+
+//Stats is a snapshot of the measurements kept by the default metrics. Each map is keyed by bucket name then metric name.
+type Stats struct {
+	Durations map[string]map[string]Histogram //Distributions of recorded durations.
+	Counts    map[string]map[string]int64     //Totals of recorded counts.
+}
+
+//memMetrics is the default metrics used when the performance monitor is enabled. Measurements are kept in memory.
+type memMetrics struct {
+	lock      sync.Mutex
+	durations map[string]map[string]*Histogram
+	counts    map[string]map[string]int64
+}
+
+//newMemMetrics creates an empty in memory metrics.
+func newMemMetrics() *memMetrics {
+	return &memMetrics{
+		durations: make(map[string]map[string]*Histogram),
+		counts:    make(map[string]map[string]int64),
+	}
+}
+
+//RecordDuration adds the duration to the histogram for the bucket and metric.
+func (m *memMetrics) RecordDuration(bucket, name string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.durations[bucket] == nil {
+		m.durations[bucket] = make(map[string]*Histogram)
+	}
+	h := m.durations[bucket][name]
+	if h == nil {
+		h = &Histogram{}
+		m.durations[bucket][name] = h
+	}
+	h.observe(d)
+}
+
+//IncrCount adds n to the total for the bucket and metric.
+func (m *memMetrics) IncrCount(bucket, name string, n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counts[bucket] == nil {
+		m.counts[bucket] = make(map[string]int64)
+	}
+	m.counts[bucket][name] += int64(n)
+}
+
+//snapshot returns a copy of the measurements.
+func (m *memMetrics) snapshot() Stats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	st := Stats{
+		Durations: make(map[string]map[string]Histogram),
+		Counts:    make(map[string]map[string]int64),
+	}
+	for bucket, hists := range m.durations {
+		st.Durations[bucket] = make(map[string]Histogram)
+		for name, h := range hists {
+			c := *h
+			c.Counts = append([]int64(nil), h.Counts...)
+			st.Durations[bucket][name] = c
+		}
+	}
+	for bucket, counts := range m.counts {
+		st.Counts[bucket] = make(map[string]int64)
+		for name, n := range counts {
+			st.Counts[bucket][name] = n
+		}
+	}
+	return st
+}
+
+//nopMetrics discards all measurements.
+type nopMetrics struct{}
+
+//RecordDuration discards the duration.
+func (nopMetrics) RecordDuration(bucket, name string, d time.Duration) {}
+
+//IncrCount discards the count.
+func (nopMetrics) IncrCount(bucket, name string, n int) {}
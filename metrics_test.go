@@ -0,0 +1,40 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram_observe(t *testing.T) {
+	h := &Histogram{}
+	h.observe(500 * time.Nanosecond)
+	h.observe(5 * time.Millisecond)
+	h.observe(5 * time.Second)
+	if h.Count != 3 {
+		t.Errorf("Failure: expected h.Count == 3 got h.Count == %v", h.Count)
+	}
+	if h.Min != 500*time.Nanosecond || h.Max != 5*time.Second {
+		t.Errorf("Failure: expected h.Min == 500ns and h.Max == 5s got h.Min == %v and h.Max == %v", h.Min, h.Max)
+	}
+	if h.Counts[0] != 1 || h.Counts[4] != 1 || h.Counts[len(HistogramBounds)] != 1 {
+		t.Errorf("Failure: unexpected histogram counts %v", h.Counts)
+	}
+}
+
+func TestMemMetrics_snapshot(t *testing.T) {
+	m := newMemMetrics()
+	m.RecordDuration("bkt", METRIC_COMMIT, time.Millisecond)
+	m.IncrCount("bkt", METRIC_COMMIT_ENTRIES, 2)
+	m.IncrCount("bkt", METRIC_COMMIT_ENTRIES, 3)
+	st := m.snapshot()
+	m.RecordDuration("bkt", METRIC_COMMIT, time.Millisecond)
+	if st.Durations["bkt"][METRIC_COMMIT].Count != 1 {
+		t.Errorf("Failure: expected snapshot commit count == 1 got %v", st.Durations["bkt"][METRIC_COMMIT].Count)
+	}
+	if st.Counts["bkt"][METRIC_COMMIT_ENTRIES] != 5 {
+		t.Errorf("Failure: expected snapshot commit entries == 5 got %v", st.Counts["bkt"][METRIC_COMMIT_ENTRIES])
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package stitchdb
+
+import (
+	"os"
+
+	"github.com/juju/errors"
+)
+
+//mmapSupported indicates if bucket files can be memory mapped on this platform.
+const mmapSupported = false
+
+//mmapFile is not supported on this platform and always returns an error.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	return nil, nil, errors.New("error: bucket: memory mapped bucket files are not supported on this platform")
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package stitchdb
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+//mmapSupported indicates if bucket files can be memory mapped on this platform.
+const mmapSupported = true
+
+//mmapFile maps the contents of the file read only and returns the mapped region with a function that unmaps it. The
+//region must not be used after it is unmapped. An empty file returns an empty region.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "error: bucket: failed to stat bucket file")
+	}
+	if fi.Size() == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	if int64(int(fi.Size())) != fi.Size() {
+		return nil, nil, errors.New("error: bucket: bucket file is too large to map")
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "error: bucket: failed to map bucket file")
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"container/list"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/tidwall/gjson"
+)
+
+//Filter is a condition on a field of the entry values evaluated by Tx.Query. If Equal is not nil the filter matches
+//entries whose field is equal to Equal, otherwise the filter matches entries whose field is greater than or equal to Min
+//and less than Max where a nil bound is unbounded. Entries that do not contain the field never match.
+type Filter struct {
+	Path  string         //Path to the field that the filter applies to. Uses tidwall/gjson access format.
+	Type  IndexValueType //Defines the type of the field and determines how values will be compared.
+	Equal interface{}    //Value the field must be equal to.
+	Min   interface{}    //Inclusive lower bound of the field.
+	Max   interface{}    //Exclusive upper bound of the field.
+}
+
+//Query returns the live entries of the bucket matching the provided filter. If the bucket has an index over the filter
+//path with the filter type the index is used to answer the query and the entries are returned in index order, otherwise
+//the bucket is scanned and the entries are returned in key order. A filter path with wildcards, as accepted by
+//CreateIndex, matches an entry if any field matching the path matches; each entry is returned once. If the query cache
+//is enabled with QueryCacheSize the keys of the result are cached and reused by an identical query until the entries of
+//the bucket change. Returns an error if the filter is invalid or if the db or bucket is closed.
+func (t *Tx) Query(filter Filter) ([]*Entry, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot query; db is in invalid state")
+	}
+	if strings.TrimSpace(filter.Path) == "" {
+		return nil, errors.New("error: tx: filter path is empty")
+	}
+	eq, err := filterEntry(filter.Path, filter.Equal)
+	if err != nil {
+		return nil, err
+	}
+	min, err := filterEntry(filter.Path, filter.Min)
+	if err != nil {
+		return nil, err
+	}
+	max, err := filterEntry(filter.Path, filter.Max)
+	if err != nil {
+		return nil, err
+	}
+	idx, useIdx := t.bkt.indexes[filter.Path]
+	useIdx = useIdx && t.bkt.indexExists(filter.Path) && idx.vtype == filter.Type
+	//Results are cached with whether an index answered them as the index determines their order.
+	var ckey string
+	if t.bkt.qcache != nil {
+		ckey = queryCacheKey(filter, useIdx, eq, min, max)
+		if keys, ok := t.bkt.qcache.get(ckey, t.bkt.qgen); ok {
+			res := make([]*Entry, 0, len(keys))
+			for _, k := range keys {
+				//Entries only stop being live without a write by expiring or invalidating.
+				if e := t.bkt.get(&Entry{k: k}); e != nil && !e.IsExpired() && !e.IsInvalid() {
+					res = append(res, e)
+				}
+			}
+			return res, nil
+		}
+	}
+	defer t.recordScan(time.Now())
+	//An index without a tree provides the comparator for the filter type.
+	cmp := &Index{ppath: filter.Path, vtype: filter.Type, multi: strings.ContainsAny(filter.Path, "*?")}
+	var eqv, minv, maxv gjson.Result
+	if eq != nil {
+		eqv = gjson.Get(eq.v, filter.Path)
+	}
+	if min != nil {
+		minv = gjson.Get(min.v, filter.Path)
+	}
+	if max != nil {
+		maxv = gjson.Get(max.v, filter.Path)
+	}
+	match := func(v gjson.Result) bool {
+		if eq != nil {
+			return cmp.compareValues(v, eqv) == 0
+		}
+		return (min == nil || cmp.compareValues(v, minv) >= 0) && (max == nil || cmp.compareValues(v, maxv) < 0)
+	}
+	var res []*Entry
+	//A wildcard path matches an entry if any matching field matches; the index visits the entry once for each field.
+	var seen map[string]bool
+	if cmp.multi {
+		seen = make(map[string]bool)
+	}
+	i := t.iterator(func(e *Entry) bool {
+		if e.IsExpired() || e.IsInvalid() || e.binary || seen[e.k] {
+			return true
+		}
+		//The scan reads each entry once so its fields are parsed without the cache of Field.
+		var ok bool
+		if cmp.multi {
+			for _, item := range cmp.items(e) {
+				if ok = match(item.(*indexItem).v); ok {
+					seen[e.k] = true
+					break
+				}
+			}
+		} else if v := gjson.Get(e.v, filter.Path); v.Exists() {
+			ok = match(v)
+		}
+		if ok {
+			res = append(res, e)
+		}
+		return true
+	})
+	t.setIterating(true)
+	defer t.setIterating(false)
+	if useIdx {
+		switch {
+		case eq != nil:
+			idx.t.AscendRange(&indexPivot{e: eq}, &indexPivot{e: eq, high: true}, i)
+		case min != nil && max != nil:
+			idx.t.AscendRange(&indexPivot{e: min}, &indexPivot{e: max}, i)
+		case min != nil:
+			idx.t.AscendGreaterOrEqual(&indexPivot{e: min}, i)
+		case max != nil:
+			idx.t.AscendLessThan(&indexPivot{e: max}, i)
+		default:
+			idx.t.Ascend(i)
+		}
+	} else {
+		t.bkt.data.Ascend(i)
+	}
+	if t.bkt.qcache != nil {
+		keys := make([]string, len(res))
+		for k, e := range res {
+			keys[k] = e.k
+		}
+		t.bkt.qcache.put(ckey, t.bkt.qgen, keys)
+	}
+	return res, nil
+}
+
+//queryCacheKey returns the key of the query cache identifying a query with the provided filter and filter entries.
+func queryCacheKey(filter Filter, useIdx bool, eq, min, max *Entry) string {
+	bound := func(e *Entry) string {
+		if e == nil {
+			return ""
+		}
+		return e.v
+	}
+	return strings.Join([]string{filter.Path, strconv.Itoa(int(filter.Type)), strconv.FormatBool(useIdx), bound(eq), bound(min), bound(max)}, "\x00")
+}
+
+//queryCache is a least recently used cache of the keys returned by queries of a bucket. Results are cached with the
+//generation of the bucket they were computed at, which each insert and delete of the bucket increments; the cache is
+//emptied when it is used at a different generation. Queries of concurrent read only transactions share the cache so it
+//is guarded by its own lock.
+type queryCache struct {
+	lock  sync.Mutex               //Lock for the cache.
+	size  int                      //Maximum number of cached results.
+	gen   uint64                   //Generation of the bucket the cached results were computed at.
+	lru   *list.List               //Cached results from most to least recently used.
+	items map[string]*list.Element //Elements of lru by query cache key.
+}
+
+//queryCacheItem is a result held by a query cache.
+type queryCacheItem struct {
+	key  string
+	keys []string
+}
+
+//newQueryCache returns a query cache holding up to size results or nil if size is zero.
+func newQueryCache(size int) *queryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &queryCache{size: size, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+//reset empties the cache if the provided generation differs from the generation of the cached results. It is assumed
+//the caller holds the lock on the cache.
+func (c *queryCache) reset(gen uint64) {
+	if c.gen != gen {
+		c.lru.Init()
+		c.items = make(map[string]*list.Element)
+		c.gen = gen
+	}
+}
+
+//get returns the keys cached for the query cache key at the provided generation.
+func (c *queryCache) get(key string, gen uint64) ([]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset(gen)
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*queryCacheItem).keys, true
+}
+
+//put caches the keys for the query cache key at the provided generation evicting the least recently used result if the
+//cache is full.
+func (c *queryCache) put(key string, gen uint64, keys []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset(gen)
+	if el, ok := c.items[key]; ok {
+		el.Value.(*queryCacheItem).keys = keys
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&queryCacheItem{key: key, keys: keys})
+	if c.lru.Len() > c.size {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.items, last.Value.(*queryCacheItem).key)
+	}
+}
+
+//filterEntry returns an entry with only the field at path populated with the provided value. Returns nil if the value is
+//nil. Returns an error if the value could not be marshaled or the path cannot be represented.
+func filterEntry(path string, value interface{}) (*Entry, error) {
+	if value == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to marshal filter value")
+	}
+	v := string(j)
+	parts := strings.Split(path, ".")
+	for k := len(parts) - 1; k >= 0; k-- {
+		name, _ := json.Marshal(parts[k])
+		v = "{" + string(name) + ":" + v + "}"
+	}
+	if !gjson.Get(v, path).Exists() {
+		return nil, errors.New("error: tx: unsupported filter path")
+	}
+	return &Entry{v: v}, nil
+}
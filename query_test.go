@@ -0,0 +1,231 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterEntry(t *testing.T) {
+	e, err := filterEntry("info.age", 3)
+	if err != nil {
+		t.Errorf("Failure: filterEntry(\"info.age\", 3) returned error \"%v\"", err)
+	}
+	if e == nil || e.v != "{\"info\":{\"age\":3}}" {
+		t.Errorf("Failure: filterEntry(\"info.age\", 3) expected {\"info\":{\"age\":3}} got %v", e)
+	}
+	if e, err := filterEntry("age", nil); e != nil || err != nil {
+		t.Errorf("Failure: filterEntry(\"age\", nil) expected nil got %v, \"%v\"", e, err)
+	}
+}
+
+func TestTx_Query(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("query", opts)
+	db.Update("query", func(tx *Tx) error {
+		for i := 0; i < 20; i++ {
+			eopt, _ := NewEntryOptions()
+			if i == 4 {
+				eopt, _ = NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+			}
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"age\":"+strconv.Itoa(i%10)+", \"info\": { \"name\":\"n"+strconv.Itoa(i%5)+"\"}}", false, eopt)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("key-none", "{ \"other\":1}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	keys := func(entries []*Entry) string {
+		var ks []string
+		for _, e := range entries {
+			ks = append(ks, e.k)
+		}
+		return strings.Join(ks, ",")
+	}
+	queries := func(expected map[string]Filter) {
+		db.View("query", func(tx *Tx) error {
+			for exp, filter := range expected {
+				res, err := tx.Query(filter)
+				if err != nil {
+					t.Errorf("Failure: tx.Query(%v) returned error \"%v\"", filter, err)
+				}
+				if got := keys(res); got != exp {
+					t.Errorf("Failure: tx.Query(%v) expected %v got %v", filter, exp, got)
+				}
+			}
+			return nil
+		})
+	}
+	//Without indexes the bucket is scanned in key order.
+	queries(map[string]Filter{
+		"key-13,key-3":              {Path: "age", Type: INT_INDEX, Equal: 3},
+		"key-1,key-11,key-12,key-2": {Path: "age", Type: INT_INDEX, Min: 1, Max: 3},
+		"key-18,key-19,key-8,key-9": {Path: "age", Type: INT_INDEX, Min: 8},
+		"key-0,key-10":              {Path: "age", Type: INT_INDEX, Max: 1},
+		"key-1,key-11,key-16,key-6": {Path: "info.name", Type: STRING_INDEX, Equal: "n1"},
+		"key-14,key-19,key-9":       {Path: "info.name", Type: STRING_INDEX, Min: "n4"},
+	})
+	db.Update("query", func(tx *Tx) error {
+		tx.CreateIndex("age", INT_INDEX)
+		return tx.CreateIndex("info.name", STRING_INDEX)
+	})
+	//With indexes the entries are returned in index order.
+	queries(map[string]Filter{
+		"key-13,key-3":              {Path: "age", Type: INT_INDEX, Equal: 3},
+		"key-1,key-11,key-12,key-2": {Path: "age", Type: INT_INDEX, Min: 1, Max: 3},
+		"key-18,key-8,key-19,key-9": {Path: "age", Type: INT_INDEX, Min: 8},
+		"key-0,key-10":              {Path: "age", Type: INT_INDEX, Max: 1},
+		"key-1,key-11,key-16,key-6": {Path: "info.name", Type: STRING_INDEX, Equal: "n1"},
+		"key-14,key-19,key-9":       {Path: "info.name", Type: STRING_INDEX, Min: "n4"},
+	})
+	//A wildcard path matches any field of the entry matching the path; each entry is returned once.
+	db.Update("query", func(tx *Tx) error {
+		e, _ := NewEntry("key-tags", "{ \"tags\": [\"n1\", \"n2\", \"n1\"], \"alt\": { \"name\":\"n1\"}}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	pattern := map[string]Filter{
+		"key-1,key-11,key-16,key-6,key-tags": {Path: "*.name", Type: STRING_INDEX, Equal: "n1"},
+	}
+	queries(pattern)
+	queries(map[string]Filter{"key-tags": {Path: "tags.*", Type: STRING_INDEX, Min: "n2"}})
+	db.Update("query", func(tx *Tx) error {
+		if err := tx.CreateIndex("tags.*", STRING_INDEX); err != nil {
+			t.Errorf("Failure: tx.CreateIndex(\"tags.*\", STRING_INDEX) returned error \"%v\"", err)
+		}
+		return tx.CreateIndex("*.name", STRING_INDEX)
+	})
+	queries(pattern)
+	queries(map[string]Filter{"key-tags": {Path: "tags.*", Type: STRING_INDEX, Equal: "n1"}})
+	db.View("query", func(tx *Tx) error {
+		var visited int
+		tx.Ascend("tags.*", func(e *Entry) bool {
+			visited++
+			return true
+		})
+		if visited != 3 {
+			t.Errorf("Failure: expected tags index to visit key-tags once for each tag got %d", visited)
+		}
+		return nil
+	})
+	if report, err := db.Verify("query"); err != nil || !report.Ok() {
+		t.Errorf("Failure: db.Verify(\"query\") expected consistent indexes got %v error \"%v\"", report, err)
+	}
+	db.View("query", func(tx *Tx) error {
+		if _, err := tx.Query(Filter{Type: INT_INDEX, Equal: 1}); err == nil {
+			t.Error("Failure: tx.Query(Filter{}) expected error for empty path got nil")
+		}
+		return nil
+	})
+	db.DropBucket("query")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_QueryCache(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10), QueryCacheSize(2))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("querycache", opts)
+	set := func(tx *Tx, k string, age int) {
+		e, _ := NewEntry(k, "{ \"age\":"+strconv.Itoa(age)+"}", false, nil)
+		tx.Set(e)
+	}
+	db.Update("querycache", func(tx *Tx) error {
+		for i := 0; i < 6; i++ {
+			set(tx, "key-"+strconv.Itoa(i), i%3)
+		}
+		return nil
+	})
+	keys := func(filter Filter) string {
+		var ks []string
+		db.View("querycache", func(tx *Tx) error {
+			res, err := tx.Query(filter)
+			if err != nil {
+				t.Errorf("Failure: tx.Query(%+v) returned error \"%v\"", filter, err)
+			}
+			for _, e := range res {
+				ks = append(ks, e.k)
+			}
+			return nil
+		})
+		return strings.Join(ks, ",")
+	}
+	age1 := Filter{Path: "age", Type: INT_INDEX, Equal: 1}
+	if got := keys(age1); got != "key-1,key-4" {
+		t.Errorf("Failure: expected query age == 1 to return key-1,key-4 got %v", got)
+	}
+	//A cached result is reused while the bucket is unchanged; an entry added without a write is not observed.
+	b := db.buckets["querycache"]
+	b.data.ReplaceOrInsert(&Entry{k: "key-7", v: "{ \"age\":1}", opts: &EntryOptions{}})
+	if got := keys(age1); got != "key-1,key-4" {
+		t.Errorf("Failure: expected cached query age == 1 to return key-1,key-4 got %v", got)
+	}
+	b.data.Delete(&Entry{k: "key-7"})
+	//Writes, including writes that are rolled back, discard the cached results.
+	db.Update("querycache", func(tx *Tx) error {
+		set(tx, "key-8", 1)
+		if got, _ := tx.Query(age1); len(got) != 3 {
+			t.Errorf("Failure: expected query age == 1 within writing transaction to return 3 entries got %d", len(got))
+		}
+		return fmt.Errorf("rollback")
+	})
+	if got := keys(age1); got != "key-1,key-4" {
+		t.Errorf("Failure: expected query age == 1 after rollback to return key-1,key-4 got %v", got)
+	}
+	db.Update("querycache", func(tx *Tx) error {
+		set(tx, "key-9", 1)
+		return nil
+	})
+	if got := keys(age1); got != "key-1,key-4,key-9" {
+		t.Errorf("Failure: expected query age == 1 after commit to return key-1,key-4,key-9 got %v", got)
+	}
+	age1Key := queryCacheKey(age1, false, &Entry{v: "{\"age\":1}"}, nil, nil)
+	if _, ok := b.qcache.get(age1Key, b.qgen); !ok {
+		t.Error("Failure: expected query age == 1 to be cached")
+	}
+	//The least recently used result is evicted.
+	keys(Filter{Path: "age", Type: INT_INDEX, Equal: 0})
+	keys(Filter{Path: "age", Type: INT_INDEX, Min: 2})
+	if n := len(b.qcache.items); n != 2 {
+		t.Errorf("Failure: expected 2 cached query results got %d", n)
+	}
+	if _, ok := b.qcache.get(age1Key, b.qgen); ok {
+		t.Error("Failure: expected least recently used query age == 1 to be evicted")
+	}
+	//Creating an index changes the plan of the query so its result is cached separately.
+	db.Update("querycache", func(tx *Tx) error {
+		return tx.CreateIndex("age", INT_INDEX)
+	})
+	if got := keys(age1); got != "key-1,key-4,key-9" {
+		t.Errorf("Failure: expected indexed query age == 1 to return key-1,key-4,key-9 got %v", got)
+	}
+	db.DropBucket("querycache")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	if _, err := NewConfig(QueryCacheSize(-1)); err == nil {
+		t.Error("Failure: NewConfig(QueryCacheSize(-1)) expected error got nil")
+	}
+}
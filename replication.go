@@ -0,0 +1,233 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+//REPLICATION_BUFFER_SIZE is the number of records a replication stream buffers before it is closed for falling behind.
+const REPLICATION_BUFFER_SIZE int = 1024
+
+//ReplicationStream returns a channel receiving a record for each read/write transaction committed to a bucket of the db
+//after the call. A record holds the epoch of the db, a sequence number, the name of the bucket, and the statements the
+//commit wrote to the bucket file in the length prefixed format of the bucket file; records are received in commit order
+//with increasing sequence numbers. Sequence numbers are not persisted: each Open starts a new epoch and numbers its
+//records from 1. Commits to the system buckets are not streamed. Commits are not blocked by the stream; a stream that
+//falls REPLICATION_BUFFER_SIZE records behind is closed and the follower must be resynchronized. Streams are closed when
+//the db is closed. Returns an error if the db is closed.
+func (db *StitchDB) ReplicationStream() (<-chan []byte, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return nil, errors.New("error: db: db is closed")
+	}
+	db.repllock.Lock()
+	defer db.repllock.Unlock()
+	stream := make(chan []byte, REPLICATION_BUFFER_SIZE)
+	db.streams = append(db.streams, stream)
+	return stream, nil
+}
+
+//ApplyReplication reads records received from ReplicationStream of a primary db from r and applies each record to the
+//bucket of the same name in a read/write transaction until r is exhausted. Records with a sequence number that has been
+//applied already are skipped so a follower can resume from a stream that repeats records; a record from a new epoch of
+//the primary, which numbers its records from 1 again after it is reopened, is never skipped. Returns an error if the db
+//is closed, a record is malformed, a record follows a gap in the sequence, the bucket of a record does not exist, or a
+//record could not be applied; records before the failing record remain applied.
+func (db *StitchDB) ApplyReplication(r io.Reader) error {
+	rd := bufio.NewReader(r)
+	for {
+		record, err := readStmt(rd)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Annotate(err, "error: db: failed to read replication record")
+		}
+		header := strings.SplitN(strings.SplitN(record, "\n", 2)[0], "~", 4)
+		if len(header) != 4 || header[0] != "REPLICATE" {
+			return errors.New("error: db: invalid replication record")
+		}
+		epoch := header[1]
+		seq, err := strconv.ParseUint(header[2], 10, 64)
+		if err != nil {
+			return errors.Annotate(err, "error: db: invalid replication record sequence")
+		}
+		db.repllock.Lock()
+		applied := db.replapplied
+		if epoch != db.replfrom {
+			//The primary was reopened and numbers its records from 1 again.
+			applied = 0
+		}
+		db.repllock.Unlock()
+		if seq <= applied {
+			continue
+		}
+		if applied != 0 && seq != applied+1 {
+			return errors.New("error: db: replication record " + header[2] + " does not follow " + strconv.FormatUint(applied, 10))
+		}
+		stmts := bufio.NewReader(strings.NewReader(record[strings.Index(record, "\n")+1:]))
+		err = db.Update(header[3], func(t *Tx) error {
+			for {
+				stmt, err := readStmt(stmts)
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := t.applyStmt(stmt); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return errors.Annotate(err, "error: db: failed to apply replication record "+header[2])
+		}
+		db.repllock.Lock()
+		db.replfrom, db.replapplied = epoch, seq
+		db.repllock.Unlock()
+	}
+}
+
+//resetReplication starts a new epoch of the records sent to the replication streams numbering them from 1.
+func (db *StitchDB) resetReplication() {
+	db.repllock.Lock()
+	defer db.repllock.Unlock()
+	db.replepoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	db.replseq = 0
+}
+
+//replicating returns true if the db has open replication streams.
+func (db *StitchDB) replicating() bool {
+	db.repllock.Lock()
+	defer db.repllock.Unlock()
+	return len(db.streams) > 0
+}
+
+//replicate sends a record holding the statements committed to the bucket to each replication stream. Streams that are
+//full are closed.
+func (db *StitchDB) replicate(bucket string, stmts []byte) {
+	db.repllock.Lock()
+	defer db.repllock.Unlock()
+	if len(db.streams) == 0 {
+		return
+	}
+	db.replseq++
+	var body []byte
+	body = append(body, "REPLICATE~"...)
+	body = append(body, db.replepoch...)
+	body = append(body, '~')
+	body = append(body, strconv.FormatUint(db.replseq, 10)...)
+	body = append(body, '~')
+	body = append(body, bucket...)
+	body = append(body, '\n')
+	body = append(body, stmts...)
+	record := append([]byte(strconv.Itoa(len(body))+"\n"), body...)
+	streams := db.streams[:0]
+	for _, stream := range db.streams {
+		select {
+		case stream <- record:
+			streams = append(streams, stream)
+		default:
+			close(stream)
+			db.config.logger.Errorf("%v", errors.New("error: db: replication stream fell behind and was closed"))
+		}
+	}
+	db.streams = streams
+}
+
+//closeStreams closes each replication stream.
+func (db *StitchDB) closeStreams() {
+	db.repllock.Lock()
+	defer db.repllock.Unlock()
+	for _, stream := range db.streams {
+		close(stream)
+	}
+	db.streams = nil
+}
+
+//readStmt reads a length prefixed statement from r. Returns io.EOF if r is exhausted before a statement begins.
+func readStmt(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && len(line) == 0 {
+		return "", io.EOF
+	} else if err != nil {
+		return "", errors.Annotate(err, "error: db: failed to read statement length")
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || size < 0 {
+		return "", errors.New("error: db: missing or unusable statement length")
+	}
+	stmt := make([]byte, size)
+	if _, err := io.ReadFull(r, stmt); err != nil {
+		return "", errors.Annotate(err, "error: db: statement is shorter than its length")
+	}
+	return string(stmt), nil
+}
+
+//applyStmt applies a statement of the bucket file to the bucket of the transaction recording the changes as Set and
+//Delete do so that the commit writes the same statement. Returns an error if the statement could not be parsed.
+func (t *Tx) applyStmt(stmt string) error {
+	stype, sparts, err := parseEntryStmtTypeName(stmt)
+	if err != nil {
+		return err
+	}
+	switch stype {
+	case "INSERT":
+		e, err := NewEntryFromStmt(sparts)
+		if err != nil {
+			return err
+		}
+		if err := t.validate(e); err != nil {
+			return err
+		}
+		t.set(e)
+	case "DELETE":
+		if len(sparts) < 2 {
+			return errors.New("error: tx: invalid delete statement")
+		}
+		if _, err := t.Delete(&Entry{k: sparts[1]}); err != nil {
+			return err
+		}
+	case "TOMBSTONE":
+		ts, err := newTombstoneFromStmt(sparts)
+		if err != nil {
+			return err
+		}
+		t.recordBackwardTombstone(ts.k, t.bkt.setTombstone(ts))
+	case "COUNTER":
+		name, c, err := newCounterFromStmt(sparts)
+		if err != nil {
+			return err
+		}
+		t.setCounter(name, c.value)
+	case "EXPIRE":
+		if len(sparts) < 3 {
+			return errors.New("error: tx: invalid expire statement")
+		}
+		exp, err := parseStmtTime(sparts[2])
+		if err != nil {
+			return errors.Annotate(err, "error: tx: invalid expire statement")
+		}
+		curr := t.bkt.get(&Entry{k: sparts[1]})
+		if curr == nil {
+			return nil
+		}
+		opts := *curr.opts
+		opts.doesExp = true
+		opts.expTime = exp
+		_, changed := t.rbctx.forward[curr.k]
+		t.set(&Entry{k: curr.k, v: curr.v, opts: &opts, location: curr.location, binary: curr.binary, version: curr.version})
+		if !changed {
+			t.rbctx.forwardExpire[curr.k] = true
+		}
+	}
+	return nil
+}
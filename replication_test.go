@@ -0,0 +1,127 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_Replication(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	primary, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	primary.Open()
+	fc, _ := NewConfig(Persist, DirPath("stitch/test/follower/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	follower, err := NewStitchDB(fc)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(fc) returned error \"%v\"", err)
+	}
+	follower.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Hour))
+	primary.CreateBucket("replicated", opts)
+	follower.CreateBucket("replicated", opts)
+	stream, err := primary.ReplicationStream()
+	if err != nil {
+		t.Errorf("Failure: primary.ReplicationStream() returned error \"%v\"", err)
+	}
+	primary.Update("replicated", func(tx *Tx) error {
+		for i := 0; i < 5; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	primary.Update("replicated", func(tx *Tx) error {
+		tx.Delete(&Entry{k: "key-1"})
+		return tx.Touch("key-2", time.Hour)
+	})
+	//Read only and empty transactions are not streamed.
+	primary.View("replicated", func(tx *Tx) error { return nil })
+	primary.Update("replicated", func(tx *Tx) error { return nil })
+	var records bytes.Buffer
+	for len(stream) > 0 {
+		records.Write(<-stream)
+	}
+	if n := bytes.Count(records.Bytes(), []byte("REPLICATE~")); n != 2 {
+		t.Errorf("Failure: expected 2 replication records got %d in %q", n, records.Bytes())
+	}
+	data := records.Bytes()
+	if err := follower.ApplyReplication(bytes.NewReader(data)); err != nil {
+		t.Errorf("Failure: follower.ApplyReplication(...) returned error \"%v\"", err)
+	}
+	//Records that were applied already are skipped.
+	if err := follower.ApplyReplication(bytes.NewReader(data)); err != nil {
+		t.Errorf("Failure: follower.ApplyReplication(...) returned error \"%v\" for repeated records", err)
+	}
+	primary.View("replicated", func(ptx *Tx) error {
+		return follower.View("replicated", func(ftx *Tx) error {
+			for i := 0; i < 5; i++ {
+				k := "key-" + strconv.Itoa(i)
+				p, _ := ptx.Get(&Entry{k: k})
+				f, _ := ftx.Get(&Entry{k: k})
+				if (p == nil) != (f == nil) || (p != nil && !verifyEntry(p, f)) {
+					t.Errorf("Failure: expected follower entry %v got %v", p, f)
+				}
+			}
+			p, _ := ptx.GetWithTombstone("key-1")
+			f, _ := ftx.GetWithTombstone("key-1")
+			if p == nil || f == nil || p.deleted.UnixNano() != f.deleted.UnixNano() {
+				t.Errorf("Failure: expected follower tombstone %v got %v", p, f)
+			}
+			return nil
+		})
+	})
+	//A record that does not follow the last applied record is rejected.
+	primary.Update("replicated", func(tx *Tx) error {
+		e, _ := NewEntry("key-5", "{}", false, nil)
+		_, err := tx.Set(e)
+		return err
+	})
+	<-stream
+	primary.Update("replicated", func(tx *Tx) error {
+		e, _ := NewEntry("key-6", "{}", false, nil)
+		_, err := tx.Set(e)
+		return err
+	})
+	if err := follower.ApplyReplication(bytes.NewReader(<-stream)); err == nil {
+		t.Error("Failure: follower.ApplyReplication(...) expected error for gap in sequence got nil")
+	}
+	if err := follower.ApplyReplication(bytes.NewReader([]byte("5\nDROP\n"))); err == nil {
+		t.Error("Failure: follower.ApplyReplication(...) expected error for invalid record got nil")
+	}
+	primary.Close()
+	if _, ok := <-stream; ok {
+		t.Error("Failure: expected replication stream to be closed when the db is closed")
+	}
+	//A reopened primary numbers its records from 1 again; the follower applies them rather than skipping them.
+	primary, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	primary.Open()
+	stream, _ = primary.ReplicationStream()
+	primary.Update("replicated", func(tx *Tx) error {
+		e, _ := NewEntry("key-7", "{}", false, nil)
+		_, err := tx.Set(e)
+		return err
+	})
+	if err := follower.ApplyReplication(bytes.NewReader(<-stream)); err != nil {
+		t.Errorf("Failure: follower.ApplyReplication(...) returned error \"%v\" for record of reopened primary", err)
+	}
+	follower.View("replicated", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "key-7"}); e == nil {
+			t.Error("Failure: expected follower to apply the first record of the reopened primary")
+		}
+		return nil
+	})
+	primary.DropBucket("replicated")
+	follower.DropBucket("replicated")
+	primary.Close()
+	follower.Close()
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+//RING_DEFAULT_REPLICAS is the number of virtual nodes placed on a ring for each node if not otherwise specified.
+const RING_DEFAULT_REPLICAS int = 160
+
+//Ring assigns keys to nodes, such as the addresses of the StitchDB instances a client shards its keys across, using
+//consistent hashing. Each node is placed on the ring at several virtual positions so the keys are balanced across the
+//nodes, and adding or removing a node moves only the keys assigned to or from that node. A Ring is safe for concurrent
+//use.
+type Ring struct {
+	lock     sync.RWMutex      //Lock for the ring.
+	replicas int               //Number of virtual nodes placed on the ring for each node.
+	hashes   []uint64          //Positions of the virtual nodes in ascending order.
+	owners   map[uint64]string //Node of each virtual node position.
+	nodes    map[string]bool   //Nodes on the ring.
+}
+
+//NewRing creates an empty ring placing the provided number of virtual nodes for each node; a replica count of zero uses
+//RING_DEFAULT_REPLICAS. Returns an error if replicas is negative.
+func NewRing(replicas int) (*Ring, error) {
+	if replicas < 0 {
+		return nil, errors.New("error: ring: replicas must not be negative")
+	}
+	if replicas == 0 {
+		replicas = RING_DEFAULT_REPLICAS
+	}
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+		nodes:    make(map[string]bool),
+	}, nil
+}
+
+//Add places the node on the ring. Adding a node that is already on the ring has no effect.
+func (r *Ring) Add(node string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+	r.place(node)
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+//Remove removes the node from the ring. Removing a node that is not on the ring has no effect.
+func (r *Ring) Remove(node string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+	//Positions are rebuilt so that positions the node shared with other nodes return to them.
+	r.hashes = r.hashes[:0]
+	r.owners = make(map[uint64]string)
+	for n := range r.nodes {
+		r.place(n)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+//place adds the virtual node positions of the node to the ring without sorting the positions. A position already held by
+//another node is kept by the node that sorts first so placement does not depend on the order nodes are added.
+func (r *Ring) place(node string) {
+	for i := 0; i < r.replicas; i++ {
+		h := ringHash(node + "#" + strconv.Itoa(i))
+		if owner, ok := r.owners[h]; !ok {
+			r.hashes = append(r.hashes, h)
+		} else if owner < node {
+			continue
+		}
+		r.owners[h] = node
+	}
+}
+
+//Get returns the node assigned the key: the node of the first virtual node at or after the position of the key on the
+//ring. Returns an empty string if the ring has no nodes.
+func (r *Ring) Get(key string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+//Nodes returns the nodes on the ring in ascending order.
+func (r *Ring) Nodes() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	nodes := make([]string, 0, len(r.nodes))
+	for n := range r.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+//ringHash returns the position of s on the ring. The FNV-1a hash is mixed so that similar strings, such as the virtual
+//nodes of a node, are spread across the ring.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
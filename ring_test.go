@@ -0,0 +1,77 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	if _, err := NewRing(-1); err == nil {
+		t.Error("Failure: NewRing(-1) expected error got nil")
+	}
+	r, err := NewRing(0)
+	if err != nil {
+		t.Errorf("Failure: NewRing(0) returned error \"%v\"", err)
+	}
+	if n := r.Get("key"); n != "" {
+		t.Errorf("Failure: r.Get(\"key\") on empty ring expected \"\" got %v", n)
+	}
+	nodes := []string{"node-a:7000", "node-b:7000", "node-c:7000", "node-d:7000"}
+	for _, n := range nodes {
+		r.Add(n)
+	}
+	r.Add(nodes[0])
+	if got := r.Nodes(); len(got) != 4 {
+		t.Errorf("Failure: r.Nodes() expected 4 nodes got %v", got)
+	}
+	const keys = 20000
+	counts := make(map[string]int)
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		k := "key-" + strconv.Itoa(i)
+		n := r.Get(k)
+		counts[n]++
+		before[k] = n
+	}
+	//Virtual nodes balance the keys; each node is expected to own a quarter of the keys.
+	for _, n := range nodes {
+		if share := float64(counts[n]) / keys; share < 0.2 || share > 0.3 {
+			t.Errorf("Failure: expected %s to own about 25%% of keys got %.1f%%", n, share*100)
+		}
+	}
+	//Adding a node only moves keys to the added node.
+	r.Add("node-e:7000")
+	moved := 0
+	for k, n := range before {
+		if got := r.Get(k); got != n {
+			moved++
+			if got != "node-e:7000" {
+				t.Fatalf("Failure: expected %s to stay on %s or move to node-e:7000 got %s", k, n, got)
+			}
+		}
+	}
+	if share := float64(moved) / keys; share < 0.15 || share > 0.25 {
+		t.Errorf("Failure: expected about 20%% of keys to move to added node got %.1f%%", share*100)
+	}
+	//Removing the node returns the keys to their previous nodes.
+	r.Remove("node-e:7000")
+	r.Remove("node-x:7000")
+	for k, n := range before {
+		if got := r.Get(k); got != n {
+			t.Fatalf("Failure: expected %s to return to %s after remove got %s", k, n, got)
+		}
+	}
+	//Placement does not depend on the order nodes are added.
+	r2, _ := NewRing(0)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		r2.Add(nodes[i])
+	}
+	for k, n := range before {
+		if got := r2.Get(k); got != n {
+			t.Fatalf("Failure: expected %s on %s regardless of add order got %s", k, n, got)
+		}
+	}
+}
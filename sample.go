@@ -0,0 +1,62 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/cbergoon/btree"
+	"github.com/juju/errors"
+)
+
+//SampleN returns up to n distinct live entries of the bucket chosen uniformly at random in no particular order. The
+//entries are chosen by reservoir sampling (Algorithm L) during a single walk of the keys of the bucket: every set of n
+//live entries is equally likely to be returned and all of them are returned if the bucket holds n or fewer. The walk
+//visits each entry once to skip expired and invalid entries but neither copies nor decodes values, and the number of
+//random draws grows with the logarithm of the size of the bucket rather than its size. The tree of the bucket does not
+//expose its nodes so a random descent that would avoid the walk is not possible. Returns an error if n is negative or the
+//db or bucket is closed.
+func (t *Tx) SampleN(n int) ([]*Entry, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot sample; db is in invalid state")
+	}
+	if n < 0 {
+		return nil, errors.New("error: tx: sample size must not be negative")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	res := make([]*Entry, 0, n)
+	//w is the largest of n uniform draws and skip the number of live entries passed over before the next replacement.
+	w := math.Exp(math.Log(rand.Float64()) / float64(n))
+	skip := sampleSkip(w)
+	t.bkt.data.Ascend(func(i btree.Item) bool {
+		e := i.(*Entry)
+		if e.IsExpired() || e.IsInvalid() {
+			return true
+		}
+		if len(res) < n {
+			res = append(res, e)
+		} else if skip > 0 {
+			skip--
+		} else {
+			res[rand.Intn(n)] = e
+			w *= math.Exp(math.Log(rand.Float64()) / float64(n))
+			skip = sampleSkip(w)
+		}
+		return true
+	})
+	return res, nil
+}
+
+//sampleSkip returns the number of live entries reservoir sampling passes over before the next replacement given the
+//largest draw w.
+func sampleSkip(w float64) int {
+	s := math.Floor(math.Log(rand.Float64()) / math.Log(1-w))
+	if !(s >= 0 && s <= math.MaxInt32) {
+		return math.MaxInt32
+	}
+	return int(s)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTx_SampleN(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("sample", opts)
+	db.Update("sample", func(tx *Tx) error {
+		for i := 0; i < 200; i++ {
+			e, _ := NewEntry(fmt.Sprintf("%016x", rand.Uint64()), "{\"live\":true}", false, nil)
+			tx.Set(e)
+		}
+		for i := 0; i < 50; i++ {
+			eopts, _ := NewEntryOptions(ExpireAfter(time.Millisecond))
+			e, _ := NewEntry(fmt.Sprintf("%016x", rand.Uint64()), "{\"live\":false}", false, eopts)
+			tx.Set(e)
+		}
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	check := func(what string, entries []*Entry, want int) map[string]bool {
+		keys := make(map[string]bool)
+		for _, e := range entries {
+			if e.IsExpired() || keys[e.k] {
+				t.Errorf("Failure: %s returned an expired or repeated entry %s", what, e.k)
+			}
+			keys[e.k] = true
+		}
+		if len(entries) != want {
+			t.Errorf("Failure: %s expected %d entries got %d", what, want, len(entries))
+		}
+		return keys
+	}
+	db.View("sample", func(tx *Tx) error {
+		if _, err := tx.SampleN(-1); err == nil {
+			t.Error("Failure: tx.SampleN(-1) expected error got nil")
+		}
+		if entries, err := tx.SampleN(0); err != nil || len(entries) != 0 {
+			t.Errorf("Failure: tx.SampleN(0) expected no entries got %v, error \"%v\"", entries, err)
+		}
+		entries, _ := tx.SampleN(10)
+		check("tx.SampleN(10)", entries, 10)
+		entries, _ = tx.SampleN(1000)
+		check("tx.SampleN(1000)", entries, 200)
+		//Each live entry is expected 100 times in 2000 samples of 10.
+		counts := make(map[string]int)
+		for i := 0; i < 2000; i++ {
+			entries, _ = tx.SampleN(10)
+			for k := range check("tx.SampleN(10)", entries, 10) {
+				counts[k]++
+			}
+		}
+		if len(counts) != 200 {
+			t.Errorf("Failure: tx.SampleN(10) expected to sample each of 200 live entries got %d", len(counts))
+		}
+		for k, count := range counts {
+			if count < 50 || count > 150 {
+				t.Errorf("Failure: tx.SampleN(10) expected entry %s about 100 times got %d", k, count)
+			}
+		}
+		return nil
+	})
+	db.DropBucket("sample")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+//spilledEntry marks a key of the backward changes whose previous entry was written to the spill file of the rollback
+//context. Only persisted entries are spilled so the marker reports that the previous entry persists.
+var spilledEntry = &Entry{}
+
+//rollbackSpill is a temporary file holding previous entries of a transaction that exceeded the rollback spill threshold.
+type rollbackSpill struct {
+	f    *os.File //Temporary file holding the insert statements of the spilled entries; nil if it failed to create.
+	size int64    //Number of bytes of complete statements written to the file.
+}
+
+//spillBackward writes the previous entry to the spill file of the rollback context once the number of previous entries
+//held in memory reaches the configured threshold. Entries whose state is not fully recorded by an insert statement are
+//kept in memory. Returns false if the entry was not spilled and must be held in memory.
+func (t *Tx) spillBackward(prev *Entry) bool {
+	limit := t.db.config.spillThreshold
+	if limit == 0 || t.rbctx.held < limit || (t.rbctx.spill != nil && t.rbctx.spill.f == nil) || prev.invalid || !prev.persists() || prev.opts.expTTL > 0 || prev.opts.invTTL > 0 {
+		return false
+	}
+	if t.rbctx.spill == nil {
+		dir := ""
+		if t.db.config.persist {
+			dir = strings.TrimSpace(t.db.config.dirPath)
+		}
+		f, err := ioutil.TempFile(dir, "stitch-rollback-")
+		if err != nil {
+			t.db.config.logger.Errorf("%v", errors.Annotate(err, "error: tx: failed to create rollback spill file"))
+			//The remaining previous entries of the transaction are held in memory.
+			t.rbctx.spill = &rollbackSpill{}
+			return false
+		}
+		t.rbctx.spill = &rollbackSpill{f: f}
+	}
+	stmt := prev.insertStmt(false)
+	if _, err := t.rbctx.spill.f.WriteAt(stmt, t.rbctx.spill.size); err != nil {
+		t.db.config.logger.Errorf("%v", errors.Annotate(err, "error: tx: failed to write rollback spill file"))
+		return false
+	}
+	t.rbctx.spill.size += int64(len(stmt))
+	return true
+}
+
+//spilled calls f with each entry of the spill file of the rollback context in the order the entries were spilled until f
+//returns false. Entries that cannot be read are logged and skipped.
+func (t *Tx) spilled(f func(e *Entry) bool) {
+	if t.rbctx.spill == nil || t.rbctx.spill.f == nil {
+		return
+	}
+	rd := bufio.NewReader(io.NewSectionReader(t.rbctx.spill.f, 0, t.rbctx.spill.size))
+	for {
+		stmt, err := readStmt(rd)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.db.config.logger.Errorf("%v", errors.Annotate(err, "error: tx: failed to read rollback spill file"))
+			return
+		}
+		_, parts, err := parseEntryStmtTypeName(stmt)
+		var e *Entry
+		if err == nil {
+			e, err = NewEntryFromStmt(parts)
+		}
+		if err != nil {
+			t.db.config.logger.Errorf("%v", errors.Annotate(err, "error: tx: failed to parse rollback spill file"))
+			continue
+		}
+		if !f(e) {
+			return
+		}
+	}
+}
+
+//spilledEntryOf returns the previous entry of key from the spill file of the rollback context; nil if not found.
+func (t *Tx) spilledEntryOf(key string) *Entry {
+	var res *Entry
+	t.spilled(func(e *Entry) bool {
+		if e.k == key {
+			res = e
+			return false
+		}
+		return true
+	})
+	return res
+}
+
+//closeSpill closes and removes the spill file of the rollback context. Called when the transaction completes.
+func (t *Tx) closeSpill() {
+	if t.rbctx.spill == nil || t.rbctx.spill.f == nil {
+		t.rbctx.spill = nil
+		return
+	}
+	name := t.rbctx.spill.f.Name()
+	t.rbctx.spill.f.Close()
+	if err := os.Remove(name); err != nil {
+		t.db.config.logger.Errorf("%v", errors.Annotate(err, "error: tx: failed to remove rollback spill file"))
+	}
+	t.rbctx.spill = nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTx_RollbackSpill(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10), RollbackSpillThreshold(2))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("spill", opts)
+	db.Update("spill", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	var spill string
+	db.Update("spill", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"value\":\"changed\"}", false, nil)
+			tx.Set(e)
+		}
+		tx.Delete(&Entry{k: "key-9"})
+		e, _ := NewEntry("key-new", "{}", false, nil)
+		tx.Set(e)
+		if tx.rbctx.held != 2 || tx.rbctx.spill == nil {
+			t.Fatalf("Failure: expected 2 previous entries held in memory and the rest spilled got %d held", tx.rbctx.held)
+		}
+		spill = tx.rbctx.spill.f.Name()
+		if e, _ := tx.Refresh("key-7"); e == nil || e.GetValue() != "{\"value\":7}" {
+			t.Errorf("Failure: tx.Refresh(\"key-7\") expected spilled entry {\"value\":7} got %v", e)
+		}
+		return fmt.Errorf("rollback")
+	})
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Errorf("Failure: expected spill file %s to be removed after rollback got error \"%v\"", spill, err)
+	}
+	expect := func(when string, value func(i int) string) {
+		db.View("spill", func(tx *Tx) error {
+			if size, _ := tx.Size(""); size != 10 {
+				t.Errorf("Failure: expected 10 entries %s got %d", when, size)
+			}
+			for i := 0; i < 10; i++ {
+				e, _ := tx.Get(&Entry{k: "key-" + strconv.Itoa(i)})
+				if e == nil || e.GetValue() != value(i) {
+					t.Errorf("Failure: expected key-%d to be %s %s got %v", i, value(i), when, e)
+				}
+			}
+			return nil
+		})
+	}
+	expect("after rollback", func(i int) string { return "{\"value\":" + strconv.Itoa(i) + "}" })
+	db.Update("spill", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"value\":\"changed\"}", false, nil)
+			tx.Set(e)
+		}
+		spill = tx.rbctx.spill.f.Name()
+		return nil
+	})
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Errorf("Failure: expected spill file %s to be removed after commit got error \"%v\"", spill, err)
+	}
+	changed := func(i int) string { return "{\"value\":\"changed\"}" }
+	expect("after commit", changed)
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen", changed)
+	db.DropBucket("spill")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
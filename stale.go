@@ -0,0 +1,116 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+//errLockTimeout is returned when the lock on a bucket was not obtained before the timeout of a transaction.
+var errLockTimeout = errors.New("error: bucket: lock timeout")
+
+//GetTimeout returns the live entry for the provided key as a read only transaction calling Get would, waiting at most
+//timeout for the lock on the bucket. If the lock was not obtained before the timeout and the bucket enables StaleReads,
+//the entry last read or committed for the key is returned marked as stale provided it was known current within the
+//bound of the bucket; IsStale reports whether the entry was returned from the cache. Returns nil if the entry is
+//invalid, expired, or not found. Returns a lock timeout error if the lock was not obtained and no entry is cached. A
+//timeout that is not positive waits indefinitely.
+func (db *StitchDB) GetTimeout(bucket, key string, timeout time.Duration) (*Entry, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return nil, errors.New("error: db: db is closed")
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: db: invalid bucket")
+	}
+	if b == nil {
+		return nil, errors.New("error: db: invalid bucket")
+	}
+	var res *Entry
+	err = b.handleTx(MODE_READ, timeout, func(t *Tx) error {
+		res, err = t.Get(&Entry{k: key})
+		return err
+	})
+	if err == errLockTimeout && b.stale != nil {
+		if e, ok := b.stale.get(key, time.Now()); ok {
+			if e.IsExpired() || e.IsInvalid() {
+				return nil, nil
+			}
+			e.stale = true
+			return e, nil
+		}
+	}
+	return res, err
+}
+
+//staleCache holds the entries of a bucket last read by Get in read only transactions with the time they were known
+//current. Cached entries are refreshed when a commit writes their key. Concurrent read only transactions share the
+//cache so it is guarded by its own lock.
+type staleCache struct {
+	lock  sync.Mutex           //Lock for the cache.
+	bound time.Duration        //Longest time an entry is served after it was known current.
+	items map[string]staleItem //Cached entries by key.
+}
+
+//staleItem is an entry held by a stale cache.
+type staleItem struct {
+	e  *Entry    //Copy of the entry.
+	at time.Time //Time the entry was known current.
+}
+
+//newStaleCache returns a stale cache serving entries known current within bound or nil if bound is zero.
+func newStaleCache(bound time.Duration) *staleCache {
+	if bound <= 0 {
+		return nil
+	}
+	return &staleCache{bound: bound, items: make(map[string]staleItem)}
+}
+
+//put caches a copy of the entry as known current now.
+func (c *staleCache) put(e *Entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items[e.k] = staleItem{e: e.Clone(), at: time.Now()}
+}
+
+//update caches a copy of the committed entry for the key if the key is cached; the key is removed if the entry is nil.
+func (c *staleCache) update(key string, e *Entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.items[key]; !ok {
+		return
+	}
+	if e == nil {
+		delete(c.items, key)
+		return
+	}
+	c.items[key] = staleItem{e: e.Clone(), at: time.Now()}
+}
+
+//get returns a copy of the entry cached for the key if it was known current within the bound of the cache at now.
+func (c *staleCache) get(key string, now time.Time) (*Entry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	item, ok := c.items[key]
+	if !ok || now.Sub(item.at) > c.bound {
+		return nil, false
+	}
+	return item.e.Clone(), true
+}
+
+//prune removes the entries that were not known current within the bound of the cache at now.
+func (c *staleCache) prune(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, item := range c.items {
+		if now.Sub(item.at) > c.bound {
+			delete(c.items, key)
+		}
+	}
+}
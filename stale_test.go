@@ -0,0 +1,95 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStitchDB_GetTimeout(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), StaleReads(time.Hour))
+	db.CreateBucket("stale", opts)
+	plain, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("stale-off", plain)
+	for _, name := range []string{"stale", "stale-off"} {
+		db.Update(name, func(tx *Tx) error {
+			for _, k := range []string{"read", "unread", "deleted"} {
+				e, _ := NewEntry(k, "{\"v\":1}", false, nil)
+				tx.Set(e)
+			}
+			return nil
+		})
+	}
+	for _, k := range []string{"read", "deleted"} {
+		e, err := db.GetTimeout("stale", k, 0)
+		if err != nil || e == nil || e.IsStale() {
+			t.Errorf("Failure: db.GetTimeout(\"stale\", %q, 0) expected current entry got %v, \"%v\"", k, e, err)
+		}
+	}
+	db.GetTimeout("stale-off", "read", 0)
+	//Commits refresh the cached entries of the keys they write.
+	db.Update("stale", func(tx *Tx) error {
+		e, _ := NewEntry("read", "{\"v\":2}", false, nil)
+		tx.Set(e)
+		tx.Delete(&Entry{k: "deleted"})
+		return nil
+	})
+
+	held := make(chan *Tx)
+	release := make(chan bool)
+	done := make(chan bool)
+	hold := func(name string) {
+		go func() {
+			tx, _ := db.Begin(name, MODE_READ_WRITE)
+			held <- tx
+			<-release
+			tx.RollbackTx()
+			done <- true
+		}()
+		<-held
+	}
+	hold("stale")
+	e, err := db.GetTimeout("stale", "read", 20*time.Millisecond)
+	if err != nil || e == nil || !e.IsStale() || e.GetValue() != "{\"v\":2}" {
+		t.Errorf("Failure: db.GetTimeout(\"stale\", \"read\") under held lock expected stale committed entry got %v, \"%v\"", e, err)
+	}
+	if e, err := db.GetTimeout("stale", "unread", 20*time.Millisecond); err == nil || e != nil {
+		t.Errorf("Failure: db.GetTimeout(\"stale\", \"unread\") under held lock expected lock timeout error got %v, \"%v\"", e, err)
+	}
+	if e, err := db.GetTimeout("stale", "deleted", 20*time.Millisecond); err == nil || e != nil {
+		t.Errorf("Failure: db.GetTimeout(\"stale\", \"deleted\") under held lock expected lock timeout error got %v, \"%v\"", e, err)
+	}
+	//Entries known current longer ago than the bound are not served.
+	cache := db.buckets["stale"].stale
+	cache.lock.Lock()
+	cache.bound = time.Nanosecond
+	cache.lock.Unlock()
+	if e, err := db.GetTimeout("stale", "read", 20*time.Millisecond); err == nil || e != nil {
+		t.Errorf("Failure: db.GetTimeout(\"stale\", \"read\") past bound expected lock timeout error got %v, \"%v\"", e, err)
+	}
+	release <- true
+	<-done
+	hold("stale-off")
+	if e, err := db.GetTimeout("stale-off", "read", 20*time.Millisecond); err == nil || e != nil {
+		t.Errorf("Failure: db.GetTimeout(\"stale-off\", \"read\") without StaleReads expected lock timeout error got %v, \"%v\"", e, err)
+	}
+	release <- true
+	<-done
+	if e, err := db.GetTimeout("stale", "read", 0); err != nil || e == nil || e.IsStale() {
+		t.Errorf("Failure: db.GetTimeout(\"stale\", \"read\", 0) after release expected current entry got %v, \"%v\"", e, err)
+	}
+	db.DropBucket("stale")
+	db.DropBucket("stale-off")
+	db.Close()
+	if db.open {
+		t.Errorf("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -6,15 +6,21 @@ package stitchdb
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/cbergoon/btree"
 	"github.com/dhconnelly/rtreego"
 	"github.com/juju/errors"
+	"github.com/tidwall/gjson"
 )
 
+//FIELD_CACHE_SIZE is the number of entries whose parsed field values a transaction keeps for Field.
+const FIELD_CACHE_SIZE int = 256
+
 //RbCtx preserves the state of the tree during a transaction representing the changes made to allow for commits/rollbacks.
 type RbCtx struct {
 	//Holds the backward changes made during the transaction. Keys with a nil value were inserted
@@ -25,10 +31,26 @@ type RbCtx struct {
 	//and need to be deleted on rollback. Keys with a non-nil value were dropped and need to be replaced
 	//with the value on rollback.
 	backwardIndex map[string]*Index
+	//Holds the backward tombstone changes made during the transaction. Keys with a nil value had no tombstone
+	//before the transaction and the tombstone should be deleted. Keys with a non-nil value had a tombstone that
+	//should be restored on rollback.
+	backwardTombstone map[string]*Entry
+	//Holds the backward counter changes made during the transaction. Keys with a nil value did not exist before the
+	//transaction and should be deleted. Keys with a non-nil value should be restored to the value. The keys are the
+	//counters changed by the transaction. Created on the first counter change.
+	backwardCounter map[string]*counter
 	//Holds the forward changes made during the transaction. Keys with a nil value were deleted during
 	//the transaction and should be deleted. Keys with a non-nil value were inserted during the transaction
 	//and should be inserted.
 	forward map[string]*Entry
+	//Holds the keys of forward changes that only changed the expiry of the entry. The change is persisted with an
+	//expire statement rather than the entire entry.
+	forwardExpire map[string]bool
+	//Number of previous entries held in backward. Once the rollback spill threshold is reached further previous
+	//entries are written to spill and their keys hold spilledEntry. The forward changes reference the entries held by
+	//the bucket so only their keys add to the memory of the transaction.
+	held  int
+	spill *rollbackSpill
 }
 
 //Tx represents the a transaction including rollback information.
@@ -39,6 +61,22 @@ type Tx struct {
 	rbctx     *RbCtx                  //Context containing changes to bucket
 	iterating bool                    //True if iterating over tree; used to prevent effects of updates while iterating.
 	sysperf   *SystemPerformanceEntry //Slice of entries to be committed; contains matrics on tx operations
+	scanned   int                     //Number of entries passed to iterators during the current scan.
+	manual    bool                    //True if the tx was started with Begin and is completed by the caller.
+	done      bool                    //True once a tx started with Begin has been committed or rolled back.
+	started   time.Time               //Time the tx obtained the lock on the bucket.
+	id        uint64                  //Id of the tx in the active transactions of the db; zero if not registered.
+	goid      int64                   //Id of the goroutine that started the tx while it holds the lock; zero otherwise.
+	//Field values parsed by Field during the tx keyed by entry then path; discarded with the tx.
+	fields map[*Entry]map[string]gjson.Result
+}
+
+//TxInfo describes a transaction that holds the lock on its bucket as returned by ActiveTransactions.
+type TxInfo struct {
+	Bucket  string    //Name of the bucket the transaction operates on.
+	Mode    RWMode    //Describes if the transaction is read-only or read-write.
+	Started time.Time //Time the transaction obtained the lock on the bucket.
+	Manual  bool      //True if the transaction was started with Begin and is completed by the caller.
 }
 
 //newTx creates a new transaction for the DB and bucket provided with the RW specified modifier.
@@ -48,9 +86,11 @@ func newTx(db *StitchDB, bkt *Bucket, mode RWMode) (*Tx, error) {
 		bkt:  bkt,
 		mode: mode,
 		rbctx: &RbCtx{
-			backward:      make(map[string]*Entry), //Changes to main tree during tx to rollback (backward).
-			backwardIndex: make(map[string]*Index), //Changes to the index trees during tx to rollback (backward).
-			forward:       make(map[string]*Entry), //Changes to main tree during tx to commit (forward).
+			backward:          make(map[string]*Entry), //Changes to main tree during tx to rollback (backward).
+			backwardIndex:     make(map[string]*Index), //Changes to the index trees during tx to rollback (backward).
+			backwardTombstone: make(map[string]*Entry), //Changes to the tombstones during tx to rollback (backward).
+			forward:           make(map[string]*Entry), //Changes to main tree during tx to commit (forward).
+			forwardExpire:     make(map[string]bool),   //Expiry only changes to main tree during tx to commit (forward).
 		},
 	}, nil
 }
@@ -59,19 +99,10 @@ func newTx(db *StitchDB, bkt *Bucket, mode RWMode) (*Tx, error) {
 //equivalent to the state of the bucket pre-transaction.
 func (t *Tx) rollbackTx() error {
 	t.sysperf.Rollback = true
-	for key, entry := range t.rbctx.backward {
-		if entry == nil { //Entry was inserted during transaction; delete
-			t.bkt.delete(&Entry{k: key})
-			for _, ind := range t.bkt.indexes {
-				ind.t.Delete(&Entry{k: key})
-			}
-		} else { //Entry was deleted or overwritten during transaction; insert
-			t.bkt.insert(entry)
-			for _, ind := range t.bkt.indexes {
-				ind.t.ReplaceOrInsert(entry)
-			}
-		}
-	}
+	start := time.Now()
+	t.revert()
+	t.closeSpill()
+	t.db.config.metrics.RecordDuration(t.bkt.name, METRIC_ROLLBACK, time.Since(start))
 	t.unlock()
 	if t.bkt.name != "_sysperf" {
 		t.db.Update("_sysperf", func(t *Tx) error {
@@ -94,27 +125,170 @@ func (t *Tx) rollbackTx() error {
 	return nil
 }
 
-//commitTx iterates over forward changes to the bucket and persists changes to the AOF.
+//revert applies the backward changes stored in rollback context rbctx to the bucket. The caller holds the lock on the
+//bucket.
+func (t *Tx) revert() {
+	//Bucket insert/delete maintain the expires, invalidation, index, and rtree structures.
+	for key, entry := range t.rbctx.backward {
+		if entry == nil { //Entry was inserted during transaction; delete
+			t.bkt.delete(&Entry{k: key})
+		} else if entry != spilledEntry { //Entry was deleted or overwritten during transaction; insert
+			t.bkt.insert(entry)
+		}
+	}
+	t.spilled(func(e *Entry) bool {
+		t.bkt.insert(e)
+		return true
+	})
+	for key, ts := range t.rbctx.backwardTombstone {
+		if ts == nil { //Tombstone was created during transaction; delete
+			t.bkt.deleteTombstone(key)
+		} else { //Tombstone was removed or replaced during transaction; restore
+			t.bkt.setTombstone(ts)
+		}
+	}
+	for pattern, index := range t.rbctx.backwardIndex {
+		if index == nil { //Index was created during transaction; drop
+			delete(t.bkt.indexes, pattern)
+		} else { //Index was dropped during transaction; restore and rebuild as entries may have changed
+			t.bkt.indexes[pattern] = index
+			index.rebuild()
+		}
+	}
+	for name, c := range t.rbctx.backwardCounter {
+		if c == nil { //Counter was created during transaction; delete
+			delete(t.bkt.counters, name)
+		} else { //Counter was changed during transaction; restore
+			t.bkt.counters[name] = *c
+		}
+	}
+	//Indexes suspended during the transaction are rebuilt from the restored entries.
+	t.bkt.resumeIndexes()
+}
+
+//commitTx iterates over forward changes to the bucket and persists changes to the AOF. If the changes cannot be written
+//they are removed from the AOF and rolled back in the bucket and an error is returned.
 func (t *Tx) commitTx() error {
-	sysperf := t.sysperf
-	sysperf.Commit = true
+	t.sysperf.Commit = true
 	if !t.db.open {
 		return errors.New("error: tx: db is closed")
 	}
 	if t.mode == MODE_READ {
 		return errors.New("error: tx: cannot commit read only transaction")
 	}
+	start := time.Now()
+	var keys []string
+	var werr error
 	if t.mode == MODE_READ_WRITE {
-		for key, entry := range t.rbctx.forward {
+		keys, _, werr = t.writeChanges()
+	}
+	return t.completeCommit(keys, start, werr)
+}
+
+//writeChanges writes the statements of the changes made by a read/write transaction to the bucket file and syncs the
+//file as configured. Returns the changed keys in key order and the mark of the bucket file before the statements; the
+//statements are removed with discardAOF(mark). If the statements could not be written they are discarded, the bucket is
+//returned to its state before the transaction, and the error is returned.
+func (t *Tx) writeChanges() ([]string, aofMark, error) {
+	t.bkt.resumeIndexes()
+	//Write changes in key order so that the AOF, and any tree rebuilt from it, is deterministic.
+	keys := make([]string, 0, len(t.rbctx.forward))
+	for key := range t.rbctx.forward {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	//Statements of commits to buckets other than the system buckets are collected for the replication streams.
+	if t.bkt != t.db.system && t.bkt != t.db.systemperf && t.db.replicating() {
+		t.bkt.replbuf = []byte{}
+	}
+	//Statements written after the mark are discarded if the commit fails.
+	mark, werr := t.bkt.markAOF()
+	if werr == nil {
+		for _, key := range keys {
+			entry := t.rbctx.forward[key]
+			//Entries that are not persisted have no statements in the bucket file.
+			prev, prevPersisted := t.rbctx.backward[key], true
+			if prev != nil {
+				prevPersisted = prev.persists()
+			}
+			lsn := t.db.nextLSN()
+			//The insertion tree is ordered by log sequence number; the entry is moved to its new position.
+			if entry != nil && t.bkt.insertion != nil {
+				t.bkt.insertion.Delete(entry)
+			}
 			if entry == nil { //Entry was deleted or overwritten during transaction; delete/overwrite
-				t.bkt.writeDeleteEntry(&Entry{k: key})
+				if prevPersisted {
+					werr = t.bkt.writeDeleteEntry(&Entry{k: key, lsn: lsn})
+				}
+				if ts := t.bkt.getTombstone(key); werr == nil && ts != nil && ts.persists() {
+					ts.lsn = lsn
+					werr = t.bkt.writeTombstoneEntry(ts)
+				}
+			} else if !entry.persists() { //Entry is not persisted; remove any persisted entry it replaced
+				entry.lsn = lsn
+				if prev != nil && prevPersisted {
+					werr = t.bkt.writeDeleteEntry(&Entry{k: key, lsn: lsn})
+				}
+			} else if t.rbctx.forwardExpire[key] { //Only the expiry of the entry changed during transaction; expire
+				entry.lsn = lsn
+				werr = t.bkt.writeExpireEntry(entry)
 			} else { //Entry was inserted during transaction; insert
-				t.bkt.writeInsertEntry(entry)
+				entry.lsn = lsn
+				werr = t.bkt.writeInsertEntry(entry)
+			}
+			if entry != nil && t.bkt.insertion != nil {
+				t.bkt.insertion.ReplaceOrInsert(entry)
+			}
+			if werr != nil {
+				break
+			}
+		}
+		if werr == nil {
+			werr = t.writeCounters()
+		}
+		if werr == nil {
+			werr = t.bkt.writeAOFBuf()
+		}
+		if werr != nil {
+			if derr := t.bkt.discardAOF(mark); derr != nil {
+				t.db.config.logger.Errorf("%v", errors.ErrorStack(derr))
+			}
+		}
+	}
+	if werr != nil {
+		//The changes were not persisted; return the bucket to its state before the transaction.
+		t.revert()
+		t.bkt.replbuf = nil
+	}
+	return keys, mark, werr
+}
+
+//completeCommit completes a commit of the changed keys started at start whose statements were written by writeChanges
+//with the provided error. If werr is nil the statements are sent to the replication streams and the stale read cache is
+//refreshed. The lock on the bucket is released and, if werr is nil, the transaction is recorded in the system
+//performance bucket. Returns werr annotated if it is not nil.
+func (t *Tx) completeCommit(keys []string, start time.Time, werr error) error {
+	sysperf := t.sysperf
+	if t.mode == MODE_READ_WRITE {
+		if werr == nil {
+			if len(t.bkt.replbuf) > 0 {
+				t.db.replicate(t.bkt.name, t.bkt.replbuf)
+			}
+			if t.bkt.stale != nil {
+				for _, key := range keys {
+					t.bkt.stale.update(key, t.bkt.get(&Entry{k: key}))
+				}
 			}
 		}
-		t.bkt.writeAOFBuf()
+		t.bkt.replbuf = nil
+		t.db.config.metrics.RecordDuration(t.bkt.name, METRIC_COMMIT, time.Since(start))
+		t.db.config.metrics.IncrCount(t.bkt.name, METRIC_COMMIT_ENTRIES, len(keys))
+		t.closeSpill()
 	}
 	t.unlock()
+	if werr != nil {
+		return errors.Annotate(werr, "error: tx: failed to write changes")
+	}
 
 	if t.bkt.name != "_sysperf" {
 		t.db.Update("_sysperf", func(t *Tx) error {
@@ -137,24 +311,148 @@ func (t *Tx) commitTx() error {
 	return nil
 }
 
+//CommitTx completes a transaction started with Begin. Read/write transactions are committed and read only transactions
+//are rolled back. Returns an error if the transaction was not started with Begin, has already been completed, or failed
+//to commit.
+func (t *Tx) CommitTx() error {
+	if err := t.finish(); err != nil {
+		return err
+	}
+	if t.mode == MODE_READ_WRITE {
+		return t.commitTx()
+	}
+	return t.rollbackTx()
+}
+
+//RollbackTx completes a transaction started with Begin by rolling back its changes. Returns an error if the transaction
+//was not started with Begin or has already been completed.
+func (t *Tx) RollbackTx() error {
+	if err := t.finish(); err != nil {
+		return err
+	}
+	return t.rollbackTx()
+}
+
+//finish marks a transaction started with Begin as completed and records its performance entry. Returns an error if the
+//transaction was not started with Begin or has already been completed.
+func (t *Tx) finish() error {
+	if !t.manual {
+		return errors.New("error: tx: transaction is managed by View or Update")
+	}
+	if t.done {
+		return errors.New("error: tx: transaction has already been completed")
+	}
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot complete transaction")
+	}
+	t.done = true
+	t.sysperf = &SystemPerformanceEntry{
+		Transaction: true,
+		Bucket:      t.bkt.name,
+		Mode:        t.mode,
+		TxTime:      time.Since(t.started),
+	}
+	return nil
+}
+
 //lock is a helper function to obtain a lock on the bucket appropriately based on the RW modifier of the transaction.
 func (t *Tx) lock() {
 	if t.mode == MODE_READ {
+		if t.bkt.rdrsem != nil {
+			t.bkt.rdrsem <- struct{}{}
+		}
 		t.bkt.bktlock.RLock()
 	} else if t.mode == MODE_READ_WRITE {
 		t.bkt.bktlock.Lock()
 	}
 }
 
+//lockTimeout obtains the lock on the bucket as lock does waiting at most timeout. Returns false if the lock was not
+//obtained before the timeout; the pending acquisition is released as soon as it completes so a timed out transaction
+//never holds the lock. Waits indefinitely if the timeout is not positive.
+func (t *Tx) lockTimeout(timeout time.Duration) bool {
+	if timeout <= 0 {
+		t.lock()
+		return true
+	}
+	locked := make(chan struct{}, 1)
+	go func() {
+		t.lock()
+		locked <- struct{}{}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-locked:
+		return true
+	case <-timer.C:
+		go func() {
+			<-locked
+			t.unlock()
+		}()
+		return false
+	}
+}
+
 //unlock is a helper function to release the lock on the bucket appropriately based on the RW modifier of the transaction.
 func (t *Tx) unlock() {
+	t.db.deregisterTx(t)
+	t.bkt.disown(t)
 	if t.mode == MODE_READ {
 		t.bkt.bktlock.RUnlock()
+		if t.bkt.rdrsem != nil {
+			<-t.bkt.rdrsem
+		}
 	} else if t.mode == MODE_READ_WRITE {
 		t.bkt.bktlock.Unlock()
 	}
 }
 
+//recordBackward records the state of key prior to the transaction. Only the first change to a key is recorded so that a
+//rollback restores the pre-transaction entry rather than an intermediate entry written during the transaction.
+func (t *Tx) recordBackward(key string, prev *Entry) {
+	if _, ok := t.rbctx.backward[key]; !ok {
+		if prev != nil && t.spillBackward(prev) {
+			t.rbctx.backward[key] = spilledEntry
+			return
+		}
+		t.rbctx.backward[key] = prev
+		if prev != nil {
+			t.rbctx.held++
+		}
+	}
+}
+
+//recordBackwardTombstone records the tombstone of key prior to the transaction. Only the first change to a key is
+//recorded.
+func (t *Tx) recordBackwardTombstone(key string, prev *Entry) {
+	if _, ok := t.rbctx.backwardTombstone[key]; !ok {
+		t.rbctx.backwardTombstone[key] = prev
+	}
+}
+
+//iterator wraps the provided callback for use with the bucket trees. Entries are cloned before being passed to f if the
+//bucket is configured with IterateClones.
+func (t *Tx) iterator(f func(e *Entry) bool) func(i btree.Item) bool {
+	if t.bkt.options.clones {
+		return func(i btree.Item) bool {
+			t.scanned++
+			return f(itemEntry(i).Clone())
+		}
+	}
+	return func(i btree.Item) bool {
+		t.scanned++
+		return f(itemEntry(i))
+	}
+}
+
+//recordScan records the duration of a scan started at start and the number of entries passed to its iterator.
+func (t *Tx) recordScan(start time.Time) {
+	t.db.config.metrics.RecordDuration(t.bkt.name, METRIC_SCAN, time.Since(start))
+	t.db.config.metrics.IncrCount(t.bkt.name, METRIC_SCAN_ENTRIES, t.scanned)
+	t.scanned = 0
+}
+
 //setIterating sets the iterating flag to the specified value.
 func (t *Tx) setIterating(i bool) {
 	t.iterating = i
@@ -162,13 +460,12 @@ func (t *Tx) setIterating(i bool) {
 
 //Ascend iterates over the items in the bucket using the specified index for each item calling the provided function f
 //terminating only when there are no more entries in the bucket or the provided function returns false. An empty string
-//represents no index in which case entries will use the default key ordering.
+//represents no index in which case entries will use the default key ordering. The default key ordering is stable across
+//restarts; a bucket reloaded from its file yields the same sequence for identical data.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) Ascend(index string, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
@@ -185,34 +482,61 @@ func (t *Tx) Ascend(index string, f func(e *Entry) bool) error {
 //ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) AscendGreaterOrEqual(index string, pivot *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.AscendGreaterOrEqual(pivot, i)
+		t.bkt.indexes[index].t.AscendGreaterOrEqual(&indexPivot{e: pivot}, i)
 	} else {
 		t.bkt.data.AscendGreaterOrEqual(pivot, i)
 	}
 	return nil
 }
 
+//AscendAfter iterates over the items in the bucket using the specified index for each item after the pivot entry calling
+//the provided function f terminating only when there are no more entries in the bucket or the provided function returns
+//false. Unlike AscendGreaterOrEqual entries with a field value equal to that of the pivot are ordered by key as they are
+//in the index, so passing the last entry visited as the pivot resumes iteration after it even if other entries share its
+//field value. An empty string represents no index in which case entries with a key greater than the key of the pivot are
+//visited in the default key ordering.
+//Note: only the portion of the entry that the index is built with and the key need to be populated.
+func (t *Tx) AscendAfter(index string, pivot *Entry, f func(e *Entry) bool) error {
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
+	t.setIterating(true)
+	defer t.setIterating(false)
+	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
+		idx := t.bkt.indexes[index]
+		idx.t.AscendGreaterOrEqual(&indexPivot{e: pivot, keyed: true}, func(item btree.Item) bool {
+			if itemEntry(item).k == pivot.k && idx.compareValues(idx.itemValue(item), gjson.Get(pivot.v, idx.ppath)) == 0 {
+				return true
+			}
+			return i(item)
+		})
+	} else {
+		t.bkt.data.AscendGreaterOrEqual(pivot, func(item btree.Item) bool {
+			if item.(*Entry).k == pivot.k {
+				return true
+			}
+			return i(item)
+		})
+	}
+	return nil
+}
+
 //AscendLessThan iterates over the items in the bucket using the specified index for each item less than the pivot entry
 //calling the provided function f. Iteration terminates only when there are no more entries less than pivot in the bucket
 //or the provided function returns false. An empty string represents no index in which case entries will use the default
 //key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) AscendLessThan(index string, pivot *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.AscendLessThan(pivot, i)
+		t.bkt.indexes[index].t.AscendLessThan(&indexPivot{e: pivot}, i)
 	} else {
 		t.bkt.data.AscendLessThan(pivot, i)
 	}
@@ -225,14 +549,12 @@ func (t *Tx) AscendLessThan(index string, pivot *Entry, f func(e *Entry) bool) e
 //key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) AscendRange(index string, greaterOrEqual *Entry, lessThan *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.AscendRange(greaterOrEqual, lessThan, i)
+		t.bkt.indexes[index].t.AscendRange(&indexPivot{e: greaterOrEqual}, &indexPivot{e: lessThan}, i)
 	} else {
 		t.bkt.data.AscendRange(greaterOrEqual, lessThan, i)
 	}
@@ -244,10 +566,8 @@ func (t *Tx) AscendRange(index string, greaterOrEqual *Entry, lessThan *Entry, f
 //represents no index in which case entries will use the default key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) Descend(index string, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
@@ -264,34 +584,35 @@ func (t *Tx) Descend(index string, f func(e *Entry) bool) error {
 //default key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) DescendGreaterThan(index string, pivot *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.DescendGreaterThan(pivot, i)
+		t.bkt.indexes[index].t.DescendGreaterThan(&indexPivot{e: pivot, high: true}, i)
 	} else {
 		t.bkt.data.DescendGreaterThan(pivot, i)
 	}
 	return nil
 }
 
-//DescendLessOrEqual iterates over the items in the bucket using the specified index for each item less than the pivot entry
-//calling the provided function f. Iteration terminates only when there are no more entries less than or equal to pivot
-//in the bucket or the provided function returns false. An empty string represents no index in which case entries will
-//use the default key ordering.
+//DescendLessOrEqual iterates over the items in the bucket using the specified index for each item less than or equal to
+//the pivot entry calling the provided function f. Expired and invalid entries are skipped. Iteration terminates only
+//when there are no more entries less than or equal to pivot in the bucket or the provided function returns false. An
+//empty string represents no index in which case entries will use the default key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) DescendLessOrEqual(index string, pivot *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(func(e *Entry) bool {
+		if e.IsExpired() || e.IsInvalid() {
+			return true
+		}
+		return f(e)
+	})
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.DescendLessOrEqual(pivot, i)
+		t.bkt.indexes[index].t.DescendLessOrEqual(&indexPivot{e: pivot, high: true}, i)
 	} else {
 		t.bkt.data.DescendLessOrEqual(pivot, i)
 	}
@@ -304,20 +625,78 @@ func (t *Tx) DescendLessOrEqual(index string, pivot *Entry, f func(e *Entry) boo
 //key ordering.
 //Note: only the portion of the entry that the index is built with needs to be populated.
 func (t *Tx) DescendRange(index string, lessOrEqual *Entry, greaterThan *Entry, f func(e *Entry) bool) error {
-	i := func(i btree.Item) bool {
-		eItem := i.(*Entry)
-		return f(eItem)
-	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
 	t.setIterating(true)
 	defer t.setIterating(false)
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		t.bkt.indexes[index].t.DescendRange(lessOrEqual, greaterThan, i)
+		t.bkt.indexes[index].t.DescendRange(&indexPivot{e: lessOrEqual, high: true}, &indexPivot{e: greaterThan, high: true}, i)
 	} else {
 		t.bkt.data.DescendRange(lessOrEqual, greaterThan, i)
 	}
 	return nil
 }
 
+//AscendExpired iterates over the entries in the bucket in key order that have expired but have not yet been removed by
+//the manager calling the provided function f for each. Iteration terminates when there are no more entries or the
+//provided function returns false. Intended for diagnosing the delay between expiry and removal.
+func (t *Tx) AscendExpired(f func(e *Entry) bool) error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot iterate expired entries; db is in invalid state")
+	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
+	t.setIterating(true)
+	defer t.setIterating(false)
+	t.bkt.data.Ascend(func(item btree.Item) bool {
+		if !item.(*Entry).IsExpired() {
+			return true
+		}
+		return i(item)
+	})
+	return nil
+}
+
+//AscendSince iterates over the entries in the bucket in key order that were written by a commit with a log sequence
+//number greater than lsn calling the provided function f for each. Iteration terminates when there are no more entries or
+//the provided function returns false. Deleted entries are not visited.
+func (t *Tx) AscendSince(lsn uint64, f func(e *Entry) bool) error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot iterate entries; db is in invalid state")
+	}
+	defer t.recordScan(time.Now())
+	i := t.iterator(f)
+	t.setIterating(true)
+	defer t.setIterating(false)
+	t.bkt.data.Ascend(func(item btree.Item) bool {
+		if item.(*Entry).lsn <= lsn {
+			return true
+		}
+		return i(item)
+	})
+	return nil
+}
+
+//AscendInsertionOrder iterates over the entries in the bucket in the order of the commits that last wrote them, oldest
+//first, calling the provided function f for each. Entries written by the same commit are visited in key order. Entries
+//set by the transaction that have not been committed and entries replayed from a bucket file written before log
+//sequence numbers were recorded are visited first. Iteration terminates when there are no more entries or the provided
+//function returns false. Returns an error if the bucket was not created with InsertionOrder or if the db or bucket is
+//closed.
+func (t *Tx) AscendInsertionOrder(f func(e *Entry) bool) error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot iterate entries; db is in invalid state")
+	}
+	if t.bkt.insertion == nil {
+		return errors.New("error: tx: insertion order is not enabled for the bucket")
+	}
+	defer t.recordScan(time.Now())
+	t.setIterating(true)
+	defer t.setIterating(false)
+	t.bkt.insertion.Ascend(t.iterator(f))
+	return nil
+}
+
 //Get returns an entry from the bucket using the default tree to search (i.e. searches on entry key). Returns nil if the
 //the entry is invalid, expired, or not found in the bucket. Returns an error if the db or bucket is closed.
 func (t *Tx) Get(e *Entry) (*Entry, error) {
@@ -329,13 +708,196 @@ func (t *Tx) Get(e *Entry) (*Entry, error) {
 		if res.IsExpired() || res.IsInvalid() {
 			return nil, nil
 		}
+		//Read/write transactions may return changes that are not committed.
+		if t.mode == MODE_READ && t.bkt.stale != nil {
+			t.bkt.stale.put(res)
+		}
+	}
+	return res, nil
+}
+
+//Refresh returns the entry for the provided key as it was committed before the transaction began. Writes made by the
+//transaction that have not been committed are bypassed, as are field values cached by Field, so the committed entry can
+//be compared against the in-flight changes of the transaction. Returns nil if the committed entry is invalid, expired,
+//or not found. Returns an error if the db or bucket is closed.
+func (t *Tx) Refresh(key string) (*Entry, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot get entry; db is in invalid state")
+	}
+	res, changed := t.rbctx.backward[key]
+	if !changed {
+		res = t.bkt.get(&Entry{k: key})
+	} else if res == spilledEntry {
+		res = t.spilledEntryOf(key)
+	}
+	if res == nil || res.IsExpired() || res.IsInvalid() {
+		return nil, nil
 	}
 	return res, nil
 }
 
+//Diff compares the provided desired entries against the live entries of the bucket by key and value and returns the
+//changes that converge the bucket to the desired entries: the desired entries whose key has no live entry, the desired
+//entries whose value, or whether the value is binary, differs from the live entry of the key, and the live entries whose
+//key is not desired. Options such as expiry are not compared. Each result is in key order and the bucket is not changed;
+//the changes can be applied in a later read/write transaction. Returns an error if a desired entry is nil or repeats the
+//key of another desired entry, the transaction is iterating, or the db or bucket is closed.
+func (t *Tx) Diff(desired []*Entry) (toInsert, toUpdate, toDelete []*Entry, err error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, nil, nil, errors.New("error: tx: cannot diff entries; db is in invalid state")
+	}
+	if t.iterating {
+		return nil, nil, nil, errors.New("error: tx: transaction is iterating; cannot diff entries")
+	}
+	want := make(map[string]*Entry, len(desired))
+	for _, e := range desired {
+		if e == nil {
+			return nil, nil, nil, errors.New("error: tx: cannot diff nil entry")
+		}
+		if _, ok := want[e.k]; ok {
+			return nil, nil, nil, errors.New("error: tx: cannot diff entries; key " + e.k + " is desired more than once")
+		}
+		want[e.k] = e
+	}
+	live := make(map[string]bool, len(desired))
+	t.Ascend("", func(e *Entry) bool {
+		if e.IsExpired() || e.IsInvalid() {
+			return true
+		}
+		d, ok := want[e.k]
+		if !ok {
+			toDelete = append(toDelete, e)
+		} else if d.v != e.v || d.binary != e.binary {
+			toUpdate = append(toUpdate, d)
+		}
+		live[e.k] = true
+		return true
+	})
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		if !live[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		toInsert = append(toInsert, want[k])
+	}
+	return toInsert, toUpdate, toDelete, nil
+}
+
+//IsExpired returns true if the entry stored under the provided key has passed its expiry time. Entries that expired but
+//have not yet been swept by the bucket manager are reported as expired. Returns an error if no entry is stored under the
+//key or if the db or bucket is closed.
+func (t *Tx) IsExpired(key string) (bool, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return false, errors.New("error: tx: cannot get entry; db is in invalid state")
+	}
+	res := t.bkt.get(&Entry{k: key})
+	if res == nil {
+		return false, errors.New("error: tx: key does not exist")
+	}
+	return res.IsExpired(), nil
+}
+
+//GetJSON unmarshals the value of the entry with the provided key into v. Returns false if the entry is invalid, expired,
+//or not found in which case v is unchanged. An entry with an empty value is found and leaves v unchanged. Returns an
+//error if the db or bucket is closed or if the value could not be unmarshaled into v.
+func (t *Tx) GetJSON(key string, v interface{}) (bool, error) {
+	res, err := t.Get(&Entry{k: key})
+	if err != nil || res == nil {
+		return false, err
+	}
+	if res.v == "" {
+		return true, nil
+	}
+	if err := json.Unmarshal([]byte(res.v), v); err != nil {
+		return false, errors.Annotate(err, "error: tx: failed to unmarshal value")
+	}
+	return true, nil
+}
+
+//Field returns the value of the field at path of the value of the provided entry. Uses tidwall/gjson access format. The
+//value is parsed once per entry and path during the transaction and reused by later calls so that callbacks and filters
+//reading the same fields of an entry repeatedly do not parse the value again; parsed values are discarded when the
+//transaction completes and when the entry is replaced or deleted by the transaction. Parsed values are kept for at most
+//FIELD_CACHE_SIZE entries; the cache is emptied when a further entry is parsed. Returns a result that does not exist if
+//the entry is nil or binary or does not contain the field.
+func (t *Tx) Field(e *Entry, path string) gjson.Result {
+	if e == nil || e.binary {
+		return gjson.Result{}
+	}
+	if paths, ok := t.fields[e]; ok {
+		if res, ok := paths[path]; ok {
+			return res
+		}
+	}
+	if t.fields == nil || (t.fields[e] == nil && len(t.fields) >= FIELD_CACHE_SIZE) {
+		t.fields = make(map[*Entry]map[string]gjson.Result)
+	}
+	if t.fields[e] == nil {
+		t.fields[e] = make(map[string]gjson.Result)
+	}
+	res := gjson.Get(e.v, path)
+	t.fields[e][path] = res
+	return res
+}
+
+//forgetFields discards the field values parsed by Field for the provided entries.
+func (t *Tx) forgetFields(entries ...*Entry) {
+	for _, e := range entries {
+		if e != nil {
+			delete(t.fields, e)
+		}
+	}
+}
+
+//GetWithTombstone returns the entry for the provided key from the bucket. If the entry has been deleted and the bucket
+//retains tombstones, the tombstone of the entry is returned; see Entry.IsTombstone and Entry.DeletedAt. Returns nil if the
+//entry is invalid, expired, or not found and has no tombstone. Returns an error if the db or bucket is closed.
+func (t *Tx) GetWithTombstone(key string) (*Entry, error) {
+	res, err := t.Get(&Entry{k: key})
+	if err != nil || res != nil {
+		return res, err
+	}
+	return t.bkt.getTombstone(key), nil
+}
+
+//Reserve sizes the maps holding the changes of the transaction for n changed keys so that a bulk load of about n entries
+//does not repeatedly grow them. Changes already made by the transaction are kept. Reserving capacity for fewer keys than
+//have been changed has no effect. The trees of the bucket are not affected. Returns an error if n is negative or if the
+//db or bucket is closed.
+func (t *Tx) Reserve(n int) error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot reserve capacity; db is in invalid state")
+	}
+	if n < 0 {
+		return errors.New("error: tx: reserved capacity cannot be negative")
+	}
+	if n <= len(t.rbctx.forward) {
+		return nil
+	}
+	t.rbctx.backward = reserveEntries(t.rbctx.backward, n)
+	t.rbctx.forward = reserveEntries(t.rbctx.forward, n)
+	return nil
+}
+
+//reserveEntries returns a map holding the entries of m sized for n keys.
+func reserveEntries(m map[string]*Entry, n int) map[string]*Entry {
+	r := make(map[string]*Entry, n)
+	for k, e := range m {
+		r[k] = e
+	}
+	return r
+}
+
 //Set inserts an entry into the bucket. If the key of the entry to insert already exists in the tree the old entry is
-//replaced and returned otherwise returns nil. Returns an error if the transaction is iterating and if the the db or bucket
-//is closed.
+//replaced and returned otherwise returns nil. The default entry options of the bucket are applied to the options the
+//entry does not set, and the entry is given a version one greater than the version of the live entry it replaces. If
+//the bucket has a merge function and a live entry exists for the key, the result of the merge is stored in place of the
+//provided entry. If the bucket has a validator the entry to be stored is validated before it is recorded. Returns an
+//error if the transaction is iterating, if the the db or bucket is closed, if the key is empty or exceeds the maximum
+//key length of the bucket, if the merge fails, or if the entry fails validation.
 func (t *Tx) Set(e *Entry) (*Entry, error) {
 	if t.iterating {
 		return nil, errors.New("error: tx: transaction is iterating; cannot set entry")
@@ -343,10 +905,440 @@ func (t *Tx) Set(e *Entry) (*Entry, error) {
 	if !t.db.open || t.bkt == nil || !t.bkt.open {
 		return nil, errors.New("error: tx: cannot set entry; db is in invalid state")
 	}
+	e, err := t.prepare(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkTotals(e); err != nil {
+		return nil, err
+	}
+	e.version = t.version(e.k) + 1
+	return t.set(e), nil
+}
+
+//prepare checks the key of the entry, applies the default entry options and merge function of the bucket, and validates
+//the result. Returns the entry to be set or an error if the entry cannot be set.
+func (t *Tx) prepare(e *Entry) (*Entry, error) {
+	if err := t.checkKey(e.k); err != nil {
+		return nil, err
+	}
+	t.bkt.options.withDefaults(e)
+	if t.bkt.options.merge != nil {
+		curr := t.bkt.get(e)
+		if curr != nil && !curr.IsExpired() && !curr.IsInvalid() {
+			merged, err := t.bkt.options.merge(curr, e)
+			if err != nil {
+				return nil, errors.Annotate(err, "error: tx: failed to merge entry")
+			}
+			if merged == nil || merged.k != e.k {
+				return nil, errors.New("error: tx: merge must return an entry with the same key")
+			}
+			e = merged
+		}
+	}
+	if err := t.validate(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+//SetFast inserts the entry as Set does without returning the replaced entry. Set looks up the live entry of the key to
+//version the entry before inserting it; SetFast inserts the entry first and versions it from the entry the insert
+//replaced, which is also recorded for rollback, so the tree is searched once per write. If the bucket has a merge
+//function or the db limits its total entries or bytes the live entry must be read before the insert and SetFast behaves
+//as Set. Returns an error as Set does.
+func (t *Tx) SetFast(e *Entry) error {
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot set entry")
+	}
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot set entry; db is in invalid state")
+	}
+	if t.bkt.options.merge != nil || (!t.bkt.options.system && (t.db.config.maxTotalEntries > 0 || t.db.config.maxTotalBytes > 0)) {
+		_, err := t.Set(e)
+		return err
+	}
+	e, err := t.prepare(e)
+	if err != nil {
+		return err
+	}
+	pres := t.set(e)
+	e.version = 1
+	if pres != nil && !pres.IsExpired() && !pres.IsInvalid() {
+		e.version = pres.version + 1
+	}
+	return nil
+}
+
+//SetIfVersion sets the entry as Set does only if the version of the live entry stored under its key is equal to
+//expected; an expected version of zero requires that no live entry exists. Returns false without changing the bucket if
+//the versions differ. Returns an error if the entry could not be set.
+func (t *Tx) SetIfVersion(e *Entry, expected uint64) (bool, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return false, errors.New("error: tx: cannot set entry; db is in invalid state")
+	}
+	if t.version(e.k) != expected {
+		return false, nil
+	}
+	if _, err := t.Set(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//Condition is a precondition on the live entry stored under a key evaluated by Tx.ConditionalWrite. If Value is nil
+//the condition holds if the version of the live entry is equal to Version, where a Version of zero requires that no live
+//entry exists. If Value is not nil the condition holds if a live entry exists with a value equal to Value and, if
+//Version is not zero, a version equal to Version.
+type Condition struct {
+	Key     string  //Key of the entry the condition applies to.
+	Version uint64  //Expected version of the live entry.
+	Value   *string //Expected value of the live entry; nil if the value is not checked.
+}
+
+//ConditionalWrite sets each of the writes as Set does only if every condition holds, returning whether the writes were
+//set. The conditions are evaluated against the bucket as changed by the transaction so far. Every write is prepared,
+//applying the default entry options, merge function, and validator of the bucket, and checked against MaxTotalEntries
+//and MaxTotalBytes before any is set, so either all of the writes are set or the bucket is unchanged and nothing is
+//recorded for rollback. Returns false without changing the bucket if a condition does not hold. Returns an error if a
+//condition has an empty key, two writes have the same key, a write could not be set, the transaction is iterating, or
+//the db or bucket is closed.
+func (t *Tx) ConditionalWrite(conditions []Condition, writes []*Entry) (bool, error) {
+	if t.iterating {
+		return false, errors.New("error: tx: transaction is iterating; cannot set entry")
+	}
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return false, errors.New("error: tx: cannot set entry; db is in invalid state")
+	}
+	for _, c := range conditions {
+		if c.Key == "" {
+			return false, errors.New("error: tx: condition key must not be empty")
+		}
+		if !t.holds(c) {
+			return false, nil
+		}
+	}
+	keys := make(map[string]bool, len(writes))
+	prepared := make([]*Entry, len(writes))
+	for i, e := range writes {
+		if keys[e.k] {
+			return false, errors.New("error: tx: conditional write sets key " + e.k + " more than once")
+		}
+		keys[e.k] = true
+		p, err := t.prepare(e)
+		if err != nil {
+			return false, err
+		}
+		prepared[i] = p
+	}
+	if err := t.checkTotals(prepared...); err != nil {
+		return false, err
+	}
+	for _, e := range prepared {
+		e.version = t.version(e.k) + 1
+		t.set(e)
+	}
+	return true, nil
+}
+
+//holds returns true if the condition holds for the live entry stored under its key.
+func (t *Tx) holds(c Condition) bool {
+	if c.Value == nil {
+		return t.version(c.Key) == c.Version
+	}
+	curr := t.bkt.get(&Entry{k: c.Key})
+	if curr == nil || curr.IsExpired() || curr.IsInvalid() {
+		return false
+	}
+	return curr.v == *c.Value && (c.Version == 0 || curr.version == c.Version)
+}
+
+//version returns the version of the live entry stored under the key or zero if no live entry exists.
+func (t *Tx) version(key string) uint64 {
+	curr := t.bkt.get(&Entry{k: key})
+	if curr == nil || curr.IsExpired() || curr.IsInvalid() {
+		return 0
+	}
+	return curr.version
+}
+
+//checkKey returns an error if the key is empty or longer than the maximum key length of the bucket.
+func (t *Tx) checkKey(key string) error {
+	if key == "" {
+		return errors.New("error: tx: key must not be empty")
+	}
+	if t.bkt.options.maxkeyln > 0 && len(key) > t.bkt.options.maxkeyln {
+		return errors.New("error: tx: key exceeds the maximum key length of the bucket")
+	}
+	return nil
+}
+
+//set inserts the entry into the bucket recording the changes for commit and rollback. Returns the replaced entry.
+func (t *Tx) set(e *Entry) *Entry {
 	pres := t.bkt.insert(e)
-	t.rbctx.backward[e.k] = pres
+	t.forgetFields(e, pres)
+	t.recordBackward(e.k, pres)
 	t.rbctx.forward[e.k] = e
-	return pres, nil
+	delete(t.rbctx.forwardExpire, e.k)
+	if ts := t.bkt.deleteTombstone(e.k); ts != nil {
+		t.recordBackwardTombstone(e.k, ts)
+	}
+	return pres
+}
+
+//validate returns an error if the entry has an invalid location for the bucket or the bucket has a validator and the
+//entry fails it.
+func (t *Tx) validate(e *Entry) error {
+	if err := t.bkt.checkLocation(e); err != nil {
+		return err
+	}
+	if t.bkt.options.validator == nil {
+		return nil
+	}
+	if err := t.bkt.options.validator(e); err != nil {
+		return errors.Annotate(err, "error: tx: entry "+e.k+" failed validation")
+	}
+	return nil
+}
+
+//checkTotals returns an error if setting the entries would grow the totals of the db beyond MaxTotalEntries or
+//MaxTotalBytes. The entries are expected to have distinct keys.
+func (t *Tx) checkTotals(es ...*Entry) error {
+	maxEntries, maxBytes := t.db.config.maxTotalEntries, t.db.config.maxTotalBytes
+	if (maxEntries == 0 && maxBytes == 0) || t.bkt.options.system {
+		return nil
+	}
+	var dentries, dbytes int64
+	for _, e := range es {
+		dbytes += e.size()
+		if curr := t.bkt.get(e); curr != nil {
+			dbytes -= curr.size()
+		} else {
+			dentries++
+		}
+	}
+	entries, bytes := t.db.Usage()
+	if maxEntries > 0 && dentries > 0 && entries+dentries > maxEntries {
+		return errors.New("error: tx: cannot set entry; db would exceed max total entries")
+	}
+	if maxBytes > 0 && dbytes > 0 && bytes+dbytes > maxBytes {
+		return errors.New("error: tx: cannot set entry; db would exceed max total bytes")
+	}
+	return nil
+}
+
+//Replace overwrites the entry with the same key as the provided entry and returns the replaced entry. The merge function
+//of the bucket applies as it does for Set. Returns an error if no live entry exists for the key, if the transaction is
+//iterating, or if the db or bucket is closed.
+func (t *Tx) Replace(e *Entry) (*Entry, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot replace entry; db is in invalid state")
+	}
+	curr, err := t.Get(e)
+	if err != nil {
+		return nil, err
+	}
+	if curr == nil {
+		return nil, errors.New("error: tx: cannot replace entry; key does not exist")
+	}
+	return t.Set(e)
+}
+
+//UpdateValue overwrites the value of the live entry with the provided key, as Set does, carrying forward the expiry,
+//invalidation, and other options of the entry so that changing the data of an entry does not refresh its time to live;
+//use Touch to refresh the time to live without changing the data. Entries do not record creation or update times; the
+//version of the entry is incremented and the commit records its log sequence number. A binary entry remains binary.
+//Returns the replaced entry. Returns an error if no live entry exists for the key, the entry could not be set, the
+//transaction is iterating, or the db or bucket is closed.
+func (t *Tx) UpdateValue(key, value string) (*Entry, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot update value; db is in invalid state")
+	}
+	curr, err := t.Get(&Entry{k: key})
+	if err != nil {
+		return nil, err
+	}
+	if curr == nil {
+		return nil, errors.New("error: tx: cannot update value; key does not exist")
+	}
+	opts := *curr.opts
+	var e *Entry
+	if curr.binary {
+		e, err = NewBinaryEntry(key, []byte(value), &opts)
+	} else {
+		e, err = NewEntry(key, value, t.bkt.options.geo, &opts)
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to create entry")
+	}
+	return t.Set(e)
+}
+
+//Add inserts an entry with the provided key and an empty value, as Set does, so the bucket can be used as a set of keys.
+//An existing entry for the key is replaced. Returns an error if the entry could not be set.
+func (t *Tx) Add(key string) error {
+	e, err := NewEntry(key, "", false, nil)
+	if err != nil {
+		return errors.Annotate(err, "error: tx: failed to create entry")
+	}
+	_, err = t.Set(e)
+	return err
+}
+
+//SetNX inserts the provided entry only if no live entry exists for its key. Returns true if the entry was inserted. The
+//merge function of the bucket is not applied as no existing entry is merged. Returns an error if the transaction is
+//iterating or if the db or bucket is closed.
+func (t *Tx) SetNX(e *Entry) (bool, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return false, errors.New("error: tx: cannot set entry; db is in invalid state")
+	}
+	curr, err := t.Get(e)
+	if err != nil {
+		return false, err
+	}
+	if curr != nil {
+		return false, nil
+	}
+	if _, err := t.Set(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//SetWithTTL creates an entry from key and value that expires after ttl and inserts it into the bucket. Returns the
+//replaced entry as Set does. Returns an error if ttl is not positive or if the entry could not be set.
+func (t *Tx) SetWithTTL(key, value string, ttl time.Duration) (*Entry, error) {
+	if ttl <= 0 {
+		return nil, errors.New("error: tx: ttl must be greater than zero")
+	}
+	opts, err := NewEntryOptions(ExpireTime(time.Now().Add(ttl)))
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to create entry options")
+	}
+	e, err := NewEntry(key, value, t.bkt != nil && t.bkt.options.geo, opts)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to create entry")
+	}
+	return t.Set(e)
+}
+
+//Touch sets the entry stored under key to expire after ttl keeping its value and remaining options. The entry is
+//replaced by a copy sharing the value so the previous expiry is restored on rollback. Unless the entry was otherwise
+//changed in the transaction only the new expiry is written to the bucket file. Returns an error if ttl is not
+//positive, if no live entry exists for the key, if the transaction is iterating, or if the db or bucket is closed.
+func (t *Tx) Touch(key string, ttl time.Duration) error {
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot touch entry")
+	}
+	if ttl <= 0 {
+		return errors.New("error: tx: ttl must be greater than zero")
+	}
+	curr, err := t.Get(&Entry{k: key})
+	if err != nil {
+		return err
+	}
+	if curr == nil {
+		return errors.New("error: tx: cannot touch entry; key does not exist")
+	}
+	opts := *curr.opts
+	opts.doesExp = true
+	opts.expTime = time.Now().Add(ttl)
+	_, changed := t.rbctx.forward[key]
+	t.set(&Entry{k: curr.k, v: curr.v, opts: &opts, location: curr.location, binary: curr.binary, version: curr.version})
+	if !changed {
+		t.rbctx.forwardExpire[key] = true
+	}
+	return nil
+}
+
+//SetJSON marshals v to JSON and inserts it as the value of an entry with the provided key and options. Returns the
+//replaced entry as Set does. Returns an error if v could not be marshaled or if the entry could not be set.
+func (t *Tx) SetJSON(key string, v interface{}, options *EntryOptions) (*Entry, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to marshal value")
+	}
+	e, err := NewEntry(key, string(j), t.bkt != nil && t.bkt.options.geo, options)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to create entry")
+	}
+	return t.Set(e)
+}
+
+//Patch applies the RFC 7386 JSON merge patch provided to the value of the live entry with the provided key and sets the
+//result as the value of the entry with the options of the entry, as Set does, so that the indexes of the bucket are
+//updated and the change is rolled back with the transaction. The patched value is re-encoded so the fields of objects
+//are written in key order. An empty value is patched as an empty object. Returns the patched entry. Returns an error if
+//the key does not exist, the entry is binary, the value of the entry or the patch is not valid JSON, the transaction is
+//iterating, or the db or bucket is closed.
+func (t *Tx) Patch(key string, mergePatch string) (*Entry, error) {
+	curr, err := t.Get(&Entry{k: key})
+	if err != nil {
+		return nil, err
+	}
+	if curr == nil {
+		return nil, errors.New("error: tx: cannot patch entry; key does not exist")
+	}
+	if curr.binary {
+		return nil, errors.New("error: tx: cannot patch binary entry")
+	}
+	var target, patch interface{}
+	if curr.v == "" {
+		target = map[string]interface{}{}
+	} else if err := decodeJSONNumbers(curr.v, &target); err != nil {
+		return nil, errors.Annotate(err, "error: tx: value of entry is not valid json")
+	}
+	if err := decodeJSONNumbers(mergePatch, &patch); err != nil {
+		return nil, errors.Annotate(err, "error: tx: merge patch is not valid json")
+	}
+	j, err := json.Marshal(applyMergePatch(target, patch))
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to marshal patched value")
+	}
+	opts := *curr.opts
+	e, err := NewEntry(key, string(j), t.bkt.options.geo, &opts)
+	if err != nil {
+		return nil, errors.Annotate(err, "error: tx: failed to create entry")
+	}
+	if _, err := t.Set(e); err != nil {
+		return nil, err
+	}
+	return t.bkt.get(e), nil
+}
+
+//decodeJSONNumbers decodes the JSON document s into v keeping numbers as json.Number so that integers that do not fit
+//the 53 bit mantissa of a float64 are re-encoded exactly. Returns an error if s is not a single valid JSON document.
+func decodeJSONNumbers(s string, v *interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("error: tx: unexpected data after json document")
+	}
+	return nil
+}
+
+//applyMergePatch returns the result of applying the merge patch to the target as defined by RFC 7386. Members of the
+//target are modified in place.
+func applyMergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = applyMergePatch(t[name], value)
+		}
+	}
+	return t
 }
 
 //Delete removes an entry from the bucket. If an entry is removed returns the removed entry otherwise returns nil. Returns
@@ -360,15 +1352,179 @@ func (t *Tx) Delete(e *Entry) (*Entry, error) {
 	}
 	dres := t.bkt.delete(e)
 	if dres != nil {
-		t.rbctx.backward[e.k] = dres
+		t.forgetFields(dres)
+		t.recordBackward(e.k, dres)
 		t.rbctx.forward[e.k] = nil
+		delete(t.rbctx.forwardExpire, e.k)
+		if t.bkt.options.tombret > 0 {
+			ts := &Entry{k: dres.k, v: dres.v, opts: dres.opts, binary: dres.binary, deleted: time.Now()}
+			t.recordBackwardTombstone(e.k, t.bkt.setTombstone(ts))
+		}
 	}
 	return dres, nil
 }
 
+//GetDelete removes and returns the live entry for the provided key. The delete is recorded as Delete records it so it is
+//rolled back with the transaction and written to the bucket file on commit; as the bucket is locked for the transaction
+//no other transaction can get the entry once it has been removed. Returns nil if the entry is invalid, expired, or not
+//found in which case nothing is deleted. Returns an error if the transaction is iterating or if the db or bucket is
+//closed.
+func (t *Tx) GetDelete(key string) (*Entry, error) {
+	if t.iterating {
+		return nil, errors.New("error: tx: transaction is iterating; cannot delete entry")
+	}
+	res, err := t.Get(&Entry{k: key})
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return t.Delete(res)
+}
+
+//PopFront removes and returns the entry that was written by the oldest commit in insertion order. Expired and invalid
+//entries are skipped, and entries set by the transaction that have not been committed are ordered before the committed
+//entries as they are by AscendInsertionOrder. The delete is recorded as Delete records it so it is rolled back with the
+//transaction and written to the bucket file on commit. Returns nil if the bucket has no live entries. Returns an error
+//if the bucket was not created with InsertionOrder, if the transaction is iterating, or if the db or bucket is closed.
+func (t *Tx) PopFront() (*Entry, error) {
+	return t.pop(true)
+}
+
+//PopBack removes and returns the entry that was written by the newest commit in insertion order as PopFront does for
+//the oldest.
+func (t *Tx) PopBack() (*Entry, error) {
+	return t.pop(false)
+}
+
+//pop removes and returns the first live entry from the front or back of the insertion tree.
+func (t *Tx) pop(front bool) (*Entry, error) {
+	if t.iterating {
+		return nil, errors.New("error: tx: transaction is iterating; cannot pop entry")
+	}
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot pop entry; db is in invalid state")
+	}
+	if t.bkt.insertion == nil {
+		return nil, errors.New("error: tx: insertion order is not enabled for the bucket")
+	}
+	var found *Entry
+	i := func(item btree.Item) bool {
+		e := item.(*Entry)
+		if e.IsExpired() || e.IsInvalid() {
+			return true
+		}
+		found = e
+		return false
+	}
+	if front {
+		t.bkt.insertion.Ascend(i)
+	} else {
+		t.bkt.insertion.Descend(i)
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return t.Delete(found)
+}
+
+//Rename moves the entry stored under oldKey to newKey keeping its value and options. If an entry exists for newKey it is
+//overwritten when overwrite is true. The merge function of the bucket is not applied. Returns an error if no live entry
+//exists for oldKey, if an entry exists for newKey and overwrite is false, if newKey is empty or exceeds the maximum key
+//length of the bucket, if the transaction is iterating, or if the db or bucket is closed.
+func (t *Tx) Rename(oldKey, newKey string, overwrite bool) error {
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot rename entry")
+	}
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot rename entry; db is in invalid state")
+	}
+	if err := t.checkKey(newKey); err != nil {
+		return err
+	}
+	curr, err := t.Get(&Entry{k: oldKey})
+	if err != nil {
+		return err
+	}
+	if curr == nil {
+		return errors.New("error: tx: cannot rename entry; key does not exist")
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if !overwrite {
+		dest, err := t.Get(&Entry{k: newKey})
+		if err != nil {
+			return err
+		}
+		if dest != nil {
+			return errors.New("error: tx: cannot rename entry; new key exists")
+		}
+	}
+	if _, err := t.Delete(curr); err != nil {
+		return err
+	}
+	//The renamed entry shares no options with the deleted entry.
+	r := curr.Clone()
+	r.k, r.lsn = newKey, 0
+	t.set(r)
+	return nil
+}
+
+//CopyTo inserts a deep copy of the entry stored under srcKey under dstKey and returns the copy. The copy has the value
+//and options of the source entry; expiry and invalidation set with ExpireAfter or InvalidAfter start again from the
+//time of the copy. If an entry exists for dstKey it is overwritten when overwrite is true. The merge function of the
+//bucket is not applied. Returns an error if no live entry exists for srcKey, if srcKey and dstKey are equal, if an entry
+//exists for dstKey and overwrite is false, if dstKey is empty or exceeds the maximum key length of the bucket, if the
+//copy would exceed the limits of the db, if the transaction is iterating, or if the db or bucket is closed.
+func (t *Tx) CopyTo(srcKey, dstKey string, overwrite bool) (*Entry, error) {
+	if t.iterating {
+		return nil, errors.New("error: tx: transaction is iterating; cannot copy entry")
+	}
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot copy entry; db is in invalid state")
+	}
+	if err := t.checkKey(dstKey); err != nil {
+		return nil, err
+	}
+	curr, err := t.Get(&Entry{k: srcKey})
+	if err != nil {
+		return nil, err
+	}
+	if curr == nil {
+		return nil, errors.New("error: tx: cannot copy entry; key does not exist")
+	}
+	if srcKey == dstKey {
+		return nil, errors.New("error: tx: cannot copy entry to its own key")
+	}
+	if !overwrite {
+		dest, err := t.Get(&Entry{k: dstKey})
+		if err != nil {
+			return nil, err
+		}
+		if dest != nil {
+			return nil, errors.New("error: tx: cannot copy entry; destination key exists")
+		}
+	}
+	c := curr.Clone()
+	c.k, c.lsn = dstKey, 0
+	if c.opts.expTTL > 0 {
+		c.opts.expTime = time.Now().Add(c.opts.expTTL)
+	}
+	if c.opts.invTTL > 0 {
+		c.opts.invTime = time.Now().Add(c.opts.invTTL)
+	}
+	if err := t.checkTotals(c); err != nil {
+		return nil, err
+	}
+	c.version = t.version(dstKey) + 1
+	t.set(c)
+	return c, nil
+}
+
 //CreateIndex builds an index over a field of the value of the entry. The field is identified by pattern and its type is
-//described by vtype. Returns an error if the db or bucket is closed, the index already exists, or if an error occurred
-//while populating the index.
+//described by vtype. The pattern is a tidwall/gjson path whose components may contain the wildcards of path.Match, such
+//as "attrs.*", matching the keys of objects and the positions of arrays; an entry is indexed once for each field
+//matching the pattern so iterating the index visits an entry once for each of its matching fields. Returns an error if
+//the db or bucket is closed, the index already exists, or if an error occurred while populating the index.
 func (t *Tx) CreateIndex(pattern string, vtype IndexValueType) error {
 	if !t.db.open || t.bkt == nil || !t.bkt.open {
 		return errors.New("error: tx: cannot create index; db is in invalid state")
@@ -384,7 +1540,9 @@ func (t *Tx) CreateIndex(pattern string, vtype IndexValueType) error {
 	}
 	t.bkt.indexes[pattern] = index
 	//Add to backward indexes with nil value
-	t.rbctx.backwardIndex[pattern] = nil
+	if _, ok := t.rbctx.backwardIndex[pattern]; !ok {
+		t.rbctx.backwardIndex[pattern] = nil
+	}
 	//Rebuild Index
 	t.bkt.indexes[pattern].build()
 	return nil
@@ -401,13 +1559,52 @@ func (t *Tx) DropIndex(pattern string) error {
 	if !ok || index == nil {
 		return errors.New("error: tx: cannot drop; index does not exist")
 	}
-	t.rbctx.backwardIndex[pattern] = index
+	if _, ok := t.rbctx.backwardIndex[pattern]; !ok {
+		t.rbctx.backwardIndex[pattern] = index
+	}
 	//Set map pointer to nil, Delete entry from index map
 	t.bkt.indexes[pattern] = nil
 	delete(t.bkt.indexes, pattern)
 	return nil
 }
 
+//SuspendIndexes stops maintaining the index trees of the bucket on each Set and Delete until ResumeIndexes is called or
+//the transaction completes; the indexes are then rebuilt once from the entries of the bucket which is faster than
+//maintaining them while loading many entries. While suspended the indexes are treated as if they do not exist; iterators
+//over an index use the default key ordering and queries scan the bucket. Rolling back the transaction rebuilds the
+//indexes from the restored entries. Returns an error if the transaction is read only or iterating, or if the db or
+//bucket is closed.
+func (t *Tx) SuspendIndexes() error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot suspend indexes; db is in invalid state")
+	}
+	if t.mode != MODE_READ_WRITE {
+		return errors.New("error: tx: cannot suspend indexes in read only transaction")
+	}
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot suspend indexes")
+	}
+	t.bkt.idxsusp = true
+	return nil
+}
+
+//ResumeIndexes resumes maintaining the index trees of the bucket suspended by SuspendIndexes rebuilding each index from
+//the entries of the bucket. Has no effect if the indexes are not suspended. Returns an error if the transaction is read
+//only or iterating, or if the db or bucket is closed.
+func (t *Tx) ResumeIndexes() error {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return errors.New("error: tx: cannot resume indexes; db is in invalid state")
+	}
+	if t.mode != MODE_READ_WRITE {
+		return errors.New("error: tx: cannot resume indexes in read only transaction")
+	}
+	if t.iterating {
+		return errors.New("error: tx: transaction is iterating; cannot resume indexes")
+	}
+	t.bkt.resumeIndexes()
+	return nil
+}
+
 //Indexes returns a slice of strings containing the names (patterns) of all indexes in the bucket.
 func (t *Tx) Indexes() ([]string, error) {
 	var idxs []string
@@ -427,7 +1624,7 @@ func (t *Tx) Min(index string) (*Entry, error) {
 	} else {
 		item = t.bkt.data.Min()
 	}
-	return item.(*Entry), nil
+	return itemEntry(item), nil
 }
 
 //Max returns the maximum value entry inthe bucket for a given index. An empty string represents no index in which case
@@ -440,18 +1637,63 @@ func (t *Tx) Max(index string) (*Entry, error) {
 	} else {
 		item = t.bkt.data.Max()
 	}
-	return item.(*Entry), nil
+	return itemEntry(item), nil
 }
 
 //Has chacks if an entry exists in the bucket for a given index. An empty string represents no index in which case
 //entries will use the default key ordering.
 func (t *Tx) Has(index string, e *Entry) (bool, error) {
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
-		return t.bkt.indexes[index].t.Has(e), nil
+		return t.bkt.indexes[index].get(e) != nil, nil
 	}
 	return t.bkt.data.Has(e), nil
 }
 
+//HasMany checks the presence of each of the provided keys in the bucket using the default (key) tree. Returns a map
+//containing an entry for every provided key indicating if the key is present. Expired or invalid entries are reported as
+//not present. Returns an error if the db or bucket is closed.
+func (t *Tx) HasMany(keys []string) (map[string]bool, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot check entries; db is in invalid state")
+	}
+	res := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		e := t.bkt.get(&Entry{k: key})
+		res[key] = e != nil && !e.IsExpired() && !e.IsInvalid()
+	}
+	return res, nil
+}
+
+//Keys returns the keys of the live entries of the bucket in key order. Equivalent to KeysWithPrefix with an empty
+//prefix.
+func (t *Tx) Keys() ([]string, error) {
+	return t.KeysWithPrefix("")
+}
+
+//KeysWithPrefix returns the keys of the live entries of the bucket that begin with the provided prefix in key order. The
+//default (key) tree is walked from the prefix until the first key that does not begin with it; entries are neither
+//cloned nor passed to a callback so their values are not copied. Expired or invalid entries are skipped. Returns an
+//error if the db or bucket is closed.
+func (t *Tx) KeysWithPrefix(prefix string) ([]string, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot list keys; db is in invalid state")
+	}
+	defer t.recordScan(time.Now())
+	var keys []string
+	t.bkt.data.AscendGreaterOrEqual(&Entry{k: prefix}, func(i btree.Item) bool {
+		e := i.(*Entry)
+		if !strings.HasPrefix(e.k, prefix) {
+			return false
+		}
+		t.scanned++
+		if !e.IsExpired() && !e.IsInvalid() {
+			keys = append(keys, e.k)
+		}
+		return true
+	})
+	return keys, nil
+}
+
 //Size returns the number of entries in the bucket.
 func (t *Tx) Size(index string) (int, error) {
 	if strings.TrimSpace(index) != "" && t.bkt.indexExists(index) {
@@ -460,6 +1702,42 @@ func (t *Tx) Size(index string) (int, error) {
 	return t.bkt.data.Len(), nil
 }
 
+//BucketStats returns statistics for the bucket of the transaction including the number of live and expired entries, the
+//number of indexes, and the degree and maximum height of the bucket's tree. Returns an error if the db or bucket is
+//closed.
+func (t *Tx) BucketStats() (BucketStats, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return BucketStats{}, errors.New("error: tx: cannot get bucket stats; db is in invalid state")
+	}
+	return t.bkt.stats(), nil
+}
+
+//IndexStats returns statistics for the index of the bucket with the provided name including the number of distinct
+//indexed values and the number of entries in the index. Only live entries are counted. Returns an error if the index
+//does not exist or if the db or bucket is closed.
+func (t *Tx) IndexStats(index string) (IndexStats, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return IndexStats{}, errors.New("error: tx: cannot get index stats; db is in invalid state")
+	}
+	if !t.bkt.indexExists(index) {
+		return IndexStats{}, errors.New("error: tx: index does not exist")
+	}
+	return t.bkt.indexes[index].stats(), nil
+}
+
+//IndexValueCounts returns the number of live entries of the index with the provided name for each distinct indexed
+//value. Values are represented as they compare under the index type; integers and floats are formatted in decimal and
+//case insensitive strings are lower cased. Returns an error if the index does not exist or if the db or bucket is closed.
+func (t *Tx) IndexValueCounts(index string) (map[string]int, error) {
+	if !t.db.open || t.bkt == nil || !t.bkt.open {
+		return nil, errors.New("error: tx: cannot count index values; db is in invalid state")
+	}
+	if !t.bkt.indexExists(index) {
+		return nil, errors.New("error: tx: index does not exist")
+	}
+	return t.bkt.indexes[index].valueCounts(), nil
+}
+
 //SearchIntersect finds entries of the bucket that fall within the bounds of the provided rectangle. Bucket must be
 //configured for geolocation. Returns a slice containing pointers to the entries that are within the bounds of the rectangle.
 //Returns an error if the bucket is not geo enabled.
@@ -482,8 +1760,13 @@ func (t *Tx) SearchIntersect(rbb *Rect) ([]*Entry, error) {
 //points. If the GeoRangeIsInclusive option is set for the bucket then the point is found the be within the n-sphere if
 //the distance between the two points is less than the specified radius. If the GeoRangeIsInclusive option is not set
 //then the point is found to be within the n-sphere if the distance between the two points is less than or equal to the
-//specified radius. Returns an error if the bucket is not geo enabled.
+//specified radius. In a GeoLatLon bucket the radius is a great-circle distance in meters and the entries are returned
+//ordered by distance. Returns an error if the bucket is not geo enabled or, in a GeoLatLon bucket, if the point is not a
+//valid latitude and longitude or the radius is negative.
 func (t *Tx) SearchWithinRadius(pt Point, radius float64) ([]*Entry, error) {
+	if t.bkt.options.latlon {
+		return t.searchLatLon(pt, radius, t.bkt.options.georincl)
+	}
 	//if len(p) != t.bkt.options.dims {
 	//	fmt.Println(t.bkt.options.dims)
 	//	return nil, errors.New("error: tx: invalid dimension for bucket")
@@ -537,22 +1820,35 @@ func (t *Tx) SearchWithinRadius(pt Point, radius float64) ([]*Entry, error) {
 	return res, nil
 }
 
-//NearestNeighbor returns the closest neighbor to a given point pt. Returns an error if the bucket is not geo enabled.
+//NearestNeighbor returns the closest neighbor to a given point pt. In a GeoLatLon bucket the closest neighbor is found by
+//great-circle distance and nil is returned if the bucket has no located entries. Returns an error if the bucket is not
+//geo enabled or, in a GeoLatLon bucket, if the point is not a valid latitude and longitude.
 func (t *Tx) NearestNeighbor(pt Point) (*Entry, error) {
 	p := rtreegoPoint(pt)
 	if !t.bkt.options.geo {
 		return nil, errors.New("error: tx: bucket is not geo")
 	}
+	if t.bkt.options.latlon {
+		res, err := t.nearestLatLon(1, pt)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
 	e := t.bkt.rtree.NearestNeighbor(p)
 	return e.(*Entry), nil
 }
 
-//NearestNeighbors returns a slice of the k closest entries to a given point pt. Returns an error if the bucket is not
-//geo enabled.
+//NearestNeighbors returns a slice of the k closest entries to a given point pt. In a GeoLatLon bucket the entries are
+//found by great-circle distance and ordered by distance. Returns an error if the bucket is not geo enabled or, in a
+//GeoLatLon bucket, if the point is not a valid latitude and longitude.
 func (t *Tx) NearestNeighbors(k int, pt Point) ([]*Entry, error) {
 	if !t.bkt.options.geo {
 		return nil, errors.New("error: tx: bucket is not geo")
 	}
+	if t.bkt.options.latlon {
+		return t.nearestLatLon(k, pt)
+	}
 	p := rtreegoPoint(pt)
 	var res []*Entry
 	e := t.bkt.rtree.NearestNeighbors(k, p)
@@ -4,8 +4,12 @@
 package stitchdb
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -53,6 +57,41 @@ func TestTx_Ascend(t *testing.T) {
 	}
 }
 
+func TestTx_AscendClones(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), IterateClones)
+	db.CreateBucket("clones", opts)
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	db.Update("clones", func(t *Tx) error {
+		_, err := t.Set(e)
+		return err
+	})
+	var yielded *Entry
+	db.View("clones", func(t *Tx) error {
+		return t.Ascend("", func(e *Entry) bool {
+			yielded = e
+			return true
+		})
+	})
+	if yielded == nil || yielded == e || yielded.k != e.k {
+		t.Error("Failure: t.Ascend(...) expected a clone of the stored entry")
+	}
+	db.DropBucket("clones")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_AscendGreaterOrEqual(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -167,6 +206,85 @@ func TestTx_AscendLessThan(t *testing.T) {
 	}
 }
 
+func TestTx_AscendAfter(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("ascendafter", opts)
+	db.Update("ascendafter", func(tx *Tx) error {
+		tx.CreateIndex("group", INT_INDEX)
+		for i := 0; i < 100; i++ {
+			e, _ := NewEntry(fmt.Sprintf("key-%03d", i), "{\"group\":"+strconv.Itoa(i%3)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	page := func(index string) []string {
+		var keys []string
+		var last *Entry
+		for {
+			n := 0
+			db.View("ascendafter", func(tx *Tx) error {
+				f := func(e *Entry) bool {
+					keys = append(keys, e.k)
+					last = e
+					n++
+					return n < 7
+				}
+				if last == nil {
+					return tx.Ascend(index, f)
+				}
+				return tx.AscendAfter(index, last, f)
+			})
+			if n == 0 {
+				return keys
+			}
+		}
+	}
+	var all []string
+	db.View("ascendafter", func(tx *Tx) error {
+		var prev *Entry
+		return tx.Ascend("group", func(e *Entry) bool {
+			if prev != nil && tx.Field(prev, "group").Int() == tx.Field(e, "group").Int() && prev.k >= e.k {
+				t.Errorf("Failure: expected entries with equal index values in key order got %v before %v", prev.k, e.k)
+			}
+			all = append(all, e.k)
+			prev = e
+			return true
+		})
+	})
+	if len(all) != 100 {
+		t.Errorf("Failure: expected 100 indexed entries got %d", len(all))
+	}
+	if keys := page("group"); strings.Join(keys, ",") != strings.Join(all, ",") {
+		t.Errorf("Failure: expected paging with tx.AscendAfter(\"group\", ...) to visit each entry once in index order got %v", keys)
+	}
+	if keys := page(""); len(keys) != 100 || keys[0] != "key-000" || keys[99] != "key-099" {
+		t.Errorf("Failure: expected paging with tx.AscendAfter(\"\", ...) to visit each entry once in key order got %v", keys)
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.Update("ascendafter", func(tx *Tx) error {
+		return tx.CreateIndex("group", INT_INDEX)
+	})
+	if keys := page("group"); strings.Join(keys, ",") != strings.Join(all, ",") {
+		t.Errorf("Failure: expected index order to be stable across restarts got %v", keys)
+	}
+	db.DropBucket("ascendafter")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_AscendRange(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -383,6 +501,61 @@ func TestTx_DescendLessOrEqual(t *testing.T) {
 	}
 }
 
+func TestTx_DescendLessOrEqualPaging(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("paging", opts)
+	db.Update("paging", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		//Expired entries are skipped.
+		eopts, _ := NewEntryOptions(ExpireAfter(time.Millisecond))
+		e, _ := NewEntry("key-55", "{ \"value\":55}", false, eopts)
+		tx.Set(e)
+		return nil
+	})
+	time.Sleep(5 * time.Millisecond)
+	//Pages of three keys are read from the highest key down using the last key of a page as the pivot of the next.
+	var pages []string
+	pivot := &Entry{k: "key-9"}
+	for pivot != nil {
+		var page []string
+		var last *Entry
+		db.View("paging", func(tx *Tx) error {
+			return tx.DescendLessOrEqual("", pivot, func(e *Entry) bool {
+				if last != nil {
+					return false
+				}
+				if e.k == pivot.k && len(pages) > 0 {
+					return true
+				}
+				page = append(page, e.k)
+				if len(page) == 3 {
+					last = e
+				}
+				return true
+			})
+		})
+		pages = append(pages, strings.Join(page, ","))
+		pivot = last
+	}
+	if exp := "key-9,key-8,key-7|key-6,key-5,key-4|key-3,key-2,key-1|key-0"; strings.Join(pages, "|") != exp {
+		t.Errorf("Failure: tx.DescendLessOrEqual(...) expected pages %v got %v", exp, strings.Join(pages, "|"))
+	}
+	db.DropBucket("paging")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_DescendRange(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -485,6 +658,118 @@ func TestTx_Get(t *testing.T) {
 	}
 }
 
+func TestTx_GetWithTombstone(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Hour))
+	db.CreateBucket("tombstone", opts)
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	e2, _ := NewEntry("key-2", "{ \"value\":\"2\"}", false, eopt)
+	db.Update("tombstone", func(t *Tx) error {
+		t.Set(e)
+		t.Set(e2)
+		return nil
+	})
+	db.Update("tombstone", func(t *Tx) error {
+		_, err := t.Delete(e)
+		return err
+	})
+	db.Update("tombstone", func(t *Tx) error {
+		t.Delete(e2)
+		return fmt.Errorf("rollback")
+	})
+	var eget, ets, ets2 *Entry
+	db.View("tombstone", func(t *Tx) error {
+		eget, err = t.Get(e)
+		ets, err = t.GetWithTombstone("key-1")
+		ets2, err = t.GetWithTombstone("key-2")
+		return err
+	})
+	if eget != nil {
+		t.Error("Failure: t.Get(e) expected nil for deleted entry")
+	}
+	if ets == nil || !ets.IsTombstone() || ets.DeletedAt().IsZero() {
+		t.Error("Failure: t.GetWithTombstone(\"key-1\") expected tombstone for deleted entry")
+	}
+	if ets2 == nil || ets2.IsTombstone() {
+		t.Error("Failure: t.GetWithTombstone(\"key-2\") expected live entry after rollback")
+	}
+	db.Update("tombstone", func(t *Tx) error {
+		t.bkt.options.tombret = 0
+		t.bkt.reapTombstones()
+		ets, err = t.GetWithTombstone("key-1")
+		return err
+	})
+	if ets != nil {
+		t.Error("Failure: reapTombstones() expected tombstone to be reaped")
+	}
+	db.DropBucket("tombstone")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_GetWithTombstoneReopen(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), TombstoneRetention(time.Hour))
+	db.CreateBucket("tombstone-reopen", opts)
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	db.Update("tombstone-reopen", func(t *Tx) error {
+		_, err := t.Set(e)
+		return err
+	})
+	var deleted time.Time
+	db.Update("tombstone-reopen", func(t *Tx) error {
+		t.Delete(e)
+		ts, err := t.GetWithTombstone("key-1")
+		if ts != nil {
+			deleted = ts.DeletedAt()
+		}
+		return err
+	})
+	for _, compact := range []bool{false, true} {
+		db.Close()
+		db, err = NewStitchDB(c)
+		if err != nil {
+			t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+		}
+		db.Open()
+		db.Update("tombstone-reopen", func(tx *Tx) error {
+			ts, _ := tx.GetWithTombstone("key-1")
+			if ts == nil || !ts.IsTombstone() || !ts.DeletedAt().Equal(deleted.Round(0)) || ts.v != e.v {
+				t.Errorf("Failure: expected tombstone for key-1 after reopen (compacted %v) got %v", compact, ts)
+			}
+			if compact {
+				return nil
+			}
+			return tx.bkt.compactLog()
+		})
+	}
+	db.DropBucket("tombstone-reopen")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_Set(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -530,6 +815,116 @@ func TestTx_Set(t *testing.T) {
 	}
 }
 
+func TestTx_SetFast(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("setfast", opts)
+	entry := func(v string) *Entry {
+		e, _ := NewEntry("key-1", v, false, nil)
+		return e
+	}
+	db.Update("setfast", func(tx *Tx) error {
+		if err := tx.SetFast(&Entry{k: ""}); err == nil {
+			t.Error("Failure: tx.SetFast() with empty key expected error got nil")
+		}
+		tx.SetFast(entry("{\"value\":1}"))
+		tx.SetFast(entry("{\"value\":2}"))
+		return nil
+	})
+	db.Update("setfast", func(tx *Tx) error {
+		tx.SetFast(entry("{\"value\":3}"))
+		return fmt.Errorf("rollback")
+	})
+	expect := func(when, value string, version uint64) {
+		db.View("setfast", func(tx *Tx) error {
+			e, _ := tx.Get(&Entry{k: "key-1"})
+			if e == nil || e.GetValue() != value || e.Version() != version {
+				t.Errorf("Failure: expected key-1 to be %s at version %d %s got %v", value, version, when, e)
+			}
+			return nil
+		})
+	}
+	expect("after rollback", "{\"value\":2}", 2)
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen", "{\"value\":2}", 2)
+	//Buckets with a merge function read the live entry as Set does.
+	db.SetMergeFunc("setfast", func(existing, incoming *Entry) (*Entry, error) {
+		return NewEntry(incoming.k, existing.v, false, nil)
+	})
+	db.Update("setfast", func(tx *Tx) error {
+		return tx.SetFast(entry("{\"value\":4}"))
+	})
+	expect("after merge", "{\"value\":2}", 3)
+	db.DropBucket("setfast")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SetMerge(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), MergeFunc(func(existing, incoming *Entry) (*Entry, error) {
+		a, _ := strconv.Atoi(existing.v)
+		b, err := strconv.Atoi(incoming.v)
+		if err != nil {
+			return nil, err
+		}
+		return NewEntry(incoming.k, strconv.Itoa(a+b), false, nil)
+	}))
+	db.CreateBucket("merge", opts)
+	eopt, _ := NewEntryOptions()
+	db.Update("merge", func(t *Tx) error {
+		e, _ := NewEntry("counter", "1", false, eopt)
+		t.Set(e)
+		e, _ = NewEntry("counter", "2", false, eopt)
+		t.Set(e)
+		return nil
+	})
+	err = db.Update("merge", func(t *Tx) error {
+		e, _ := NewEntry("counter", "4", false, eopt)
+		t.Set(e)
+		return fmt.Errorf("rollback")
+	})
+	var merr error
+	db.Update("merge", func(t *Tx) error {
+		e, _ := NewEntry("counter", "x", false, eopt)
+		_, merr = t.Set(e)
+		return merr
+	})
+	if merr == nil {
+		t.Error("Failure: t.Set(e) expected merge error got nil")
+	}
+	var eret *Entry
+	db.View("merge", func(t *Tx) error {
+		eret, err = t.Get(&Entry{k: "counter"})
+		return err
+	})
+	if eret == nil || eret.v != "3" {
+		t.Errorf("Failure: t.Set(e) expected merged value 3 got %v", eret)
+	}
+	db.DropBucket("merge")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_Delete(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -829,6 +1224,42 @@ func TestTx_Has(t *testing.T) {
 	}
 }
 
+func TestTx_HasMany(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if db == nil {
+		t.Error("Failure: NewStitchDB(c) expected not nil db got nil")
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	eopt, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	e, _ := NewEntry("key-expired", "{ \"value\":\"999\"}", false, eopt)
+	var res map[string]bool
+	db.View("test", func(t *Tx) error {
+		t.Set(e)
+		res, err = t.HasMany([]string{"key-25", "key-1000", "key-expired"})
+		return err
+	})
+	if err != nil {
+		t.Errorf("Failure: t.HasMany(...) returned error \"%v\"", err)
+	}
+	if len(res) != 3 {
+		t.Errorf("Failure: t.HasMany(...) expected 3 results got %v", len(res))
+	}
+	if !res["key-25"] || res["key-1000"] || res["key-expired"] {
+		t.Error("Failure: t.HasMany(...) returned incorrect membership")
+	}
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
 func TestTx_Size(t *testing.T) {
 	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
 	db, err := NewStitchDB(c)
@@ -1031,3 +1462,1898 @@ func TestTx_NearestNeighbor(t *testing.T) {
 		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
 	}
 }
+
+func TestTx_RollbackGeo(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	if db == nil {
+		t.Error("Failure: NewStitchDB(c) expected not nil db got nil")
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	eopt, _ := NewEntryOptions()
+	enew, _ := NewEntry("key-999", "{ \"value\":\"999\", \"coords\": [999, 999]}", true, eopt)
+	emod, _ := NewEntry("key-0", "{ \"value\":\"256\", \"coords\": [500, 500]}", true, eopt)
+	emod2, _ := NewEntry("key-0", "{ \"value\":\"256\", \"coords\": [600, 600]}", true, eopt)
+	edel, _ := NewEntry("key-1", "", false, eopt)
+	err = db.Update("test", func(t *Tx) error {
+		t.Set(enew)
+		t.Set(emod)
+		t.Set(emod2)
+		t.Delete(edel)
+		return fmt.Errorf("rollback")
+	})
+	if err == nil || err.Error() != "rollback" {
+		t.Errorf("Failure: db.Update(...) expected rollback error got \"%v\"", err)
+	}
+	var entries []*Entry
+	var nearest, nearestMoved *Entry
+	var eret *Entry
+	db.View("test", func(t *Tx) error {
+		rt, _ := NewRect(Point{-1.0, -1.0}, []float64{1001, 1001})
+		entries, err = t.SearchIntersect(rt)
+		nearest, err = t.NearestNeighbor(Point{0.0, 256.0})
+		nearestMoved, err = t.NearestNeighbor(Point{600.0, 600.0})
+		eret, err = t.Get(edel)
+		return err
+	})
+	if len(entries) != 256 {
+		t.Errorf("Failure: rollback left rtree with %v entries expected 256", len(entries))
+	}
+	if nearest == nil || nearest.k != "key-0" {
+		t.Error("Failure: rollback did not restore the original location of an overwritten entry")
+	}
+	if nearestMoved != nil && nearestMoved.k == "key-0" && nearestMoved.v == emod2.v {
+		t.Error("Failure: rollback restored an intermediate entry")
+	}
+	if eret == nil {
+		t.Error("Failure: rollback did not restore a deleted entry")
+	}
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SetWithTTL(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("ttl", opts)
+	db.Update("ttl", func(tx *Tx) error {
+		if _, err := tx.SetWithTTL("key-0", "{ \"value\":\"0\"}", 0); err == nil {
+			t.Error("Failure: tx.SetWithTTL(..., 0) expected error got nil")
+		}
+		if _, err := tx.SetWithTTL("key-1", "{ \"value\":\"1\"}", time.Hour); err != nil {
+			t.Errorf("Failure: tx.SetWithTTL(..., time.Hour) returned error \"%v\"", err)
+		}
+		return nil
+	})
+	db.View("ttl", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "", false, nil)
+		eget, err := tx.Get(e)
+		if err != nil || eget == nil {
+			t.Errorf("Failure: tx.Get(key-1) expected entry got \"%v\", \"%v\"", eget, err)
+			return nil
+		}
+		if !eget.opts.doesExp || eget.opts.expTime.Before(time.Now()) {
+			t.Errorf("Failure: expected key-1 to expire in the future got doesExp == %v expTime == %v", eget.opts.doesExp, eget.opts.expTime)
+		}
+		has, _ := tx.HasMany([]string{"key-0"})
+		if has["key-0"] {
+			t.Error("Failure: expected key-0 to not be set")
+		}
+		return nil
+	})
+	db.DropBucket("ttl")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_AscendExpired(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("expired", opts)
+	db.View("expired", func(tx *Tx) error {
+		for i := 0; i < 8; i++ {
+			exp := time.Now().Add(time.Hour)
+			if i%2 == 0 {
+				exp = time.Now().Add(-time.Duration(i+1) * time.Second)
+			}
+			eopt, _ := NewEntryOptions(ExpireTime(exp))
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			tx.Set(e)
+		}
+		var keys []string
+		tx.AscendExpired(func(e *Entry) bool {
+			keys = append(keys, e.k)
+			return true
+		})
+		if strings.Join(keys, ",") != "key-0,key-2,key-4,key-6" {
+			t.Errorf("Failure: tx.AscendExpired(...) expected key-0,key-2,key-4,key-6 got %v", keys)
+		}
+		return nil
+	})
+	db.DropBucket("expired")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_BucketStats(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(2))
+	db.CreateBucket("stats", opts)
+	expired := time.Now().Add(-time.Second)
+	db.Update("stats", func(tx *Tx) error {
+		for i := 0; i < 7; i++ {
+			eopt, _ := NewEntryOptions()
+			if i < 2 {
+				eopt, _ = NewEntryOptions(ExpireTime(expired))
+			} else if i < 4 {
+				eopt, _ = NewEntryOptions(ExpireTime(time.Now().Add(time.Hour)))
+			}
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":\""+strconv.Itoa(i)+"\"}", false, eopt)
+			tx.Set(e)
+		}
+		tx.CreateIndex("value", INT_INDEX)
+		return nil
+	})
+	var st BucketStats
+	db.Update("stats", func(tx *Tx) error {
+		st, err = tx.BucketStats()
+		return err
+	})
+	if err != nil {
+		t.Errorf("Failure: tx.BucketStats() returned error \"%v\"", err)
+	}
+	if st.Entries != 5 || st.Expired != 2 || st.Indexes != 1 || st.Degree != 2 {
+		t.Errorf("Failure: tx.BucketStats() expected {5 2 1 2} got %v", st)
+	}
+	//A tree of degree 2 and height h holds at least 2^h-1 items; MaxHeight is the greatest such h.
+	if min := 1<<uint(st.MaxHeight) - 1; st.MaxHeight < 1 || min > 7 || 2*min+1 <= 7 {
+		t.Errorf("Failure: tx.BucketStats() expected MaxHeight to bound a tree of 7 entries got %v", st.MaxHeight)
+	}
+	db.DropBucket("stats")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_IndexStats(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("indexstats", opts)
+	db.Update("indexstats", func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			eopt, _ := NewEntryOptions()
+			if i == 9 {
+				eopt, _ = NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+			}
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i%3)+"}", false, eopt)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("key-none", "{ \"other\":1}", false, nil)
+		tx.Set(e)
+		return tx.CreateIndex("value", INT_INDEX)
+	})
+	db.View("indexstats", func(tx *Tx) error {
+		st, err := tx.IndexStats("value")
+		if err != nil {
+			t.Errorf("Failure: tx.IndexStats(\"value\") returned error \"%v\"", err)
+		}
+		if st.Distinct != 3 || st.Entries != 9 {
+			t.Errorf("Failure: tx.IndexStats(\"value\") expected {3 9} got %v", st)
+		}
+		if _, err := tx.IndexStats("missing"); err == nil {
+			t.Error("Failure: tx.IndexStats(\"missing\") expected error got nil")
+		}
+		counts, err := tx.IndexValueCounts("value")
+		if err != nil {
+			t.Errorf("Failure: tx.IndexValueCounts(\"value\") returned error \"%v\"", err)
+		}
+		if len(counts) != 3 || counts["0"] != 3 || counts["1"] != 3 || counts["2"] != 3 {
+			t.Errorf("Failure: tx.IndexValueCounts(\"value\") expected map[0:3 1:3 2:3] got %v", counts)
+		}
+		if _, err := tx.IndexValueCounts("missing"); err == nil {
+			t.Error("Failure: tx.IndexValueCounts(\"missing\") expected error got nil")
+		}
+		return nil
+	})
+	db.DropBucket("indexstats")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SetGetJSON(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	type person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("json", opts)
+	db.View("json", func(tx *Tx) error {
+		if _, err := tx.SetJSON("key-1", person{Name: "ada", Age: 36}, nil); err != nil {
+			t.Errorf("Failure: tx.SetJSON(...) returned error \"%v\"", err)
+		}
+		var p person
+		found, err := tx.GetJSON("key-1", &p)
+		if err != nil || !found {
+			t.Errorf("Failure: tx.GetJSON(key-1) expected found got %v, \"%v\"", found, err)
+		}
+		if p.Name != "ada" || p.Age != 36 {
+			t.Errorf("Failure: tx.GetJSON(key-1) expected {ada 36} got %v", p)
+		}
+		found, err = tx.GetJSON("key-2", &p)
+		if err != nil || found {
+			t.Errorf("Failure: tx.GetJSON(key-2) expected not found got %v, \"%v\"", found, err)
+		}
+		if _, err := tx.SetJSON("key-3", make(chan int), nil); err == nil {
+			t.Error("Failure: tx.SetJSON(..., chan) expected error got nil")
+		}
+		return nil
+	})
+	db.DropBucket("json")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_MaxReaders(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), MaxReaders(1))
+	db.CreateBucket("readers", opts)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	second := make(chan struct{})
+	go db.View("readers", func(tx *Tx) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	go db.View("readers", func(tx *Tx) error {
+		close(second)
+		return nil
+	})
+	select {
+	case <-second:
+		t.Error("Failure: expected second reader to wait for the first with MaxReaders(1)")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Error("Failure: expected second reader to run after the first completed")
+	}
+	db.DropBucket("readers")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Replace(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("replace", opts)
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	e2, _ := NewEntry("key-1", "{ \"value\":\"2\"}", false, eopt)
+	db.View("replace", func(tx *Tx) error {
+		if _, err := tx.Replace(e1); err == nil {
+			t.Error("Failure: tx.Replace(e1) expected error for absent key got nil")
+		}
+		tx.Set(e1)
+		prev, err := tx.Replace(e2)
+		if err != nil {
+			t.Errorf("Failure: tx.Replace(e2) returned error \"%v\"", err)
+		}
+		if prev != e1 {
+			t.Errorf("Failure: tx.Replace(e2) expected previous entry e1 got %v", prev)
+		}
+		curr, _ := tx.Get(e1)
+		if curr != e2 {
+			t.Errorf("Failure: expected key-1 to be e2 after replace got %v", curr)
+		}
+		return nil
+	})
+	db.DropBucket("replace")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_UpdateValue(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("updatevalue", opts)
+	exp := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	eopt, _ := NewEntryOptions(ExpireTime(exp))
+	e1, _ := NewEntry("key-1", "{\"value\":\"1\"}", false, eopt)
+	db.Update("updatevalue", func(tx *Tx) error {
+		if _, err := tx.UpdateValue("key-1", "{\"value\":\"2\"}"); err == nil {
+			t.Error("Failure: tx.UpdateValue(\"key-1\") expected error for absent key got nil")
+		}
+		tx.Set(e1)
+		return nil
+	})
+	db.Update("updatevalue", func(tx *Tx) error {
+		prev, err := tx.UpdateValue("key-1", "{\"value\":\"2\"}")
+		if err != nil {
+			t.Errorf("Failure: tx.UpdateValue(\"key-1\") returned error \"%v\"", err)
+		}
+		if prev == nil || prev.GetValue() != "{\"value\":\"1\"}" {
+			t.Errorf("Failure: tx.UpdateValue(\"key-1\") expected replaced entry {\"value\":\"1\"} got %v", prev)
+		}
+		return fmt.Errorf("rollback")
+	})
+	expect := func(when, value string, version uint64) {
+		db.View("updatevalue", func(tx *Tx) error {
+			e, _ := tx.Get(e1)
+			if e == nil || e.GetValue() != value || e.Version() != version {
+				t.Errorf("Failure: expected key-1 to be %s at version %d %s got %v", value, version, when, e)
+			} else if !e.opts.doesExp || !e.opts.expTime.Equal(exp) {
+				t.Errorf("Failure: expected key-1 to keep expiry %v %s got %v", exp, when, e.opts.expTime)
+			}
+			return nil
+		})
+	}
+	expect("after rollback", "{\"value\":\"1\"}", 1)
+	db.Update("updatevalue", func(tx *Tx) error {
+		_, err := tx.UpdateValue("key-1", "{\"value\":\"2\"}")
+		return err
+	})
+	expect("after update", "{\"value\":\"2\"}", 2)
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen", "{\"value\":\"2\"}", 2)
+	db.DropBucket("updatevalue")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SetNX(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("setnx", opts)
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	e2, _ := NewEntry("key-1", "{ \"value\":\"2\"}", false, eopt)
+	db.View("setnx", func(tx *Tx) error {
+		inserted, err := tx.SetNX(e1)
+		if err != nil || !inserted {
+			t.Errorf("Failure: tx.SetNX(e1) expected insert got %v, \"%v\"", inserted, err)
+		}
+		inserted, err = tx.SetNX(e2)
+		if err != nil || inserted {
+			t.Errorf("Failure: tx.SetNX(e2) expected no insert got %v, \"%v\"", inserted, err)
+		}
+		if _, ok := tx.rbctx.backward["key-1"]; !ok || len(tx.rbctx.backward) != 1 {
+			t.Errorf("Failure: expected a single rollback record for key-1 got %v", tx.rbctx.backward)
+		}
+		curr, _ := tx.Get(e1)
+		if curr != e1 {
+			t.Errorf("Failure: expected key-1 to remain e1 got %v", curr)
+		}
+		return nil
+	})
+	db.DropBucket("setnx")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Rename(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("rename", opts)
+	eopt, _ := NewEntryOptions()
+	e1, _ := NewEntry("key-1", "{ \"value\":1}", false, eopt)
+	e2, _ := NewEntry("key-2", "{ \"value\":2}", false, eopt)
+	db.Update("rename", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		tx.Set(e1)
+		tx.Set(e2)
+		return nil
+	})
+	db.Update("rename", func(tx *Tx) error {
+		if err := tx.Rename("key-3", "key-4", false); err == nil {
+			t.Error("Failure: tx.Rename(key-3, key-4, false) expected error for absent key got nil")
+		}
+		if err := tx.Rename("key-1", "key-2", false); err == nil {
+			t.Error("Failure: tx.Rename(key-1, key-2, false) expected error for existing key got nil")
+		}
+		if err := tx.Rename("key-1", "key-3", false); err != nil {
+			t.Errorf("Failure: tx.Rename(key-1, key-3, false) returned error \"%v\"", err)
+		}
+		return fmt.Errorf("rollback")
+	})
+	db.View("rename", func(tx *Tx) error {
+		curr, _ := tx.Get(&Entry{k: "key-1"})
+		if curr != e1 {
+			t.Errorf("Failure: expected key-1 restored after rollback got %v", curr)
+		}
+		if err := tx.Rename("key-1", "key-2", true); err != nil {
+			t.Errorf("Failure: tx.Rename(key-1, key-2, true) returned error \"%v\"", err)
+		}
+		if curr, _ := tx.Get(&Entry{k: "key-1"}); curr != nil {
+			t.Errorf("Failure: expected key-1 removed after rename got %v", curr)
+		}
+		curr, _ = tx.Get(&Entry{k: "key-2"})
+		if curr == nil || curr.v != e1.v {
+			t.Errorf("Failure: expected key-2 to hold value of key-1 got %v", curr)
+		}
+		if curr != nil && curr.opts == e1.opts {
+			t.Error("Failure: expected renamed entry to hold a copy of the options of key-1")
+		}
+		ind := tx.bkt.indexes["value"].get(&Entry{v: "{ \"value\":1}"})
+		if ind == nil || ind.k != "key-2" {
+			t.Errorf("Failure: expected index to reference key-2 got %v", ind)
+		}
+		return nil
+	})
+	db.DropBucket("rename")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_MaxKeyLength(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32), MaxKeyLength(5))
+	db.CreateBucket("maxkeylen", opts)
+	db.Update("maxkeylen", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{}", false, nil)
+		if _, err := tx.Set(e); err != nil {
+			t.Errorf("Failure: tx.Set(\"key-1\") returned error \"%v\"", err)
+		}
+		e, _ = NewEntry("key-10", "{}", false, nil)
+		if _, err := tx.Set(e); err == nil {
+			t.Error("Failure: tx.Set(\"key-10\") expected error for key longer than max key length got nil")
+		}
+		if _, err := tx.Set(&Entry{k: "", v: "{}", opts: &EntryOptions{}}); err == nil {
+			t.Error("Failure: tx.Set(\"\") expected error for empty key got nil")
+		}
+		if err := tx.Rename("key-1", "key-11", false); err == nil {
+			t.Error("Failure: tx.Rename(\"key-1\", \"key-11\") expected error for key longer than max key length got nil")
+		}
+		if len(tx.rbctx.forward) != 1 {
+			t.Errorf("Failure: expected only key-1 recorded in transaction got %v", tx.rbctx.forward)
+		}
+		return nil
+	})
+	db.DropBucket("maxkeylen")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Touch(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("touch", opts)
+	exp := time.Now().Add(time.Minute)
+	eopt, _ := NewEntryOptions(ExpireTime(exp))
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	db.Update("touch", func(tx *Tx) error {
+		tx.Set(e)
+		return nil
+	})
+	db.Update("touch", func(tx *Tx) error {
+		if err := tx.Touch("key-2", time.Hour); err == nil {
+			t.Error("Failure: tx.Touch(key-2, time.Hour) expected error for absent key got nil")
+		}
+		if err := tx.Touch("key-1", time.Hour); err != nil {
+			t.Errorf("Failure: tx.Touch(key-1, time.Hour) returned error \"%v\"", err)
+		}
+		curr, _ := tx.Get(e)
+		if curr == nil || !curr.ExpiresAt().After(exp) || curr.v != e.v {
+			t.Errorf("Failure: expected key-1 to expire after %v got %v", exp, curr)
+		}
+		if tx.bkt.eviction.Len() != 1 || tx.bkt.eviction.Min().(*Entry) != curr {
+			t.Error("Failure: expected eviction tree to hold only the touched entry")
+		}
+		return fmt.Errorf("rollback")
+	})
+	db.View("touch", func(tx *Tx) error {
+		curr, _ := tx.Get(e)
+		if curr != e || !curr.ExpiresAt().Equal(exp) {
+			t.Errorf("Failure: expected original expiry %v restored after rollback got %v", exp, curr)
+		}
+		return nil
+	})
+	db.DropBucket("touch")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_TouchReopen(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("touch-reopen", opts)
+	eopt, _ := NewEntryOptions()
+	e, _ := NewEntry("key-1", "{ \"value\":\"1\"}", false, eopt)
+	db.Update("touch-reopen", func(t *Tx) error {
+		_, err := t.Set(e)
+		return err
+	})
+	var expires time.Time
+	db.Update("touch-reopen", func(t *Tx) error {
+		err := t.Touch("key-1", time.Hour)
+		if curr, _ := t.Get(e); curr != nil {
+			expires = curr.ExpiresAt()
+		}
+		return err
+	})
+	data, err := ioutil.ReadFile(db.getDBFilePath("touch-reopen" + BUCKET_FILE_EXTENSION))
+	if err != nil {
+		t.Errorf("Failure: failed to read bucket file \"%v\"", err)
+	}
+	if strings.Count(string(data), "INSERT~") != 1 || strings.Count(string(data), "EXPIRE~key-1~") != 1 {
+		t.Errorf("Failure: expected one insert and one expire statement got %q", data)
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("touch-reopen", func(tx *Tx) error {
+		curr, _ := tx.Get(e)
+		if curr == nil || curr.v != e.v || !curr.opts.doesExp || curr.ExpiresAt().Unix() != expires.Unix() {
+			t.Errorf("Failure: expected key-1 to expire at %v after reopen got %v", expires, curr)
+		}
+		return nil
+	})
+	db.DropBucket("touch-reopen")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_NoPersistReopen(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("nopersist-reopen", opts)
+	eopt, _ := NewEntryOptions()
+	nopt, _ := NewEntryOptions(NoPersist)
+	if !nopt.noPersist {
+		t.Error("Failure: NewEntryOptions(NoPersist) expected noPersist == true got false")
+	}
+	db.Update("nopersist-reopen", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		for _, k := range []string{"key-1", "key-3"} {
+			e, _ := NewEntry(k, "{ \"value\":1}", false, eopt)
+			tx.Set(e)
+		}
+		return nil
+	})
+	db.Update("nopersist-reopen", func(tx *Tx) error {
+		e, _ := NewEntry("key-2", "{ \"value\":2}", false, nopt)
+		tx.Set(e)
+		//A persisted entry replaced by an entry that is not persisted is removed from the bucket file.
+		e, _ = NewEntry("key-3", "{ \"value\":3}", false, nopt)
+		tx.Set(e)
+		return nil
+	})
+	db.View("nopersist-reopen", func(tx *Tx) error {
+		count := 0
+		tx.Ascend("value", func(e *Entry) bool {
+			count++
+			return true
+		})
+		if count != 3 {
+			t.Errorf("Failure: expected 3 indexed entries before reopen got %d", count)
+		}
+		return nil
+	})
+	data, err := ioutil.ReadFile(db.getDBFilePath("nopersist-reopen" + BUCKET_FILE_EXTENSION))
+	if err != nil {
+		t.Errorf("Failure: failed to read bucket file \"%v\"", err)
+	}
+	if strings.Contains(string(data), "key-2") || strings.Count(string(data), "INSERT~") != 2 || strings.Count(string(data), "DELETE~") != 1 {
+		t.Errorf("Failure: expected two insert and one delete statement got %q", data)
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("nopersist-reopen", func(tx *Tx) error {
+		for k, exp := range map[string]bool{"key-1": true, "key-2": false, "key-3": false} {
+			if curr, _ := tx.Get(&Entry{k: k}); (curr != nil) != exp {
+				t.Errorf("Failure: expected %v present == %v after reopen got %v", k, exp, curr)
+			}
+		}
+		return nil
+	})
+	db.DropBucket("nopersist-reopen")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_LSN(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("lsn", opts)
+	lsns := func() map[string]uint64 {
+		res := make(map[string]uint64)
+		db.View("lsn", func(tx *Tx) error {
+			return tx.AscendSince(0, func(e *Entry) bool {
+				res[e.k] = e.LSN()
+				return true
+			})
+		})
+		return res
+	}
+	db.Update("lsn", func(tx *Tx) error {
+		for _, k := range []string{"key-1", "key-2"} {
+			e, _ := NewEntry(k, "{}", false, nil)
+			tx.Set(e)
+			if e.LSN() != 0 {
+				t.Errorf("Failure: expected LSN 0 before commit got %d", e.LSN())
+			}
+		}
+		return nil
+	})
+	db.Update("lsn", func(tx *Tx) error {
+		e, _ := NewEntry("key-3", "{}", false, nil)
+		tx.Set(e)
+		return tx.Touch("key-1", time.Hour)
+	})
+	before := lsns()
+	//key-1 was touched by the second commit.
+	if before["key-2"] == 0 || before["key-1"] <= before["key-2"] || before["key-3"] <= before["key-2"] || before["key-1"] == before["key-3"] {
+		t.Errorf("Failure: expected increasing LSNs got %v", before)
+	}
+	since := 0
+	db.View("lsn", func(tx *Tx) error {
+		return tx.AscendSince(before["key-2"], func(e *Entry) bool {
+			since++
+			return true
+		})
+	})
+	if since != 2 {
+		t.Errorf("Failure: tx.AscendSince(%d) expected 2 entries got %d", before["key-2"], since)
+	}
+	//Delete the entry with the highest number and compact so the number is only recorded by the sequence statement.
+	db.Update("lsn", func(tx *Tx) error {
+		_, err := tx.Delete(&Entry{k: "key-3"})
+		return err
+	})
+	high := db.currentLSN()
+	b := db.buckets["lsn"]
+	b.lock(MODE_READ_WRITE)
+	if err := b.compactLog(); err != nil {
+		t.Errorf("Failure: b.compactLog() returned error \"%v\"", err)
+	}
+	b.unlock(MODE_READ_WRITE)
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	after := lsns()
+	if after["key-1"] != before["key-1"] || after["key-2"] != before["key-2"] || len(after) != 2 {
+		t.Errorf("Failure: expected LSNs %v after reopen got %v", before, after)
+	}
+	if db.currentLSN() < high {
+		t.Errorf("Failure: expected LSN counter of at least %d after reopen got %d", high, db.currentLSN())
+	}
+	db.DropBucket("lsn")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_AscendInsertionOrder(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), InsertionOrder)
+	db.CreateBucket("insord", opts)
+	order := func() []string {
+		var res []string
+		err := db.View("insord", func(tx *Tx) error {
+			return tx.AscendInsertionOrder(func(e *Entry) bool {
+				res = append(res, e.k)
+				return true
+			})
+		})
+		if err != nil {
+			t.Errorf("Failure: tx.AscendInsertionOrder(...) returned error \"%v\"", err)
+		}
+		return res
+	}
+	for _, k := range []string{"key-c", "key-a", "key-d", "key-b"} {
+		db.Update("insord", func(tx *Tx) error {
+			e, _ := NewEntry(k, "{}", false, nil)
+			_, err := tx.Set(e)
+			return err
+		})
+	}
+	//Overwriting moves an entry to the end; deleting removes it; a failed transaction leaves the order unchanged.
+	db.Update("insord", func(tx *Tx) error {
+		e, _ := NewEntry("key-c", "{ \"value\":1}", false, nil)
+		tx.Set(e)
+		_, err := tx.Delete(&Entry{k: "key-d"})
+		return err
+	})
+	db.Update("insord", func(tx *Tx) error {
+		e, _ := NewEntry("key-a", "{ \"value\":1}", false, nil)
+		tx.Set(e)
+		return fmt.Errorf("rollback")
+	})
+	expected := "key-a,key-b,key-c"
+	if got := strings.Join(order(), ","); got != expected {
+		t.Errorf("Failure: expected insertion order %v got %v", expected, got)
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if got := strings.Join(order(), ","); got != expected {
+		t.Errorf("Failure: expected insertion order %v after reopen got %v", expected, got)
+	}
+	opts, _ = NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("keyord", opts)
+	err = db.View("keyord", func(tx *Tx) error {
+		return tx.AscendInsertionOrder(func(e *Entry) bool { return true })
+	})
+	if err == nil {
+		t.Error("Failure: tx.AscendInsertionOrder(...) expected error for bucket without insertion order got nil")
+	}
+	db.DropBucket("insord")
+	db.DropBucket("keyord")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Pop(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), InsertionOrder)
+	db.CreateBucket("queue", opts)
+	for _, k := range []string{"job-3", "job-1", "job-2"} {
+		db.Update("queue", func(tx *Tx) error {
+			e, _ := NewEntry(k, "{}", false, nil)
+			_, err := tx.Set(e)
+			return err
+		})
+	}
+	//A rolled back pop leaves the entry in the queue.
+	db.Update("queue", func(tx *Tx) error {
+		if e, err := tx.PopFront(); err != nil || e == nil || e.k != "job-3" {
+			t.Errorf("Failure: tx.PopFront() expected job-3 got %v and error \"%v\"", e, err)
+		}
+		return fmt.Errorf("rollback")
+	})
+	db.Update("queue", func(tx *Tx) error {
+		if e, err := tx.PopFront(); err != nil || e == nil || e.k != "job-3" {
+			t.Errorf("Failure: tx.PopFront() expected job-3 got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.PopBack(); err != nil || e == nil || e.k != "job-2" {
+			t.Errorf("Failure: tx.PopBack() expected job-2 got %v and error \"%v\"", e, err)
+		}
+		return nil
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.Update("queue", func(tx *Tx) error {
+		if e, err := tx.PopBack(); err != nil || e == nil || e.k != "job-1" {
+			t.Errorf("Failure: tx.PopBack() expected job-1 after reopen got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.PopFront(); err != nil || e != nil {
+			t.Errorf("Failure: tx.PopFront() expected nil for empty queue got %v and error \"%v\"", e, err)
+		}
+		return nil
+	})
+	opts, _ = NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("keyord", opts)
+	db.Update("keyord", func(tx *Tx) error {
+		if _, err := tx.PopFront(); err == nil {
+			t.Error("Failure: tx.PopFront() expected error for bucket without insertion order got nil")
+		}
+		return nil
+	})
+	db.DropBucket("queue")
+	db.DropBucket("keyord")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_IsExpired(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("isexpired", opts)
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("isexpired", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{}", false, nil)
+		e2, _ := NewEntry("key-2", "{}", false, expired)
+		tx.Set(e1)
+		tx.Set(e2)
+		return nil
+	})
+	db.View("isexpired", func(tx *Tx) error {
+		if exp, err := tx.IsExpired("key-1"); err != nil || exp {
+			t.Errorf("Failure: tx.IsExpired(\"key-1\") expected false got %v and error \"%v\"", exp, err)
+		}
+		if exp, err := tx.IsExpired("key-2"); err != nil || !exp {
+			t.Errorf("Failure: tx.IsExpired(\"key-2\") expected true got %v and error \"%v\"", exp, err)
+		}
+		if _, err := tx.IsExpired("key-3"); err == nil {
+			t.Error("Failure: tx.IsExpired(\"key-3\") expected error for missing key got nil")
+		}
+		return nil
+	})
+	db.DropBucket("isexpired")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SubSecondExpiry(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("subsecond", opts)
+	var expires time.Time
+	db.Update("subsecond", func(tx *Tx) error {
+		eopts, _ := NewEntryOptions(ExpireAfter(500 * time.Millisecond))
+		e, _ := NewEntry("key-1", "{}", false, eopts)
+		tx.Set(e)
+		expires = e.ExpiresAt()
+		return nil
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("subsecond", func(tx *Tx) error {
+		e := tx.bkt.get(&Entry{k: "key-1"})
+		if e == nil || !e.ExpiresAt().Equal(expires) {
+			t.Errorf("Failure: expected expiry %v to be replayed with nanosecond precision got %v", expires, e)
+		}
+		return nil
+	})
+	time.Sleep(time.Until(expires.Add(-100 * time.Millisecond)))
+	db.View("subsecond", func(tx *Tx) error {
+		if exp, err := tx.IsExpired("key-1"); err != nil || exp {
+			t.Errorf("Failure: tx.IsExpired(\"key-1\") expected false before the ttl elapsed got %v and error \"%v\"", exp, err)
+		}
+		return nil
+	})
+	time.Sleep(time.Until(expires.Add(50 * time.Millisecond)))
+	db.View("subsecond", func(tx *Tx) error {
+		if exp, err := tx.IsExpired("key-1"); err != nil || !exp {
+			t.Errorf("Failure: tx.IsExpired(\"key-1\") expected true once the ttl elapsed got %v and error \"%v\"", exp, err)
+		}
+		return nil
+	})
+	db.DropBucket("subsecond")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Refresh(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("refresh", opts)
+	db.Update("refresh", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{\"v\":1}", false, nil)
+		e2, _ := NewEntry("key-2", "{\"v\":2}", false, nil)
+		tx.Set(e1)
+		tx.Set(e2)
+		return nil
+	})
+	db.Update("refresh", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{\"v\":10}", false, nil)
+		e1b, _ := NewEntry("key-1", "{\"v\":100}", false, nil)
+		e3, _ := NewEntry("key-3", "{\"v\":3}", false, nil)
+		tx.Set(e1)
+		tx.Set(e1b)
+		tx.Set(e3)
+		tx.Delete(&Entry{k: "key-2"})
+		if e, err := tx.Refresh("key-1"); err != nil || e == nil || e.GetValue() != "{\"v\":1}" {
+			t.Errorf("Failure: tx.Refresh(\"key-1\") expected committed value got %v and error \"%v\"", e, err)
+		}
+		if e, _ := tx.Get(&Entry{k: "key-1"}); e == nil || e.GetValue() != "{\"v\":100}" {
+			t.Errorf("Failure: tx.Get(\"key-1\") expected uncommitted value got %v", e)
+		}
+		if e, err := tx.Refresh("key-2"); err != nil || e == nil || e.GetValue() != "{\"v\":2}" {
+			t.Errorf("Failure: tx.Refresh(\"key-2\") expected committed entry of deleted key got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.Refresh("key-3"); err != nil || e != nil {
+			t.Errorf("Failure: tx.Refresh(\"key-3\") expected nil for uncommitted insert got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.Refresh("key-4"); err != nil || e != nil {
+			t.Errorf("Failure: tx.Refresh(\"key-4\") expected nil for missing key got %v and error \"%v\"", e, err)
+		}
+		return nil
+	})
+	db.View("refresh", func(tx *Tx) error {
+		if e, _ := tx.Refresh("key-1"); e == nil || e.GetValue() != "{\"v\":100}" {
+			t.Errorf("Failure: tx.Refresh(\"key-1\") expected value committed by previous tx got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("refresh")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Diff(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("diff", opts)
+	entry := func(k, v string) *Entry {
+		e, _ := NewEntry(k, v, false, nil)
+		return e
+	}
+	keys := func(entries []*Entry) string {
+		var ks []string
+		for _, e := range entries {
+			ks = append(ks, e.k)
+		}
+		return strings.Join(ks, ",")
+	}
+	db.Update("diff", func(tx *Tx) error {
+		tx.Set(entry("key-1", "{\"value\":1}"))
+		tx.Set(entry("key-2", "{\"value\":2}"))
+		tx.Set(entry("key-3", "{\"value\":3}"))
+		tx.Set(entry("key-5", "{\"value\":5}"))
+		return nil
+	})
+	desired := []*Entry{entry("key-4", "{\"value\":4}"), entry("key-2", "{\"value\":\"two\"}"), entry("key-1", "{\"value\":1}"), entry("key-0", "{}")}
+	db.View("diff", func(tx *Tx) error {
+		ins, upd, del, err := tx.Diff(desired)
+		if err != nil {
+			t.Errorf("Failure: tx.Diff() returned error \"%v\"", err)
+		}
+		if keys(ins) != "key-0,key-4" || keys(upd) != "key-2" || keys(del) != "key-3,key-5" {
+			t.Errorf("Failure: tx.Diff() expected insert key-0,key-4 update key-2 delete key-3,key-5 got insert %v update %v delete %v", keys(ins), keys(upd), keys(del))
+		}
+		if len(upd) == 1 && upd[0] != desired[1] {
+			t.Errorf("Failure: tx.Diff() expected update to be the desired entry got %v", upd[0])
+		}
+		if _, _, _, err := tx.Diff([]*Entry{entry("key-1", "{}"), entry("key-1", "{}")}); err == nil {
+			t.Error("Failure: tx.Diff() with repeated key expected error got nil")
+		}
+		if _, _, _, err := tx.Diff([]*Entry{nil}); err == nil {
+			t.Error("Failure: tx.Diff() with nil entry expected error got nil")
+		}
+		if ins, upd, del, _ := tx.Diff(nil); len(ins) != 0 || len(upd) != 0 || len(del) != 4 {
+			t.Errorf("Failure: tx.Diff(nil) expected every entry deleted got insert %v update %v delete %v", keys(ins), keys(upd), keys(del))
+		}
+		return nil
+	})
+	//Applying the diff converges the bucket to the desired entries.
+	db.Update("diff", func(tx *Tx) error {
+		ins, upd, del, _ := tx.Diff(desired)
+		for _, e := range append(ins, upd...) {
+			tx.Set(e)
+		}
+		for _, e := range del {
+			tx.Delete(e)
+		}
+		return nil
+	})
+	db.View("diff", func(tx *Tx) error {
+		if ins, upd, del, _ := tx.Diff(desired); len(ins)+len(upd)+len(del) != 0 {
+			t.Errorf("Failure: tx.Diff() after applying diff expected no changes got insert %v update %v delete %v", keys(ins), keys(upd), keys(del))
+		}
+		return nil
+	})
+	db.DropBucket("diff")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_GetDelete(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("getdelete", opts)
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("getdelete", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{\"task\":1}", false, nil)
+		e2, _ := NewEntry("key-2", "{\"task\":2}", false, expired)
+		tx.Set(e1)
+		tx.Set(e2)
+		return nil
+	})
+	db.Update("getdelete", func(tx *Tx) error {
+		if e, err := tx.GetDelete("key-1"); err != nil || e == nil || e.GetValue() != "{\"task\":1}" {
+			t.Errorf("Failure: tx.GetDelete(\"key-1\") expected entry got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.GetDelete("key-1"); err != nil || e != nil {
+			t.Errorf("Failure: tx.GetDelete(\"key-1\") expected nil once deleted got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.GetDelete("key-2"); err != nil || e != nil {
+			t.Errorf("Failure: tx.GetDelete(\"key-2\") expected nil for expired entry got %v and error \"%v\"", e, err)
+		}
+		if e, err := tx.GetDelete("key-3"); err != nil || e != nil {
+			t.Errorf("Failure: tx.GetDelete(\"key-3\") expected nil for missing key got %v and error \"%v\"", e, err)
+		}
+		return nil
+	})
+	db.View("getdelete", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "key-1"}); e != nil {
+			t.Errorf("Failure: expected key-1 to be deleted after commit got %v", e)
+		}
+		if e := tx.bkt.get(&Entry{k: "key-2"}); e == nil {
+			t.Error("Failure: expected expired key-2 to not be deleted by tx.GetDelete got nil")
+		}
+		return nil
+	})
+	//A rolled back claim leaves the entry in place.
+	db.Update("getdelete", func(tx *Tx) error {
+		e, _ := NewEntry("key-4", "{\"task\":4}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	db.Update("getdelete", func(tx *Tx) error {
+		tx.GetDelete("key-4")
+		return fmt.Errorf("rollback")
+	})
+	db.View("getdelete", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "key-4"}); e == nil {
+			t.Error("Failure: expected key-4 to be restored after rollback got nil")
+		}
+		return nil
+	})
+	db.DropBucket("getdelete")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Reserve(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("reserve", opts)
+	db.Update("reserve", func(tx *Tx) error {
+		e, _ := NewEntry("key-0", "{}", false, nil)
+		tx.Set(e)
+		if err := tx.Reserve(-1); err == nil {
+			t.Error("Failure: tx.Reserve(-1) expected error got nil")
+		}
+		if err := tx.Reserve(1000); err != nil {
+			t.Errorf("Failure: tx.Reserve(1000) returned error \"%v\"", err)
+		}
+		if _, ok := tx.rbctx.forward["key-0"]; !ok {
+			t.Error("Failure: expected tx.Reserve(1000) to keep changes made before the call")
+		}
+		for i := 1; i < 1000; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	db.View("reserve", func(tx *Tx) error {
+		if size, _ := tx.Size(""); size != 1000 {
+			t.Errorf("Failure: expected 1000 entries after reserved bulk load got %d", size)
+		}
+		return nil
+	})
+	db.DropBucket("reserve")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	//Test cases from RFC 7386 Appendix A.
+	cases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range cases {
+		var target, patch interface{}
+		json.Unmarshal([]byte(tc[0]), &target)
+		json.Unmarshal([]byte(tc[1]), &patch)
+		res, _ := json.Marshal(applyMergePatch(target, patch))
+		if string(res) != tc[2] {
+			t.Errorf("Failure: applyMergePatch(%v, %v) expected %v got %v", tc[0], tc[1], tc[2], string(res))
+		}
+	}
+}
+
+func TestTx_Patch(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("patch", opts)
+	expires := time.Now().Add(time.Hour)
+	db.Update("patch", func(tx *Tx) error {
+		tx.CreateIndex("age", INT_INDEX)
+		eopts, _ := NewEntryOptions(ExpireTime(expires))
+		e, _ := NewEntry("key-1", "{\"name\":\"a\",\"age\":1,\"tags\":[\"x\"]}", false, eopts)
+		tx.Set(e)
+		b, _ := NewBinaryEntry("key-2", []byte("{}"), nil)
+		tx.Set(b)
+		return nil
+	})
+	db.Update("patch", func(tx *Tx) error {
+		e, err := tx.Patch("key-1", "{\"age\":5,\"tags\":null,\"address\":{\"city\":\"b\"}}")
+		if err != nil {
+			t.Errorf("Failure: tx.Patch(\"key-1\", ...) returned error \"%v\"", err)
+		}
+		if e == nil || e.GetValue() != "{\"address\":{\"city\":\"b\"},\"age\":5,\"name\":\"a\"}" {
+			t.Errorf("Failure: tx.Patch(\"key-1\", ...) expected patched entry got %v", e)
+		}
+		if e != nil && !e.ExpiresAt().Equal(expires) {
+			t.Errorf("Failure: tx.Patch(\"key-1\", ...) expected options of the entry to be kept got expiry %v", e.ExpiresAt())
+		}
+		if _, err := tx.Patch("key-3", "{}"); err == nil {
+			t.Error("Failure: tx.Patch(\"key-3\", ...) expected error for missing key got nil")
+		}
+		if _, err := tx.Patch("key-2", "{}"); err == nil {
+			t.Error("Failure: tx.Patch(\"key-2\", ...) expected error for binary entry got nil")
+		}
+		if _, err := tx.Patch("key-1", "{"); err == nil {
+			t.Error("Failure: tx.Patch(\"key-1\", \"{\") expected error for invalid patch got nil")
+		}
+		if _, err := tx.Patch("key-1", "{} {}"); err == nil {
+			t.Error("Failure: tx.Patch(\"key-1\", \"{} {}\") expected error for trailing data got nil")
+		}
+		//Integers beyond the precision of a float64 are kept exactly in fields the patch does not touch.
+		big, _ := NewEntry("key-4", "{\"id\":9007199254740993,\"max\":9223372036854775807,\"n\":1}", false, nil)
+		tx.Set(big)
+		e, err = tx.Patch("key-4", "{\"n\":2.5}")
+		if err != nil || e == nil || e.GetValue() != "{\"id\":9007199254740993,\"max\":9223372036854775807,\"n\":2.5}" {
+			t.Errorf("Failure: tx.Patch(\"key-4\", ...) expected large integers to be kept got %v, \"%v\"", e, err)
+		}
+		return nil
+	})
+	db.View("patch", func(tx *Tx) error {
+		var ages []int64
+		tx.Ascend("age", func(e *Entry) bool {
+			ages = append(ages, tx.Field(e, "age").Int())
+			return true
+		})
+		if len(ages) != 1 || ages[0] != 5 {
+			t.Errorf("Failure: expected index to hold patched value 5 got %v", ages)
+		}
+		return nil
+	})
+	db.Update("patch", func(tx *Tx) error {
+		tx.Patch("key-1", "{\"age\":9}")
+		return fmt.Errorf("rollback")
+	})
+	db.View("patch", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "key-1"}); e == nil || tx.Field(e, "age").Int() != 5 {
+			t.Errorf("Failure: expected patch to be rolled back got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("patch")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_CopyTo(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("copyto", opts)
+	db.Update("copyto", func(tx *Tx) error {
+		ttl, _ := NewEntryOptions(ExpireAfter(time.Hour))
+		src, _ := NewEntry("template", "{\"name\":\"t\"}", false, ttl)
+		tx.Set(src)
+		other, _ := NewEntry("other", "{}", false, nil)
+		tx.Set(other)
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	db.Update("copyto", func(tx *Tx) error {
+		src, _ := tx.Get(&Entry{k: "template"})
+		cp, err := tx.CopyTo("template", "copy-1", false)
+		if err != nil || cp == nil || cp.k != "copy-1" || cp.GetValue() != src.GetValue() {
+			t.Errorf("Failure: tx.CopyTo(\"template\", \"copy-1\", false) expected copy got %v and error \"%v\"", cp, err)
+		}
+		if cp != nil && (cp.opts == src.opts || !cp.ExpiresAt().After(src.ExpiresAt())) {
+			t.Errorf("Failure: expected copy to have its own options with a fresh expiry got %v and %v", cp.ExpiresAt(), src.ExpiresAt())
+		}
+		if _, err := tx.CopyTo("template", "other", false); err == nil {
+			t.Error("Failure: tx.CopyTo(\"template\", \"other\", false) expected error for existing key got nil")
+		}
+		if _, err := tx.CopyTo("template", "other", true); err != nil {
+			t.Errorf("Failure: tx.CopyTo(\"template\", \"other\", true) returned error \"%v\"", err)
+		}
+		if _, err := tx.CopyTo("missing", "copy-2", false); err == nil {
+			t.Error("Failure: tx.CopyTo(\"missing\", ...) expected error for missing key got nil")
+		}
+		if _, err := tx.CopyTo("template", "template", true); err == nil {
+			t.Error("Failure: tx.CopyTo(\"template\", \"template\", true) expected error got nil")
+		}
+		return nil
+	})
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	db.View("copyto", func(tx *Tx) error {
+		for _, key := range []string{"template", "copy-1", "other"} {
+			if e, _ := tx.Get(&Entry{k: key}); e == nil || e.GetValue() != "{\"name\":\"t\"}" {
+				t.Errorf("Failure: expected %v to hold the template value after reopen got %v", key, e)
+			}
+		}
+		return nil
+	})
+	db.DropBucket("copyto")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Field(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("field", opts)
+	db.Update("field", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{\"name\":\"a\",\"age\":1}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	db.Update("field", func(tx *Tx) error {
+		e, _ := tx.Get(&Entry{k: "key-1"})
+		if v := tx.Field(e, "name"); v.String() != "a" {
+			t.Errorf("Failure: tx.Field(e, \"name\") expected \"a\" got \"%v\"", v.String())
+		}
+		if v := tx.Field(e, "age"); v.Int() != 1 {
+			t.Errorf("Failure: tx.Field(e, \"age\") expected 1 got %v", v.Int())
+		}
+		if len(tx.fields[e]) != 2 {
+			t.Errorf("Failure: expected 2 cached fields for entry got %d", len(tx.fields[e]))
+		}
+		if v := tx.Field(e, "missing"); v.Exists() {
+			t.Errorf("Failure: tx.Field(e, \"missing\") expected result to not exist got \"%v\"", v.Raw)
+		}
+		n, _ := NewEntry("key-1", "{\"name\":\"b\",\"age\":2}", false, nil)
+		tx.Set(n)
+		if _, ok := tx.fields[e]; ok {
+			t.Error("Failure: expected cached fields of replaced entry to be discarded")
+		}
+		curr, _ := tx.Get(&Entry{k: "key-1"})
+		if v := tx.Field(curr, "name"); v.String() != "b" {
+			t.Errorf("Failure: tx.Field(curr, \"name\") expected \"b\" after set got \"%v\"", v.String())
+		}
+		tx.Delete(curr)
+		if _, ok := tx.fields[curr]; ok {
+			t.Error("Failure: expected cached fields of deleted entry to be discarded")
+		}
+		b, _ := NewBinaryEntry("key-2", []byte("{\"name\":\"c\"}"), nil)
+		if v := tx.Field(b, "name"); v.Exists() {
+			t.Errorf("Failure: tx.Field(b, \"name\") expected binary entry result to not exist got \"%v\"", v.Raw)
+		}
+		return nil
+	})
+	db.Update("field", func(tx *Tx) error {
+		for i := 0; i < 2*FIELD_CACHE_SIZE; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{\"age\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+			if v := tx.Field(e, "age"); v.Int() != int64(i) {
+				t.Errorf("Failure: tx.Field(e, \"age\") expected %d got %v", i, v.Int())
+			}
+		}
+		if len(tx.fields) > FIELD_CACHE_SIZE {
+			t.Errorf("Failure: expected at most %d cached entries got %d", FIELD_CACHE_SIZE, len(tx.fields))
+		}
+		return nil
+	})
+	db.View("field", func(tx *Tx) error {
+		if res, _ := tx.Query(Filter{Path: "age", Type: INT_INDEX, Min: 10}); len(res) != 2*FIELD_CACHE_SIZE-10 {
+			t.Errorf("Failure: tx.Query(...) expected %d entries got %d", 2*FIELD_CACHE_SIZE-10, len(res))
+		}
+		if len(tx.fields) != 0 {
+			t.Errorf("Failure: expected a query scan to cache no fields got %d entries", len(tx.fields))
+		}
+		return nil
+	})
+	db.DropBucket("field")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_DefaultEntryOptions(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	defaults, _ := NewEntryOptions(ExpireAfter(time.Hour))
+	opts, _ := NewBucketOptions(BTreeDegree(32), DefaultEntryOptions(defaults))
+	db.CreateBucket("defaults", opts)
+	shared, _ := NewEntryOptions()
+	own, _ := NewEntryOptions(ExpireTime(time.Now().Add(time.Minute)))
+	db.Update("defaults", func(tx *Tx) error {
+		e1, _ := NewEntry("key-1", "{}", false, shared)
+		e2, _ := NewEntry("key-2", "{}", false, own)
+		tx.Set(e1)
+		tx.Set(e2)
+		return nil
+	})
+	if shared.doesExp {
+		t.Error("Failure: expected shared entry options to be unchanged by the bucket defaults")
+	}
+	db.View("defaults", func(tx *Tx) error {
+		e1, _ := tx.Get(&Entry{k: "key-1"})
+		if e1 == nil || !e1.opts.doesExp || time.Until(e1.opts.expTime) < 59*time.Minute {
+			t.Errorf("Failure: expected key-1 to expire after the default ttl got %v", e1)
+		}
+		e2, _ := tx.Get(&Entry{k: "key-2"})
+		if e2 == nil || time.Until(e2.opts.expTime) > time.Minute {
+			t.Errorf("Failure: expected key-2 to keep its own expiry got %v", e2)
+		}
+		return nil
+	})
+	db.DropBucket("defaults")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SetIfVersion(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("version", opts)
+	version := func() uint64 {
+		var v uint64
+		db.View("version", func(tx *Tx) error {
+			if e, _ := tx.Get(&Entry{k: "key-1"}); e != nil {
+				v = e.Version()
+			}
+			return nil
+		})
+		return v
+	}
+	db.Update("version", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{ \"value\":1}", false, nil)
+		if ok, err := tx.SetIfVersion(e, 0); !ok || err != nil {
+			t.Errorf("Failure: tx.SetIfVersion(e, 0) expected true for new key got %v and error \"%v\"", ok, err)
+		}
+		return nil
+	})
+	if v := version(); v != 1 {
+		t.Errorf("Failure: expected version 1 got %d", v)
+	}
+	db.Update("version", func(tx *Tx) error {
+		e, _ := NewEntry("key-1", "{ \"value\":2}", false, nil)
+		if ok, err := tx.SetIfVersion(e, 0); ok || err != nil {
+			t.Errorf("Failure: tx.SetIfVersion(e, 0) expected false for existing key got %v and error \"%v\"", ok, err)
+		}
+		if ok, err := tx.SetIfVersion(e, 1); !ok || err != nil {
+			t.Errorf("Failure: tx.SetIfVersion(e, 1) expected true got %v and error \"%v\"", ok, err)
+		}
+		//Changing only the expiry keeps the version.
+		return tx.Touch("key-1", time.Hour)
+	})
+	if v := version(); v != 2 {
+		t.Errorf("Failure: expected version 2 got %d", v)
+	}
+	db.Close()
+	db, err = NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if v := version(); v != 2 {
+		t.Errorf("Failure: expected version 2 after reopen got %d", v)
+	}
+	db.DropBucket("version")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_ConditionalWrite(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("condwrite", opts)
+	entry := func(k, v string) *Entry {
+		e, _ := NewEntry(k, v, false, nil)
+		return e
+	}
+	expect := func(when string, want map[string]string) {
+		db.View("condwrite", func(tx *Tx) error {
+			for k, v := range want {
+				e, _ := tx.Get(&Entry{k: k})
+				if (v == "" && e != nil) || (v != "" && (e == nil || e.GetValue() != v)) {
+					t.Errorf("Failure: expected %s to be %q %s got %v", k, v, when, e)
+				}
+			}
+			return nil
+		})
+	}
+	db.Update("condwrite", func(tx *Tx) error {
+		ok, err := tx.ConditionalWrite([]Condition{{Key: "a"}, {Key: "b"}}, []*Entry{entry("a", "{\"v\":1}"), entry("b", "{\"v\":1}")})
+		if !ok || err != nil {
+			t.Errorf("Failure: tx.ConditionalWrite() of absent keys expected true got %v and error \"%v\"", ok, err)
+		}
+		return nil
+	})
+	db.Update("condwrite", func(tx *Tx) error {
+		//A single failing condition prevents every write and records nothing for rollback.
+		ok, err := tx.ConditionalWrite([]Condition{{Key: "a", Version: 1}, {Key: "b", Version: 2}}, []*Entry{entry("a", "{\"v\":2}"), entry("c", "{\"v\":2}")})
+		if ok || err != nil {
+			t.Errorf("Failure: tx.ConditionalWrite() with a failing condition expected false got %v and error \"%v\"", ok, err)
+		}
+		if len(tx.rbctx.backward) != 0 || len(tx.rbctx.forward) != 0 {
+			t.Error("Failure: tx.ConditionalWrite() with a failing condition recorded rollback state")
+		}
+		//A write that cannot be set prevents the writes before it.
+		if _, err := tx.ConditionalWrite([]Condition{{Key: "a", Version: 1}}, []*Entry{entry("a", "{\"v\":2}"), {k: ""}}); err == nil {
+			t.Error("Failure: tx.ConditionalWrite() with an empty key expected error got nil")
+		}
+		if _, err := tx.ConditionalWrite(nil, []*Entry{entry("c", "{\"v\":2}"), entry("c", "{\"v\":3}")}); err == nil {
+			t.Error("Failure: tx.ConditionalWrite() with a repeated key expected error got nil")
+		}
+		if _, err := tx.ConditionalWrite([]Condition{{}}, nil); err == nil {
+			t.Error("Failure: tx.ConditionalWrite() with an empty condition key expected error got nil")
+		}
+		if len(tx.rbctx.backward) != 0 || len(tx.rbctx.forward) != 0 {
+			t.Error("Failure: tx.ConditionalWrite() returning an error recorded rollback state")
+		}
+		return nil
+	})
+	expect("after failed conditions", map[string]string{"a": "{\"v\":1}", "b": "{\"v\":1}", "c": ""})
+	v := "{\"v\":1}"
+	db.Update("condwrite", func(tx *Tx) error {
+		ok, err := tx.ConditionalWrite([]Condition{{Key: "a", Value: &v}, {Key: "b", Version: 1, Value: &v}, {Key: "c"}}, []*Entry{entry("a", "{\"v\":2}"), entry("c", "{\"v\":2}")})
+		if !ok || err != nil {
+			t.Errorf("Failure: tx.ConditionalWrite() with holding conditions expected true got %v and error \"%v\"", ok, err)
+		}
+		//Conditions see the writes of the transaction.
+		if ok, _ := tx.ConditionalWrite([]Condition{{Key: "a", Version: 2}, {Key: "c", Version: 1}}, nil); !ok {
+			t.Error("Failure: tx.ConditionalWrite() expected conditions to see the writes of the transaction")
+		}
+		return fmt.Errorf("rollback")
+	})
+	expect("after rollback", map[string]string{"a": "{\"v\":1}", "b": "{\"v\":1}", "c": ""})
+	db.Update("condwrite", func(tx *Tx) error {
+		_, err := tx.ConditionalWrite([]Condition{{Key: "a", Value: &v}}, []*Entry{entry("a", "{\"v\":3}"), entry("c", "{\"v\":3}")})
+		return err
+	})
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	expect("after reopen", map[string]string{"a": "{\"v\":3}", "b": "{\"v\":1}", "c": "{\"v\":3}"})
+	db.View("condwrite", func(tx *Tx) error {
+		if e, _ := tx.Get(&Entry{k: "a"}); e == nil || e.Version() != 2 {
+			t.Errorf("Failure: expected a at version 2 after reopen got %v", e)
+		}
+		return nil
+	})
+	db.DropBucket("condwrite")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Keys(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("keys", opts)
+	expired, _ := NewEntryOptions(ExpireTime(time.Now().Add(-time.Second)))
+	db.Update("keys", func(tx *Tx) error {
+		for _, k := range []string{"user:2", "user:1", "order:1", "user", "users:1", "v"} {
+			e, _ := NewEntry(k, "{}", false, nil)
+			tx.Set(e)
+		}
+		e, _ := NewEntry("user:3", "{}", false, expired)
+		tx.Set(e)
+		return nil
+	})
+	expect := func(what string, got []string, want ...string) {
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Failure: %s expected %v got %v", what, want, got)
+		}
+	}
+	db.View("keys", func(tx *Tx) error {
+		keys, err := tx.Keys()
+		if err != nil {
+			t.Errorf("Failure: tx.Keys() returned error \"%v\"", err)
+		}
+		expect("tx.Keys()", keys, "order:1", "user", "user:1", "user:2", "users:1", "v")
+		keys, _ = tx.KeysWithPrefix("user:")
+		expect("tx.KeysWithPrefix(\"user:\")", keys, "user:1", "user:2")
+		keys, _ = tx.KeysWithPrefix("user")
+		expect("tx.KeysWithPrefix(\"user\")", keys, "user", "user:1", "user:2", "users:1")
+		keys, _ = tx.KeysWithPrefix("missing")
+		expect("tx.KeysWithPrefix(\"missing\")", keys)
+		return nil
+	})
+	db.DropBucket("keys")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_SuspendIndexes(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("suspendidx", opts)
+	db.Update("suspendidx", func(tx *Tx) error {
+		return tx.CreateIndex("value", INT_INDEX)
+	})
+	db.Update("suspendidx", func(tx *Tx) error {
+		if err := tx.SuspendIndexes(); err != nil {
+			t.Errorf("Failure: tx.SuspendIndexes() returned error \"%v\"", err)
+		}
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(9-i)+"}", false, nil)
+			tx.Set(e)
+		}
+		tx.Delete(&Entry{k: "key-0"})
+		if size, _ := tx.Size("value"); size != 9 {
+			t.Errorf("Failure: expected suspended index to fall back to key tree of size 9 got %d", size)
+		}
+		if _, err := tx.IndexStats("value"); err == nil {
+			t.Error("Failure: tx.IndexStats(\"value\") expected error while suspended got nil")
+		}
+		if err := tx.ResumeIndexes(); err != nil {
+			t.Errorf("Failure: tx.ResumeIndexes() returned error \"%v\"", err)
+		}
+		var first string
+		tx.Ascend("value", func(e *Entry) bool {
+			first = e.k
+			return false
+		})
+		if first != "key-9" {
+			t.Errorf("Failure: expected resumed index to order key-9 first got %q", first)
+		}
+		return tx.SuspendIndexes()
+	})
+	//Indexes suspended when the transaction commits are rebuilt.
+	db.View("suspendidx", func(tx *Tx) error {
+		if st, err := tx.IndexStats("value"); err != nil || st.Entries != 9 {
+			t.Errorf("Failure: expected index with 9 entries after commit got %v error \"%v\"", st, err)
+		}
+		if err := tx.SuspendIndexes(); err == nil {
+			t.Error("Failure: tx.SuspendIndexes() expected error in read only transaction got nil")
+		}
+		return nil
+	})
+	//Rolling back a transaction with suspended indexes rebuilds them from the restored entries.
+	db.Update("suspendidx", func(tx *Tx) error {
+		tx.SuspendIndexes()
+		tx.Delete(&Entry{k: "key-1"})
+		e, _ := NewEntry("key-new", "{ \"value\":100}", false, nil)
+		tx.Set(e)
+		return fmt.Errorf("rollback")
+	})
+	db.View("suspendidx", func(tx *Tx) error {
+		if st, err := tx.IndexStats("value"); err != nil || st.Entries != 9 {
+			t.Errorf("Failure: expected index with 9 entries after rollback got %v error \"%v\"", st, err)
+		}
+		if max, _ := tx.Max("value"); max == nil || max.k != "key-1" {
+			t.Errorf("Failure: expected key-1 to be the maximum after rollback got %v", max)
+		}
+		return nil
+	})
+	db.DropBucket("suspendidx")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
+
+func TestTx_Add(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(32), Geo)
+	db.CreateBucket("add", opts)
+	db.Update("add", func(tx *Tx) error {
+		if err := tx.CreateIndex("name", STRING_INDEX); err != nil {
+			t.Errorf("Failure: tx.CreateIndex(\"name\", STRING_INDEX) returned error \"%v\"", err)
+		}
+		for _, key := range []string{"member-1", "member-2"} {
+			if err := tx.Add(key); err != nil {
+				t.Errorf("Failure: tx.Add(%q) returned error \"%v\"", key, err)
+			}
+		}
+		if err := tx.Add(""); err == nil {
+			t.Error("Failure: tx.Add(\"\") expected error got nil")
+		}
+		return nil
+	})
+	db.Close()
+	db, _ = NewStitchDB(c)
+	db.Open()
+	db.Update("add", func(tx *Tx) error {
+		tx.CreateIndex("name", STRING_INDEX)
+		has, _ := tx.HasMany([]string{"member-1", "member-2", "member-3"})
+		if !has["member-1"] || !has["member-2"] || has["member-3"] {
+			t.Errorf("Failure: expected members to be present after reopen got %v", has)
+		}
+		var v map[string]interface{}
+		if ok, err := tx.GetJSON("member-1", &v); !ok || err != nil || v != nil {
+			t.Errorf("Failure: tx.GetJSON(\"member-1\") expected true and unchanged value got %v %v error \"%v\"", ok, v, err)
+		}
+		if size, _ := tx.Size("name"); size != 0 {
+			t.Errorf("Failure: expected empty values not to be indexed got %d", size)
+		}
+		if res, err := tx.Query(Filter{Path: "name", Equal: "x"}); err != nil || len(res) != 0 {
+			t.Errorf("Failure: tx.Query(...) expected no entries got %v error \"%v\"", res, err)
+		}
+		e, err := tx.Patch("member-2", "{\"name\":\"x\"}")
+		if err != nil || e == nil || e.GetValue() != "{\"name\":\"x\"}" {
+			t.Errorf("Failure: tx.Patch(\"member-2\", ...) on empty value expected {\"name\":\"x\"} got %v error \"%v\"", e, err)
+		}
+		return nil
+	})
+	var buf bytes.Buffer
+	if err := db.Export("add", &buf, EXPORT_NDJSON); err != nil || buf.String() != "{\"name\":\"x\"}\n" {
+		t.Errorf("Failure: db.Export(\"add\", ...) expected entries with empty values to have no line got %q error \"%v\"", buf.String(), err)
+	}
+	db.DropBucket("add")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build go1.18
+
+package stitchdb
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+//SetTyped marshals v to JSON and inserts it as the value of an entry with the provided key and options using the
+//transaction provided. Returns the replaced entry as Set does. Returns an error if v could not be marshaled or if the
+//entry could not be set.
+func SetTyped[T any](t *Tx, key string, v T, options *EntryOptions) (*Entry, error) {
+	return t.SetJSON(key, v, options)
+}
+
+//GetTyped unmarshals the value of the entry with the provided key into a T using the transaction provided. Returns false
+//and the zero value of T if the entry is invalid, expired, or not found. Returns an error if the db or bucket is closed or
+//if the value could not be unmarshaled into a T.
+func GetTyped[T any](t *Tx, key string) (T, bool, error) {
+	var v T
+	ok, err := t.GetJSON(key, &v)
+	if err != nil || !ok {
+		var zero T
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+//CreateTypedIndex builds an index over the field of T with the provided Go field name. The field is located in the
+//entry value by its JSON name so T must be stored with SetTyped or an equivalent encoding. Returns an error if T is not a
+//struct, the field does not exist or is not encoded, or if the index could not be created.
+func CreateTypedIndex[T any](t *Tx, field string, vtype IndexValueType) error {
+	pattern, err := typedFieldPath(reflect.TypeOf((*T)(nil)).Elem(), field)
+	if err != nil {
+		return err
+	}
+	return t.CreateIndex(pattern, vtype)
+}
+
+//typedFieldPath returns the JSON name of the struct field with the provided Go field name following the encoding/json
+//naming rules.
+func typedFieldPath(typ reflect.Type, field string) (string, error) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return "", errors.New("error: typed: type is not a struct")
+	}
+	sf, ok := typ.FieldByName(field)
+	if !ok || sf.PkgPath != "" {
+		return "", errors.New("error: typed: struct has no exported field " + field)
+	}
+	name := sf.Name
+	if tag, ok := sf.Tag.Lookup("json"); ok {
+		if tag == "-" {
+			return "", errors.New("error: typed: field " + field + " is not encoded")
+		}
+		if n := strings.Split(tag, ",")[0]; n != "" {
+			name = n
+		}
+	}
+	return name, nil
+}
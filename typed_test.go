@@ -0,0 +1,100 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build go1.18
+
+package stitchdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cbergoon/btree"
+)
+
+type typedPerson struct {
+	Name    string `json:"name"`
+	Age     int    `json:"age"`
+	Email   string
+	private string
+	Skip    string `json:"-"`
+}
+
+func TestTypedFieldPath(t *testing.T) {
+	tests := []struct {
+		field string
+		path  string
+		err   bool
+	}{
+		{"Name", "name", false},
+		{"Email", "Email", false},
+		{"private", "", true},
+		{"Skip", "", true},
+		{"Missing", "", true},
+	}
+	for _, test := range tests {
+		path, err := typedFieldPath(reflect.TypeOf(typedPerson{}), test.field)
+		if (err != nil) != test.err || path != test.path {
+			t.Errorf("Failure: typedFieldPath(typedPerson, %q) expected %q, error %v got %q, \"%v\"", test.field, test.path, test.err, path, err)
+		}
+	}
+	if _, err := typedFieldPath(reflect.TypeOf(0), "Name"); err == nil {
+		t.Errorf("Failure: typedFieldPath(int, \"Name\") expected error got nil")
+	}
+}
+
+func TestSetGetTyped(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Second), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(32))
+	db.CreateBucket("typed", opts)
+	eopt, _ := NewEntryOptions()
+	db.Update("typed", func(tx *Tx) error {
+		if _, err := SetTyped(tx, "key-1", typedPerson{Name: "bob", Age: 40}, eopt); err != nil {
+			t.Errorf("Failure: SetTyped(tx, \"key-1\", ...) returned error \"%v\"", err)
+		}
+		SetTyped(tx, "key-2", typedPerson{Name: "alice", Age: 30}, eopt)
+		if err := CreateTypedIndex[typedPerson](tx, "Age", INT_INDEX); err != nil {
+			t.Errorf("Failure: CreateTypedIndex[typedPerson](tx, \"Age\", INT_INDEX) returned error \"%v\"", err)
+		}
+		if err := CreateTypedIndex[typedPerson](tx, "Missing", INT_INDEX); err == nil {
+			t.Errorf("Failure: CreateTypedIndex[typedPerson](tx, \"Missing\", INT_INDEX) expected error got nil")
+		}
+		return nil
+	})
+	db.View("typed", func(tx *Tx) error {
+		p, ok, err := GetTyped[typedPerson](tx, "key-1")
+		if err != nil || !ok || p.Name != "bob" || p.Age != 40 {
+			t.Errorf("Failure: GetTyped[typedPerson](tx, \"key-1\") expected bob got %v, %v, \"%v\"", p, ok, err)
+		}
+		p, ok, err = GetTyped[typedPerson](tx, "missing")
+		if err != nil || ok || p != (typedPerson{}) {
+			t.Errorf("Failure: GetTyped[typedPerson](tx, \"missing\") expected zero value got %v, %v, \"%v\"", p, ok, err)
+		}
+		if _, _, err := GetTyped[int](tx, "key-1"); err == nil {
+			t.Errorf("Failure: GetTyped[int](tx, \"key-1\") expected error got nil")
+		}
+		var order []string
+		tx.bkt.indexes["age"].t.Ascend(func(item btree.Item) bool {
+			order = append(order, item.(*Entry).k)
+			return true
+		})
+		if len(order) != 2 || order[0] != "key-2" || order[1] != "key-1" {
+			t.Errorf("Failure: expected age index order [key-2 key-1] got %v", order)
+		}
+		return nil
+	})
+	db.DropBucket("typed")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+}
@@ -3,6 +3,16 @@
 
 package stitchdb
 
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
 //boolToInt returns an integer representation of a provided bool. Returns 1 for a true value and 0 for a false value.
 func boolToInt(b bool) int {
 	if b {
@@ -10,3 +20,50 @@ func boolToInt(b bool) int {
 	}
 	return 0
 }
+
+//goroutineID returns the id of the calling goroutine parsed from the header of its stack trace. Returns zero if the id
+//could not be parsed; ids of running goroutines are never zero.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, err := strconv.ParseInt(string(buf), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+//formatStmtTime returns the representation of a time in a statement as seconds since the unix epoch followed by the
+//nanoseconds within the second, if any, after a decimal point.
+func formatStmtTime(t time.Time) string {
+	sec := strconv.FormatInt(t.Unix(), 10)
+	if t.Nanosecond() == 0 {
+		return sec
+	}
+	nsec := strconv.Itoa(t.Nanosecond())
+	return sec + "." + strings.Repeat("0", 9-len(nsec)) + nsec
+}
+
+//parseStmtTime parses a time formatted by formatStmtTime. Times written as whole seconds, as statements written before
+//sub-second precision was recorded are, are parsed as well. Returns an error if the time could not be parsed.
+func parseStmtTime(s string) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Annotate(err, "error: stmt: failed to parse time")
+	}
+	var nsec int64
+	if len(parts) == 2 {
+		if len(parts[1]) != 9 {
+			return time.Time{}, errors.New("error: stmt: failed to parse time; invalid nanoseconds")
+		}
+		if nsec, err = strconv.ParseInt(parts[1], 10, 64); err != nil || nsec < 0 {
+			return time.Time{}, errors.New("error: stmt: failed to parse time; invalid nanoseconds")
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
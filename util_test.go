@@ -3,7 +3,10 @@
 
 package stitchdb
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestBoolToInt(t *testing.T) {
 	tr := boolToInt(true)
@@ -15,3 +18,27 @@ func TestBoolToInt(t *testing.T) {
 		t.Errorf("Failure: Expected boolToInt(false) == 0 got %v", tr)
 	}
 }
+
+func TestStmtTime(t *testing.T) {
+	for _, tm := range []time.Time{time.Unix(1500000000, 0), time.Unix(1500000000, 5), time.Unix(1500000000, 500000000), time.Unix(-1, 999999999), {}} {
+		s := formatStmtTime(tm)
+		p, err := parseStmtTime(s)
+		if err != nil {
+			t.Errorf("Failure: parseStmtTime(%q) returned error \"%v\"", s, err)
+		}
+		if !p.Equal(tm) {
+			t.Errorf("Failure: parseStmtTime(formatStmtTime(%v)) expected %v got %v", tm, tm, p)
+		}
+	}
+	if s := formatStmtTime(time.Unix(1500000000, 5)); s != "1500000000.000000005" {
+		t.Errorf("Failure: formatStmtTime(...) expected \"1500000000.000000005\" got %q", s)
+	}
+	if p, err := parseStmtTime("1500000000"); err != nil || !p.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("Failure: parseStmtTime(\"1500000000\") expected %v got %v and error \"%v\"", time.Unix(1500000000, 0), p, err)
+	}
+	for _, invalid := range []string{"", "a", "1.5", "1.-00000005", "1.0000000050"} {
+		if _, err := parseStmtTime(invalid); err == nil {
+			t.Errorf("Failure: parseStmtTime(%q) expected error got nil", invalid)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cbergoon/btree"
+	"github.com/juju/errors"
+)
+
+//VerifyReport describes the discrepancies found by Verify. Keys are reported in key order for the bucket and in index
+//order for indexes.
+type VerifyReport struct {
+	Replayed   int                 //Number of statements replayed from the bucket file.
+	Entries    int                 //Number of persisted entries in the bucket.
+	Missing    []string            //Keys of persisted entries in the bucket that are not in the replayed bucket file.
+	Unexpected []string            //Keys of entries in the replayed bucket file that are not in the bucket.
+	Mismatched []string            //Keys of entries whose replayed value or options differ from the entry in the bucket.
+	Indexes    map[string][]string //Keys of entries missing, unexpected, or out of order in each index by index pattern.
+}
+
+//Ok returns true if no discrepancies were found.
+func (r VerifyReport) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Unexpected) == 0 && len(r.Mismatched) == 0 && len(r.Indexes) == 0
+}
+
+//Verify checks the bucket specified by the bucket name provided for drift between the bucket, its bucket file, and its
+//indexes. The bucket file, including writes that have not been flushed, is replayed into a temporary bucket that is
+//compared against the bucket, and each index is checked to contain exactly the entries of the bucket with the index
+//field in index order. Entries that are not persisted are not compared against the bucket file. The bucket is locked for
+//reading, and neither the bucket nor its file is modified. Returns an error if the db is closed, the bucket is invalid,
+//or the bucket file could not be read or replayed.
+func (db *StitchDB) Verify(bucket string) (VerifyReport, error) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	if !db.open {
+		return VerifyReport{}, errors.New("error: db: db is closed")
+	}
+	b, err := db.getBucket(bucket)
+	if err != nil || b == nil {
+		return VerifyReport{}, errors.New("error: db: invalid bucket")
+	}
+	b.lock(MODE_READ)
+	defer b.unlock(MODE_READ)
+	if !b.open {
+		return VerifyReport{}, errors.New("error: db: invalid bucket")
+	}
+	var report VerifyReport
+	if db.config.persist && b.file != nil {
+		if report, err = b.verifyFile(); err != nil {
+			return VerifyReport{}, err
+		}
+	}
+	b.verifyIndexes(&report)
+	return report, nil
+}
+
+//runScrubber runs a pass of the scrubber at the configured scrub frequency until the db is closed.
+func (db *StitchDB) runScrubber() {
+	ticker := time.NewTicker(db.config.scrubFrequency)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !db.scrub() {
+			return
+		}
+	}
+}
+
+//scrubState holds the progress of the scrubber through the bucket it is verifying.
+type scrubState struct {
+	bucket *Bucket                //Bucket being verified.
+	file   *os.File               //Bucket file of the bucket when the verification started.
+	rd     *os.File               //Handle the bucket file is read through; nil if the bucket is not persisted.
+	br     *bufio.Reader          //Reader of the bucket file up to its size when the verification started.
+	next   func() (string, error) //Reads the next statement from br.
+	end    int64                  //Size of the bucket file when the verification started.
+	tmp    *Bucket                //Temporary bucket the bucket file is replayed into.
+	err    error                  //Error that prevented the verification from starting.
+	stale  bool                   //Indicates if the bucket or its file was replaced and the verification must restart.
+}
+
+//scrub verifies buckets in name order resuming after the bucket verified last and reports each result. The bucket file
+//of a bucket is replayed into a temporary bucket without holding the lock on the bucket, up to the configured scrub
+//budget of records per pass; a bucket file with more records is replayed over several passes. Once its file is
+//replayed the bucket is locked for reading, the records written since the verification started are replayed, and the
+//bucket and its indexes are compared as Verify compares them. A verification restarts if the bucket file is compacted
+//or the bucket replaced before it completes. The pass stops at a bucket that cannot be locked for reading without
+//waiting so that the scrubber gives way to read/write transactions. Returns false if the db is closed.
+func (db *StitchDB) scrub() bool {
+	db.lock(MODE_READ)
+	if !db.open {
+		db.unlock(MODE_READ)
+		db.stopScrub()
+		return false
+	}
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	db.unlock(MODE_READ)
+	sort.Strings(names)
+	budget, used := db.config.scrubBudget, 0
+	for i := 0; i < len(names); i++ {
+		if db.scrubbing == nil {
+			start := sort.SearchStrings(names, db.scrubbed)
+			if start < len(names) && names[start] == db.scrubbed {
+				start++
+			}
+			name := names[start%len(names)]
+			s, ok := db.startScrub(name)
+			if !ok {
+				return true
+			}
+			if s == nil {
+				db.scrubbed = name
+				continue
+			}
+			db.scrubbing = s
+		}
+		s := db.scrubbing
+		limit := 0
+		if budget > 0 {
+			limit = budget - used
+		}
+		n, complete, err := s.replayFile(limit)
+		used += n
+		if err == nil && !complete {
+			return true
+		}
+		var report VerifyReport
+		if err == nil {
+			var ok bool
+			if report, ok = db.finishScrub(s); !ok {
+				if s.stale {
+					db.stopScrub()
+				}
+				return true
+			}
+		}
+		db.stopScrub()
+		db.scrubbed = s.bucket.name
+		db.reportScrub(s.bucket.name, report, err)
+		if budget > 0 && used >= budget {
+			return true
+		}
+	}
+	return true
+}
+
+//startScrub starts the verification of the bucket with the provided name by the scrubber. Returns false if the bucket
+//cannot be locked for reading without waiting, and a nil state if the bucket no longer exists.
+func (db *StitchDB) startScrub(name string) (*scrubState, bool) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	b, err := db.getBucket(name)
+	if err != nil || b == nil {
+		return nil, true
+	}
+	if !b.bktlock.TryRLock() {
+		return nil, false
+	}
+	defer b.bktlock.RUnlock()
+	if !b.open {
+		return nil, true
+	}
+	s := &scrubState{bucket: b, file: b.file}
+	if s.tmp, s.err = newBucket(db, b.options, b.name); s.err != nil {
+		s.err = errors.Annotate(s.err, "error: db: failed to create temporary bucket")
+		return s, true
+	}
+	if !db.config.persist || b.file == nil {
+		return s, true
+	}
+	//The bucket file is complete up to its current size while the bucket is locked; later writes are replayed once the
+	//bucket is locked again.
+	if s.rd, s.err = os.Open(b.file.Name()); s.err != nil {
+		s.err = errors.Annotate(s.err, "error: db: failed to open bucket file")
+		return s, true
+	}
+	info, err := s.rd.Stat()
+	if err != nil {
+		s.err = errors.Annotate(err, "error: db: failed to stat bucket file")
+		return s, true
+	}
+	s.end = info.Size()
+	s.br = bufio.NewReader(io.NewSectionReader(s.rd, 0, s.end))
+	s.next = stmtReader(s.br)
+	return s, true
+}
+
+//replayFile replays up to limit records of the bucket file, or every record if limit is zero, into the temporary bucket
+//without holding the lock on the bucket. Returns the number of records replayed and true once the bucket file has been
+//replayed up to its size when the verification started.
+func (s *scrubState) replayFile(limit int) (int, bool, error) {
+	if s.err != nil {
+		return 0, true, s.err
+	}
+	if s.next == nil {
+		return 0, true, nil
+	}
+	n, spent := 0, false
+	err := s.tmp.replayStmts(func() (string, error) {
+		if limit > 0 && n >= limit {
+			//The file is replayed once nothing follows the last record within the budget.
+			_, err := s.br.Peek(1)
+			spent = err != io.EOF
+			return "", io.EOF
+		}
+		stmt, err := s.next()
+		if err == nil {
+			n++
+		}
+		return stmt, err
+	}, nil)
+	if err != nil {
+		return n, true, errors.Annotate(err, "error: db: failed to replay bucket file")
+	}
+	return n, !spent, nil
+}
+
+//finishScrub locks the bucket being verified for reading, replays the records written to its file or buffered since the
+//verification started, and compares the bucket and its indexes with the replayed entries. Returns false if the bucket
+//cannot be locked for reading without waiting, or if the bucket or its file was replaced, in which case the state is
+//marked stale.
+func (db *StitchDB) finishScrub(s *scrubState) (VerifyReport, bool) {
+	db.lock(MODE_READ)
+	defer db.unlock(MODE_READ)
+	b := s.bucket
+	if cur, err := db.getBucket(b.name); err != nil || cur != b {
+		s.stale = true
+		return VerifyReport{}, false
+	}
+	if !b.bktlock.TryRLock() {
+		return VerifyReport{}, false
+	}
+	defer b.bktlock.RUnlock()
+	if !b.open || b.file != s.file {
+		s.stale = true
+		return VerifyReport{}, false
+	}
+	var report VerifyReport
+	if s.rd != nil {
+		rest := io.MultiReader(s.br, io.NewSectionReader(s.rd, s.end, math.MaxInt64-s.end), bytes.NewReader(b.aofbuf))
+		if err := s.tmp.replay(rest, nil); err != nil {
+			s.err = errors.Annotate(err, "error: db: failed to replay bucket file")
+		} else {
+			report = b.compareReplayed(s.tmp)
+		}
+	}
+	if s.err == nil {
+		b.verifyIndexes(&report)
+	}
+	return report, true
+}
+
+//stopScrub ends the verification of the scrubber, if any, closing its handle of the bucket file.
+func (db *StitchDB) stopScrub() {
+	if db.scrubbing != nil && db.scrubbing.rd != nil {
+		db.scrubbing.rd.Close()
+	}
+	db.scrubbing = nil
+}
+
+//reportScrub reports the result of the verification of a bucket by the scrubber.
+func (db *StitchDB) reportScrub(name string, report VerifyReport, err error) {
+	if db.config.scrubReport != nil {
+		db.config.scrubReport(name, report, err)
+	} else if err != nil {
+		db.config.logger.Errorf("%v", errors.Annotate(err, "error: db: scrubber failed to verify bucket "+name))
+	} else if !report.Ok() {
+		db.config.logger.Errorf("error: db: scrubber found discrepancies in bucket %v: %+v", name, report)
+	}
+}
+
+//verifyFile replays the bucket file and the unflushed write buffer into a temporary bucket and compares its entries with
+//the persisted entries of the bucket. It is assumed the caller holds a lock on the bucket.
+func (b *Bucket) verifyFile() (VerifyReport, error) {
+	f, err := os.Open(b.file.Name())
+	if err != nil {
+		return VerifyReport{}, errors.Annotate(err, "error: db: failed to open bucket file")
+	}
+	defer f.Close()
+	tmp, err := newBucket(b.db, b.options, b.name)
+	if err != nil {
+		return VerifyReport{}, errors.Annotate(err, "error: db: failed to create temporary bucket")
+	}
+	if err := tmp.replay(io.MultiReader(f, bytes.NewReader(b.aofbuf)), nil); err != nil {
+		return VerifyReport{}, errors.Annotate(err, "error: db: failed to replay bucket file")
+	}
+	return b.compareReplayed(tmp), nil
+}
+
+//compareReplayed compares the entries replayed into the temporary bucket with the persisted entries of the bucket. It
+//is assumed the caller holds a lock on the bucket.
+func (b *Bucket) compareReplayed(tmp *Bucket) VerifyReport {
+	var report VerifyReport
+	report.Replayed = tmp.loaded
+	//Walk both trees in key order comparing the entries with equal keys.
+	var live []*Entry
+	b.data.Ascend(func(item btree.Item) bool {
+		if e := item.(*Entry); e.persists() {
+			live = append(live, e)
+		}
+		return true
+	})
+	report.Entries = len(live)
+	tmp.data.Ascend(func(item btree.Item) bool {
+		e := item.(*Entry)
+		for len(live) > 0 && live[0].k < e.k {
+			report.Missing = append(report.Missing, live[0].k)
+			live = live[1:]
+		}
+		if len(live) == 0 || live[0].k != e.k {
+			report.Unexpected = append(report.Unexpected, e.k)
+			return true
+		}
+		if !verifyEntry(live[0], e) {
+			report.Mismatched = append(report.Mismatched, e.k)
+		}
+		live = live[1:]
+		return true
+	})
+	for _, e := range live {
+		report.Missing = append(report.Missing, e.k)
+	}
+	return report
+}
+
+//verifyIndexes records in the report the keys found by verifyIndex for each index of the bucket. It is assumed the
+//caller holds a lock on the bucket.
+func (b *Bucket) verifyIndexes(report *VerifyReport) {
+	for pattern, index := range b.indexes {
+		if keys := b.verifyIndex(index); len(keys) > 0 {
+			if report.Indexes == nil {
+				report.Indexes = make(map[string][]string)
+			}
+			report.Indexes[pattern] = keys
+		}
+	}
+}
+
+//verifyIndex returns the keys of the entries that are missing from the index, that are in the index but not the bucket,
+//or that are out of order in the index. It is assumed the caller holds a lock on the bucket.
+func (b *Bucket) verifyIndex(index *Index) []string {
+	var keys []string
+	var prev btree.Item
+	index.t.Ascend(func(item btree.Item) bool {
+		e := itemEntry(item)
+		if b.get(e) != e || (prev != nil && !prev.Less(item, index)) {
+			keys = append(keys, e.k)
+		}
+		prev = item
+		return true
+	})
+	b.data.Ascend(func(item btree.Item) bool {
+		e := item.(*Entry)
+		for _, it := range index.items(e) {
+			if itemEntry(index.t.Get(it)) != e {
+				keys = append(keys, e.k)
+				break
+			}
+		}
+		return true
+	})
+	return keys
+}
+
+//verifyEntry returns true if the replayed entry matches the entry of the bucket. Times are compared at the resolution of
+//the bucket file.
+func verifyEntry(e, replayed *Entry) bool {
+	if e.v != replayed.v || e.binary != replayed.binary {
+		return false
+	}
+	x, y := e.opts, replayed.opts
+	if x == nil || y == nil {
+		return x == y
+	}
+	return x.doesExp == y.doesExp && x.doesInv == y.doesInv && x.expTime.Equal(y.expTime) &&
+		x.invTime.Equal(y.invTime) && x.tol == y.tol
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package stitchdb
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStitchDB_Verify(t *testing.T) {
+	c, _ := NewConfig(Persist, DirPath("stitch/test/db/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	if !db.open {
+		t.Error("Failure: db.Open() expected db to be open got db.open == false")
+	}
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("verify", opts)
+	db.Update("verify", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		for i := 0; i < 10; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i%3)+"}", false, nil)
+			tx.Set(e)
+		}
+		tx.Delete(&Entry{k: "key-9"})
+		tx.Touch("key-8", time.Hour)
+		nopt, _ := NewEntryOptions(NoPersist)
+		e, _ := NewEntry("key-nopersist", "{ \"value\":1}", false, nopt)
+		tx.Set(e)
+		return nil
+	})
+	report, err := db.Verify("verify")
+	if err != nil {
+		t.Errorf("Failure: db.Verify(\"verify\") returned error \"%v\"", err)
+	}
+	if !report.Ok() || report.Entries != 9 || report.Replayed != 10 {
+		t.Errorf("Failure: db.Verify(\"verify\") expected ok report for 9 entries and 10 statements got %+v", report)
+	}
+	//Drift the bucket from its file and index without writing statements.
+	b := db.buckets["verify"]
+	b.insert(&Entry{k: "key-1", v: "{ \"value\":5}", opts: &EntryOptions{}})
+	b.insert(&Entry{k: "key-a", v: "{ \"value\":5}", opts: &EntryOptions{}})
+	b.data.Delete(&Entry{k: "key-2"})
+	b.indexes["value"].t.Delete(b.get(&Entry{k: "key-3"}))
+	report, err = db.Verify("verify")
+	if err != nil {
+		t.Errorf("Failure: db.Verify(\"verify\") returned error \"%v\"", err)
+	}
+	if report.Ok() {
+		t.Error("Failure: db.Verify(\"verify\") expected discrepancies got ok report")
+	}
+	if !reflect.DeepEqual(report.Missing, []string{"key-a"}) || !reflect.DeepEqual(report.Unexpected, []string{"key-2"}) || !reflect.DeepEqual(report.Mismatched, []string{"key-1"}) {
+		t.Errorf("Failure: db.Verify(\"verify\") unexpected bucket file discrepancies %+v", report)
+	}
+	if !reflect.DeepEqual(report.Indexes, map[string][]string{"value": {"key-2", "key-3"}}) {
+		t.Errorf("Failure: db.Verify(\"verify\") unexpected index discrepancies %v", report.Indexes)
+	}
+	if _, err := db.Verify("invalid"); err == nil {
+		t.Error("Failure: db.Verify(\"invalid\") expected error got nil")
+	}
+	db.DropBucket("verify")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	if _, err := db.Verify("verify"); err == nil {
+		t.Error("Failure: db.Verify(\"verify\") expected error on closed db got nil")
+	}
+}
+
+func TestStitchDB_Scrub(t *testing.T) {
+	//The passes below depend on the number of records in the bucket files.
+	os.RemoveAll("stitch/test/scrub/")
+	reports := make(chan string, 16)
+	var last VerifyReport
+	c, _ := NewConfig(Persist, DirPath("stitch/test/scrub/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), Developer, PerformanceMonitor, BucketFileMultLimit(10), ScrubBudget(2), ScrubReport(func(bucket string, report VerifyReport, err error) {
+		if bucket == "scrub-b" {
+			last = report
+		}
+		if err != nil {
+			reports <- bucket + ":error"
+		} else if !report.Ok() {
+			reports <- bucket + ":drift"
+		} else {
+			reports <- bucket + ":ok"
+		}
+	}))
+	db, err := NewStitchDB(c)
+	if err != nil {
+		t.Errorf("Failure: NewStitchDB(c) returned error \"%v\"", err)
+	}
+	db.Open()
+	opts, _ := NewBucketOptions(BTreeDegree(4))
+	db.CreateBucket("scrub-a", opts)
+	db.CreateBucket("scrub-b", opts)
+	db.Update("scrub-b", func(tx *Tx) error {
+		tx.CreateIndex("value", INT_INDEX)
+		for i := 0; i < 5; i++ {
+			e, _ := NewEntry("key-"+strconv.Itoa(i), "{ \"value\":"+strconv.Itoa(i)+"}", false, nil)
+			tx.Set(e)
+		}
+		return nil
+	})
+	b := db.buckets["scrub-b"]
+	b.indexes["value"].t.Delete(b.get(&Entry{k: "key-3"}))
+	next := func() string {
+		select {
+		case r := <-reports:
+			return r
+		default:
+			return ""
+		}
+	}
+	//A budget of two records per pass verifies the empty scrub-a and replays scrub-b over three passes.
+	db.scrub()
+	if r := next(); r != "scrub-a:ok" {
+		t.Errorf("Failure: expected first scrub pass to report scrub-a:ok got %q", r)
+	}
+	if r := next(); r != "" {
+		t.Errorf("Failure: expected first scrub pass to stop within scrub-b got %q", r)
+	}
+	//The bucket is not locked between passes and writes made during the verification are replayed when it completes.
+	db.Update("scrub-b", func(tx *Tx) error {
+		e, _ := NewEntry("key-5", "{ \"value\":5}", false, nil)
+		tx.Set(e)
+		return nil
+	})
+	db.scrub()
+	if r := next(); r != "" {
+		t.Errorf("Failure: expected second scrub pass to stop within scrub-b got %q", r)
+	}
+	db.scrub()
+	if r := next(); r != "scrub-b:drift" {
+		t.Errorf("Failure: expected third scrub pass to report scrub-b:drift got %q", r)
+	}
+	if last.Entries != 6 || len(last.Missing) != 0 || len(last.Indexes["value"]) != 1 {
+		t.Errorf("Failure: expected scrub-b report with 6 entries and 1 index discrepancy got %+v", last)
+	}
+	if r := next(); r != "scrub-a:ok" {
+		t.Errorf("Failure: expected third scrub pass to continue with scrub-a:ok got %q", r)
+	}
+	//A pass stops at a bucket held by a read/write transaction and resumes there.
+	tx, _ := db.Begin("scrub-b", MODE_READ_WRITE)
+	db.scrub()
+	if r := next(); r != "" {
+		t.Errorf("Failure: expected scrub pass to skip busy bucket got %q", r)
+	}
+	tx.RollbackTx()
+	for i := 0; i < 3; i++ {
+		db.scrub()
+	}
+	if r := next(); r != "scrub-b:drift" {
+		t.Errorf("Failure: expected scrub passes after rollback to report scrub-b:drift got %q", r)
+	}
+	db.DropBucket("scrub-a")
+	db.DropBucket("scrub-b")
+	db.Close()
+	if db.open {
+		t.Error("Failure: db.Close() expected db to be not open got db.open == true")
+	}
+	if db.scrub() {
+		t.Error("Failure: db.scrub() expected false on closed db got true")
+	}
+	//The scrubber runs in the background at the scrub frequency.
+	c, _ = NewConfig(Persist, DirPath("stitch/test/scrub/"), Sync(MNGFREQ), ManageFrequency(1*time.Hour), ScrubFrequency(10*time.Millisecond), ScrubReport(func(bucket string, report VerifyReport, err error) {
+		reports <- bucket
+	}))
+	db, _ = NewStitchDB(c)
+	db.Open()
+	db.CreateBucket("scrub-a", opts)
+	select {
+	case <-reports:
+	case <-time.After(2 * time.Second):
+		t.Error("Failure: expected background scrubber to verify scrub-a got no report")
+	}
+	db.DropBucket("scrub-a")
+	db.Close()
+}